@@ -0,0 +1,69 @@
+package gork
+
+import (
+	"strings"
+
+	"github.com/eloonstra/go-little-drunken-bishop/pkg/drunkenbishop"
+)
+
+const (
+	artWidth  = 34
+	artHeight = 18
+	// artSymbols are drawn for squares the bishop visited more and more often
+	artSymbols = " .o+=*B0X@%&#/^"
+)
+
+// randomArt draws the drunken bishop's walk over b, as in OpenSSH's randomart.
+// It's drawn here, rather than by drunkenbishop, which runs off the end of its symbols for the busiest squares.
+func randomArt(width, height int, b []byte, title string) string {
+	heat := drunkenbishop.GenerateHeatmap(width, height, b)
+
+	//	where the bishop started, and where he ended up
+	startX, startY := width/2, height/2
+	endX, endY := startX, startY
+	for _, c := range b {
+		for i := 0; i < 4; i++ {
+			step := c >> (i * 2) & 3
+			if step&1 == 0 {
+				endX = max(endX-1, 0)
+			} else {
+				endX = min(endX+1, width-1)
+			}
+			if step&2 == 0 {
+				endY = max(endY-1, 0)
+			} else {
+				endY = min(endY+1, height-1)
+			}
+		}
+	}
+
+	var sb strings.Builder
+	switch {
+	case title == "":
+		sb.WriteString("+" + strings.Repeat("-", width) + "+\n")
+	default:
+		if len(title)&1 == width&1 {
+			title = "[" + title + "]"
+		} else {
+			title = "[ " + title + "]"
+		}
+		edge := strings.Repeat("-", max(width/2-len(title)/2, 0))
+		sb.WriteString("+" + edge + title + edge + "+\n")
+	}
+	for y, row := range heat {
+		sb.WriteString("|")
+		for x, n := range row {
+			switch {
+			case x == endX && y == endY:
+				sb.WriteString("E")
+			case x == startX && y == startY:
+				sb.WriteString("S")
+			default:
+				sb.WriteByte(artSymbols[min(n, len(artSymbols)-1)])
+			}
+		}
+		sb.WriteString("|\n")
+	}
+	sb.WriteString("+" + strings.Repeat("-", width) + "+\n")
+	return sb.String()
+}
@@ -0,0 +1,41 @@
+package gork
+
+import (
+	"crypto/rand"
+	"strings"
+	"testing"
+
+	"github.com/eloonstra/go-little-drunken-bishop/pkg/drunkenbishop"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArt(t *testing.T) {
+
+	//	art looks as it always has
+	for range 50 {
+		me := NewPrincipal(rand.Reader, nil, nil)
+		p := me.AsPeer()
+		want := ""
+		func() {
+			//	unless drunkenbishop can't draw it
+			defer func() { recover() }()
+			want = drunkenbishop.GenerateRandomArt(artWidth, artHeight, p.Bytes(), true, "ORACLE PEER "+p.Grip())
+		}()
+		if want != "" {
+			assert.Equal(t, want, p.Art())
+		}
+	}
+
+	//	but keys that keep the bishop busy no longer run him out of symbols
+	stuck := []byte(strings.Repeat("\xcc", 64))
+	assert.Panics(t, func() {
+		drunkenbishop.GenerateRandomArt(artWidth, artHeight, stuck, true, "stuck")
+	})
+	var art string
+	assert.NotPanics(t, func() {
+		art = randomArt(artWidth, artHeight, stuck, "stuck")
+	})
+	assert.Len(t, strings.Split(strings.TrimSpace(art), "\n"), artHeight+2)
+	assert.Contains(t, art, "^")
+
+}
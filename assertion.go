@@ -0,0 +1,122 @@
+package gork
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/sean9999/go-delphi"
+	"github.com/sean9999/pear"
+)
+
+const (
+	// SubjectAssertion is the subject of a message in which a node asserts who it is
+	SubjectAssertion = "ASSERTION"
+	// SubjectAck is the subject of a message telling a node that we've accepted its assertion
+	SubjectAck = "ORACLE MESSAGE"
+	// AssertionWindow is how far an assertion's time can be from ours when we hear it
+	AssertionWindow = 5 * time.Minute
+)
+
+var ErrBadAssertion = pear.Defer("bad assertion")
+var ErrStaleAssertion = pear.Defer("stale assertion")
+
+// assertionBody is what an assertion says.
+// Props are included in the body so that they're signed along with everything else,
+// as is the time it was made, so that it can't be replayed later.
+type assertionBody struct {
+	Msg   string    `json:"msg"`
+	Props *KV       `json:"props"`
+	Time  time.Time `json:"time"`
+}
+
+// Assert produces a signed message asserting that we are who we say we are, and have the props we say we have
+func (g *Principal) Assert() (*delphi.Message, error) {
+	body, err := json.Marshal(assertionBody{"i assert that I am me", g.Props, time.Now().UTC()})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBadAssertion, err)
+	}
+	msg := delphi.NewMessage(g.randomness, body)
+	msg.Sender = g.PublicKey()
+	msg.Subject = SubjectAssertion
+	err = msg.Sign(g.randomness, g)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBadAssertion, err)
+	}
+	return msg, nil
+}
+
+// ParseAssertion verifies an assertion, and returns the Peer it describes, with the props it asserts.
+// Reserved props are left out, since they're derived from the sender's key rather than taken on trust,
+// as are observed props, since they're ours to observe, and record props, since they only come with an address record.
+func ParseAssertion(msg *delphi.Message) (Peer, error) {
+	if msg == nil || msg.Subject != SubjectAssertion {
+		return Peer{}, pear.Errorf("%w: not an assertion", ErrBadAssertion)
+	}
+	if !Verify(msg) {
+		return Peer{}, fmt.Errorf("%w: %w", ErrBadAssertion, ErrBadSignature)
+	}
+	peer := NewPeer(msg.Sender.Bytes())
+	var body assertionBody
+	if json.Unmarshal(msg.PlainText, &body) == nil && body.Props != nil {
+		for pair := body.Props.Oldest(); pair != nil; pair = pair.Next() {
+			if !IsReservedProp(pair.Key) && !IsObservedProp(pair.Key) && !IsRecordProp(pair.Key) {
+				peer.Properties.Set(pair.Key, pair.Value)
+			}
+		}
+	}
+	return peer, nil
+}
+
+// AssertedAt is when an assertion says it was made. It's only to be trusted once the assertion is verified.
+func AssertedAt(msg *delphi.Message) time.Time {
+	var body assertionBody
+	if msg == nil || json.Unmarshal(msg.PlainText, &body) != nil {
+		return time.Time{}
+	}
+	return body.Time
+}
+
+// FreshAssertion verifies an assertion heard at now, like [ParseAssertion], and checks that it was made within [AssertionWindow] of now.
+// Since we take a node's address from where we heard it, an old assertion replayed from elsewhere would otherwise move the node there.
+func FreshAssertion(msg *delphi.Message, now time.Time) (Peer, error) {
+	peer, err := ParseAssertion(msg)
+	if err != nil {
+		return Peer{}, err
+	}
+	at := AssertedAt(msg)
+	if at.Before(now.Add(-AssertionWindow)) || at.After(now.Add(AssertionWindow)) {
+		return Peer{}, pear.Errorf("%w: made at %s", ErrStaleAssertion, at.Format(time.RFC3339))
+	}
+	return peer, nil
+}
+
+// Acknowledge produces a signed message telling peer that we've accepted its assertion.
+// If contact isn't empty, it says where we can be reached.
+func (g *Principal) Acknowledge(peer Peer, contact string) (*delphi.Message, error) {
+	msg := g.Compose([]byte("I friended you."), nil, peer)
+	msg.Subject = SubjectAck
+	if contact != "" {
+		msg.Headers.Set("you_can_contact_me_at", contact)
+	}
+	err := msg.Sign(g.randomness, g)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBadAssertion, err)
+	}
+	return msg, nil
+}
+
+// AskAcknowledge produces a signed request for goracled to acknowledge peer on our behalf, at addr, where it asserted itself from.
+// It's an ACK from us to peer, which goracled answers by sending peer an ACK of its own, saying where goracled can be reached.
+// Since a message's recipient isn't signed, peer is named in a header too.
+func (g *Principal) AskAcknowledge(peer Peer, addr string) (*delphi.Message, error) {
+	msg := g.Compose([]byte("acknowledge them for me"), nil, peer)
+	msg.Subject = SubjectAck
+	msg.Headers.Set("for", peer.ToHex())
+	msg.Headers.Set("at", addr)
+	err := msg.Sign(g.randomness, g)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBadAssertion, err)
+	}
+	return msg, nil
+}
@@ -0,0 +1,280 @@
+package gork
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/sean9999/pear"
+	"github.com/spf13/afero"
+)
+
+var ErrLogTampered = pear.Defer("change log has been tampered with")
+var ErrLogTruncated = pear.Defer("change log has been truncated")
+
+// ChangeKind is the kind of change recorded in a [ChangeLog]
+type ChangeKind string
+
+const (
+	ChangeAddPeer       ChangeKind = "add_peer"
+	ChangeDropPeer      ChangeKind = "drop_peer"
+	ChangeSetProp       ChangeKind = "set_prop"
+	ChangeUnsetProp     ChangeKind = "unset_prop"
+	ChangeSetPeerProp   ChangeKind = "set_peer_prop"
+	ChangeUnsetPeerProp ChangeKind = "unset_peer_prop"
+)
+
+// a Change is one entry in a [ChangeLog].
+// Each Change carries the hash of the one before it, and is signed by the owner of the log.
+type Change struct {
+	Seq    uint64     `json:"seq" yaml:"seq"`
+	Time   time.Time  `json:"time" yaml:"time"`
+	Kind   ChangeKind `json:"kind" yaml:"kind"`
+	Source string     `json:"source,omitempty" yaml:"source,omitempty"`
+	Peer   string     `json:"peer,omitempty" yaml:"peer,omitempty"`
+	Key    string     `json:"key,omitempty" yaml:"key,omitempty"`
+	Value  string     `json:"value,omitempty" yaml:"value,omitempty"`
+	Old    string     `json:"old,omitempty" yaml:"old,omitempty"`
+	Prev   string     `json:"prev" yaml:"prev"`
+	Sig    string     `json:"sig" yaml:"sig"`
+}
+
+// Hash is the hex-encoded SHA-256 of everything in the Change except its signature
+func (c Change) Hash() string {
+	c.Sig = ""
+	b, _ := json.Marshal(c)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func (c Change) String() string {
+	subject := c.Key
+	if c.Peer != "" {
+		subject = c.Peer
+		if len(subject) > 16 {
+			subject = subject[:16]
+		}
+		if c.Key != "" {
+			subject += " " + c.Key
+		}
+	}
+	if c.Value != "" {
+		subject += "=" + c.Value
+	}
+	return fmt.Sprintf("%d\t%s\t%s\t%s\t%s", c.Seq, c.Time.Format(time.RFC3339), c.Source, c.Kind, subject)
+}
+
+// a ChangeLog is an append-only, hash-chained, signed record of changes to a [Config]
+type ChangeLog []Change
+
+// Head is the hash of the last entry, or the empty string for an empty log
+func (l ChangeLog) Head() string {
+	if len(l) == 0 {
+		return ""
+	}
+	return l[len(l)-1].Hash()
+}
+
+// VerifyChangeLog checks every link and signature in the chain, and that the chain ends at head.
+// An empty head means there is no record of where the chain should end.
+func (g *Principal) VerifyChangeLog(l ChangeLog, head string) error {
+	prev := ""
+	for i, c := range l {
+		if c.Seq != uint64(i) {
+			return pear.Errorf("%w: entry %d has sequence number %d", ErrLogTampered, i, c.Seq)
+		}
+		if c.Prev != prev {
+			return pear.Errorf("%w: entry %d does not follow from entry %d", ErrLogTampered, i, i-1)
+		}
+		hash := c.Hash()
+		digest, _ := hex.DecodeString(hash)
+		sig, err := hex.DecodeString(c.Sig)
+		if err != nil || !g.Verify(g.PublicKey(), digest, sig) {
+			return pear.Errorf("%w: %w on entry %d", ErrLogTampered, ErrBadSignature, i)
+		}
+		prev = hash
+	}
+	if head != "" && prev != head {
+		return pear.Errorf("%w: log ends at %q but config expects %q", ErrLogTruncated, prev, head)
+	}
+	return nil
+}
+
+// a Journal stores a [ChangeLog]
+type Journal interface {
+	Read() (ChangeLog, error)
+	Append(...Change) error
+	// Source is recorded in every Change, to say where it came from (ie: "goracle add")
+	Source() string
+	// Lock keeps other writers, such as goracle and goracled, from recording changes at the same time
+	Lock() (unlock func(), err error)
+}
+
+// FileJournal stores a [ChangeLog] as a file with one JSON-encoded [Change] per line.
+// It's written in plain text.
+type FileJournal struct {
+	Fs     afero.Fs
+	Name   string
+	Origin string
+}
+
+func (j FileJournal) Source() string {
+	return j.Origin
+}
+
+func (j FileJournal) Lock() (unlock func(), err error) {
+	return lockFile(j.Fs, j.Name+".lock", 0)
+}
+
+func (j FileJournal) Read() (ChangeLog, error) {
+	b, err := afero.ReadFile(j.Fs, j.Name)
+	if errors.Is(err, os.ErrNotExist) {
+		return ChangeLog{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	l := ChangeLog{}
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var c Change
+		err = json.Unmarshal(scanner.Bytes(), &c)
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: %w", ErrLogTampered, len(l)+1, err)
+		}
+		l = append(l, c)
+	}
+	return l, scanner.Err()
+}
+
+func (j FileJournal) Append(changes ...Change) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	fd, err := j.Fs.OpenFile(j.Name, os.O_CREATE|os.O_APPEND|os.O_WRONLY, filePerm)
+	if err != nil {
+		return err
+	}
+	buf := new(bytes.Buffer)
+	enc := json.NewEncoder(buf)
+	for _, c := range changes {
+		err = enc.Encode(c)
+		if err != nil {
+			fd.Close()
+			return err
+		}
+	}
+	_, err = fd.Write(buf.Bytes())
+	if err == nil {
+		err = fd.Sync()
+	}
+	if cerr := fd.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// chain links and signs changes onto the end of a [Journal], returning them along with what will be the new head.
+// They're not appended, so that the caller can do that once it's safe to.
+func (g *Principal) chain(j Journal, changes []Change) ([]Change, string, error) {
+	l, err := j.Read()
+	if err != nil {
+		return nil, "", err
+	}
+	prev := l.Head()
+	seq := uint64(len(l))
+	now := time.Now().UTC()
+	for i := range changes {
+		c := &changes[i]
+		c.Seq = seq
+		c.Time = now
+		c.Source = j.Source()
+		c.Prev = prev
+		digest, _ := hex.DecodeString(c.Hash())
+		sig, err := g.Principal.Sign(nil, digest, nil)
+		if err != nil {
+			return nil, "", err
+		}
+		c.Sig = hex.EncodeToString(sig)
+		prev = c.Hash()
+		seq++
+	}
+	return changes, prev, nil
+}
+
+// DiffConfigs lists the changes that turn config a into config b. A nil a is an empty config.
+func DiffConfigs(a, b *Config) []Change {
+	if a == nil {
+		a = NewConfig()
+	}
+	changes := diffKV(a.Props, b.Props, "", ChangeSetProp, ChangeUnsetProp, "grip")
+
+	before := map[string]*KV{}
+	if a.Peers != nil {
+		for _, p := range *a.Peers {
+			before[p.ToHex()] = p.Properties
+		}
+	}
+	after := map[string]bool{}
+	if b.Peers != nil {
+		for _, p := range *b.Peers {
+			k := p.ToHex()
+			after[k] = true
+			old, existed := before[k]
+			if !existed {
+				changes = append(changes, Change{Kind: ChangeAddPeer, Peer: k})
+				old = NewKV()
+			}
+			changes = append(changes, diffKV(old, p.Properties, k, ChangeSetPeerProp, ChangeUnsetPeerProp, "nick", "grip")...)
+		}
+	}
+	if a.Peers != nil {
+		for _, p := range *a.Peers {
+			if k := p.ToHex(); !after[k] {
+				changes = append(changes, Change{Kind: ChangeDropPeer, Peer: k})
+			}
+		}
+	}
+	return changes
+}
+
+func diffKV(a, b *KV, peer string, set, unset ChangeKind, ignore ...string) []Change {
+	if a == nil {
+		a = NewKV()
+	}
+	if b == nil {
+		b = NewKV()
+	}
+	ignored := func(k string) bool {
+		for _, i := range ignore {
+			if k == i {
+				return true
+			}
+		}
+		return false
+	}
+	changes := []Change{}
+	for pair := b.Oldest(); pair != nil; pair = pair.Next() {
+		old, existed := a.Get(pair.Key)
+		if ignored(pair.Key) || (existed && old == pair.Value) {
+			continue
+		}
+		changes = append(changes, Change{Kind: set, Peer: peer, Key: pair.Key, Value: pair.Value, Old: old})
+	}
+	for pair := a.Oldest(); pair != nil; pair = pair.Next() {
+		if _, exists := b.Get(pair.Key); !exists && !ignored(pair.Key) {
+			changes = append(changes, Change{Kind: unset, Peer: peer, Key: pair.Key, Old: pair.Value})
+		}
+	}
+	return changes
+}
@@ -0,0 +1,140 @@
+package gork
+
+import (
+	"crypto/rand"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChangeLog(t *testing.T) {
+
+	alice := NewPrincipal(rand.Reader, nil, nil)
+	bob := NewPrincipal(rand.Reader, nil, nil)
+	carol := NewPrincipal(rand.Reader, nil, nil)
+
+	fs := afero.NewMemMapFs()
+	prov := FileBasedConfigProvider{Fs: fs, Name: "conf.json"}
+	journal := FileJournal{Fs: fs, Name: "conf.json.log", Origin: "test"}
+	alice.Journal = journal
+
+	t.Run("every save is recorded and anchored in the config", func(t *testing.T) {
+		alice.Props.Set("hometown", "wonderland")
+		alice.AddPeer(bob.AsPeer())
+		assert.NoError(t, alice.Save(prov))
+
+		alice.AddPeer(carol.AsPeer())
+		alice.Props.Delete("hometown")
+		assert.NoError(t, alice.Save(prov))
+
+		//	saving with nothing to say adds nothing
+		assert.NoError(t, alice.Save(prov))
+
+		l, err := journal.Read()
+		assert.NoError(t, err)
+		kinds := []ChangeKind{}
+		for _, c := range l {
+			kinds = append(kinds, c.Kind)
+			assert.Equal(t, "test", c.Source)
+		}
+		assert.Equal(t, []ChangeKind{ChangeSetProp, ChangeAddPeer, ChangeUnsetProp, ChangeAddPeer}, kinds)
+
+		conf, err := prov.Get()
+		assert.NoError(t, err)
+		assert.Equal(t, l.Head(), conf.Log)
+		assert.NoError(t, alice.VerifyChangeLog(l, conf.Log))
+	})
+
+	t.Run("tampering is detected", func(t *testing.T) {
+		l, _ := journal.Read()
+		forged := append(ChangeLog{}, l...)
+		forged[0].Value = "looking-glass"
+		assert.ErrorIs(t, alice.VerifyChangeLog(forged, ""), ErrLogTampered)
+
+		//	someone else's signature won't do
+		assert.ErrorIs(t, bob.VerifyChangeLog(l, ""), ErrLogTampered)
+	})
+
+	t.Run("truncation is detected", func(t *testing.T) {
+		l, _ := journal.Read()
+		conf, _ := prov.Get()
+		assert.ErrorIs(t, alice.VerifyChangeLog(l[:len(l)-1], conf.Log), ErrLogTruncated)
+		assert.NoError(t, alice.VerifyChangeLog(l[:len(l)-1], ""))
+	})
+
+	t.Run("nothing is recorded unless the config is saved", func(t *testing.T) {
+		before, _ := journal.Read()
+		alice.Props.Set("hometown", "looking-glass")
+		assert.ErrorIs(t, alice.Save(unwritable{prov}), os.ErrPermission)
+		after, _ := journal.Read()
+		assert.Equal(t, before, after)
+
+		//	so trying again records it once
+		assert.NoError(t, alice.Save(prov))
+		after, _ = journal.Read()
+		assert.Len(t, after, len(before)+1)
+		conf, _ := prov.Get()
+		assert.NoError(t, alice.VerifyChangeLog(after, conf.Log))
+
+		//	and nobody else can be recording changes meanwhile
+		unlock, err := journal.Lock()
+		assert.NoError(t, err)
+		defer unlock()
+		_, err = lockFile(fs, journal.Name+".lock", 10*time.Millisecond)
+		assert.ErrorIs(t, err, ErrLocked)
+	})
+
+	t.Run("the log head is covered by the config signature", func(t *testing.T) {
+		conf, _ := prov.Get()
+		conf.Log = strings.Repeat("0", 64)
+		assert.Error(t, alice.VerifyConfig(conf))
+	})
+
+}
+
+// unwritable is a provider that can be read from, but not written to
+type unwritable struct {
+	ConfigProvider
+}
+
+func (unwritable) Set(*Config) error {
+	return os.ErrPermission
+}
+
+func TestDiffConfigs(t *testing.T) {
+
+	alice := NewPrincipal(rand.Reader, nil, nil)
+	bob := NewPrincipal(rand.Reader, nil, nil)
+
+	//	Export shares state with the Principal, so take snapshots
+	snapshot := func() *Config {
+		prov := NewMemoryConfigProvider()
+		prov.Set(alice.Export())
+		conf, _ := prov.Get()
+		return conf
+	}
+
+	before := snapshot()
+	alice.AddPeer(bob.AsPeer())
+	alice.Peers[0].Properties.Set("addr", "[::1]:5656")
+	alice.Props.Set("hometown", "wonderland")
+	after := snapshot()
+
+	changes := DiffConfigs(before, after)
+	assert.Len(t, changes, 3)
+	assert.Equal(t, Change{Kind: ChangeSetProp, Key: "hometown", Value: "wonderland"}, changes[0])
+	assert.Equal(t, Change{Kind: ChangeAddPeer, Peer: bob.PublicKey().ToHex()}, changes[1])
+	assert.Equal(t, Change{Kind: ChangeSetPeerProp, Peer: bob.PublicKey().ToHex(), Key: "addr", Value: "[::1]:5656"}, changes[2])
+
+	changes = DiffConfigs(after, before)
+	assert.Len(t, changes, 2)
+	assert.Equal(t, ChangeUnsetProp, changes[0].Kind)
+	assert.Equal(t, ChangeDropPeer, changes[1].Kind)
+
+	assert.Empty(t, DiffConfigs(after, after))
+
+}
@@ -3,7 +3,6 @@ package main
 import (
 	"context"
 	"encoding/pem"
-	"errors"
 	"fmt"
 	"io"
 
@@ -23,48 +22,47 @@ func is(e error) bool {
 	return e != nil
 }
 
-func (cmd *Exe) Add(ctx context.Context, env hermeti.Env, args []string) ([]string, error) {
+func (cmd *Exe) Add(ctx context.Context, env hermeti.Env, args []string) (Result, error) {
 
-	args, err := cmd.ensureSelf(ctx, env, args)
+	_, err := cmd.ensureSelf(ctx, env, args)
 	if is(err) {
-		return args, wrap(err)
+		return nil, wrap(err)
 	}
 
 	pemBytes, err := io.ReadAll(env.InStream)
 	if is(err) {
-		return args, wrap(err)
+		return nil, wrap(err)
 	}
 
+	//	what's piped in has to be an assertion, signed by whoever it says it's from
 	pemBlock, _ := pem.Decode(pemBytes)
+	if pemBlock == nil {
+		return nil, usageError(wrap(gork.ErrBadPem))
+	}
 	msg := &delphi.Message{}
-
 	err = msg.FromPEM(*pemBlock)
 	if is(err) {
-		return args, wrap(err)
+		return nil, usageError(wrap(fmt.Errorf("%w: %w", gork.ErrBadPem, err)))
 	}
-
-	me := cmd.Self
-	dig, err := msg.Digest()
+	peer, err := gork.ParseAssertion(msg)
 	if is(err) {
-		return args, wrap(err)
-	}
-
-	valid := me.Verify(msg.Sender, dig, msg.Signature())
-	if !valid {
-		return args, wrap(errors.New("invalid signature"))
+		return nil, wrap(err)
 	}
 
-	peer := gork.NewPeer(msg.Sender.Bytes())
-
+	me := cmd.Self
 	err = me.AddPeer(peer)
 	if is(err) {
-		return args, wrap(err)
+		return nil, wrap(err)
 	}
 
-	//	output the full config
-	_, err = io.Copy(env.OutStream, me.Export())
-	if is(err) {
-		return args, wrap(err)
+	//	remember them, if there's somewhere to remember them
+	if cmd.Config != nil {
+		err = me.Save(cmd.Config)
+		if is(err) {
+			return nil, wrap(err)
+		}
 	}
-	return args, nil
+
+	//	output the full config
+	return AddResult{peerResult(peer), me.Export()}, nil
 }
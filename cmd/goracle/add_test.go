@@ -2,28 +2,30 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"io"
 	"testing"
 
+	"github.com/sean9999/gork"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestAdd(t *testing.T) {
 
 	//	me
-	pem := "../../testdata/late-silence.pem"
+	pem := testPem
 	conf := "../../testdata/late-silence.config.json"
 
-	//	person to add
-	assertion := "../../testdata/aged-smoke.assertion.pem"
-
 	check := assert.New(t)
 	cli := SetupTestCLI(t)
 
-	//	pipe assertion to stdin
-	fd, err := cli.Env.Filesystem.Open(assertion)
+	//	person to add, who asserts who they are
+	them := gork.NewPrincipal(rand.Reader, nil, nil)
+	assertion, err := them.Assert()
 	check.NoError(err)
-	io.Copy(cli.Env.InStream.(io.Writer), fd)
+
+	//	pipe assertion to stdin
+	io.WriteString(cli.Env.InStream.(io.Writer), assertion.String())
 
 	//	launch the CLI
 	cli.Env.Args = []string{"goracle", "add", "--priv", pem, "--config", conf}
@@ -31,11 +33,31 @@ func TestAdd(t *testing.T) {
 	cli.Run(ctx)
 
 	//	capture output
+	check.NoError(cli.Obj().Err)
 	outstream, err := cli.OutStream()
 	check.NoError(err)
 	result, err := io.ReadAll(outstream)
 	check.NoError(err)
-	check.Contains(string(result), "96c1e46")
-	check.Contains(string(result), "aged-smoke")
+	check.Contains(string(result), them.AsPeer().Grip())
+	check.Contains(string(result), them.Nickname())
+
+	//	what isn't an assertion is the fault of the command line
+	greeting := them.Compose([]byte("hello"), nil, them.AsPeer())
+	check.NoError(greeting.Sign(rand.Reader, &them))
+	for _, in := range []string{"not even pem", greeting.String()} {
+		cli := SetupTestCLI(t)
+		io.WriteString(cli.Env.InStream.(io.Writer), in)
+		cli.Env.Args = []string{"goracle", "add", "--priv", pem, "--config", conf}
+		cli.Run(ctx)
+		check.Equal(ExitUsage, cli.Obj().ExitCode, cli.Obj().Err)
+	}
+
+	//	and what's not signed by who it says it's from is refused
+	assertion.PlainText = []byte(`{"msg":"i assert that I am mallory"}`)
+	cli = SetupTestCLI(t)
+	io.WriteString(cli.Env.InStream.(io.Writer), assertion.String())
+	cli.Env.Args = []string{"goracle", "add", "--priv", pem, "--config", conf}
+	cli.Run(ctx)
+	check.Equal(ExitBadSignature, cli.Obj().ExitCode, cli.Obj().Err)
 
 }
@@ -2,56 +2,68 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
 
 	"github.com/sean9999/go-delphi"
 	"github.com/sean9999/gork"
 	"github.com/sean9999/hermeti"
-	"github.com/sean9999/pear"
 )
 
 var ErrAssert = errors.New("could not assert")
 
-func (cmd *Exe) Assert(ctx context.Context, env hermeti.Env, args []string) ([]string, error) {
+func (cmd *Exe) Assert(ctx context.Context, env hermeti.Env, args []string) (Result, error) {
 
-	args, err := cmd.ensureSelf(ctx, env, args)
+	_, err := cmd.ensureSelf(ctx, env, args)
 	if err != nil {
-		return nil, pear.Errorf("%w: %w. Could not ensure self.", ErrAssert, err)
+		return nil, fmt.Errorf("%w: %w. Could not ensure self.", ErrAssert, err)
 	}
 
-	//	by including props in the body
-	//	we can ensure the integrity of those too.
-	//	props are included has headers, but headers are not used in digest calculation
-	body := struct {
-		Msg   string   `json:"msg"`
-		Props *gork.KV `json:"props"`
-	}{
-		"i assert that I am me",
-		cmd.Self.Props,
-	}
-
-	bodyBytes, jerr := json.Marshal(body)
-	if jerr != nil {
+	msg, err := cmd.assertion(env)
+	if err != nil {
 		return nil, err
 	}
 
-	msg := delphi.NewMessage(env.Randomness, bodyBytes)
-	msg.Sender = cmd.Self.PublicKey()
-	msg.Subject = "ASSERTION"
+	return AssertionResult{
+		Sender: msg.Sender.ToHex(),
+		Props:  orEmpty(cmd.Self.Props),
+		PEM:    fmt.Sprintf("%s", msg),
+	}, nil
 
-	for pair := msg.Headers.Oldest(); pair != nil; pair = pair.Next() {
-		msg.Headers.Set(pair.Key, pair.Value)
-	}
+}
 
-	err = msg.Sign(env.Randomness, cmd.Self)
+// assertion is a signed message asserting that we are who we say we are, and have the props we say we have
+func (cmd *Exe) assertion(env hermeti.Env) (*delphi.Message, error) {
+	cmd.Self.WithRand(env.Randomness)
+	msg, err := cmd.Self.Assert()
 	if err != nil {
-		return nil, pear.Errorf("%w: %w. Could not sign message", ErrAssert, err)
+		return nil, fmt.Errorf("%w: %w", ErrAssert, err)
 	}
+	return msg, nil
+}
 
-	fmt.Fprintf(env.OutStream, "%s\n", msg)
-
-	return args, nil
-
+// broadcast sends a message to every peer we have an address for, and returns how many it went to
+func broadcast(ctx context.Context, env hermeti.Env, msg *delphi.Message, peers gork.PeerList) int {
+	sent := 0
+	for _, peer := range peers {
+		addr, exists := peer.Properties.Get("addr")
+		if !exists {
+			continue
+		}
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "udp", addr)
+		if err != nil {
+			fmt.Fprintf(env.ErrStream, "could not reach %s: %s\n", peer.Nickname(), err)
+			continue
+		}
+		_, err = fmt.Fprintf(conn, "%s", msg)
+		conn.Close()
+		if err != nil {
+			fmt.Fprintf(env.ErrStream, "could not send to %s: %s\n", peer.Nickname(), err)
+			continue
+		}
+		sent++
+	}
+	return sent
 }
@@ -0,0 +1,526 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/sean9999/hermeti"
+	"github.com/sean9999/pear"
+)
+
+var ErrUnknownCommand = pear.Defer("unknown command")
+
+// a flagDoc describes a flag, for help and completion
+type flagDoc struct {
+	Name  string `json:"name" yaml:"name"`
+	Arg   string `json:"arg,omitempty" yaml:"arg,omitempty"`
+	Usage string `json:"usage" yaml:"usage"`
+	// Values are what the flag's argument completes to. None means a file name.
+	Values []string `json:"-" yaml:"-"`
+}
+
+func (f flagDoc) String() string {
+	if f.Arg == "" {
+		return "--" + f.Name
+	}
+	return "--" + f.Name + " <" + f.Arg + ">"
+}
+
+// an argKind says what a positional argument refers to, so that it can be completed
+type argKind int
+
+const (
+	// argAny is free-form, or a file name
+	argAny argKind = iota
+	// argPeer is a peer's nickname or grip
+	argPeer
+	// argProp is one of our own properties
+	argProp
+	// argCommand is a goracle command
+	argCommand
+	// argShell is a shell we can write completion for
+	argShell
+	// argNearby is a node heard on the local network
+	argNearby
+	// argRequest is a node waiting to be approved as a peer
+	argRequest
+	// argMail is a message in our inbox
+	argMail
+)
+
+// globalFlags may appear anywhere on the command line
+var globalFlags = []flagDoc{
+	{Name: "format", Arg: "text|json|yaml", Usage: "how results are written out (default text)", Values: []string{"text", "json", "yaml"}},
+	{Name: "verbosity", Arg: "n", Usage: "verbosity level", Values: []string{"0", "1", "2"}},
+}
+
+// selfFlags are taken by every command that loads our key and config
+var selfFlags = []flagDoc{
+	{Name: "priv", Arg: "pem", Usage: "private key location (default ~/.gork/priv.pem). If it has a passphrase, set $GORACLE_PASSPHRASE"},
+	{Name: "config", Arg: "file", Usage: "config file location (default ~/.gork/config.json)"},
+}
+
+// a command is a goracle subcommand, along with everything that help and completion need to know about it
+type command struct {
+	Name     string
+	Synopsis string
+	// Usage lines, without flags that every command of its kind takes
+	Usage    []string
+	Flags    []flagDoc
+	Examples []string
+	// Self means the command loads our key and config, and so takes --priv and --config
+	Self bool
+	// Args say what each positional argument is. With Variadic, the last one repeats.
+	Args     []argKind
+	Variadic bool
+	// Subcommands are documented here, but dispatched by the command itself
+	Subcommands []command
+	// Hidden commands work, but aren't listed
+	Hidden bool
+	Run    subcommand
+}
+
+// AllFlags are the flags a command takes, including those it takes by virtue of being Self
+func (c command) AllFlags() []flagDoc {
+	if !c.Self {
+		return c.Flags
+	}
+	return append(append([]flagDoc{}, c.Flags...), selfFlags...)
+}
+
+// sub finds a subcommand by name
+func (c command) sub(name string) (command, bool) {
+	for _, s := range c.Subcommands {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return command{}, false
+}
+
+// arg says what the i'th positional argument is
+func (c command) arg(i int) argKind {
+	switch {
+	case i < len(c.Args):
+		return c.Args[i]
+	case c.Variadic && len(c.Args) > 0:
+		return c.Args[len(c.Args)-1]
+	default:
+		return argAny
+	}
+}
+
+// root is goracle itself, whose subcommands are the registry of everything goracle can do
+func (exe *Exe) root() command {
+	return command{
+		Name:     "goracle",
+		Synopsis: "keep an address book of peers, signed with your own key",
+		Usage:    []string{"goracle [--format=text|json|yaml] <command> [flags] [args]"},
+		Flags:    globalFlags,
+		Examples: []string{
+			"goracle init -o",
+			"goracle --format=json peers list",
+			"goracle help peers",
+		},
+		Subcommands: []command{
+			{
+				Name:     "info",
+				Synopsis: "describe yourself (the default)",
+				Usage:    []string{"goracle info"},
+				Self:     true,
+				Run:      exe.Info,
+			},
+			{
+				Name:     "init",
+				Synopsis: "create a new key pair",
+				Usage:    []string{"goracle init [-o[=<dir>]] [--force] [-i] [--prop <key>=<value>]..."},
+				Flags: []flagDoc{
+					{Name: "o", Arg: "dir", Usage: "write priv.pem, pub.pem and config.json to a directory (default ~/.gork)"},
+					{Name: "force", Usage: "replace an existing identity"},
+					{Name: "i", Usage: "ask for properties and a passphrase"},
+					{Name: "prop", Arg: "key=value", Usage: "set a property; may be repeated"},
+				},
+				Examples: []string{
+					"goracle init",
+					"goracle init -o=/tmp/keys --prop addr=10.0.0.2:5656",
+					"GORACLE_PASSPHRASE=jabberwock goracle init -o",
+					"goracle init -o -i",
+				},
+				Run: exe.Init,
+			},
+			{
+				Name:     "save",
+				Synopsis: "re-sign and save your config",
+				Usage:    []string{"goracle save"},
+				Self:     true,
+				Run:      exe.Save,
+			},
+			{
+				Name:     "assert",
+				Synopsis: "produce a signed assertion of who you are",
+				Usage:    []string{"goracle assert"},
+				Self:     true,
+				Examples: []string{"goracle assert > me.pem"},
+				Run:      exe.Assert,
+			},
+			{
+				Name:     "add",
+				Synopsis: "add a peer from an assertion on stdin, and save",
+				Usage:    []string{"goracle add < assertion.pem"},
+				Self:     true,
+				Examples: []string{"goracle add --config conf.json < them.pem"},
+				Run:      exe.Add,
+			},
+			{
+				Name:     "export",
+				Synopsis: "print your private key",
+				Usage:    []string{"goracle export"},
+				Self:     true,
+				Run:      exe.Export,
+			},
+			{
+				Name:     "config",
+				Synopsis: "convert, encrypt, decrypt, and merge config files",
+				Usage:    []string{"goracle config <subcommand> [args]"},
+				Run:      exe.Conf,
+				Subcommands: []command{
+					{
+						Name:     "convert",
+						Synopsis: "write a config file out in another format",
+						Usage:    []string{"goracle config convert [--to json|yaml|msgpack] <in> [out]"},
+						Flags: []flagDoc{
+							{Name: "to", Arg: "format", Usage: "output format, if out doesn't say", Values: []string{"json", "yaml", "msgpack"}},
+						},
+						Examples: []string{"goracle config convert conf.json conf.yaml"},
+					},
+					{
+						Name:     "encrypt",
+						Synopsis: "encrypt your config with your key",
+						Usage:    []string{"goracle config encrypt"},
+						Self:     true,
+					},
+					{
+						Name:     "decrypt",
+						Synopsis: "decrypt your config",
+						Usage:    []string{"goracle config decrypt"},
+						Self:     true,
+					},
+					{
+						Name:     "merge",
+						Synopsis: "merge another copy of your config into yours",
+						Usage:    []string{"goracle config merge <other> [base]"},
+						Self:     true,
+						Examples: []string{"goracle config merge --config conf.json laptop.json base.json"},
+					},
+				},
+			},
+			{
+				Name:     "log",
+				Synopsis: "show (and verify) the change log of your config",
+				Usage:    []string{"goracle log [verify]"},
+				Self:     true,
+				Examples: []string{"goracle log verify --config conf.json"},
+				Run:      exe.Log,
+			},
+			{
+				Name:     "peers",
+				Synopsis: "list, show, drop, and set properties on peers",
+				Usage:    []string{"goracle peers [<subcommand>] [args]"},
+				Run:      exe.Peers,
+				Subcommands: []command{
+					{
+						Name:     "list",
+						Synopsis: "list your peers (the default)",
+						Usage:    []string{"goracle peers list"},
+						Self:     true,
+					},
+					{
+						Name:     "show",
+						Synopsis: "show everything you know about a peer",
+						Usage:    []string{"goracle peers show <peer>"},
+						Self:     true,
+						Args:     []argKind{argPeer},
+						Examples: []string{"goracle peers show shy-river", "goracle peers show 4f2a"},
+					},
+					{
+						Name:     "drop",
+						Synopsis: "forget a peer",
+						Usage:    []string{"goracle peers drop <peer>"},
+						Self:     true,
+						Args:     []argKind{argPeer},
+					},
+					{
+						Name:     "set",
+						Synopsis: "set properties on a peer",
+						Usage:    []string{"goracle peers set <peer> <key>=<value>..."},
+						Self:     true,
+						Args:     []argKind{argPeer},
+						Examples: []string{"goracle peers set shy-river addr=10.0.0.7:5656"},
+					},
+					{
+						Name:     "unset",
+						Synopsis: "remove properties from a peer",
+						Usage:    []string{"goracle peers unset <peer> <key>..."},
+						Self:     true,
+						Args:     []argKind{argPeer},
+					},
+				},
+			},
+			{
+				Name:     "props",
+				Synopsis: "list, get, set, and unset your own properties",
+				Usage:    []string{"goracle props [<subcommand>] [args]"},
+				Run:      exe.Props,
+				Subcommands: []command{
+					{
+						Name:     "list",
+						Synopsis: "list your properties (the default)",
+						Usage:    []string{"goracle props list"},
+						Self:     true,
+					},
+					{
+						Name:     "get",
+						Synopsis: "print one of your properties",
+						Usage:    []string{"goracle props get <key>"},
+						Self:     true,
+						Args:     []argKind{argProp},
+					},
+					{
+						Name:     "set",
+						Synopsis: "set your own properties",
+						Usage:    []string{"goracle props set [--broadcast] <key>=<value>..."},
+						Flags:    []flagDoc{broadcastFlag},
+						Self:     true,
+						Examples: []string{"goracle props set --broadcast addr=10.0.0.2:5656"},
+					},
+					{
+						Name:     "unset",
+						Synopsis: "remove your own properties",
+						Usage:    []string{"goracle props unset [--broadcast] <key>..."},
+						Flags:    []flagDoc{broadcastFlag},
+						Self:     true,
+						Args:     []argKind{argProp},
+						Variadic: true,
+					},
+				},
+			},
+			{
+				Name:     "nearby",
+				Synopsis: "list and accept nodes that goracled --discover has heard nearby",
+				Usage:    []string{"goracle nearby [<subcommand>] [args]"},
+				Run:      exe.Nearby,
+				Subcommands: []command{
+					{
+						Name:     "list",
+						Synopsis: "list nodes heard on the local network that aren't your peers (the default)",
+						Usage:    []string{"goracle nearby list"},
+						Self:     true,
+					},
+					{
+						Name:     "accept",
+						Synopsis: "add nearby nodes to your peers",
+						Usage:    []string{"goracle nearby accept [--introduce] <node>..."},
+						Flags: []flagDoc{
+							{Name: "introduce", Usage: "send them your assertion, so that they can add you back"},
+						},
+						Self:     true,
+						Args:     []argKind{argNearby},
+						Variadic: true,
+						Examples: []string{"goracle nearby accept --introduce shy-river"},
+					},
+					{
+						Name:     "forget",
+						Synopsis: "stop listing nearby nodes, until they're heard from again",
+						Usage:    []string{"goracle nearby forget <node>..."},
+						Self:     true,
+						Args:     []argKind{argNearby},
+						Variadic: true,
+					},
+				},
+			},
+			{
+				Name:     "requests",
+				Synopsis: "approve or deny nodes that have asked to be your peers",
+				Usage:    []string{"goracle requests [<subcommand>] [args]"},
+				Run:      exe.Requests,
+				Subcommands: []command{
+					{
+						Name:     "list",
+						Synopsis: "list requests queued by goracled --policy manual (the default)",
+						Usage:    []string{"goracle requests list"},
+						Self:     true,
+					},
+					{
+						Name:     "approve",
+						Synopsis: "add requesting nodes to your peers, and acknowledge them",
+						Usage:    []string{"goracle requests approve [--daemon host:port] <node>..."},
+						Flags: []flagDoc{
+							{Name: "daemon", Arg: "host:port", Usage: "where goracled is listening (default " + DefaultDaemon + ")"},
+						},
+						Self:     true,
+						Args:     []argKind{argRequest},
+						Variadic: true,
+						Examples: []string{"goracle requests approve shy-river"},
+					},
+					{
+						Name:     "deny",
+						Synopsis: "drop requests, without answering them",
+						Usage:    []string{"goracle requests deny <node>..."},
+						Self:     true,
+						Args:     []argKind{argRequest},
+						Variadic: true,
+					},
+				},
+			},
+			{
+				Name:     "send",
+				Synopsis: "send a peer an encrypted message, by way of goracled",
+				Usage:    []string{"goracle send [--thread <id>] [--daemon host:port] [--direct] [--wait 3s] <peer> [file|-]"},
+				Flags: []flagDoc{
+					{Name: "thread", Arg: "id", Usage: "the thread this message continues"},
+					{Name: "daemon", Arg: "host:port", Usage: "where goracled is listening (default " + DefaultDaemon + ")"},
+					{Name: "direct", Usage: "send straight to the peer's addr, without goracled"},
+					{Name: "wait", Arg: "duration", Usage: "how long to wait to hear that it arrived (default 3s)"},
+				},
+				Self:     true,
+				Args:     []argKind{argPeer, argAny},
+				Examples: []string{"goracle send shy-river notes.txt", "echo hello | goracle send shy-river"},
+				Run:      exe.Send,
+			},
+			{
+				Name:     "inbox",
+				Synopsis: "list messages goracled has received for you",
+				Usage:    []string{"goracle inbox [--from <peer>] [--thread <id>] [--unread]"},
+				Flags: []flagDoc{
+					{Name: "from", Arg: "peer", Usage: "only messages from this peer"},
+					{Name: "thread", Arg: "id", Usage: "only messages in this thread"},
+					{Name: "unread", Usage: "only messages not yet read"},
+				},
+				Self:     true,
+				Examples: []string{"goracle inbox --unread", "goracle inbox --from shy-river"},
+				Run:      exe.Inbox,
+			},
+			{
+				Name:     "read",
+				Synopsis: "show a message, and mark it read",
+				Usage:    []string{"goracle read <id>"},
+				Self:     true,
+				Args:     []argKind{argMail},
+				Examples: []string{"goracle read 1f3a"},
+				Run:      exe.Read,
+			},
+			{
+				Name:     "ping",
+				Synopsis: "ask goracled to ping a peer, and report the round trip time",
+				Usage:    []string{"goracle ping [--daemon host:port] [--timeout 5s] <peer>"},
+				Flags: []flagDoc{
+					{Name: "daemon", Arg: "host:port", Usage: "where goracled is listening (default " + DefaultDaemon + ")"},
+					{Name: "timeout", Arg: "duration", Usage: "how long to wait for an answer (default 5s)"},
+				},
+				Self:     true,
+				Args:     []argKind{argPeer},
+				Examples: []string{"goracle ping shy-river"},
+				Run:      exe.Ping,
+			},
+			{
+				Name:     "locate",
+				Synopsis: "ask goracled to find where a key can be reached, using the DHT",
+				Usage:    []string{"goracle locate [--daemon host:port] [--timeout 15s] <pubkey|grip>"},
+				Flags: []flagDoc{
+					{Name: "daemon", Arg: "host:port", Usage: "where goracled is listening (default " + DefaultDaemon + ")"},
+					{Name: "timeout", Arg: "duration", Usage: "how long to wait for an answer (default 15s)"},
+				},
+				Self:     true,
+				Examples: []string{"goracle locate 96c1e46"},
+				Run:      exe.Locate,
+			},
+			{
+				Name:     "help",
+				Synopsis: "explain a command",
+				Usage:    []string{"goracle help [<command> [<subcommand>]]", "goracle <command> -h"},
+				Args:     []argKind{argCommand, argCommand},
+				Examples: []string{"goracle help peers set"},
+				Run:      exe.Help,
+			},
+			{
+				Name:     "completion",
+				Synopsis: "write a shell completion script",
+				Usage:    []string{"goracle completion bash|zsh|fish"},
+				Args:     []argKind{argShell},
+				Examples: []string{
+					`source <(goracle completion bash)`,
+					`goracle completion zsh > "${fpath[1]}/_goracle"`,
+					`goracle completion fish > ~/.config/fish/completions/goracle.fish`,
+				},
+				Run: exe.Completion,
+			},
+			{
+				Name:     "complete",
+				Synopsis: "list completions for a partial command line",
+				Usage:    []string{"goracle complete -- <word>..."},
+				Hidden:   true,
+				Run:      exe.Complete,
+			},
+		},
+	}
+}
+
+var broadcastFlag = flagDoc{Name: "broadcast", Usage: "send an updated assertion to every peer with an addr"}
+
+// lookup finds a command, and optionally one of its subcommands, by name.
+// It also returns the full name, as in "peers show".
+func (exe *Exe) lookup(names ...string) (command, string, error) {
+	c := exe.root()
+	path := []string{}
+	for _, name := range names {
+		s, ok := c.sub(name)
+		if !ok {
+			if len(path) == 0 {
+				return c, "", usageError(pear.Errorf("%w: %q", ErrUnknownCommand, name))
+			}
+			return c, strings.Join(path, " "), usageError(pear.Errorf("%w: %q", ErrUnknownCommand, strings.Join(append(path, name), " ")))
+		}
+		c = s
+		path = append(path, name)
+	}
+	return c, strings.Join(path, " "), nil
+}
+
+// Help explains goracle, or one of its commands.
+//
+//	goracle help [<command> [<subcommand>]]
+func (exe *Exe) Help(_ context.Context, _ hermeti.Env, args []string) (Result, error) {
+	if len(args) > 2 {
+		args = args[:2]
+	}
+	c, name, err := exe.lookup(args...)
+	if err != nil {
+		return nil, err
+	}
+	return helpResult(c, name), nil
+}
+
+// helpFor is the help for "goracle <command> ... -h".
+// The first word of args names a subcommand if it is one.
+func (exe *Exe) helpFor(name string, args []string) Result {
+	c, full, _ := exe.lookup(name)
+	if len(args) > 0 {
+		if s, ok := c.sub(args[0]); ok {
+			c, full = s, full+" "+s.Name
+		}
+	}
+	return helpResult(c, full)
+}
+
+// wantsHelp reports whether -h or --help appears before any "--"
+func wantsHelp(args []string) bool {
+	for _, arg := range args {
+		switch arg {
+		case "--":
+			return false
+		case "-h", "--h", "-help", "--help":
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/sean9999/gork"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHelp(t *testing.T) {
+
+	check := assert.New(t)
+
+	run := func(args ...string) (string, int) {
+		cli := SetupTestCLI(t)
+		cli.Env.Args = append([]string{"goracle"}, args...)
+		cli.Run(context.TODO())
+		r, err := cli.OutStream()
+		check.NoError(err)
+		out, _ := io.ReadAll(r)
+		return string(out), cli.Obj().ExitCode
+	}
+
+	out, code := run("help")
+	check.Equal(ExitOK, code)
+	check.Contains(out, "usage: goracle")
+	check.Contains(out, "peers")
+	check.NotContains(out, "complete ")
+
+	out, code = run("help", "peers", "show")
+	check.Equal(ExitOK, code)
+	check.Contains(out, "usage: goracle peers show <peer>")
+	check.Contains(out, "--priv <pem>")
+
+	//	-h is the same as help, and isn't mistaken for a bad flag
+	h, code := run("peers", "show", "-h")
+	check.Equal(ExitOK, code)
+	check.Equal(out, h)
+	h, _ = run("props", "--help")
+	check.Contains(h, "usage: goracle props")
+	check.Contains(h, "unset")
+
+	_, code = run("help", "frobnicate")
+	check.Equal(ExitUsage, code)
+
+}
+
+// every subcommand that's documented can be dispatched
+func TestRegistry(t *testing.T) {
+	for _, c := range new(Exe).root().Subcommands {
+		assert.NotNil(t, c.Run, c.Name)
+		for _, s := range c.Subcommands {
+			t.Run(c.Name+" "+s.Name, func(t *testing.T) {
+				cli := SetupTestCLI(t)
+				cli.Env.Args = []string{"goracle", c.Name, s.Name, "--priv", "nothing-here.pem"}
+				cli.Run(context.TODO())
+				if err := cli.Obj().Err; err != nil {
+					assert.NotContains(t, err.Error(), "unsupported subcommand")
+				}
+			})
+		}
+	}
+}
+
+func TestCompletion(t *testing.T) {
+
+	check := assert.New(t)
+	bob := gork.NewPrincipal(rand.Reader, nil, nil)
+	n := SetupTestNode(t, func(n *testNode) {
+		n.me.AddPeer(bob.AsPeer())
+		n.me.Props.Set("hometown", "wonderland")
+	})
+
+	complete := func(words ...string) []string {
+		_, out := n.exec(append([]string{"complete", "--"}, words...)...)
+		return strings.Fields(out)
+	}
+
+	check.Contains(complete(""), "peers")
+	check.NotContains(complete(""), "complete")
+	check.Equal([]string{"peers", "props", "ping"}, complete("p"))
+	check.Equal([]string{"list", "show", "drop", "set", "unset"}, complete("peers", ""))
+	check.Equal([]string{"json"}, complete("--format", "j"))
+	check.Equal([]string{"--format=yaml"}, complete("info", "--format=y"))
+	check.Contains(complete("peers", "show", "--"), "--priv")
+	check.Equal([]string{"show", "set"}, complete("help", "peers", "s"))
+	check.Equal([]string{"zsh"}, complete("completion", "z"))
+
+	peers := complete("peers", "show", "--priv", testPem, "--config", "conf.json", "")
+	check.Equal([]string{bob.Nickname(), bob.AsPeer().Grip()}, peers)
+	check.Equal([]string{"hometown"}, complete("props", "get", "--priv", testPem, "--config=conf.json", ""))
+
+	//	no key, no suggestions, no error
+	check.Empty(complete("peers", "show", "--priv", "nothing-here.pem", ""))
+	check.Equal(ExitOK, n.cli.Obj().ExitCode)
+
+	for _, shell := range []string{"bash", "zsh", "fish"} {
+		_, out := n.exec("completion", shell)
+		check.Contains(out, "goracle complete --", shell)
+	}
+
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"io"
+	"strings"
+
+	"github.com/sean9999/gork"
+	"github.com/sean9999/hermeti"
+	"github.com/sean9999/pear"
+)
+
+var ErrCompletion = pear.Defer("completion")
+
+// completionScripts call "goracle complete" with the words typed so far, the last being the one under the cursor.
+// When there's nothing to suggest, they fall back to file names.
+var completionScripts = map[string]string{
+	"bash": `# bash completion for goracle
+_goracle() {
+	local IFS=$'\n'
+	COMPREPLY=($(goracle complete -- "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null))
+}
+complete -o default -F _goracle goracle
+`,
+	"zsh": `#compdef goracle
+# zsh completion for goracle
+_goracle() {
+	local -a candidates
+	candidates=(${(f)"$(goracle complete -- "${(@)words[2,CURRENT]}" 2>/dev/null)"})
+	if (( ${#candidates} )); then
+		compadd -a candidates
+	else
+		_files
+	fi
+}
+if [ "$funcstack[1]" = "_goracle" ]; then
+	_goracle "$@"
+else
+	compdef _goracle goracle
+fi
+`,
+	"fish": `# fish completion for goracle
+function __goracle_complete
+	set -l words (commandline -opc) (commandline -ct)
+	goracle complete -- $words[2..-1] 2>/dev/null
+end
+complete -c goracle -f -n 'test (count (__goracle_complete)) -gt 0' -a '(__goracle_complete)'
+complete -c goracle -F -n 'test (count (__goracle_complete)) -eq 0'
+`,
+}
+
+// Completion writes a completion script for a shell.
+//
+//	goracle completion bash|zsh|fish
+func (exe *Exe) Completion(_ context.Context, _ hermeti.Env, args []string) (Result, error) {
+	if len(args) == 0 {
+		return nil, usageError(pear.Errorf("%w: which shell?", ErrCompletion))
+	}
+	script, ok := completionScripts[args[0]]
+	if !ok {
+		return nil, usageError(pear.Errorf("%w: unsupported shell: %q", ErrCompletion, args[0]))
+	}
+	return ScriptResult{Shell: args[0], Script: script}, nil
+}
+
+// Complete lists what the last word of a partial command line could be.
+// Peers and properties come from the config named by --priv and --config on that command line, or the defaults.
+//
+//	goracle complete -- <word>...
+func (exe *Exe) Complete(ctx context.Context, env hermeti.Env, args []string) (Result, error) {
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
+	current := ""
+	if len(args) > 0 {
+		current = args[len(args)-1]
+		args = args[:len(args)-1]
+	}
+
+	//	walk the words typed so far, descending into commands and collecting flag values
+	root := exe.root()
+	c := root
+	positional := []string{}
+	values := map[string]string{}
+	var pending *flagDoc
+	for _, word := range args {
+		if pending != nil {
+			values[pending.Name] = word
+			pending = nil
+			continue
+		}
+		if strings.HasPrefix(word, "-") && word != "-" {
+			name, value, hasValue := strings.Cut(strings.TrimLeft(word, "-"), "=")
+			f, known := findFlag(c, name)
+			switch {
+			case hasValue:
+				values[name] = value
+			case known && f.Arg != "":
+				pending = &f
+			}
+			continue
+		}
+		if len(positional) == 0 {
+			if s, ok := c.sub(word); ok {
+				c = s
+				continue
+			}
+		}
+		positional = append(positional, word)
+	}
+
+	res := CandidatesResult{}
+	add := func(candidates ...string) {
+		for _, candidate := range candidates {
+			if strings.HasPrefix(candidate, current) {
+				res = append(res, candidate)
+			}
+		}
+	}
+
+	switch {
+	case pending != nil:
+		add(pending.Values...)
+	case strings.HasPrefix(current, "-"):
+		name, _, hasValue := strings.Cut(strings.TrimLeft(current, "-"), "=")
+		if hasValue {
+			if f, known := findFlag(c, name); known {
+				for _, v := range f.Values {
+					add("--" + name + "=" + v)
+				}
+			}
+			break
+		}
+		for _, f := range append(c.AllFlags(), globalFlags...) {
+			add("--" + f.Name)
+		}
+	case len(positional) == 0 && len(c.Subcommands) > 0:
+		add(visible(c)...)
+	default:
+		switch c.arg(len(positional)) {
+		case argCommand:
+			if len(positional) == 0 {
+				add(visible(root)...)
+			} else if s, ok := root.sub(positional[0]); ok && len(positional) == 1 {
+				add(visible(s)...)
+			}
+		case argShell:
+			add("bash", "fish", "zsh")
+		case argPeer:
+			if self := exe.completingSelf(ctx, env, values); self != nil {
+				for _, peer := range self.Peers {
+					add(peer.Nickname(), peer.Grip())
+				}
+			}
+		case argNearby:
+			if self := exe.completingSelf(ctx, env, values); self != nil {
+				sightings, _ := exe.nearby(hermeti.Env{ErrStream: io.Discard})
+				for _, s := range sightings {
+					add(s.peer.Nickname(), s.peer.Grip())
+				}
+			}
+		case argRequest:
+			if self := exe.completingSelf(ctx, env, values); self != nil {
+				requests, _ := exe.sightings(hermeti.Env{ErrStream: io.Discard}, exe.Pending)
+				for _, s := range requests {
+					add(s.peer.Nickname(), s.peer.Grip())
+				}
+			}
+		case argMail:
+			if self := exe.completingSelf(ctx, env, values); self != nil {
+				all, _ := exe.Mail.List()
+				for _, m := range all {
+					add(m.ID[:8])
+				}
+			}
+		case argProp:
+			if self := exe.completingSelf(ctx, env, values); self != nil && self.Props != nil {
+				for pair := self.Props.Oldest(); pair != nil; pair = pair.Next() {
+					if !gork.IsReservedProp(pair.Key) {
+						add(pair.Key)
+					}
+				}
+			}
+		}
+	}
+	return res, nil
+}
+
+// completingSelf loads ourselves for the sake of completion. Failure just means nothing to suggest.
+func (exe *Exe) completingSelf(ctx context.Context, env hermeti.Env, values map[string]string) *gork.Principal {
+	args := []string{}
+	for _, f := range selfFlags {
+		if v, ok := values[f.Name]; ok {
+			args = append(args, "--"+f.Name, v)
+		}
+	}
+	_, err := exe.ensureSelf(ctx, env, args)
+	if err != nil {
+		return nil
+	}
+	return exe.Self
+}
+
+// findFlag finds a flag that a command, or goracle itself, takes
+func findFlag(c command, name string) (flagDoc, bool) {
+	for _, f := range append(c.AllFlags(), globalFlags...) {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return flagDoc{}, false
+}
+
+// visible names the subcommands of c that aren't hidden
+func visible(c command) []string {
+	names := []string{}
+	for _, s := range c.Subcommands {
+		if !s.Hidden {
+			names = append(names, s.Name)
+		}
+	}
+	return names
+}
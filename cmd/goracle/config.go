@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+
+	"github.com/sean9999/gork"
+	"github.com/sean9999/hermeti"
+	"github.com/sean9999/pear"
+)
+
+var ErrConfig = pear.Defer("config")
+
+// Conf dispatches subcommands that operate on config files, such as "goracle config convert"
+func (cmd *Exe) Conf(ctx context.Context, env hermeti.Env, args []string) (Result, error) {
+
+	if len(args) == 0 {
+		return nil, usageError(pear.Errorf("%w: missing subcommand", ErrConfig))
+	}
+
+	subcommands := map[string]subcommand{
+		"convert": cmd.Convert,
+		"encrypt": cmd.Encrypt,
+		"decrypt": cmd.Decrypt,
+		"merge":   cmd.Merge,
+	}
+
+	fn, exists := subcommands[args[0]]
+	if !exists {
+		return nil, usageError(pear.Errorf("%w: unsupported subcommand: %q", ErrConfig, args[0]))
+	}
+	return fn(ctx, env, args[1:])
+}
+
+// Convert reads a config file and writes it out in another format.
+//
+//	goracle config convert [--to json|yaml|msgpack] <in> [out]
+//
+// Formats are inferred from file extensions. If out is omitted, the result goes to stdout.
+func (cmd *Exe) Convert(_ context.Context, env hermeti.Env, args []string) (Result, error) {
+
+	fset := flag.NewFlagSet("convert", flag.ContinueOnError)
+	fset.SetOutput(io.Discard)
+	to := fset.String("to", "", "output format: json, yaml, or msgpack")
+	err := fset.Parse(args)
+	if err != nil {
+		return nil, usageError(err)
+	}
+	if fset.NArg() < 1 {
+		return nil, usageError(pear.Errorf("%w: convert requires an input file", ErrConfig))
+	}
+
+	src := gork.FileBasedConfigProvider{
+		Fs:   env.Filesystem,
+		Name: expandPath(env, fset.Arg(0)),
+	}
+	conf, err := src.Get()
+	if err != nil {
+		return nil, fmt.Errorf("%w: could not read %s: %w", ErrConfig, src.Name, err)
+	}
+
+	var format gork.Format
+	if *to != "" {
+		format, err = gork.ParseFormat(*to)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	//	no output file means stdout
+	if fset.NArg() < 2 {
+		buf, err := format.Marshal(conf)
+		if err != nil {
+			return nil, err
+		}
+		if format == "" {
+			format = gork.FormatJSON
+		}
+		return ConvertResult{In: src.Name, To: string(format), Data: string(buf)}, nil
+	}
+
+	dst := gork.FileBasedConfigProvider{
+		Fs:     env.Filesystem,
+		Name:   expandPath(env, fset.Arg(1)),
+		Format: format,
+	}
+	err = dst.Set(conf)
+	if err != nil {
+		return nil, fmt.Errorf("%w: could not write %s: %w", ErrConfig, dst.Name, err)
+	}
+	if format == "" {
+		format = gork.FormatFromName(dst.Name)
+	}
+	return ConvertResult{In: src.Name, Out: dst.Name, To: string(format)}, nil
+}
+
+// Encrypt turns on encryption at rest for an existing config, encrypting it to our own key.
+// The change log is removed, since it's kept in plain text.
+//
+//	goracle config encrypt --priv <pem> --config <file>
+func (cmd *Exe) Encrypt(ctx context.Context, env hermeti.Env, args []string) (Result, error) {
+	return cmd.setEncryption(ctx, env, args, true)
+}
+
+// Decrypt turns off encryption at rest for an existing config.
+//
+//	goracle config decrypt --priv <pem> --config <file>
+func (cmd *Exe) Decrypt(ctx context.Context, env hermeti.Env, args []string) (Result, error) {
+	return cmd.setEncryption(ctx, env, args, false)
+}
+
+func (cmd *Exe) setEncryption(ctx context.Context, env hermeti.Env, args []string, on bool) (Result, error) {
+
+	args, err := cmd.ensureSelf(ctx, env, args)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrConfig, err)
+	}
+	if cmd.Config == nil {
+		return nil, notFound(pear.Errorf("%w: no config file", ErrConfig))
+	}
+
+	var plain gork.FileBasedConfigProvider
+	switch prov := cmd.Config.(type) {
+	case gork.FileBasedConfigProvider:
+		plain = prov
+	case gork.EncryptedConfigProvider:
+		plain = prov.FileBasedConfigProvider
+	default:
+		return nil, pear.Errorf("%w: config is not a file", ErrConfig)
+	}
+
+	var prov gork.ConfigProvider = plain
+	if on {
+		prov = gork.EncryptedConfigProvider{
+			FileBasedConfigProvider: plain,
+			Owner:                   cmd.Self,
+		}
+		//	the change log is plain text, and would give away what the config says
+		cmd.Self.Journal = nil
+	}
+
+	err = cmd.Self.Save(prov)
+	if err != nil {
+		return nil, fmt.Errorf("%w: could not save: %w", ErrConfig, err)
+	}
+	if on {
+		err = env.Filesystem.Remove(plain.Name + ".log")
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("%w: could not remove change log: %w", ErrConfig, err)
+		}
+	}
+	cmd.Config = prov
+	cmd.Self.ConfigProvider = prov
+	return configResult(prov)
+}
+
+// Merge merges another copy of our config, such as one from another device, into ours.
+// If the common ancestor of the two is known, pass it as base to get a true three-way merge.
+// Conflicts are resolved deterministically and reported.
+//
+//	goracle config merge --priv <pem> --config <file> <other> [base]
+func (cmd *Exe) Merge(ctx context.Context, env hermeti.Env, args []string) (Result, error) {
+
+	args, err := cmd.ensureSelf(ctx, env, args)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrConfig, err)
+	}
+	if cmd.Config == nil {
+		return nil, notFound(pear.Errorf("%w: no config file", ErrConfig))
+	}
+	if len(args) == 0 {
+		return nil, usageError(pear.Errorf("%w: merge requires a config to merge", ErrConfig))
+	}
+
+	read := func(name string) (*gork.Config, error) {
+		conf, err := gork.OpenConfigFile(env.Filesystem, expandPath(env, name), cmd.Self).Get()
+		if err != nil {
+			return nil, fmt.Errorf("%w: could not read %s: %w", ErrConfig, name, err)
+		}
+		return conf, nil
+	}
+
+	theirs, err := read(args[0])
+	if err != nil {
+		return nil, err
+	}
+	var base *gork.Config
+	if len(args) > 1 {
+		base, err = read(args[1])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	conflicts, err := cmd.Self.Merge(base, theirs)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrConfig, err)
+	}
+
+	err = cmd.Self.Save(cmd.Config)
+	if err != nil {
+		return nil, fmt.Errorf("%w: could not save: %w", ErrConfig, err)
+	}
+	return MergeResult{args[0], conflicts}, nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"io"
+	"testing"
+
+	"github.com/sean9999/gork"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigConvert(t *testing.T) {
+
+	check := assert.New(t)
+	cli := SetupTestCLI(t)
+
+	src := gork.FileBasedConfigProvider{Fs: cli.Env.Filesystem, Name: "../../testdata/late-silence.config.json"}
+	conf, err := src.Get()
+	check.NoError(err)
+	want, err := gork.FormatJSON.Marshal(conf)
+	check.NoError(err)
+
+	//	json to yaml
+	cli.Env.Args = []string{"goracle", "config", "convert", src.Name, "conf.yaml"}
+	cli.Run(context.TODO())
+
+	yml, err := afero.ReadFile(cli.Env.Filesystem, "conf.yaml")
+	check.NoError(err)
+	check.Contains(string(yml), "pub: "+conf.Pub.ToHex())
+	check.Contains(string(yml), "nick: aged-smoke")
+
+	//	yaml to msgpack
+	cli.Env.Args = []string{"goracle", "config", "convert", "conf.yaml", "conf.msgpack"}
+	cli.Run(context.TODO())
+	exists, _ := afero.Exists(cli.Env.Filesystem, "conf.msgpack")
+	check.True(exists)
+
+	//	msgpack to stdout, as json. Nothing is lost along the way.
+	cli.Env.Args = []string{"goracle", "config", "convert", "--to", "json", "conf.msgpack"}
+	cli.Run(context.TODO())
+	r, err := cli.OutStream()
+	check.NoError(err)
+	got, err := io.ReadAll(r)
+	check.NoError(err)
+	check.Equal(string(want), string(got))
+
+}
+
+func TestConfigEncrypt(t *testing.T) {
+
+	check := assert.New(t)
+
+	//	start with a plain config
+	n := SetupTestNode(t, func(n *testNode) {
+		n.me.Journal = gork.FileJournal{Fs: n.cli.Env.Filesystem, Name: "conf.json.log"}
+		n.me.Props.Set("hometown", "wonderland")
+	})
+	fs, plain := n.cli.Env.Filesystem, n.prov
+
+	n.exec("config", "encrypt", "--priv", testPem, "--config", plain.Name)
+	check.True(plain.IsEncrypted())
+	exists, _ := afero.Exists(fs, "conf.json.log")
+	check.False(exists, "the change log would give the config away")
+
+	//	a tampered config is refused, encrypted or not
+	b, _ := afero.ReadFile(fs, plain.Name)
+	b[len(b)/2] ^= 1
+	afero.WriteFile(fs, "tampered.json", b, 0600)
+	exe, _ := n.exec("peers", "--priv", testPem, "--config", "tampered.json")
+	check.Equal(ExitBadSignature, exe.ExitCode)
+
+	//	encryption is transparent to other subcommands
+	exe, _ = n.run("info")
+	check.IsType(gork.EncryptedConfigProvider{}, exe.Config)
+
+	n.exec("config", "decrypt", "--priv", testPem, "--config", plain.Name)
+	check.False(plain.IsEncrypted())
+	conf, err := plain.Get()
+	check.NoError(err)
+	check.NoError(n.me.VerifyConfig(conf))
+
+}
+
+func TestConfigMerge(t *testing.T) {
+
+	check := assert.New(t)
+	cli := SetupTestCLI(t)
+	pem := testPem
+
+	//	the same identity on two devices
+	device := func() gork.Principal {
+		fd, err := cli.Env.Filesystem.Open(pem)
+		check.NoError(err)
+		me := gork.NewPrincipal(cli.Env.Randomness, nil, nil)
+		check.NoError(me.FromPem(fd))
+		return me
+	}
+	laptop, desktop := device(), device()
+	bob := gork.NewPrincipal(rand.Reader, nil, nil)
+	carol := gork.NewPrincipal(rand.Reader, nil, nil)
+
+	laptop.AddPeer(bob.AsPeer())
+	laptop.Props.Set("editor", "vim")
+	check.NoError(laptop.Save(gork.FileBasedConfigProvider{Fs: cli.Env.Filesystem, Name: "laptop.json"}))
+	desktop.AddPeer(carol.AsPeer())
+	desktop.Props.Set("editor", "emacs")
+	check.NoError(desktop.Save(gork.FileBasedConfigProvider{Fs: cli.Env.Filesystem, Name: "desktop.json"}))
+
+	cli.Env.Args = []string{"goracle", "config", "merge", "--priv", pem, "--config", "laptop.json", "desktop.json"}
+	cli.Run(context.TODO())
+
+	r, err := cli.OutStream()
+	check.NoError(err)
+	out, err := io.ReadAll(r)
+	check.NoError(err)
+	check.Contains(string(out), "conflict: editor")
+	check.Contains(string(out), "merged desktop.json with 1 conflicts")
+
+	conf, err := gork.FileBasedConfigProvider{Fs: cli.Env.Filesystem, Name: "laptop.json"}.Get()
+	check.NoError(err)
+	check.NoError(laptop.VerifyConfig(conf))
+	check.Len(*conf.Peers, 2)
+	editor, _ := conf.Props.Get("editor")
+	check.Equal("vim", editor)
+
+}
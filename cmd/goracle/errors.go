@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"github.com/sean9999/gork"
+)
+
+// Exit codes. Scripts can rely on these.
+const (
+	ExitOK = 0
+	// ExitFailure is anything not covered below
+	ExitFailure = 1
+	// ExitUsage means the command line was wrong
+	ExitUsage = 2
+	// ExitNotFound means a file, config, peer, or property doesn't exist
+	ExitNotFound = 3
+	// ExitBadSignature means something didn't verify, or couldn't be decrypted
+	ExitBadSignature = 4
+	// ExitIO means a file couldn't be read or written
+	ExitIO = 5
+)
+
+// an CLIError is an error with an exit code
+type CLIError struct {
+	Msg      string
+	ExitCode int
+	Child    error
+}
+
+func (o *CLIError) Error() string {
+	switch {
+	case o.Child == nil:
+		return o.Msg
+	case o.Msg == "":
+		return o.Child.Error()
+	default:
+		return fmt.Sprintf("%s: %s", o.Msg, o.Child)
+	}
+}
+
+func (o *CLIError) Wrap(child error) {
+	o.Child = child
+}
+
+func (o *CLIError) Unwrap() error {
+	return o.Child
+}
+
+// usageError marks an error as the fault of the command line
+func usageError(err error) error {
+	return &CLIError{ExitCode: ExitUsage, Child: err}
+}
+
+// notFound marks an error as something missing
+func notFound(err error) error {
+	return &CLIError{ExitCode: ExitNotFound, Child: err}
+}
+
+// exitCode maps an error to an exit code.
+// A [CLIError] says what its code is. Anything else is classified by what it wraps.
+func exitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+	var cliErr *CLIError
+	if errors.As(err, &cliErr) && cliErr.ExitCode != ExitOK {
+		return cliErr.ExitCode
+	}
+	var pathErr *fs.PathError
+	switch {
+	case errors.Is(err, gork.ErrBadSignature),
+		errors.Is(err, gork.ErrConfigDecrypt),
+		errors.Is(err, gork.ErrLogTampered),
+		errors.Is(err, gork.ErrLogTruncated),
+		errors.Is(err, gork.ErrNeedPassphrase),
+		errors.Is(err, gork.ErrPassphrase),
+		errors.Is(err, gork.ErrNotMessage):
+		return ExitBadSignature
+	case errors.Is(err, os.ErrNotExist),
+		errors.Is(err, gork.ErrPeerNotFound),
+		errors.Is(err, gork.ErrMailNotFound):
+		return ExitNotFound
+	case errors.Is(err, flag.ErrHelp),
+		errors.Is(err, gork.ErrBadAssertion),
+		errors.Is(err, gork.ErrAmbiguousPeer),
+		errors.Is(err, gork.ErrAmbiguousMail),
+		errors.Is(err, gork.ErrReservedProp),
+		errors.Is(err, gork.ErrUnknownFormat),
+		errors.Is(err, ErrOutputFormat):
+		return ExitUsage
+	case errors.As(err, &pathErr),
+		errors.Is(err, gork.ErrLocked),
+		errors.Is(err, os.ErrDeadlineExceeded):
+		return ExitIO
+	default:
+		return ExitFailure
+	}
+}
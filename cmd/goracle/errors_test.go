@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/sean9999/gork"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExitCodes(t *testing.T) {
+
+	pem := testPem
+
+	//	a config with a change log, whose first entry has been tampered with
+	setup := func(t *testing.T) *testNode {
+		n := SetupTestNode(t, func(n *testNode) {
+			n.me.Journal = gork.FileJournal{Fs: n.cli.Env.Filesystem, Name: "conf.json.log"}
+			n.me.Props.Set("hometown", "wonderland")
+		})
+		fs := n.cli.Env.Filesystem
+		b, _ := afero.ReadFile(fs, "conf.json.log")
+		b = []byte(strings.Replace(string(b), "wonderland", "looking-glass", 1))
+		afero.WriteFile(fs, "tampered.json.log", b, 0600)
+		b, _ = afero.ReadFile(fs, "conf.json")
+		afero.WriteFile(fs, "tampered.json", b, 0600)
+		b = []byte(strings.Replace(string(b), "wonderland", "looking-glass", 1))
+		afero.WriteFile(fs, "forged.json", b, 0600)
+		return n
+	}
+
+	cases := []struct {
+		name string
+		args []string
+		code int
+	}{
+		{"success", []string{"info", "--priv", pem}, ExitOK},
+		{"unknown command", []string{"frobnicate"}, ExitUsage},
+		{"unknown format", []string{"--format=xml", "info", "--priv", pem}, ExitUsage},
+		{"unknown flag", []string{"info", "--bogus"}, ExitUsage},
+		{"missing argument", []string{"peers", "show", "--priv", pem, "--config", "conf.json"}, ExitUsage},
+		{"missing key file", []string{"info", "--priv", "nothing-here.pem"}, ExitNotFound},
+		{"missing peer", []string{"peers", "show", "--priv", pem, "--config", "conf.json", "nobody"}, ExitNotFound},
+		{"missing prop", []string{"props", "get", "--priv", pem, "--config", "conf.json", "nothing"}, ExitNotFound},
+		{"tampered log", []string{"log", "verify", "--priv", pem, "--config", "tampered.json"}, ExitBadSignature},
+		{"tampered config", []string{"peers", "--priv", pem, "--config", "forged.json"}, ExitBadSignature},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			n := setup(t)
+			exe, _ := n.exec(c.args...)
+			assert.Equal(t, c.code, exe.ExitCode, exe.Err)
+
+			r, err := n.cli.ErrStream()
+			assert.NoError(t, err)
+			stderr, _ := io.ReadAll(r)
+			if c.code == ExitOK {
+				assert.Empty(t, stderr)
+			} else {
+				assert.NotEmpty(t, stderr)
+			}
+			if c.code == ExitUsage {
+				assert.Contains(t, string(stderr), "usage: goracle")
+			}
+		})
+	}
+
+}
+
+func TestCLIError(t *testing.T) {
+	child := errors.New("child")
+	err := &CLIError{Msg: "parent", ExitCode: ExitIO, Child: child}
+	assert.Equal(t, child, errors.Unwrap(err))
+	assert.ErrorIs(t, err, child)
+	assert.Equal(t, "parent: child", err.Error())
+	assert.Equal(t, "child", usageError(child).Error())
+	//	codes survive being wrapped
+	assert.Equal(t, ExitIO, exitCode(fmt.Errorf("%w: %w", ErrPeers, err)))
+}
@@ -2,23 +2,19 @@ package main
 
 import (
 	"context"
+	"encoding/hex"
 	"fmt"
 
 	"github.com/sean9999/hermeti"
-	"github.com/sean9999/pear"
 )
 
-func (cmd *Exe) Export(ctx context.Context, env hermeti.Env, args []string) ([]string, error) {
+func (cmd *Exe) Export(ctx context.Context, env hermeti.Env, args []string) (Result, error) {
 
-	args, err := cmd.ensureSelf(ctx, env, args)
+	_, err := cmd.ensureSelf(ctx, env, args)
 	if err != nil {
-		return nil, pear.Errorf("%w: %w. Could not ensure self.", ErrAssert, err)
+		return nil, fmt.Errorf("%w: %w. Could not ensure self.", ErrAssert, err)
 	}
 
-	msg := cmd.Self.Bytes()
-
-	fmt.Fprintf(env.OutStream, "%X\n", msg)
-
-	return args, nil
+	return ExportResult{hex.EncodeToString(cmd.Self.Bytes())}, nil
 
 }
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/sean9999/gork"
+	"github.com/sean9999/hermeti"
+	"github.com/sean9999/pear"
+)
+
+var ErrInbox = pear.Defer("inbox")
+
+// Inbox lists the messages goracled has received for us, newest first
+//
+//	goracle inbox [--from <peer>] [--thread <id>] [--unread]
+func (cmd *Exe) Inbox(ctx context.Context, env hermeti.Env, args []string) (Result, error) {
+
+	fset := flag.NewFlagSet("inbox", flag.ContinueOnError)
+	from := fset.String("from", "", "only messages from this peer")
+	thread := fset.String("thread", "", "only messages in this thread")
+	unread := fset.Bool("unread", false, "only messages not yet read")
+	_, err := cmd.ensureSelfWith(ctx, env, args, fset)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInbox, err)
+	}
+
+	sender := ""
+	if *from != "" {
+		peer, err := cmd.Self.FindPeer(*from)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInbox, err)
+		}
+		sender = peer.ToHex()
+	}
+
+	all, err := cmd.Mail.List()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInbox, err)
+	}
+	res := InboxResult{}
+	for _, m := range all {
+		switch {
+		case sender != "" && m.From != sender,
+			*thread != "" && !strings.HasPrefix(m.Thread, *thread),
+			*unread && m.Read:
+			continue
+		}
+		res = append(res, cmd.mailResult(m))
+	}
+	return res, nil
+}
+
+// Read shows a message from our inbox, and marks it read
+//
+//	goracle read <id>
+func (cmd *Exe) Read(ctx context.Context, env hermeti.Env, args []string) (Result, error) {
+
+	args, err := cmd.ensureSelf(ctx, env, args)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInbox, err)
+	}
+	if len(args) != 1 {
+		return nil, usageError(pear.Errorf("%w: read which message?", ErrInbox))
+	}
+	m, err := cmd.Mail.Get(args[0])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInbox, err)
+	}
+	msg, err := cmd.Self.OpenMail(m)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInbox, err)
+	}
+	err = cmd.Mail.MarkRead(m.ID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInbox, err)
+	}
+	m.Read = true
+	return ReadResult{MailResult: cmd.mailResult(m), Body: string(msg.PlainText)}, nil
+}
+
+// mailResult describes mail, naming the sender as we know them
+func (cmd *Exe) mailResult(m gork.Mail) MailResult {
+	res := MailResult{
+		ID:       m.ID,
+		From:     m.From,
+		Thread:   m.Thread,
+		Received: m.Received,
+		Read:     m.Read,
+	}
+	if b, err := hex.DecodeString(m.From); err == nil {
+		res.Nickname = gork.NewPeer(b).Nickname()
+	}
+	return res
+}
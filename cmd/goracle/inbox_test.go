@@ -0,0 +1,57 @@
+package main
+
+import (
+	"crypto/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sean9999/gork"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInbox(t *testing.T) {
+
+	check := assert.New(t)
+	bob := gork.NewPrincipal(rand.Reader, nil, nil)
+	carol := gork.NewPrincipal(rand.Reader, nil, nil)
+	n := SetupTestNode(t, func(n *testNode) {
+		n.me.AddPeer(bob.AsPeer())
+		n.me.AddPeer(carol.AsPeer())
+	})
+	me := n.me
+
+	//	goracled has received a message from each of them
+	inbox := gork.InboxFile(n.cli.Env.Filesystem, n.prov.Name)
+	ids := map[string]string{}
+	for i, p := range []gork.Principal{bob, carol} {
+		msg, err := p.Seal(me.AsPeer(), []byte("hello from "+p.Nickname()), nil)
+		check.NoError(err)
+		_, err = inbox.Deliver(gork.NewMail(msg, time.Now().Add(time.Duration(i)*time.Second)))
+		check.NoError(err)
+		ids[p.Nickname()] = gork.MessageID(msg)
+	}
+
+	_, out := n.run("inbox")
+	check.Contains(out, bob.Nickname())
+	check.Contains(out, carol.Nickname())
+	check.Less(strings.Index(out, carol.Nickname()), strings.Index(out, bob.Nickname()))
+	_, out = n.run("inbox", "--from", bob.Nickname())
+	check.Contains(out, bob.Nickname())
+	check.NotContains(out, carol.Nickname())
+
+	exe, out := n.run("read", ids[bob.Nickname()][:8])
+	check.NoError(exe.Err)
+	check.Contains(out, "hello from "+bob.Nickname())
+
+	//	bob's message is read now
+	_, out = n.run("inbox", "--unread")
+	check.NotContains(out, bob.Nickname())
+	check.Contains(out, carol.Nickname())
+
+	exe, _ = n.run("read", "ffffffff")
+	check.Equal(ExitNotFound, exe.ExitCode)
+	exe, _ = n.run("read")
+	check.Equal(ExitUsage, exe.ExitCode)
+
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"errors"
 	"flag"
@@ -12,110 +13,188 @@ import (
 
 	"github.com/sean9999/gork"
 	"github.com/sean9999/hermeti"
+	"github.com/sean9999/pear"
 	"github.com/spf13/afero"
 )
 
-var privOut io.Writer = nil
-var pubOut io.Writer = nil
-var confOut afero.File = nil
+var ErrInit = pear.Defer("could not initialize")
 
-func resolvePath(s string) (string, error) {
-	bits := strings.Split(s, afero.FilePathSeparator)
-	if bits[0] == "~" {
-		home, err := os.UserHomeDir()
-		if err != nil {
-			return "", err
-		}
-		bits[0] = home
-		s = filepath.Join(bits...)
-	}
-	return s, nil
-}
+// PassphraseVar names the environment variable holding the passphrase that protects our private key
+const PassphraseVar = "GORACLE_PASSPHRASE"
 
-// gork init initializes a [gork.Principal]
-func (cmd *Exe) Init(ctx context.Context, env hermeti.Env, args []string) ([]string, error) {
+// the files that make up an identity, as written by "goracle init -o"
+const (
+	privFile = "priv.pem"
+	pubFile  = "pub.pem"
+	confFile = "config.json"
+)
 
-	//	the default output stream is whatever the env says
-	privOut = env.OutStream
-	pubOut = env.OutStream
+// Init creates a new key pair.
+// With -o, it's written to a directory along with a signed config, unless that would replace an existing identity.
+// With -i, it asks for properties and a passphrase. Otherwise the passphrase, if any, comes from $GORACLE_PASSPHRASE.
+//
+//	goracle init [-o[=<dir>]] [--force] [-i] [--prop <key>=<value>]...
+func (cmd *Exe) Init(ctx context.Context, env hermeti.Env, args []string) (Result, error) {
 
-	fset := flag.NewFlagSet("dirfinder", flag.ContinueOnError)
-	fset.BoolFunc("o", "directory in which to save keys", func(s string) error {
+	dir := ""
+	props := gork.NewKV()
 
+	fset := flag.NewFlagSet("init", flag.ContinueOnError)
+	fset.SetOutput(io.Discard)
+	fset.BoolFunc("o", "directory in which to save keys", func(s string) error {
 		if s == "" || s == "true" {
-			path, err := resolvePath(DefaultDirectory)
-			if err != nil {
-				return err
-			}
-			s = path
+			s = DefaultDirectory
 		}
-
-		stat, err := os.Stat(s)
-		if err != nil {
-			return fmt.Errorf("%w: %s", err, s)
-		}
-		if !stat.IsDir() {
-			return errors.New("not a dir")
-		}
-		//	the overridden output stream is whatever directory the command-line flag says
-		privOut, err = os.Create(filepath.Join(s, "priv.pem"))
-		if err != nil {
-			return err
-		}
-		pubOut, err = os.Create(filepath.Join(s, "pub.pem"))
-		if err != nil {
-			return err
-		}
-		confOut, err = os.Create(filepath.Join(s, "conf.json"))
-		if err != nil {
-			return err
+		dir = s
+		return nil
+	})
+	force := fset.Bool("force", false, "replace an existing identity")
+	interactive := fset.Bool("i", false, "ask for properties and a passphrase")
+	fset.Func("prop", "a property, as key=value", func(s string) error {
+		k, v, ok := strings.Cut(s, "=")
+		if !ok || k == "" {
+			return fmt.Errorf("expected key=value but got %q", s)
 		}
+		props.Set(k, v)
 		return nil
 	})
 	err := fset.Parse(args)
 	if err != nil {
-		fmt.Fprintln(env.ErrStream, err)
-		return args, err
+		return nil, usageError(err)
 	}
-
-	prov := gork.FileBasedConfigProvider{
-		Fs:   afero.NewOsFs(),
-		Name: "conf.json",
+	if fset.NArg() > 0 {
+		return nil, usageError(pear.Errorf("%w: unexpected argument: %q", ErrInit, fset.Arg(0)))
 	}
-	p := gork.Principal{}
-	if confOut == nil {
-		p = gork.NewPrincipal(env.Randomness, nil, nil)
-	} else {
-		p = gork.NewPrincipal(env.Randomness, nil, prov)
+
+	passphrase := env.Vars[PassphraseVar]
+	if *interactive {
+		passphrase, err = wizard(env, props, passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInit, err)
+		}
 	}
 
+	p := gork.NewPrincipal(env.Randomness, nil, nil)
+	for pair := props.Oldest(); pair != nil; pair = pair.Next() {
+		err = p.SetProp(pair.Key, pair.Value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInit, err)
+		}
+	}
 	cmd.Self = &p
 
-	privPem, err := p.MarshalPEM()
+	var privPem []byte
+	if passphrase == "" {
+		privPem, err = p.MarshalPEM()
+	} else {
+		privPem, err = p.MarshalEncryptedPEM(env.Randomness, []byte(passphrase))
+	}
 	if err != nil {
-		fmt.Fprintln(env.ErrStream, err)
-		return args, err
+		return nil, err
 	}
 
 	pubPem, err := p.AsPeer().MarshalPEM()
 	if err != nil {
-		fmt.Fprintln(env.ErrStream, err)
-		return args, err
+		return nil, err
 	}
 
-	// err = exe.Self.Save(exe.ConfigFile)
-	// if err != nil {
-	// 	return nil, pear.Errorf("couldn't save: %w", err)
-	// }
+	res := KeysResult{
+		Nickname:  p.Nickname(),
+		Grip:      p.AsPeer().Grip(),
+		Pubkey:    p.PublicKey().ToHex(),
+		Protected: passphrase != "",
+		art:       p.Art(),
+	}
+
+	//	keys go in the result, unless there's a directory to put them in
+	if dir == "" {
+		res.PrivPEM = string(privPem)
+		res.PubPEM = string(pubPem)
+		return res, nil
+	}
 
-	//p.Save()
+	dir = expandPath(env, dir)
+	fs := env.Filesystem
+	priv, pub, conf := filepath.Join(dir, privFile), filepath.Join(dir, pubFile), filepath.Join(dir, confFile)
 
-	fmt.Fprintf(privOut, "%s\n", privPem)
-	fmt.Fprintf(pubOut, "%s\n", pubPem)
+	if !*force {
+		for _, name := range []string{priv, pub, conf} {
+			if _, err := fs.Stat(name); err == nil {
+				return nil, fmt.Errorf("%w: %w: %s. Use --force to replace it", ErrInit, os.ErrExist, name)
+			}
+		}
+	}
 
-	if fpriv, ok := privOut.(afero.File); ok {
-		os.Chmod(fpriv.Name(), 0400)
+	err = fs.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInit, err)
+	}
+
+	//	start afresh. The change log belongs to whatever identity is being replaced.
+	for _, name := range []string{priv, pub, conf, conf + ".log"} {
+		err = fs.Remove(name)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("%w: %w", ErrInit, err)
+		}
+	}
+
+	err = afero.WriteFile(fs, priv, privPem, 0400)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInit, err)
+	}
+	err = afero.WriteFile(fs, pub, pubPem, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInit, err)
 	}
 
-	return args, nil
+	prov := gork.FileBasedConfigProvider{Fs: fs, Name: conf}
+	p.ConfigProvider = prov
+	p.Journal = gork.FileJournal{Fs: fs, Name: conf + ".log", Origin: "goracle init"}
+	err = p.Save(prov)
+	if err != nil {
+		return nil, fmt.Errorf("%w: could not save config: %w", ErrInit, err)
+	}
+	cmd.Config = prov
+
+	res.Dir = dir
+	res.Config = conf
+	return res, nil
+}
+
+// wizard asks for properties and a passphrase, prompting on stderr and reading lines from stdin.
+// A passphrase that's already known isn't asked for.
+func wizard(env hermeti.Env, props *gork.KV, passphrase string) (string, error) {
+	in := bufio.NewScanner(env.InStream)
+	ask := func(prompt string) string {
+		fmt.Fprint(env.ErrStream, prompt)
+		if !in.Scan() {
+			return ""
+		}
+		return strings.TrimSpace(in.Text())
+	}
+
+	fmt.Fprintln(env.ErrStream, "Describe yourself with properties such as addr=host:port. A blank line finishes.")
+	for {
+		line := ask("property: ")
+		if line == "" {
+			break
+		}
+		k, v, ok := strings.Cut(line, "=")
+		switch {
+		case !ok || k == "":
+			fmt.Fprintf(env.ErrStream, "expected key=value but got %q\n", line)
+		case gork.IsReservedProp(k):
+			fmt.Fprintf(env.ErrStream, "%q is reserved\n", k)
+		default:
+			props.Set(k, v)
+		}
+	}
+
+	if passphrase == "" {
+		passphrase = ask("passphrase to protect your private key (blank for none): ")
+		if passphrase != "" && ask("passphrase again: ") != passphrase {
+			return "", errors.New("passphrases don't match")
+		}
+	}
+	return passphrase, in.Err()
 }
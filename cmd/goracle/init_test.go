@@ -2,10 +2,15 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/sean9999/gork"
 	"github.com/sean9999/hermeti"
+	"github.com/spf13/afero"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -43,3 +48,63 @@ func TestInit(t *testing.T) {
 	pubkey1.Equal(pubkey2)
 
 }
+
+func TestInitDir(t *testing.T) {
+
+	check := assert.New(t)
+	dir := filepath.Join(t.TempDir(), "keys")
+	priv := filepath.Join(dir, privFile)
+	conf := filepath.Join(dir, confFile)
+
+	run := func(stdin string, vars map[string]string, args ...string) *Exe {
+		cli := SetupTestCLI(t)
+		cli.Env.Filesystem = afero.NewOsFs()
+		cli.Env.Randomness = rand.Reader
+		cli.Env.InStream = strings.NewReader(stdin)
+		for k, v := range vars {
+			cli.Env.Vars[k] = v
+		}
+		cli.Env.Args = append([]string{"goracle"}, args...)
+		cli.Run(context.TODO())
+		return cli.Obj()
+	}
+
+	exe := run("", nil, "init", "-o="+dir, "--prop", "hometown=wonderland")
+	check.NoError(exe.Err)
+	first := exe.Self.PublicKey()
+
+	//	the directory is private, as is the key, and the config is signed
+	stat, err := os.Stat(dir)
+	check.NoError(err)
+	check.Equal(os.FileMode(0700), stat.Mode().Perm())
+	stat, err = os.Stat(priv)
+	check.NoError(err)
+	check.Equal(os.FileMode(0400), stat.Mode().Perm())
+	exe = run("", nil, "props", "get", "--priv", priv, "--config", conf, "hometown")
+	check.NoError(exe.Err)
+
+	//	an existing identity isn't replaced without --force
+	exe = run("", nil, "init", "-o="+dir)
+	check.ErrorIs(exe.Err, os.ErrExist)
+	exe = run("", nil, "info", "--priv", priv)
+	check.True(first.Equal(exe.Self.PublicKey()))
+
+	//	the wizard asks for properties and a passphrase
+	exe = run("addr=10.0.0.2:5656\nnick=imposter\n\njabberwock\njabberwock\n", nil, "init", "-o="+dir, "--force", "-i")
+	check.NoError(exe.Err)
+	check.False(first.Equal(exe.Self.PublicKey()))
+	b, err := os.ReadFile(priv)
+	check.NoError(err)
+	check.True(gork.IsEncryptedPEM(b))
+
+	exe = run("", nil, "props", "get", "--priv", priv, "--config", conf, "addr")
+	check.Equal(ExitBadSignature, exe.ExitCode)
+	exe = run("", map[string]string{PassphraseVar: "jabberwock"}, "props", "get", "--priv", priv, "--config", conf, "addr")
+	check.NoError(exe.Err)
+	nick, _ := exe.Self.Props.Get("nick")
+	check.NotEqual("imposter", nick)
+
+	exe = run("x=y\n\nfoo\nbar\n", nil, "init", "-o="+dir, "--force", "-i")
+	check.Error(exe.Err)
+
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/sean9999/hermeti"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+// init → assert → add → save, for two users sharing an in-memory filesystem, using only default paths
+func TestLifecycle(t *testing.T) {
+
+	check := assert.New(t)
+	fs := afero.NewMemMapFs()
+
+	//	run goracle as a user, whose home directory is /home/<user>
+	run := func(user string, stdin []byte, args ...string) (*Exe, string) {
+		env := hermeti.TestEnv()
+		env.Filesystem = fs
+		env.Randomness = rand.Reader
+		env.Vars["HOME"] = "/home/" + user
+		env.InStream = bytes.NewReader(stdin)
+		env.Args = append([]string{"goracle"}, args...)
+		cli := &hermeti.CLI[*Exe]{Env: env, Cmd: new(Exe)}
+		cli.Run(context.TODO())
+		r, err := cli.OutStream()
+		check.NoError(err)
+		out, _ := io.ReadAll(r)
+		check.NoError(cli.Obj().Err, strings.Join(args, " "))
+		return cli.Obj(), string(out)
+	}
+
+	alice, _ := run("alice", nil, "init", "-o", "--prop", "hometown=wonderland")
+	bob, _ := run("bob", nil, "init", "-o=$HOME/.gork")
+	for _, name := range []string{"/home/alice/.gork/priv.pem", "/home/bob/.gork/config.json"} {
+		exists, _ := afero.Exists(fs, name)
+		check.True(exists, name)
+	}
+
+	_, assertion := run("bob", nil, "assert")
+	check.Contains(assertion, "ASSERTION")
+
+	_, out := run("alice", []byte(assertion), "add")
+	check.Contains(out, bob.Self.Nickname())
+
+	run("alice", nil, "save", "--config", "~/.gork/config.json")
+
+	me, out := run("alice", nil, "peers", "list")
+	check.True(me.Self.PublicKey().Equal(alice.Self.PublicKey()))
+	check.Contains(out, bob.Self.AsPeer().Grip())
+	hometown, _ := me.Self.Props.Get("hometown")
+	check.Equal("wonderland", hometown)
+
+	//	nothing touched the real filesystem
+	exists, _ := afero.Exists(afero.NewOsFs(), "/home/alice/.gork")
+	check.False(exists)
+
+}
+
+func TestExpandPath(t *testing.T) {
+	env := hermeti.TestEnv()
+	env.Vars["HOME"] = "/home/alice"
+	env.Vars["GORK"] = "/etc/gork"
+	assert.Equal(t, "/home/alice/.gork/priv.pem", expandPath(env, "~/.gork/priv.pem"))
+	assert.Equal(t, "/home/alice", expandPath(env, "~"))
+	assert.Equal(t, "/etc/gork/config.json", expandPath(env, "$GORK/config.json"))
+	assert.Equal(t, "/etc/gork/config.json", expandPath(env, "${GORK}/config.json"))
+	assert.Equal(t, "~alice/x", expandPath(env, "~alice/x"))
+	assert.Equal(t, "conf.json", expandPath(env, "conf.json"))
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/sean9999/go-delphi"
+	"github.com/sean9999/gork"
+	"github.com/sean9999/hermeti"
+	"github.com/sean9999/pear"
+)
+
+var ErrLocate = pear.Defer("locate")
+
+// Locate asks goracled to look a key up in the DHT, and reports where it can be reached.
+// The key can be given in full, or by its grip, which is a best-effort search, and may have more than one answer.
+// The request is a FIND signed by us and sent to ourselves, so only we can ask.
+//
+//	goracle locate [--daemon host:port] [--timeout 15s] <pubkey|grip>
+func (cmd *Exe) Locate(ctx context.Context, env hermeti.Env, args []string) (Result, error) {
+
+	fset := flag.NewFlagSet("locate", flag.ContinueOnError)
+	daemon := fset.String("daemon", DefaultDaemon, "where goracled is listening")
+	timeout := fset.Duration("timeout", 15*time.Second, "how long to wait for an answer")
+	args, err := cmd.ensureSelfWith(ctx, env, args, fset)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrLocate, err)
+	}
+	if len(args) != 1 {
+		return nil, usageError(pear.Errorf("%w: locate which key?", ErrLocate))
+	}
+
+	//	what we're looking for, and how to tell when we've found it.
+	//	A key has a place in the DHT. A grip doesn't, so goracled can only ask around.
+	ref := strings.ToLower(strings.TrimSpace(args[0]))
+	cmd.Self.WithRand(env.Randomness)
+	var msg *delphi.Message
+	var matches func(gork.AddrRecord) bool
+	if b, err := hex.DecodeString(ref); err == nil && len(b) == 64 {
+		msg, err = cmd.Self.Find(cmd.Self.AsPeer(), gork.IDOf(delphi.KeyFromBytes(b)), nil)
+		if err != nil {
+			return nil, err
+		}
+		matches = func(rec gork.AddrRecord) bool { return rec.Pub == ref }
+	} else if gork.IsGrip(ref) {
+		msg, err = cmd.Self.FindGrip(cmd.Self.AsPeer(), ref, nil)
+		if err != nil {
+			return nil, err
+		}
+		matches = func(rec gork.AddrRecord) bool {
+			return gork.Peer{Key: delphi.KeyFromHex(rec.Pub)}.Grip() == ref
+		}
+	} else {
+		return nil, usageError(pear.Errorf("%w: %q is neither a public key nor a grip", ErrLocate, args[0]))
+	}
+	id, _ := msg.Headers.Get("find")
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", *daemon)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrLocate, err)
+	}
+	defer conn.Close()
+	_, err = fmt.Fprintf(conn, "%s", msg)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrLocate, err)
+	}
+
+	//	wait for the FOUND that answers our FIND, ignoring anything else
+	conn.SetReadDeadline(time.Now().Add(*timeout))
+	buf := make([]byte, gork.MaxDatagram)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, fmt.Errorf("%w: no answer from goracled at %s: %w", ErrLocate, *daemon, err)
+		}
+		block, _ := pem.Decode(buf[:n])
+		if block == nil {
+			continue
+		}
+		answer := new(delphi.Message)
+		if answer.FromPEM(*block) != nil || !answer.Sender.Equal(cmd.Self.PublicKey()) {
+			continue
+		}
+		answerID, found, err := gork.ParseFound(answer)
+		if err != nil || answerID != id {
+			continue
+		}
+		if problem, _ := answer.Headers.Get("error"); problem != "" {
+			return nil, pear.Errorf("%w: %s", ErrLocate, problem)
+		}
+		return located(found.Records, matches, ref)
+	}
+}
+
+// located is what's left of records once the unverified, the expired, and the ones we weren't looking for are gone
+func located(recs []gork.AddrRecord, matches func(gork.AddrRecord) bool, ref string) (LocateResult, error) {
+	now := time.Now()
+	result := LocateResult{}
+	for _, rec := range recs {
+		if rec.Verify() != nil || rec.Expired(now) || !matches(rec) {
+			continue
+		}
+		peer := gork.Peer{Key: delphi.KeyFromHex(rec.Pub)}
+		result = append(result, LocatedResult{
+			Nickname: peer.Nickname(),
+			Grip:     peer.Grip(),
+			Pubkey:   rec.Pub,
+			Addr:     rec.Addr,
+			Signed:   time.Unix(0, rec.Version).UTC(),
+			Expires:  time.Unix(0, rec.Expires).UTC(),
+		})
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("%w: %w: nobody has said where %s is", ErrLocate, gork.ErrPeerNotFound, ref)
+	}
+	return result, nil
+}
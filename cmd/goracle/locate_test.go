@@ -0,0 +1,91 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/pem"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sean9999/go-delphi"
+	"github.com/sean9999/gork"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocate(t *testing.T) {
+
+	check := assert.New(t)
+	node := SetupTestNode(t, nil)
+	me := node.me
+
+	//	bob, who we've never met, has told the DHT where he is
+	bob := gork.NewPrincipal(rand.Reader, map[string]string{"addr": "10.0.0.2:5656"}, nil)
+	rec, _, err := bob.AddrRecord(time.Now())
+	check.NoError(err)
+	bobPeer := bob.AsPeer()
+
+	//	a stand-in for goracled, which answers for us, since it holds our key
+	daemon, err := net.ListenPacket("udp", "127.0.0.1:0")
+	check.NoError(err)
+	defer daemon.Close()
+	go func() {
+		buf := make([]byte, gork.MaxDatagram)
+		for {
+			n, from, err := daemon.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			block, _ := pem.Decode(buf[:n])
+			msg := new(delphi.Message)
+			if msg.FromPEM(*block) != nil {
+				continue
+			}
+			found := gork.Found{}
+			if gork.ByGrip(msg) {
+				_, grip, err := gork.FindGripOf(msg)
+				if err != nil {
+					continue
+				}
+				if grip == bobPeer.Grip() {
+					found.Records = []gork.AddrRecord{rec}
+				}
+			} else {
+				_, target, err := gork.FindTarget(msg)
+				if err != nil {
+					continue
+				}
+				if target == gork.IDOf(bobPeer.Key) {
+					found.Records = []gork.AddrRecord{rec}
+				}
+			}
+			answer, err := me.Found(msg, found, nil)
+			if err == nil {
+				daemon.WriteTo([]byte(answer.String()), from)
+			}
+		}
+	}()
+
+	run := func(args ...string) (*Exe, string) {
+		return node.run("locate", append([]string{"--daemon", daemon.LocalAddr().String()}, args...)...)
+	}
+
+	exe, out := run(bobPeer.Grip())
+	check.NoError(exe.Err)
+	check.Contains(out, bob.Nickname())
+	check.Contains(out, "10.0.0.2:5656")
+
+	exe, out = run("--format", "json", bobPeer.ToHex())
+	check.NoError(exe.Err)
+	check.Contains(out, `"addr": "10.0.0.2:5656"`)
+
+	//	a record has to be for the key we asked about
+	carol := gork.NewPrincipal(rand.Reader, nil, nil)
+	exe, _ = run(carol.AsPeer().ToHex())
+	check.Equal(ExitNotFound, exe.ExitCode)
+	_, err = located([]gork.AddrRecord{rec}, func(gork.AddrRecord) bool { return false }, "ref")
+	check.ErrorIs(err, gork.ErrPeerNotFound)
+
+	exe, _ = run("shy-river")
+	check.Equal(ExitUsage, exe.ExitCode)
+
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sean9999/hermeti"
+	"github.com/sean9999/pear"
+)
+
+var ErrLog = pear.Defer("could not read change log")
+
+// Log displays the change log kept alongside our config.
+// With "verify", it also checks the log's signatures, and that it ends where the config says it should.
+//
+//	goracle log [verify] --priv <pem> --config <file>
+func (cmd *Exe) Log(ctx context.Context, env hermeti.Env, args []string) (Result, error) {
+
+	verify := len(args) > 0 && args[0] == "verify"
+	if verify {
+		args = args[1:]
+	}
+
+	args, err := cmd.ensureSelf(ctx, env, args)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrLog, err)
+	}
+	if cmd.Self.Journal == nil {
+		return nil, notFound(pear.Errorf("%w: there is no change log", ErrLog))
+	}
+	changes, err := cmd.Self.Journal.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrLog, err)
+	}
+
+	res := LogResult{Changes: changes}
+	if verify {
+		head := ""
+		if cmd.Config != nil {
+			conf, err := cmd.Config.Get()
+			if err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrLog, err)
+			}
+			head = conf.Log
+		}
+		err = cmd.Self.VerifyChangeLog(changes, head)
+		if err != nil {
+			return nil, err
+		}
+		res.Verified = &verify
+	}
+
+	return res, nil
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/sean9999/gork"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLog(t *testing.T) {
+
+	check := assert.New(t)
+	n := SetupTestNode(t, func(n *testNode) {
+		n.me.Journal = gork.FileJournal{Fs: n.cli.Env.Filesystem, Name: "conf.json.log", Origin: "test"}
+		n.me.Props.Set("hometown", "wonderland")
+	})
+
+	_, out := n.exec("log", "verify", "--priv", testPem, "--config", n.prov.Name)
+	check.Contains(out, "set_prop\thometown=wonderland")
+	check.Contains(out, "verified")
+
+}
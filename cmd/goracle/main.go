@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"os"
 
 	"github.com/sean9999/hermeti"
 	"github.com/sean9999/pear"
@@ -13,10 +15,12 @@ func main() {
 	env := hermeti.RealEnv()
 	ctx := context.Background()
 
-	//	capture panics in a pretty stack trace
+	//	capture panics in a pretty stack trace, and don't pass them off as success
 	defer func() {
 		if r := recover(); r != nil {
+			fmt.Fprintln(env.ErrStream, r)
 			pear.NicePanic(env.ErrStream)
+			os.Exit(ExitFailure)
 		}
 	}()
 
@@ -31,4 +35,6 @@ func main() {
 
 	cli.Run(ctx)
 
+	os.Exit(cmd.ExitCode)
+
 }
@@ -2,14 +2,17 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"io"
 	"os"
 	"path/filepath"
 	"testing"
 
+	"github.com/sean9999/gork"
 	"github.com/sean9999/hermeti"
 	"github.com/sean9999/pear"
 	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
 )
 
 // fake randomness. A stream of whatever number you want
@@ -73,6 +76,56 @@ func SetupTestCLI(t testing.TB) *hermeti.CLI[*Exe] {
 	return cli
 }
 
+// testPem is the key a testNode runs with
+const testPem = "../../testdata/late-silence.pem"
+
+// a testNode is a test CLI whose filesystem holds a config for testPem, saved as conf.json
+type testNode struct {
+	cli  *hermeti.CLI[*Exe]
+	me   gork.Principal
+	prov gork.FileBasedConfigProvider
+	t    testing.TB
+}
+
+// SetupTestNode loads testPem, lets prepare add to it, if it wants, and saves its config
+func SetupTestNode(t testing.TB, prepare func(n *testNode)) *testNode {
+	t.Helper()
+	cli := SetupTestCLI(t)
+	fd, err := cli.Env.Filesystem.Open(testPem)
+	assert.NoError(t, err)
+	n := &testNode{
+		cli:  cli,
+		me:   gork.NewPrincipal(rand.Reader, nil, nil),
+		prov: gork.FileBasedConfigProvider{Fs: cli.Env.Filesystem, Name: "conf.json"},
+		t:    t,
+	}
+	assert.NoError(t, n.me.FromPem(fd))
+	if prepare != nil {
+		prepare(n)
+	}
+	assert.NoError(t, n.me.Save(n.prov))
+	return n
+}
+
+// exec runs goracle with args on a fresh Exe, against the same filesystem, and captures its output
+func (n *testNode) exec(args ...string) (*Exe, string) {
+	n.t.Helper()
+	n.cli.Cmd = new(Exe)
+	n.cli.Env.Args = append([]string{"goracle"}, args...)
+	n.cli.Run(context.TODO())
+	r, err := n.cli.OutStream()
+	assert.NoError(n.t, err)
+	out, err := io.ReadAll(r)
+	assert.NoError(n.t, err)
+	return n.cli.Obj(), string(out)
+}
+
+// run is exec for a subcommand, with our key and config
+func (n *testNode) run(subcmd string, args ...string) (*Exe, string) {
+	n.t.Helper()
+	return n.exec(append([]string{subcmd, "--priv", testPem, "--config", n.prov.Name}, args...)...)
+}
+
 func TestMain(t *testing.T) {
 
 	cli := SetupTestCLI(t)
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/sean9999/gork"
+	"github.com/sean9999/hermeti"
+	"github.com/sean9999/pear"
+)
+
+var ErrNearby = pear.Defer("nearby")
+
+// Nearby dispatches subcommands about nodes that goracled --discover has heard announce themselves on the local network.
+// Accepting a node adds it to our peers. With --introduce, we also send it our assertion, so that it can add us back.
+//
+//	goracle nearby list
+//	goracle nearby accept [--introduce] <node>...
+//	goracle nearby forget <node>...
+func (cmd *Exe) Nearby(ctx context.Context, env hermeti.Env, args []string) (Result, error) {
+
+	fset := flag.NewFlagSet("nearby", flag.ContinueOnError)
+	introduce := fset.Bool("introduce", false, "send accepted nodes our assertion")
+	//	if no subcommand is specified, "list" is implied
+	subcmd, args, err := cmd.ensureSelfAround(ctx, env, args, fset, "list")
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNearby, err)
+	}
+
+	sightings, err := cmd.nearby(env)
+	if err != nil {
+		return nil, err
+	}
+
+	switch subcmd {
+	case "list":
+		return nearbyResult(sightings), nil
+	case "accept":
+		if len(args) == 0 {
+			return nil, usageError(pear.Errorf("%w: accept which node?", ErrNearby))
+		}
+		accepted, err := findSightings(sightings, args)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrNearby, err)
+		}
+		res := PeerListResult{}
+		for _, peer := range accepted {
+			err = cmd.Self.AddPeer(peer)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrNearby, err)
+			}
+			res = append(res, peerResult(peer))
+		}
+		err = cmd.savePeers()
+		if err != nil {
+			return nil, err
+		}
+		for _, peer := range accepted {
+			err = cmd.Near.Forget(peer.ToHex())
+			if err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrNearby, err)
+			}
+		}
+		if *introduce {
+			msg, err := cmd.assertion(env)
+			if err != nil {
+				return nil, err
+			}
+			broadcast(ctx, env, msg, accepted)
+		}
+		return res, nil
+	case "forget":
+		if len(args) == 0 {
+			return nil, usageError(pear.Errorf("%w: forget which node?", ErrNearby))
+		}
+		forgotten, err := findSightings(sightings, args)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrNearby, err)
+		}
+		for _, peer := range forgotten {
+			err = cmd.Near.Forget(peer.ToHex())
+			if err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrNearby, err)
+			}
+		}
+		sightings, err = cmd.nearby(env)
+		if err != nil {
+			return nil, err
+		}
+		return nearbyResult(sightings), nil
+	default:
+		return nil, usageError(pear.Errorf("%w: unsupported subcommand: %q", ErrNearby, subcmd))
+	}
+}
+
+// a sighting is a node we've heard from, whose assertion checks out
+type sighting struct {
+	gork.Sighting
+	peer gork.Peer
+}
+
+// nearby lists the nodes heard on the local network that aren't our peers
+func (cmd *Exe) nearby(env hermeti.Env) ([]sighting, error) {
+	sightings, err := cmd.sightings(env, cmd.Near)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNearby, err)
+	}
+	return sightings, nil
+}
+
+// sightings lists the nodes on a roster that aren't our peers.
+// Sightings whose assertions don't check out are reported and left out.
+func (cmd *Exe) sightings(env hermeti.Env, roster gork.Roster) ([]sighting, error) {
+	all, err := roster.Sightings()
+	if err != nil {
+		return nil, err
+	}
+	sightings := make([]sighting, 0, len(all))
+	for _, s := range all {
+		peer, err := s.Peer()
+		if err != nil {
+			fmt.Fprintf(env.ErrStream, "ignoring %.16s: %s\n", s.Pub, err)
+			continue
+		}
+		if cmd.Self.HasPeer(peer) {
+			continue
+		}
+		sightings = append(sightings, sighting{s, peer})
+	}
+	return sightings, nil
+}
+
+// findSightings resolves references to sighted nodes, by nickname, public key, or a prefix of their grip
+func findSightings(sightings []sighting, refs []string) (gork.PeerList, error) {
+	peers := gork.PeerList{}
+	for _, s := range sightings {
+		peers = append(peers, s.peer)
+	}
+	found := gork.PeerList{}
+	for _, ref := range refs {
+		peer, err := peers.Find(ref)
+		if err != nil {
+			return nil, err
+		}
+		found = append(found, peer)
+	}
+	return found, nil
+}
+
+func nearbyResult(sightings []sighting) NearbyResult {
+	res := NearbyResult{}
+	for _, s := range sightings {
+		res = append(res, SightingResult{peerResult(s.peer), s.FirstSeen, s.LastSeen})
+	}
+	return res
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/pem"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sean9999/go-delphi"
+	"github.com/sean9999/gork"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNearby(t *testing.T) {
+
+	check := assert.New(t)
+	node := SetupTestNode(t, nil)
+	me, prov := node.me, node.prov
+
+	//	bob is listening, and has been heard announcing himself, as has carol
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	check.NoError(err)
+	defer conn.Close()
+	near := gork.NearbyRoster(node.cli.Env.Filesystem, prov.Name)
+	bob := gork.NewPrincipal(rand.Reader, map[string]string{"hometown": "wonderland"}, nil)
+	carol := gork.NewPrincipal(rand.Reader, nil, nil)
+	for addr, p := range map[string]gork.Principal{conn.LocalAddr().String(): bob, "10.0.0.3:5656": carol} {
+		msg, err := p.Assert()
+		check.NoError(err)
+		s, err := gork.NewSighting(msg, addr, time.Now())
+		check.NoError(err)
+		check.NoError(near.Sight(s))
+	}
+
+	_, out := node.run("nearby", "list")
+	check.Contains(out, bob.Nickname())
+	check.Contains(out, carol.Nickname())
+
+	exe, out := node.run("nearby", "accept", "--introduce", bob.AsPeer().Grip())
+	check.NoError(exe.Err)
+	check.Contains(out, bob.Nickname())
+
+	//	bob is a peer now, with the address he was heard from, and the props he asserted
+	conf, err := prov.Get()
+	check.NoError(err)
+	check.Len(*conf.Peers, 1)
+	peer := (*conf.Peers)[0]
+	check.True(peer.Equal(bob.AsPeer()))
+	addr, _ := peer.Properties.Get("addr")
+	check.Equal(conn.LocalAddr().String(), addr)
+	hometown, _ := peer.Properties.Get("hometown")
+	check.Equal("wonderland", hometown)
+
+	//	and he's been introduced to us
+	buf := make([]byte, 2048)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	check.NoError(err)
+	block, _ := pem.Decode(buf[:n])
+	check.NotNil(block)
+	msg := new(delphi.Message)
+	check.NoError(msg.FromPEM(*block))
+	introduced, err := gork.ParseAssertion(msg)
+	check.NoError(err)
+	check.True(introduced.Equal(me.AsPeer()))
+
+	//	he's no longer nearby, and once carol is forgotten, nobody is
+	_, out = node.run("nearby", "list")
+	check.NotContains(out, bob.Nickname())
+	_, out = node.run("nearby", "forget", carol.Nickname())
+	check.Empty(out)
+
+	exe, _ = node.run("nearby", "accept", "nobody")
+	check.Equal(ExitNotFound, exe.ExitCode)
+
+}
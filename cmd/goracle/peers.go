@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/sean9999/gork"
+	"github.com/sean9999/hermeti"
+	"github.com/sean9999/pear"
+)
+
+var ErrPeers = pear.Defer("peers")
+
+// Peers dispatches subcommands that manage our address book.
+// Peers can be referred to by nickname, public key, or a prefix of their grip.
+//
+//	goracle peers list
+//	goracle peers show <peer>
+//	goracle peers drop <peer>
+//	goracle peers set <peer> <key>=<value>...
+//	goracle peers unset <peer> <key>...
+func (cmd *Exe) Peers(ctx context.Context, env hermeti.Env, args []string) (Result, error) {
+
+	//	if no subcommand is specified, "list" is implied
+	subcmd, args, err := cmd.ensureSelfAround(ctx, env, args, flag.NewFlagSet("peers", flag.ContinueOnError), "list")
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrPeers, err)
+	}
+
+	subcommands := map[string]subcommand{
+		"list":  cmd.ListPeers,
+		"show":  cmd.ShowPeer,
+		"drop":  cmd.DropPeer,
+		"set":   cmd.SetPeerProps,
+		"unset": cmd.UnsetPeerProps,
+	}
+
+	fn, exists := subcommands[subcmd]
+	if !exists {
+		return nil, usageError(pear.Errorf("%w: unsupported subcommand: %q", ErrPeers, subcmd))
+	}
+	return fn(ctx, env, args)
+}
+
+// ListPeers lists our peers, along with what goracled has observed of them
+func (cmd *Exe) ListPeers(_ context.Context, env hermeti.Env, args []string) (Result, error) {
+	res := PeerListResult{}
+	for _, peer := range cmd.Self.Peers {
+		res = append(res, peerResult(cmd.Observed.Observe(peer)))
+	}
+	return res, nil
+}
+
+// ShowPeer shows everything we know about a peer
+func (cmd *Exe) ShowPeer(_ context.Context, env hermeti.Env, args []string) (Result, error) {
+	peer, _, err := cmd.findPeer(args)
+	if err != nil {
+		return nil, err
+	}
+	return peerResult(cmd.Observed.Observe(peer)), nil
+}
+
+// DropPeer removes a peer from our address book
+func (cmd *Exe) DropPeer(_ context.Context, env hermeti.Env, args []string) (Result, error) {
+	peer, _, err := cmd.findPeer(args)
+	if err != nil {
+		return nil, err
+	}
+	cmd.Self.DropPeer(peer)
+	err = cmd.savePeers()
+	if err != nil {
+		return nil, err
+	}
+	return DropResult{peerResult(peer)}, nil
+}
+
+// SetPeerProps sets properties on a peer
+func (cmd *Exe) SetPeerProps(_ context.Context, env hermeti.Env, args []string) (Result, error) {
+	peer, args, err := cmd.findPeer(args)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 0 {
+		return nil, usageError(pear.Errorf("%w: set requires at least one key=value", ErrPeers))
+	}
+	props := gork.NewKV()
+	for _, arg := range args {
+		k, v, ok := strings.Cut(arg, "=")
+		if !ok || k == "" {
+			return nil, usageError(pear.Errorf("%w: expected key=value but got %q", ErrPeers, arg))
+		}
+		if gork.IsReservedProp(k) {
+			return nil, pear.Errorf("%w: %w: %q", ErrPeers, gork.ErrReservedProp, k)
+		}
+		props.Set(k, v)
+	}
+	for pair := props.Oldest(); pair != nil; pair = pair.Next() {
+		peer.Properties.Set(pair.Key, pair.Value)
+	}
+	//	a peer's properties are shared with the address book, so there's nothing to put back
+	err = cmd.savePeers()
+	if err != nil {
+		return nil, err
+	}
+	return peerResult(peer), nil
+}
+
+// UnsetPeerProps removes properties from a peer
+func (cmd *Exe) UnsetPeerProps(_ context.Context, env hermeti.Env, args []string) (Result, error) {
+	peer, args, err := cmd.findPeer(args)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 0 {
+		return nil, usageError(pear.Errorf("%w: unset requires at least one key", ErrPeers))
+	}
+	for _, k := range args {
+		if gork.IsReservedProp(k) {
+			return nil, pear.Errorf("%w: %w: %q", ErrPeers, gork.ErrReservedProp, k)
+		}
+	}
+	for _, k := range args {
+		peer.Properties.Delete(k)
+	}
+	//	a peer's properties are shared with the address book, so there's nothing to put back
+	err = cmd.savePeers()
+	if err != nil {
+		return nil, err
+	}
+	return peerResult(peer), nil
+}
+
+// findPeer resolves the first argument to a peer
+func (cmd *Exe) findPeer(args []string) (gork.Peer, []string, error) {
+	if len(args) == 0 {
+		return gork.Peer{}, args, usageError(pear.Errorf("%w: which peer?", ErrPeers))
+	}
+	peer, err := cmd.Self.FindPeer(args[0])
+	if err != nil {
+		return peer, args, fmt.Errorf("%w: %w", ErrPeers, err)
+	}
+	return peer, args[1:], nil
+}
+
+// savePeers re-signs and saves our config after a change to the address book
+func (cmd *Exe) savePeers() error {
+	if cmd.Config == nil {
+		return notFound(pear.Errorf("%w: no config file", ErrPeers))
+	}
+	err := cmd.Self.Save(cmd.Config)
+	if err != nil {
+		return fmt.Errorf("%w: could not save: %w", ErrPeers, err)
+	}
+	return nil
+}
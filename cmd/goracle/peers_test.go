@@ -0,0 +1,72 @@
+package main
+
+import (
+	"crypto/rand"
+	"testing"
+
+	"github.com/sean9999/gork"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPeers(t *testing.T) {
+
+	check := assert.New(t)
+	bob := gork.NewPrincipal(rand.Reader, nil, nil)
+	carol := gork.NewPrincipal(rand.Reader, nil, nil)
+
+	n := SetupTestNode(t, func(n *testNode) {
+		n.me.AddPeer(bob.AsPeer())
+		n.me.AddPeer(carol.AsPeer())
+	})
+	me, prov := n.me, n.prov
+
+	stored := func() gork.Peer {
+		conf, err := prov.Get()
+		check.NoError(err)
+		check.NoError(me.VerifyConfig(conf))
+		for _, p := range *conf.Peers {
+			if p.Equal(bob.AsPeer()) {
+				return p
+			}
+		}
+		return gork.Peer{}
+	}
+
+	_, out := n.run("peers", "list")
+	check.Contains(out, bob.Nickname())
+	check.Contains(out, carol.AsPeer().Grip())
+
+	//	what goracled has observed of them is shown too
+	seen := gork.NewKV()
+	seen.Set("status", "online")
+	check.NoError(gork.ObservationsFile(n.cli.Env.Filesystem, prov.Name).Set(gork.Observations{bob.AsPeer().ToHex(): seen}))
+	_, out = n.run("peers", "show", bob.Nickname())
+	check.Contains(out, "status:\tonline")
+	_, observed := stored().Properties.Get("status")
+	check.False(observed)
+
+	n.run("peers", "set", bob.Nickname(), "addr=[::1]:5656", "note=met at the tea party")
+	addr, _ := stored().Properties.Get("addr")
+	check.Equal("[::1]:5656", addr)
+
+	_, out = n.run("peers", "show", bob.AsPeer().Grip())
+	check.Contains(out, bob.PublicKey().ToHex())
+	check.Contains(out, "note:\tmet at the tea party")
+
+	n.run("peers", "unset", bob.PublicKey().ToHex(), "note")
+	_, hasNote := stored().Properties.Get("note")
+	check.False(hasNote)
+
+	_, out = n.run("peers", "drop", bob.Nickname())
+	check.Contains(out, "dropped "+bob.Nickname())
+	check.False(stored().Key.Equal(bob.PublicKey()))
+	conf, _ := prov.Get()
+	check.Len(*conf.Peers, 1)
+
+	//	flags can come after the subcommand, too
+	exe, _ := n.exec("peers", "drop", "--priv", testPem, "--config", prov.Name, carol.Nickname())
+	check.NoError(exe.Err)
+	conf, _ = prov.Get()
+	check.Empty(*conf.Peers)
+
+}
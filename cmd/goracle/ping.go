@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/sean9999/go-delphi"
+	"github.com/sean9999/gork"
+	"github.com/sean9999/hermeti"
+	"github.com/sean9999/pear"
+)
+
+var ErrPing = pear.Defer("ping")
+
+// DefaultDaemon is where goracled listens, unless told otherwise
+const DefaultDaemon = "127.0.0.1:5656"
+
+// Ping asks goracled to ping a peer, and reports the round trip time.
+// The request is a PING signed by us and sent to ourselves, so only we can ask.
+//
+//	goracle ping [--daemon host:port] [--timeout 5s] <peer>
+func (cmd *Exe) Ping(ctx context.Context, env hermeti.Env, args []string) (Result, error) {
+
+	fset := flag.NewFlagSet("ping", flag.ContinueOnError)
+	daemon := fset.String("daemon", DefaultDaemon, "where goracled is listening")
+	timeout := fset.Duration("timeout", 5*time.Second, "how long to wait for an answer")
+	args, err := cmd.ensureSelfWith(ctx, env, args, fset)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrPing, err)
+	}
+	if len(args) != 1 {
+		return nil, usageError(pear.Errorf("%w: ping which peer?", ErrPing))
+	}
+	peer, err := cmd.Self.FindPeer(args[0])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrPing, err)
+	}
+
+	headers := gork.NewKV()
+	headers.Set("for", peer.ToHex())
+	cmd.Self.WithRand(env.Randomness)
+	msg, err := cmd.Self.Ping(cmd.Self.AsPeer(), headers)
+	if err != nil {
+		return nil, err
+	}
+	id, _ := msg.Headers.Get("ping")
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", *daemon)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrPing, err)
+	}
+	defer conn.Close()
+	_, err = fmt.Fprintf(conn, "%s", msg)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrPing, err)
+	}
+
+	//	wait for the PONG that answers our PING, ignoring anything else
+	conn.SetReadDeadline(time.Now().Add(*timeout))
+	buf := make([]byte, 2048)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, fmt.Errorf("%w: no answer from goracled at %s: %w", ErrPing, *daemon, err)
+		}
+		block, _ := pem.Decode(buf[:n])
+		if block == nil {
+			continue
+		}
+		answer := new(delphi.Message)
+		if answer.FromPEM(*block) != nil || !answer.Sender.Equal(cmd.Self.PublicKey()) {
+			continue
+		}
+		if answerID, err := gork.PingID(answer); err != nil || answerID != id {
+			continue
+		}
+		if problem, _ := answer.Headers.Get("error"); problem != "" {
+			return nil, pear.Errorf("%w: %s", ErrPing, problem)
+		}
+		reported, _ := answer.Headers.Get("rtt")
+		rtt, err := time.ParseDuration(reported)
+		if err != nil {
+			return nil, pear.Errorf("%w: bad rtt: %s", ErrPing, err)
+		}
+		return PingResult{Peer: peerResult(peer), RTT: rtt}, nil
+	}
+}
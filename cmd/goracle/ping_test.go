@@ -0,0 +1,70 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/pem"
+	"net"
+	"testing"
+
+	"github.com/sean9999/go-delphi"
+	"github.com/sean9999/gork"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPing(t *testing.T) {
+
+	check := assert.New(t)
+	bob := gork.NewPrincipal(rand.Reader, nil, nil)
+	bobAsPeer := bob.AsPeer()
+	bobAsPeer.Properties.Set("addr", "10.0.0.2:5656")
+	node := SetupTestNode(t, func(n *testNode) {
+		n.me.AddPeer(bobAsPeer)
+	})
+	me := node.me
+
+	//	a stand-in for goracled, which answers for us, since it holds our key
+	daemon, err := net.ListenPacket("udp", "127.0.0.1:0")
+	check.NoError(err)
+	defer daemon.Close()
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, from, err := daemon.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			block, _ := pem.Decode(buf[:n])
+			msg := new(delphi.Message)
+			if msg.FromPEM(*block) != nil {
+				continue
+			}
+			headers := gork.NewKV()
+			who, _ := msg.Headers.Get("for")
+			if who == bobAsPeer.ToHex() {
+				headers.Set("rtt", "1.5ms")
+			} else {
+				headers.Set("error", "no such peer")
+			}
+			pong, err := me.Pong(msg, headers)
+			if err == nil {
+				daemon.WriteTo([]byte(pong.String()), from)
+			}
+		}
+	}()
+
+	exe, out := node.run("ping", "--daemon", daemon.LocalAddr().String(), bob.Nickname())
+	check.NoError(exe.Err)
+	check.Contains(out, bob.Nickname())
+	check.Contains(out, "1.5ms")
+
+	exe, _ = node.run("ping", "--daemon", daemon.LocalAddr().String(), "nobody")
+	check.Equal(ExitNotFound, exe.ExitCode)
+
+	//	nobody's listening
+	quiet, err := net.ListenPacket("udp", "127.0.0.1:0")
+	check.NoError(err)
+	defer quiet.Close()
+	exe, _ = node.run("ping", "--daemon", quiet.LocalAddr().String(), "--timeout", "50ms", bob.Nickname())
+	check.Equal(ExitIO, exe.ExitCode)
+
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/sean9999/hermeti"
+	"github.com/sean9999/pear"
+)
+
+var ErrProps = pear.Defer("props")
+
+// Props dispatches subcommands that manage our own properties.
+// Changes are signed and saved, and with --broadcast, asserted to every peer we have an address for.
+//
+//	goracle props list
+//	goracle props get <key>
+//	goracle props set [--broadcast] <key>=<value>...
+//	goracle props unset [--broadcast] <key>...
+func (cmd *Exe) Props(ctx context.Context, env hermeti.Env, args []string) (Result, error) {
+
+	fset := flag.NewFlagSet("props", flag.ContinueOnError)
+	bcast := fset.Bool("broadcast", false, "send an updated assertion to known peers")
+	//	if no subcommand is specified, "list" is implied
+	subcmd, args, err := cmd.ensureSelfAround(ctx, env, args, fset, "list")
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrProps, err)
+	}
+
+	switch subcmd {
+	case "list":
+		return PropsResult{Props: orEmpty(cmd.Self.Props)}, nil
+	case "get":
+		if len(args) == 0 {
+			return nil, usageError(pear.Errorf("%w: get requires a key", ErrProps))
+		}
+		v, exists := cmd.Self.Props.Get(args[0])
+		if !exists {
+			return nil, notFound(pear.Errorf("%w: no such property: %q", ErrProps, args[0]))
+		}
+		return PropResult{args[0], v}, nil
+	case "set":
+		if len(args) == 0 {
+			return nil, usageError(pear.Errorf("%w: set requires at least one key=value", ErrProps))
+		}
+		for _, arg := range args {
+			k, v, ok := strings.Cut(arg, "=")
+			if !ok {
+				return nil, usageError(pear.Errorf("%w: expected key=value but got %q", ErrProps, arg))
+			}
+			err = cmd.Self.SetProp(k, v)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrProps, err)
+			}
+		}
+	case "unset":
+		if len(args) == 0 {
+			return nil, usageError(pear.Errorf("%w: unset requires at least one key", ErrProps))
+		}
+		for _, k := range args {
+			err = cmd.Self.UnsetProp(k)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrProps, err)
+			}
+		}
+	default:
+		return nil, usageError(pear.Errorf("%w: unsupported subcommand: %q", ErrProps, subcmd))
+	}
+
+	if cmd.Config == nil {
+		return nil, notFound(pear.Errorf("%w: no config file", ErrProps))
+	}
+	err = cmd.Self.Save(cmd.Config)
+	if err != nil {
+		return nil, fmt.Errorf("%w: could not save: %w", ErrProps, err)
+	}
+
+	res := PropsResult{Props: cmd.Self.Props}
+	if *bcast {
+		msg, err := cmd.assertion(env)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrProps, err)
+		}
+		n := broadcast(ctx, env, msg, cmd.Self.Peers)
+		res.Asserted = &n
+	}
+	return res, nil
+}
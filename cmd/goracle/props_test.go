@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/pem"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sean9999/go-delphi"
+	"github.com/sean9999/gork"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProps(t *testing.T) {
+
+	check := assert.New(t)
+	n := SetupTestNode(t, nil)
+	me, prov := n.me, n.prov
+
+	n.run("props", "set", "hometown=wonderland", "mood=curious")
+	conf, err := prov.Get()
+	check.NoError(err)
+	check.NoError(me.VerifyConfig(conf))
+	hometown, _ := conf.Props.Get("hometown")
+	check.Equal("wonderland", hometown)
+
+	_, out := n.run("props", "get", "hometown")
+	check.Equal("wonderland\n", out)
+	_, out = n.run("props", "list")
+	check.Contains(out, "mood=curious")
+
+	n.run("props", "unset", "mood")
+	conf, _ = prov.Get()
+	_, hasMood := conf.Props.Get("mood")
+	check.False(hasMood)
+
+	//	reserved props are left alone
+	n.run("props", "set", "grip=deadbeef")
+	conf, _ = prov.Get()
+	grip, _ := conf.Props.Get("grip")
+	check.NotEqual("deadbeef", grip)
+
+}
+
+func TestPropsBroadcast(t *testing.T) {
+
+	check := assert.New(t)
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip("no loopback networking:", err)
+	}
+	defer conn.Close()
+
+	node := SetupTestNode(t, func(n *testNode) {
+		other := gork.NewPrincipal(rand.Reader, nil, nil)
+		bob := other.AsPeer()
+		bob.Properties.Set("addr", conn.LocalAddr().String())
+		n.me.AddPeer(bob)
+	})
+	node.run("props", "set", "--broadcast", "hometown=wonderland")
+
+	buf := make([]byte, 4096)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	check.NoError(err)
+	block, _ := pem.Decode(buf[:n])
+	check.NotNil(block)
+	msg := new(delphi.Message)
+	check.NoError(msg.FromPEM(*block))
+	check.Equal("ASSERTION", msg.Subject)
+	check.True(msg.Sender.Equal(node.me.PublicKey()))
+	check.Contains(string(msg.PlainText), "wonderland")
+
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+
+	"github.com/sean9999/hermeti"
+	"github.com/sean9999/pear"
+)
+
+var ErrRequests = pear.Defer("requests")
+
+// Requests dispatches subcommands about nodes that have asked to be our peers,
+// and that goracled --policy manual has queued for approval.
+// Approving a request adds the node to our peers, and has goracled acknowledge it, at the address it asked from.
+//
+//	goracle requests list
+//	goracle requests approve [--daemon host:port] <node>...
+//	goracle requests deny <node>...
+func (cmd *Exe) Requests(ctx context.Context, env hermeti.Env, args []string) (Result, error) {
+
+	fset := flag.NewFlagSet("requests", flag.ContinueOnError)
+	daemon := fset.String("daemon", DefaultDaemon, "where goracled is listening")
+	//	if no subcommand is specified, "list" is implied
+	subcmd, args, err := cmd.ensureSelfAround(ctx, env, args, fset, "list")
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrRequests, err)
+	}
+
+	requests, err := cmd.sightings(env, cmd.Pending)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrRequests, err)
+	}
+
+	switch subcmd {
+	case "list":
+		return requestsResult(requests), nil
+	case "approve":
+		if len(args) == 0 {
+			return nil, usageError(pear.Errorf("%w: approve which request?", ErrRequests))
+		}
+		approved, err := findSightings(requests, args)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrRequests, err)
+		}
+		res := PeerListResult{}
+		for _, peer := range approved {
+			err = cmd.Self.AddPeer(peer)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrRequests, err)
+			}
+			res = append(res, peerResult(peer))
+		}
+		err = cmd.savePeers()
+		if err != nil {
+			return nil, err
+		}
+		for _, peer := range approved {
+			err = cmd.Pending.Forget(peer.ToHex())
+			if err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrRequests, err)
+			}
+		}
+
+		//	now that they're our peers, have goracled let them know, so that they hear back from where they asked
+		cmd.Self.WithRand(env.Randomness)
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "udp", *daemon)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrRequests, err)
+		}
+		defer conn.Close()
+		for _, peer := range approved {
+			addr, _ := peer.Properties.Get("addr")
+			ask, err := cmd.Self.AskAcknowledge(peer, addr)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrRequests, err)
+			}
+			_, err = fmt.Fprintf(conn, "%s", ask)
+			if err != nil {
+				fmt.Fprintf(env.ErrStream, "could not ask goracled to acknowledge %s: %s\n", peer.Nickname(), err)
+			}
+		}
+		return res, nil
+	case "deny":
+		if len(args) == 0 {
+			return nil, usageError(pear.Errorf("%w: deny which request?", ErrRequests))
+		}
+		denied, err := findSightings(requests, args)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrRequests, err)
+		}
+		for _, peer := range denied {
+			err = cmd.Pending.Forget(peer.ToHex())
+			if err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrRequests, err)
+			}
+		}
+		requests, err = cmd.sightings(env, cmd.Pending)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrRequests, err)
+		}
+		return requestsResult(requests), nil
+	default:
+		return nil, usageError(pear.Errorf("%w: unsupported subcommand: %q", ErrRequests, subcmd))
+	}
+}
+
+func requestsResult(requests []sighting) RequestsResult {
+	res := RequestsResult{}
+	for _, s := range requests {
+		res = append(res, RequestResult{peerResult(s.peer), s.LastSeen, s.peer.Art()})
+	}
+	return res
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/pem"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sean9999/go-delphi"
+	"github.com/sean9999/gork"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequests(t *testing.T) {
+
+	check := assert.New(t)
+	node := SetupTestNode(t, nil)
+	me, prov := node.me, node.prov
+
+	//	bob and carol have asked to be our peers
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	check.NoError(err)
+	defer conn.Close()
+	daemon, err := net.ListenPacket("udp", "127.0.0.1:0")
+	check.NoError(err)
+	defer daemon.Close()
+	requests := gork.RequestRoster(node.cli.Env.Filesystem, prov.Name)
+	bob := gork.NewPrincipal(rand.Reader, nil, nil)
+	carol := gork.NewPrincipal(rand.Reader, nil, nil)
+	for addr, p := range map[string]gork.Principal{conn.LocalAddr().String(): bob, "10.0.0.3:5656": carol} {
+		msg, err := p.Assert()
+		check.NoError(err)
+		s, err := gork.NewSighting(msg, addr, time.Now())
+		check.NoError(err)
+		check.NoError(requests.Sight(s))
+	}
+
+	_, out := node.run("requests", "list")
+	check.Contains(out, bob.Nickname())
+	check.Contains(out, carol.Nickname())
+	check.Contains(out, bob.Art())
+
+	exe, out := node.run("requests", "approve", "--daemon", daemon.LocalAddr().String(), bob.Nickname())
+	check.NoError(exe.Err)
+	check.Contains(out, bob.Nickname())
+
+	//	bob is a peer now, reachable where he asked from
+	conf, err := prov.Get()
+	check.NoError(err)
+	check.Len(*conf.Peers, 1)
+	addr, _ := (*conf.Peers)[0].Properties.Get("addr")
+	check.Equal(conn.LocalAddr().String(), addr)
+
+	//	and goracled has been asked to acknowledge him there
+	buf := make([]byte, 2048)
+	daemon.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := daemon.ReadFrom(buf)
+	check.NoError(err)
+	block, _ := pem.Decode(buf[:n])
+	check.NotNil(block)
+	msg := new(delphi.Message)
+	check.NoError(msg.FromPEM(*block))
+	check.Equal(gork.SubjectAck, msg.Subject)
+	check.True(msg.Verify())
+	check.True(msg.Sender.Equal(me.PublicKey()))
+	check.True(msg.Recipient.Equal(bob.PublicKey()))
+	at, _ := msg.Headers.Get("at")
+	check.Equal(conn.LocalAddr().String(), at)
+
+	//	denying carol leaves nobody waiting
+	_, out = node.run("requests", "list")
+	check.NotContains(out, bob.Nickname())
+	_, out = node.run("requests", "deny", carol.Nickname())
+	check.Empty(out)
+	conf, _ = prov.Get()
+	check.Len(*conf.Peers, 1)
+
+	exe, _ = node.run("requests", "approve", "--daemon", daemon.LocalAddr().String(), "nobody")
+	check.Equal(ExitNotFound, exe.ExitCode)
+
+}
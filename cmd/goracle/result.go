@@ -0,0 +1,634 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sean9999/gork"
+	"github.com/sean9999/pear"
+	"gopkg.in/yaml.v3"
+)
+
+var ErrOutputFormat = pear.Defer("unknown output format")
+
+// OutputFormat is how a subcommand's [Result] is written to stdout, chosen with the global --format flag
+type OutputFormat string
+
+const (
+	OutputText OutputFormat = "text"
+	OutputJSON OutputFormat = "json"
+	OutputYAML OutputFormat = "yaml"
+)
+
+func parseOutputFormat(s string) (OutputFormat, error) {
+	switch f := OutputFormat(strings.ToLower(s)); f {
+	case OutputText, OutputJSON, OutputYAML:
+		return f, nil
+	default:
+		return "", pear.Errorf("%w: %q", ErrOutputFormat, s)
+	}
+}
+
+// A Result is what a subcommand produces.
+// With --format=text it writes itself out for humans.
+// With --format=json or --format=yaml it's marshalled as-is,
+// so the json and yaml tags on every Result are a stable schema that scripts can rely on.
+// Fields are only ever added to that schema, never renamed or removed.
+type Result interface {
+	Text(io.Writer) error
+}
+
+// render writes a Result in the chosen format
+func (cmd *Exe) render(w io.Writer, res Result) error {
+	if res == nil {
+		return nil
+	}
+	switch cmd.Format {
+	case OutputJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "\t")
+		return enc.Encode(res)
+	case OutputYAML:
+		enc := yaml.NewEncoder(w)
+		defer enc.Close()
+		return enc.Encode(res)
+	default:
+		return res.Text(w)
+	}
+}
+
+// InfoResult describes ourselves. It's produced by "goracle info".
+//
+//	{
+//		"nickname": "late-silence",
+//		"grip": "87341d41",
+//		"pubkey": "<128 hex characters>",
+//		"props": {"grip": "87341d41", ...},
+//		"peers": 3
+//	}
+type InfoResult struct {
+	Nickname string   `json:"nickname" yaml:"nickname"`
+	Grip     string   `json:"grip" yaml:"grip"`
+	Pubkey   string   `json:"pubkey" yaml:"pubkey"`
+	Props    *gork.KV `json:"props" yaml:"props"`
+	Peers    int      `json:"peers" yaml:"peers"`
+	art      string
+}
+
+func infoResult(self *gork.Principal) InfoResult {
+	return InfoResult{
+		Nickname: self.Nickname(),
+		Grip:     self.AsPeer().Grip(),
+		Pubkey:   self.PublicKey().ToHex(),
+		Props:    orEmpty(self.Props),
+		Peers:    len(self.Peers),
+		art:      self.Art(),
+	}
+}
+
+func (r InfoResult) Text(w io.Writer) error {
+	fmt.Fprintln(w, r.Nickname)
+	fmt.Fprintf(w, "grip:\t%s\n", r.Grip)
+	fmt.Fprintf(w, "pubkey:\t%s\n\n", r.Pubkey)
+	_, err := fmt.Fprintf(w, "%s\n", r.art)
+	return err
+}
+
+// PeerResult describes a peer. It's produced by "goracle peers show|set|unset", and listed by "goracle peers list".
+//
+//	{
+//		"nickname": "aged-smoke",
+//		"grip": "96c1e46",
+//		"pubkey": "<128 hex characters>",
+//		"props": {"addr": "[::1]:5656", ...}
+//	}
+type PeerResult struct {
+	Nickname string   `json:"nickname" yaml:"nickname"`
+	Grip     string   `json:"grip" yaml:"grip"`
+	Pubkey   string   `json:"pubkey" yaml:"pubkey"`
+	Props    *gork.KV `json:"props" yaml:"props"`
+	art      string
+}
+
+func peerResult(p gork.Peer) PeerResult {
+	//	nick and grip have fields of their own
+	props := gork.NewKV()
+	if p.Properties != nil {
+		for pair := p.Properties.Oldest(); pair != nil; pair = pair.Next() {
+			if !gork.IsReservedProp(pair.Key) {
+				props.Set(pair.Key, pair.Value)
+			}
+		}
+	}
+	return PeerResult{
+		Nickname: p.Nickname(),
+		Grip:     p.Grip(),
+		Pubkey:   p.ToHex(),
+		Props:    props,
+		art:      p.Art(),
+	}
+}
+
+func (r PeerResult) Text(w io.Writer) error {
+	fmt.Fprintln(w, r.Nickname)
+	fmt.Fprintf(w, "grip:\t%s\n", r.Grip)
+	fmt.Fprintf(w, "pubkey:\t%s\n", r.Pubkey)
+	for pair := r.Props.Oldest(); pair != nil; pair = pair.Next() {
+		fmt.Fprintf(w, "%s:\t%s\n", pair.Key, pair.Value)
+	}
+	_, err := fmt.Fprintf(w, "\n%s\n", r.art)
+	return err
+}
+
+// PeerListResult is a list of peers, produced by "goracle peers list"
+type PeerListResult []PeerResult
+
+func (r PeerListResult) Text(w io.Writer) error {
+	for _, p := range r {
+		_, err := fmt.Fprintf(w, "%s\t%s\t%s\n", p.Nickname, p.Grip, p.Pubkey)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NearbyResult lists nodes heard on the local network that aren't our peers, most recently heard first.
+// It's produced by "goracle nearby list|forget".
+//
+//	[{"nickname": ..., "grip": ..., "pubkey": ..., "props": {"addr": "192.168.1.7:5656", ...}, "first_seen": ..., "last_seen": ...}]
+type NearbyResult []SightingResult
+
+// SightingResult is a [PeerResult] along with when it was heard from
+type SightingResult struct {
+	PeerResult `yaml:",inline"`
+	FirstSeen  time.Time `json:"first_seen" yaml:"first_seen"`
+	LastSeen   time.Time `json:"last_seen" yaml:"last_seen"`
+}
+
+func (r NearbyResult) Text(w io.Writer) error {
+	for _, s := range r {
+		addr, _ := s.Props.Get("addr")
+		ago := time.Since(s.LastSeen).Round(time.Second)
+		_, err := fmt.Fprintf(w, "%s\t%s\t%s\t%s ago\n", s.Nickname, s.Grip, addr, ago)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RequestsResult lists nodes waiting for us to approve or deny them as peers, most recent first.
+// It's produced by "goracle requests list|deny".
+//
+//	[{"nickname": ..., "grip": ..., "pubkey": ..., "props": {"addr": "203.0.113.9:5656", ...}, "received": ..., "art": ...}]
+type RequestsResult []RequestResult
+
+// RequestResult is a [PeerResult] along with when it asked, and its art, so that it can be recognized
+type RequestResult struct {
+	PeerResult `yaml:",inline"`
+	Received   time.Time `json:"received" yaml:"received"`
+	Art        string    `json:"art" yaml:"art"`
+}
+
+func (r RequestsResult) Text(w io.Writer) error {
+	for i, req := range r {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		addr, _ := req.Props.Get("addr")
+		fmt.Fprintln(w, req.Nickname)
+		fmt.Fprintf(w, "grip:\t%s\n", req.Grip)
+		fmt.Fprintf(w, "pubkey:\t%s\n", req.Pubkey)
+		fmt.Fprintf(w, "from:\t%s\n", addr)
+		fmt.Fprintf(w, "asked:\t%s ago\n", time.Since(req.Received).Round(time.Second))
+		_, err := fmt.Fprintf(w, "\n%s\n", req.Art)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SendResult is produced by "goracle send".
+// Status is what's known to have become of the message: queued, held, delivered, or just sent.
+//
+//	{"id": ..., "to": {"nickname": ..., ...}, "status": "delivered", "via": "127.0.0.1:5656"}
+type SendResult struct {
+	ID     string     `json:"id" yaml:"id"`
+	To     PeerResult `json:"to" yaml:"to"`
+	Status string     `json:"status" yaml:"status"`
+	Via    string     `json:"via" yaml:"via"`
+}
+
+func (r SendResult) Text(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "%.8s to %s: %s (via %s)\n", r.ID, r.To.Nickname, r.Status, r.Via)
+	return err
+}
+
+// InboxResult lists messages we've received, newest first. It's produced by "goracle inbox".
+//
+//	[{"id": ..., "from": "<128 hex characters>", "nickname": ..., "thread": ..., "received": ..., "read": false}]
+type InboxResult []MailResult
+
+// MailResult describes a message we've received, without opening it
+type MailResult struct {
+	ID       string    `json:"id" yaml:"id"`
+	From     string    `json:"from" yaml:"from"`
+	Nickname string    `json:"nickname" yaml:"nickname"`
+	Thread   string    `json:"thread" yaml:"thread"`
+	Received time.Time `json:"received" yaml:"received"`
+	Read     bool      `json:"read" yaml:"read"`
+}
+
+func (r InboxResult) Text(w io.Writer) error {
+	for _, m := range r {
+		flag := "*"
+		if m.Read {
+			flag = " "
+		}
+		ago := time.Since(m.Received).Round(time.Second)
+		_, err := fmt.Fprintf(w, "%s %.8s\t%s\t%s ago\tthread %.8s\n", flag, m.ID, m.Nickname, ago, m.Thread)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadResult is a message we've opened, produced by "goracle read"
+//
+//	{"id": ..., "from": ..., "nickname": ..., "thread": ..., "received": ..., "read": true, "body": ...}
+type ReadResult struct {
+	MailResult `yaml:",inline"`
+	Body       string `json:"body" yaml:"body"`
+}
+
+func (r ReadResult) Text(w io.Writer) error {
+	fmt.Fprintf(w, "from:\t%s\n", r.Nickname)
+	fmt.Fprintf(w, "date:\t%s\n", r.Received.Format(time.RFC1123))
+	fmt.Fprintf(w, "id:\t%s\n", r.ID)
+	fmt.Fprintf(w, "thread:\t%s\n", r.Thread)
+	_, err := fmt.Fprintf(w, "\n%s\n", r.Body)
+	return err
+}
+
+// DropResult is produced by "goracle peers drop"
+//
+//	{"dropped": {"nickname": ..., "grip": ..., "pubkey": ..., "props": {...}}}
+type DropResult struct {
+	Dropped PeerResult `json:"dropped" yaml:"dropped"`
+}
+
+func (r DropResult) Text(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "dropped %s\n", r.Dropped.Nickname)
+	return err
+}
+
+// PropsResult is our own props, produced by "goracle props list|set|unset".
+// Asserted is how many peers were sent an updated assertion, when --broadcast was asked for.
+//
+//	{"props": {"grip": "87341d41", "hometown": "wonderland"}, "asserted": 2}
+type PropsResult struct {
+	Props    *gork.KV `json:"props" yaml:"props"`
+	Asserted *int     `json:"asserted,omitempty" yaml:"asserted,omitempty"`
+}
+
+func (r PropsResult) Text(w io.Writer) error {
+	for pair := r.Props.Oldest(); pair != nil; pair = pair.Next() {
+		fmt.Fprintf(w, "%s=%s\n", pair.Key, pair.Value)
+	}
+	if r.Asserted != nil {
+		fmt.Fprintf(w, "asserted to %d peers\n", *r.Asserted)
+	}
+	return nil
+}
+
+// PropResult is a single prop, produced by "goracle props get"
+//
+//	{"key": "hometown", "value": "wonderland"}
+type PropResult struct {
+	Key   string `json:"key" yaml:"key"`
+	Value string `json:"value" yaml:"value"`
+}
+
+func (r PropResult) Text(w io.Writer) error {
+	_, err := fmt.Fprintln(w, r.Value)
+	return err
+}
+
+// PingResult is how long a peer took to answer a PING, produced by "goracle ping"
+//
+//	{"peer": {"nickname": ..., "grip": ..., "pubkey": ..., "props": {...}}, "rtt": 1234567}
+type PingResult struct {
+	Peer PeerResult    `json:"peer" yaml:"peer"`
+	RTT  time.Duration `json:"rtt" yaml:"rtt"`
+}
+
+func (r PingResult) Text(w io.Writer) error {
+	addr, _ := r.Peer.Props.Get("addr")
+	_, err := fmt.Fprintf(w, "pong from %s at %s: time=%s\n", r.Peer.Nickname, addr, r.RTT)
+	return err
+}
+
+// LocateResult is where keys were found in the DHT, produced by "goracle locate".
+// Looking a key up by grip can find more than one.
+//
+//	[{"nickname": ..., "grip": ..., "pubkey": ..., "addr": "10.0.0.2:5656", "signed": ..., "expires": ...}]
+type LocateResult []LocatedResult
+
+// LocatedResult is where a key says it can be reached, and how long that's good for
+type LocatedResult struct {
+	Nickname string    `json:"nickname" yaml:"nickname"`
+	Grip     string    `json:"grip" yaml:"grip"`
+	Pubkey   string    `json:"pubkey" yaml:"pubkey"`
+	Addr     string    `json:"addr" yaml:"addr"`
+	Signed   time.Time `json:"signed" yaml:"signed"`
+	Expires  time.Time `json:"expires" yaml:"expires"`
+}
+
+func (r LocateResult) Text(w io.Writer) error {
+	for _, l := range r {
+		_, err := fmt.Fprintf(w, "%s (%s) is at %s, until %s\n", l.Nickname, l.Grip, l.Addr, l.Expires.Format(time.RFC3339))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AssertionResult is a signed assertion of our identity and props, produced by "goracle assert".
+// PEM is the assertion itself, ready to be piped to "goracle add".
+//
+//	{"sender": "<128 hex characters>", "props": {...}, "pem": "-----BEGIN ORACLE MESSAGE-----\n..."}
+type AssertionResult struct {
+	Sender string   `json:"sender" yaml:"sender"`
+	Props  *gork.KV `json:"props" yaml:"props"`
+	PEM    string   `json:"pem" yaml:"pem"`
+}
+
+func (r AssertionResult) Text(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "%s\n", r.PEM)
+	return err
+}
+
+// AddResult is produced by "goracle add". Config is our whole config, including the new peer.
+//
+//	{"peer": {"nickname": ..., "grip": ..., "pubkey": ..., "props": {...}}, "config": {...}}
+type AddResult struct {
+	Peer   PeerResult   `json:"peer" yaml:"peer"`
+	Config *gork.Config `json:"config" yaml:"config"`
+}
+
+func (r AddResult) Text(w io.Writer) error {
+	_, err := io.Copy(w, r.Config)
+	return err
+}
+
+// ExportResult is our private key, produced by "goracle export"
+//
+//	{"priv": "<hex>"}
+type ExportResult struct {
+	Priv string `json:"priv" yaml:"priv"`
+}
+
+func (r ExportResult) Text(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "%s\n", strings.ToUpper(r.Priv))
+	return err
+}
+
+// KeysResult is a newly created key pair, produced by "goracle init".
+// The PEMs are only included if they weren't written to Dir, in which case Config is the signed config written alongside them.
+// Protected means the private key is sealed with a passphrase.
+//
+//	{"nickname": ..., "grip": ..., "pubkey": ..., "priv_pem": "...", "pub_pem": "...", "dir": "...", "config": "...", "protected": true}
+type KeysResult struct {
+	Nickname  string `json:"nickname" yaml:"nickname"`
+	Grip      string `json:"grip" yaml:"grip"`
+	Pubkey    string `json:"pubkey" yaml:"pubkey"`
+	PrivPEM   string `json:"priv_pem,omitempty" yaml:"priv_pem,omitempty"`
+	PubPEM    string `json:"pub_pem,omitempty" yaml:"pub_pem,omitempty"`
+	Dir       string `json:"dir,omitempty" yaml:"dir,omitempty"`
+	Config    string `json:"config,omitempty" yaml:"config,omitempty"`
+	Protected bool   `json:"protected,omitempty" yaml:"protected,omitempty"`
+	art       string
+}
+
+func (r KeysResult) Text(w io.Writer) error {
+	if r.PrivPEM != "" {
+		fmt.Fprintf(w, "%s\n", r.PrivPEM)
+	}
+	if r.PubPEM != "" {
+		fmt.Fprintf(w, "%s\n", r.PubPEM)
+	}
+	fmt.Fprintln(w, r.Nickname)
+	fmt.Fprintf(w, "grip:\t%s\n", r.Grip)
+	if r.Dir != "" {
+		fmt.Fprintf(w, "dir:\t%s\n", r.Dir)
+	}
+	_, err := fmt.Fprintf(w, "\n%s\n", r.art)
+	return err
+}
+
+// ConfigResult summarizes our config after it's been written, by "goracle save" and "goracle config encrypt|decrypt".
+// There's nothing to say about it in text mode.
+//
+//	{"pubkey": ..., "version": 1, "peers": 3, "encrypted": false, "log": "<hash of the last change>"}
+type ConfigResult struct {
+	Pubkey    string `json:"pubkey" yaml:"pubkey"`
+	Version   int    `json:"version" yaml:"version"`
+	Peers     int    `json:"peers" yaml:"peers"`
+	Encrypted bool   `json:"encrypted" yaml:"encrypted"`
+	Log       string `json:"log,omitempty" yaml:"log,omitempty"`
+}
+
+func configResult(prov gork.ConfigProvider) (ConfigResult, error) {
+	conf, err := prov.Get()
+	if err != nil {
+		return ConfigResult{}, err
+	}
+	_, encrypted := prov.(gork.EncryptedConfigProvider)
+	r := ConfigResult{
+		Pubkey:    conf.Pub.ToHex(),
+		Version:   conf.Version,
+		Encrypted: encrypted,
+		Log:       conf.Log,
+	}
+	if conf.Peers != nil {
+		r.Peers = len(*conf.Peers)
+	}
+	return r, nil
+}
+
+func (r ConfigResult) Text(io.Writer) error {
+	return nil
+}
+
+// ConvertResult is produced by "goracle config convert".
+// When there's no output file, Data holds the converted config.
+//
+//	{"in": "conf.json", "out": "conf.yaml", "to": "yaml"}
+type ConvertResult struct {
+	In   string `json:"in" yaml:"in"`
+	Out  string `json:"out,omitempty" yaml:"out,omitempty"`
+	To   string `json:"to" yaml:"to"`
+	Data string `json:"data,omitempty" yaml:"data,omitempty"`
+}
+
+func (r ConvertResult) Text(w io.Writer) error {
+	_, err := io.WriteString(w, r.Data)
+	return err
+}
+
+// MergeResult is produced by "goracle config merge"
+//
+//	{"merged": "other.json", "conflicts": [{"peer": ..., "key": ..., "ours": ..., "theirs": ..., "resolved": ...}]}
+type MergeResult struct {
+	Merged    string          `json:"merged" yaml:"merged"`
+	Conflicts []gork.Conflict `json:"conflicts" yaml:"conflicts"`
+}
+
+func (r MergeResult) Text(w io.Writer) error {
+	for _, c := range r.Conflicts {
+		fmt.Fprintln(w, "conflict:", c)
+	}
+	_, err := fmt.Fprintf(w, "merged %s with %d conflicts\n", r.Merged, len(r.Conflicts))
+	return err
+}
+
+// LogResult is our change log, produced by "goracle log".
+// Verified is only present if verification was asked for.
+//
+//	{"changes": [{"seq": 0, "time": ..., "kind": "add_peer", ...}], "verified": true}
+type LogResult struct {
+	Changes  gork.ChangeLog `json:"changes" yaml:"changes"`
+	Verified *bool          `json:"verified,omitempty" yaml:"verified,omitempty"`
+}
+
+func (r LogResult) Text(w io.Writer) error {
+	for _, change := range r.Changes {
+		fmt.Fprintln(w, change)
+	}
+	if r.Verified != nil && *r.Verified {
+		fmt.Fprintf(w, "verified %d entries\n", len(r.Changes))
+	}
+	return nil
+}
+
+// HelpResult explains a command. It's produced by "goracle help" and "goracle <command> -h".
+//
+//	{
+//		"name": "peers show",
+//		"synopsis": "show everything you know about a peer",
+//		"usage": ["goracle peers show <peer>"],
+//		"flags": [{"name": "priv", "arg": "pem", "usage": "private key location ..."}, ...],
+//		"subcommands": [{"name": ..., "synopsis": ...}],
+//		"examples": ["goracle peers show shy-river"]
+//	}
+type HelpResult struct {
+	Name        string        `json:"name" yaml:"name"`
+	Synopsis    string        `json:"synopsis" yaml:"synopsis"`
+	Usage       []string      `json:"usage" yaml:"usage"`
+	Flags       []flagDoc     `json:"flags,omitempty" yaml:"flags,omitempty"`
+	Subcommands []HelpSummary `json:"subcommands,omitempty" yaml:"subcommands,omitempty"`
+	Examples    []string      `json:"examples,omitempty" yaml:"examples,omitempty"`
+}
+
+// HelpSummary is a subcommand, as listed in a [HelpResult]
+type HelpSummary struct {
+	Name     string `json:"name" yaml:"name"`
+	Synopsis string `json:"synopsis" yaml:"synopsis"`
+}
+
+func helpResult(c command, name string) HelpResult {
+	if name == "" {
+		name = c.Name
+	}
+	res := HelpResult{
+		Name:     name,
+		Synopsis: c.Synopsis,
+		Usage:    c.Usage,
+		Flags:    c.AllFlags(),
+		Examples: c.Examples,
+	}
+	for _, s := range c.Subcommands {
+		if !s.Hidden {
+			res.Subcommands = append(res.Subcommands, HelpSummary{s.Name, s.Synopsis})
+		}
+	}
+	return res
+}
+
+func (r HelpResult) Text(w io.Writer) error {
+	for i, u := range r.Usage {
+		if i == 0 {
+			fmt.Fprintf(w, "usage: %s\n", u)
+		} else {
+			fmt.Fprintf(w, "       %s\n", u)
+		}
+	}
+	fmt.Fprintf(w, "\n%s\n", r.Synopsis)
+	if len(r.Subcommands) > 0 {
+		fmt.Fprintln(w, "\ncommands:")
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		for _, s := range r.Subcommands {
+			fmt.Fprintf(tw, "\t%s\t%s\n", s.Name, s.Synopsis)
+		}
+		tw.Flush()
+	}
+	if len(r.Flags) > 0 {
+		fmt.Fprintln(w, "\nflags:")
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		for _, f := range r.Flags {
+			fmt.Fprintf(tw, "\t%s\t%s\n", f, f.Usage)
+		}
+		tw.Flush()
+	}
+	if len(r.Examples) > 0 {
+		fmt.Fprintln(w, "\nexamples:")
+		for _, e := range r.Examples {
+			fmt.Fprintf(w, "  %s\n", e)
+		}
+	}
+	if len(r.Subcommands) > 0 {
+		fmt.Fprintf(w, "\nRun \"goracle help %s<command>\" for more about a command.\n", strings.TrimPrefix(r.Name+" ", "goracle "))
+	}
+	return nil
+}
+
+// ScriptResult is a shell completion script, produced by "goracle completion".
+//
+//	{"shell": "bash", "script": "..."}
+type ScriptResult struct {
+	Shell  string `json:"shell" yaml:"shell"`
+	Script string `json:"script" yaml:"script"`
+}
+
+func (r ScriptResult) Text(w io.Writer) error {
+	_, err := io.WriteString(w, r.Script)
+	return err
+}
+
+// CandidatesResult is what a partial command line completes to, one per line.
+// It's produced by "goracle complete", which completion scripts call.
+//
+//	["peers", "props"]
+type CandidatesResult []string
+
+func (r CandidatesResult) Text(w io.Writer) error {
+	for _, c := range r {
+		fmt.Fprintln(w, c)
+	}
+	return nil
+}
+
+func orEmpty(kv *gork.KV) *gork.KV {
+	if kv == nil {
+		return gork.NewKV()
+	}
+	return kv
+}
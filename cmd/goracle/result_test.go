@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func TestOutputFormat(t *testing.T) {
+
+	check := assert.New(t)
+	pem := "../../testdata/aged-smoke.pem"
+	pubkey := "e4e7cfb70470a569aa0450d708e524bdc1211b8a9fae219ea22f50f4b339c220be522655c322247ca73bfa3995d0d7f41628b4a95550d64d11e42e8a311803a8"
+
+	info := func(args ...string) []byte {
+		cli := SetupTestCLI(t)
+		cli.Env.Args = append([]string{"goracle"}, args...)
+		cli.Run(context.TODO())
+		r, err := cli.OutStream()
+		check.NoError(err)
+		out, err := io.ReadAll(r)
+		check.NoError(err)
+		return out
+	}
+
+	t.Run("json", func(t *testing.T) {
+		var res map[string]any
+		out := info("info", "--priv", pem, "--format=json")
+		check.NoError(json.Unmarshal(out, &res))
+		check.Equal("aged-smoke", res["nickname"])
+		check.Equal(pubkey, res["pubkey"])
+		check.Contains(res, "grip")
+		check.Contains(res, "props")
+		check.Contains(res, "peers")
+		check.NotContains(string(out), "ORACLE PEER")
+	})
+
+	t.Run("yaml", func(t *testing.T) {
+		var res map[string]any
+		out := info("--format", "yaml", "info", "--priv", pem)
+		check.NoError(yaml.Unmarshal(out, &res))
+		check.Equal(pubkey, res["pubkey"])
+	})
+
+	t.Run("text", func(t *testing.T) {
+		out := info("info", "--priv", pem)
+		check.Contains(string(out), "pubkey:\t"+pubkey)
+		check.Contains(string(out), "ORACLE PEER")
+	})
+
+}
+
+func TestExtractFlag(t *testing.T) {
+	cases := []struct {
+		args  []string
+		value string
+		rest  []string
+	}{
+		{[]string{"info", "--format=json"}, "json", []string{"info"}},
+		{[]string{"-format", "yaml", "peers", "list"}, "yaml", []string{"peers", "list"}},
+		{[]string{"info", "--priv", "x"}, "", []string{"info", "--priv", "x"}},
+		{[]string{"props", "set", "--", "--format=json"}, "", []string{"props", "set", "--", "--format=json"}},
+	}
+	for _, c := range cases {
+		value, rest := extractFlag(c.args, "format")
+		assert.Equal(t, c.value, value, c.args)
+		assert.Equal(t, c.rest, rest, c.args)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/sean9999/go-delphi"
+	"github.com/sean9999/gork"
+	"github.com/sean9999/hermeti"
+	"github.com/sean9999/pear"
+	"github.com/spf13/afero"
+)
+
+var ErrSend = pear.Defer("send")
+
+// StatusSent means the message went straight to the recipient, who hasn't said it arrived
+const StatusSent = "sent"
+
+// Send encrypts a message to a peer, and hands it to goracled to deliver.
+// If goracled doesn't answer, it's sent straight to the peer's addr instead.
+// The message is read from a file, or from stdin if there isn't one, or it's "-".
+//
+//	goracle send [--thread <id>] [--daemon host:port] [--direct] [--wait 3s] <peer> [file|-]
+func (cmd *Exe) Send(ctx context.Context, env hermeti.Env, args []string) (Result, error) {
+
+	fset := flag.NewFlagSet("send", flag.ContinueOnError)
+	thread := fset.String("thread", "", "the thread this message continues")
+	daemon := fset.String("daemon", DefaultDaemon, "where goracled is listening")
+	direct := fset.Bool("direct", false, "send straight to the peer, without goracled")
+	wait := fset.Duration("wait", 3*time.Second, "how long to wait to hear that it arrived")
+	args, err := cmd.ensureSelfWith(ctx, env, args, fset)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrSend, err)
+	}
+	if len(args) < 1 || len(args) > 2 {
+		return nil, usageError(pear.Errorf("%w: send to which peer?", ErrSend))
+	}
+	peer, err := cmd.Self.FindPeer(args[0])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrSend, err)
+	}
+
+	//	the body is a file, or stdin
+	var body []byte
+	if len(args) == 2 && args[1] != "-" {
+		body, err = afero.ReadFile(env.Filesystem, expandPath(env, args[1]))
+	} else {
+		body, err = io.ReadAll(env.InStream)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrSend, err)
+	}
+
+	headers := gork.NewKV()
+	if *thread != "" {
+		headers.Set("thread", *thread)
+	}
+	cmd.Self.WithRand(env.Randomness)
+	msg, err := cmd.Self.Seal(peer, body, headers)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrSend, err)
+	}
+	if len(msg.String()) > gork.MaxDatagram {
+		return nil, pear.Errorf("%w: message is too big to send", ErrSend)
+	}
+	res := SendResult{ID: gork.MessageID(msg), To: peerResult(peer)}
+
+	//	hand it to goracled, if it's there
+	if !*direct {
+		status, err := handoff(ctx, msg, *daemon, *wait)
+		if err == nil {
+			res.Status, res.Via = status, *daemon
+			return res, nil
+		}
+		fmt.Fprintf(env.ErrStream, "goracled isn't answering at %s, so sending directly: %s\n", *daemon, err)
+	}
+
+	//	otherwise, send it straight to the peer
+	addr, hasAddr := peer.Properties.Get("addr")
+	if !hasAddr {
+		return nil, pear.Errorf("%w: %s has no addr", ErrSend, peer.Nickname())
+	}
+	status, err := sendDirect(ctx, msg, addr, *wait)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrSend, err)
+	}
+	res.Status, res.Via = status, addr
+	return res, nil
+}
+
+// handoff gives a message to goracled, and waits a while to hear whether it was delivered.
+// It's an error if goracled doesn't say it has it.
+func handoff(ctx context.Context, msg *delphi.Message, daemon string, wait time.Duration) (string, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", daemon)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	_, err = fmt.Fprintf(conn, "%s", msg)
+	if err != nil {
+		return "", err
+	}
+	status := ""
+	deadline := time.Now().Add(wait)
+	for status != gork.StatusDelivered && status != gork.StatusHeld {
+		conn.SetReadDeadline(deadline)
+		next, err := receiptFor(conn, msg, msg.Sender)
+		if err != nil {
+			if status != "" {
+				//	goracled has it, and that'll have to do
+				return status, nil
+			}
+			return "", err
+		}
+		status = next
+	}
+	return status, nil
+}
+
+// sendDirect sends a message straight to addr, and waits a while for the recipient's receipt
+func sendDirect(ctx context.Context, msg *delphi.Message, addr string, wait time.Duration) (string, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", addr)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	_, err = fmt.Fprintf(conn, "%s", msg)
+	if err != nil {
+		return "", err
+	}
+	conn.SetReadDeadline(time.Now().Add(wait))
+	status, err := receiptFor(conn, msg, msg.Recipient)
+	if err != nil {
+		//	it went, but we can't say it arrived
+		return StatusSent, nil
+	}
+	return status, nil
+}
+
+// receiptFor reads until it gets a receipt for msg signed by from, and returns the status it reports
+func receiptFor(conn net.Conn, msg *delphi.Message, from delphi.Key) (string, error) {
+	id := gork.MessageID(msg)
+	buf := make([]byte, 2048)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return "", err
+		}
+		block, _ := pem.Decode(buf[:n])
+		if block == nil {
+			continue
+		}
+		receipt := new(delphi.Message)
+		if receipt.FromPEM(*block) != nil || receipt.Subject != gork.SubjectReceipt {
+			continue
+		}
+		if !receipt.Sender.Equal(from) || !receipt.Verify() {
+			continue
+		}
+		if got, _ := receipt.Headers.Get("receipt"); got != id {
+			continue
+		}
+		status, _ := receipt.Headers.Get("status")
+		if status == "" {
+			status = gork.StatusDelivered
+		}
+		return status, nil
+	}
+}
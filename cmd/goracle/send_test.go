@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/pem"
+	"net"
+	"testing"
+
+	"github.com/sean9999/go-delphi"
+	"github.com/sean9999/gork"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+// answering reads messages on conn, and answers each with whatever answer returns
+func answering(conn net.PacketConn, answer func(*delphi.Message) []*delphi.Message) {
+	buf := make([]byte, gork.MaxDatagram)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		block, _ := pem.Decode(buf[:n])
+		msg := new(delphi.Message)
+		if block == nil || msg.FromPEM(*block) != nil {
+			continue
+		}
+		for _, reply := range answer(msg) {
+			conn.WriteTo([]byte(reply.String()), from)
+		}
+	}
+}
+
+func TestSend(t *testing.T) {
+
+	check := assert.New(t)
+
+	//	bob is listening, and says he got whatever he's sent
+	bob := gork.NewPrincipal(rand.Reader, nil, nil)
+	bobConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	check.NoError(err)
+	defer bobConn.Close()
+	received := make(chan *delphi.Message, 4)
+	go answering(bobConn, func(msg *delphi.Message) []*delphi.Message {
+		receipt, _ := bob.Receipt(msg, "")
+		received <- msg
+		return []*delphi.Message{receipt}
+	})
+	node := SetupTestNode(t, func(n *testNode) {
+		bobAsPeer := bob.AsPeer()
+		bobAsPeer.Properties.Set("addr", bobConn.LocalAddr().String())
+		n.me.AddPeer(bobAsPeer)
+	})
+	me := node.me
+
+	//	a stand-in for goracled, which queues it, then hears it was delivered
+	daemon, err := net.ListenPacket("udp", "127.0.0.1:0")
+	check.NoError(err)
+	defer daemon.Close()
+	go answering(daemon, func(msg *delphi.Message) []*delphi.Message {
+		queued, _ := me.Receipt(msg, gork.StatusQueued)
+		delivered, _ := me.Receipt(msg, gork.StatusDelivered)
+		return []*delphi.Message{queued, delivered}
+	})
+
+	//	send what's on stdin
+	run := func(stdin string, args ...string) (*Exe, string) {
+		node.cli.Env.InStream = bytes.NewBufferString(stdin)
+		return node.run("send", args...)
+	}
+
+	exe, out := run("hello bob", "--daemon", daemon.LocalAddr().String(), bob.Nickname())
+	check.NoError(exe.Err)
+	check.Contains(out, gork.StatusDelivered)
+	check.Contains(out, daemon.LocalAddr().String())
+
+	//	straight to bob, from a file
+	check.NoError(afero.WriteFile(node.cli.Env.Filesystem, "note.txt", []byte("a note for bob"), 0600))
+	exe, out = run("", "--direct", bob.Nickname(), "note.txt")
+	check.NoError(exe.Err)
+	check.Contains(out, gork.StatusDelivered)
+	check.Contains(out, bobConn.LocalAddr().String())
+	msg := <-received
+	check.NoError(bob.Open(msg))
+	check.Equal("a note for bob", string(msg.PlainText))
+
+	//	with nobody answering at either end, it was sent, and that's all we know
+	quiet, err := net.ListenPacket("udp", "127.0.0.1:0")
+	check.NoError(err)
+	defer quiet.Close()
+	bobConn.Close()
+	exe, out = run("anyone there?", "--daemon", quiet.LocalAddr().String(), "--wait", "50ms", bob.Nickname())
+	check.NoError(exe.Err)
+	check.Contains(out, StatusSent)
+
+	exe, _ = run("", "nobody")
+	check.Equal(ExitNotFound, exe.ExitCode)
+
+}
@@ -2,62 +2,52 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/sean9999/gork"
 	"github.com/sean9999/hermeti"
-	"github.com/sean9999/pear"
 )
 
 var (
 	DefaultDirectory = filepath.Join("~", ".gork")
 )
 
-// an CLIError is an error with an exit code
-type CLIError struct {
-	Msg      string
-	ExitCode int
-	Child    error
-}
-
-func (o *CLIError) Error() string {
-	if o.Child == nil {
-		return o.Msg
-	} else {
-		return fmt.Sprintf("%s: %s", o.Msg, o.Child)
-	}
-}
-
-func (o *CLIError) Wrap(child error) {
-	o.Child = child
-}
-
-func (o *CLIError) Unrawp() error {
-	return o.Child
-}
-
-func complain(msg string, exitCode int, child error, stream io.Writer) {
-	err := &CLIError{msg, exitCode, child}
-	fmt.Fprintln(stream, err)
-	os.Exit(exitCode)
-}
-
 // Exe is the execution of a command, including state
 type Exe struct {
 	Verbosity uint
 	Self      *gork.Principal
 	Config    gork.ConfigProvider
+	// Near is who goracled has heard announce themselves on the local network
+	Near gork.Roster
+	// Pending is who goracled has queued asking to be our peers
+	Pending gork.Roster
+	// Mail is the messages goracled has received for us
+	Mail gork.Inbox
+	// Observed is what goracled has observed of our peers
+	Observed gork.Observations
+	// Command is the subcommand being run
+	Command string
+	// Format is how results are written out
+	Format OutputFormat
+	// Err is what went wrong, if anything
+	Err error
+	// ExitCode is what the process should exit with
+	ExitCode int
 }
 
 func (e *Exe) State() *Exe {
 	return e
 }
 
-type subcommand func(context.Context, hermeti.Env, []string) ([]string, error)
+// a subcommand returns a [Result], which Run writes out in the format asked for
+type subcommand func(context.Context, hermeti.Env, []string) (Result, error)
 
 // Run sets the whole thing in motion and contains the entire execution lifecycle
 func (exe *Exe) Run(env hermeti.Env) {
@@ -67,7 +57,8 @@ func (exe *Exe) Run(env hermeti.Env) {
 
 	args, err := exe.bootstrap(ctx, env, args)
 	if err != nil {
-		panic(err)
+		exe.fail(env, err)
+		return
 	}
 
 	//	if no subcommand is specified, "info" is implied
@@ -77,37 +68,71 @@ func (exe *Exe) Run(env hermeti.Env) {
 		subcmd = args[0]
 		args = args[1:]
 	}
+	exe.Command = subcmd
 
-	//	modify this as needed
-	subcommands := map[string]subcommand{
-		"info":   exe.Info,
-		"init":   exe.Init,
-		"save":   exe.Save,
-		"assert": exe.Assert,
-		"add":    exe.Add,
-		"export": exe.Export,
+	c, _, err := exe.lookup(subcmd)
+	if err != nil {
+		exe.fail(env, err)
+		return
 	}
 
-	fn, exists := subcommands[subcmd]
-
-	if !exists {
-		err := pear.Errorf("unsupported command: %q", subcmd)
-		panic(err)
+	//	"goracle <command> [<subcommand>] -h" is the same as "goracle help <command> [<subcommand>]"
+	fn := c.Run
+	if wantsHelp(args) {
+		fn = func(context.Context, hermeti.Env, []string) (Result, error) {
+			return exe.helpFor(subcmd, args), nil
+		}
 	}
 
-	_, err = fn(ctx, env, args)
+	res, err := fn(ctx, env, args)
 	if err != nil {
-		fmt.Fprintln(env.ErrStream, err)
+		exe.fail(env, err)
+		return
+	}
+	err = exe.render(env.OutStream, res)
+	if err != nil {
+		exe.fail(env, &CLIError{Msg: "could not write output", ExitCode: ExitIO, Child: err})
 	}
 
 }
 
+// fail reports an error, along with usage if the command line was to blame, and sets the exit code
+func (exe *Exe) fail(env hermeti.Env, err error) {
+	exe.Err = err
+	exe.ExitCode = exitCode(err)
+	fmt.Fprintln(env.ErrStream, err)
+	if exe.ExitCode == ExitUsage {
+		//	help for the command at fault, or for goracle as a whole
+		fmt.Fprintln(env.ErrStream)
+		c, name, err := exe.lookup(exe.Command)
+		if err != nil {
+			c, name = exe.root(), ""
+		}
+		helpResult(c, name).Text(env.ErrStream)
+	}
+}
+
 // parse global values early in execution
 func (cmd *Exe) bootstrap(_ context.Context, _ hermeti.Env, args []string) ([]string, error) {
 
+	//	--format may appear anywhere, as in "goracle info --format=json"
+	format, args := extractFlag(args, "format")
+	if format == "" {
+		format = string(OutputText)
+	}
+	f, err := parseOutputFormat(format)
+	if err != nil {
+		return args, usageError(err)
+	}
+	cmd.Format = f
+
 	gset := flag.NewFlagSet("global", flag.ContinueOnError)
+	gset.SetOutput(io.Discard)
 	verbosity := gset.Uint("verbosity", 0, "verbosity level")
-	gset.Parse(args)
+	err = gset.Parse(args)
+	if err != nil {
+		return args, usageError(err)
+	}
 
 	cmd.Verbosity = *verbosity
 	args = gset.Args()
@@ -116,53 +141,170 @@ func (cmd *Exe) bootstrap(_ context.Context, _ hermeti.Env, args []string) ([]st
 
 }
 
+// extractFlag removes a string flag from anywhere in args, returning its value and what's left.
+// Both "--name=value" and "--name value" are understood, with one or two dashes.
+// Nothing after a "--" is touched.
+func extractFlag(args []string, name string) (string, []string) {
+	value := ""
+	rest := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			rest = append(rest, args[i:]...)
+			break
+		}
+		bare := strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-")
+		if bare == arg {
+			rest = append(rest, arg)
+			continue
+		}
+		if v, ok := strings.CutPrefix(bare, name+"="); ok {
+			value = v
+			continue
+		}
+		if bare == name && i+1 < len(args) {
+			value = args[i+1]
+			i++
+			continue
+		}
+		rest = append(rest, arg)
+	}
+	return value, rest
+}
+
 // ensureSelf ensures the presence of a gork.Principal by checking for --priv and optionally --config
-func (cmd *Exe) ensureSelf(_ context.Context, env hermeti.Env, args []string) ([]string, error) {
+func (cmd *Exe) ensureSelf(ctx context.Context, env hermeti.Env, args []string) ([]string, error) {
 
 	if cmd.Self != nil {
 		return args, nil
 	}
 
-	fset := flag.NewFlagSet("selfer", flag.ContinueOnError)
-	conf := new(string)
-	priv := new(string)
-	fset.StringVar(conf, "config", "~/.gork/config.json", "config file location")
-	fset.StringVar(priv, "priv", "~/.gork/priv.pem", "private key location")
-	fset.Parse(args)
+	return cmd.ensureSelfWith(ctx, env, args, flag.NewFlagSet("selfer", flag.ContinueOnError))
+}
+
+// ensureSelfWith is ensureSelf for subcommands with flags of their own, which they define on fset
+func (cmd *Exe) ensureSelfWith(_ context.Context, env hermeti.Env, args []string, fset *flag.FlagSet) ([]string, error) {
+	conf, priv := keyFlags(fset)
+	err := fset.Parse(args)
+	if err != nil {
+		return args, usageError(err)
+	}
+	return fset.Args(), cmd.loadSelf(env, *priv, *conf)
+}
+
+// ensureSelfAround is ensureSelfWith for commands with subcommands of their own.
+// It takes the subcommand from args, or def if there isn't one, and flags can come before or after it,
+// as in "goracle peers --config x drop bob" and "goracle peers drop --config x bob".
+func (cmd *Exe) ensureSelfAround(_ context.Context, env hermeti.Env, args []string, fset *flag.FlagSet, def string) (string, []string, error) {
+	conf, priv := keyFlags(fset)
+	err := fset.Parse(args)
+	if err != nil {
+		return def, args, usageError(err)
+	}
+	subcmd := def
+	if fset.NArg() > 0 {
+		subcmd = fset.Arg(0)
+		err = fset.Parse(fset.Args()[1:])
+		if err != nil {
+			return subcmd, args, usageError(err)
+		}
+	}
+	return subcmd, fset.Args(), cmd.loadSelf(env, *priv, *conf)
+}
+
+// keyFlags defines the flags that say where our key and config are
+func keyFlags(fset *flag.FlagSet) (conf, priv *string) {
+	fset.SetOutput(io.Discard)
+	conf = fset.String("config", "~/.gork/config.json", "config file location")
+	priv = fset.String("priv", "~/.gork/priv.pem", "private key location")
+	return conf, priv
+}
+
+// loadSelf loads our key from priv, and our config from conf, if we haven't already
+func (cmd *Exe) loadSelf(env hermeti.Env, priv, conf string) error {
+
+	if cmd.Self != nil {
+		return nil
+	}
+	priv = expandPath(env, priv)
+	conf = expandPath(env, conf)
 
 	//	the lack of a well-formed pem file is fatal
-	pemFile, err := env.Filesystem.Open(*priv)
+	pemFile, err := env.Filesystem.Open(priv)
 	if err != nil {
-		return args, pear.Errorf("could not find pem file: %w", err)
+		return fmt.Errorf("could not find pem file: %w", err)
 	}
 
 	pemBytes, err := io.ReadAll(pemFile)
 	if err != nil {
-		return args, pear.Errorf("could not read pem file: %w", err)
+		return fmt.Errorf("could not read pem file: %w", err)
 	}
 
 	p := gork.NewPrincipal(env.Randomness, nil, nil)
 
-	err = p.UnmarshalPEM(pemBytes)
+	if gork.IsEncryptedPEM(pemBytes) {
+		err = p.UnmarshalEncryptedPEM(pemBytes, []byte(env.Vars[PassphraseVar]))
+		if errors.Is(err, gork.ErrNeedPassphrase) {
+			err = fmt.Errorf("%w. Set %s", err, PassphraseVar)
+		}
+	} else {
+		err = p.UnmarshalPEM(pemBytes)
+	}
 	if err != nil {
-		return args, pear.Errorf("could not create principal: %w", err)
+		return fmt.Errorf("could not create principal: %w", err)
 	}
 	cmd.Self = &p
 
-	//	the lack of a config file is not an error
-	prov := gork.FileBasedConfigProvider{
-		Fs:   env.Filesystem,
-		Name: *conf,
-	}
-	_, err = prov.Get()
-	if err == nil {
-		err = p.WithConfigProvider(prov)
-		if err != nil {
-			return nil, err
+	//	the lack of a config file is not an error, but one we can't read or trust is
+	prov := gork.OpenConfigFile(env.Filesystem, conf, &p)
+	//	keep a change log next to plain-text configs.
+	//	A plain-text log would leak the contents of an encrypted config.
+	if _, encrypted := prov.(gork.EncryptedConfigProvider); !encrypted {
+		p.Journal = gork.FileJournal{
+			Fs:     env.Filesystem,
+			Name:   conf + ".log",
+			Origin: "goracle " + cmd.Command,
 		}
+	}
+
+	//	goracled writes down who it hears on the local network, next to the config
+	cmd.Near = gork.NearbyRoster(env.Filesystem, conf)
+	//	and who's asked to be our peer, when it's been told to wait for approval
+	cmd.Pending = gork.RequestRoster(env.Filesystem, conf)
+	//	and the messages it's received
+	cmd.Mail = gork.InboxFile(env.Filesystem, conf)
+	//	and what it's observed of our peers, which is only for show, so it's fine if it can't be read
+	cmd.Observed, _ = gork.ObservationsFile(env.Filesystem, conf).Get()
+
+	err = p.WithConfigProvider(prov)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return err
+	default:
 		cmd.Config = prov
 	}
 
-	return fset.Args(), nil
+	return nil
+
+}
 
+// expandPath expands environment variables, and a leading "~", using the environment's variables.
+// The user's home directory comes from $HOME, or failing that, the operating system.
+func expandPath(env hermeti.Env, s string) string {
+	s = os.Expand(s, func(k string) string {
+		return env.Vars[k]
+	})
+	if s != "~" && !strings.HasPrefix(s, "~/") {
+		return s
+	}
+	home := env.Vars["HOME"]
+	if home == "" {
+		var err error
+		home, err = os.UserHomeDir()
+		if err != nil {
+			return s
+		}
+	}
+	return filepath.Join(home, strings.TrimPrefix(s, "~"))
 }
@@ -0,0 +1,697 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sean9999/go-delphi"
+	"github.com/sean9999/gork"
+)
+
+const (
+	// DefaultBucketSize is how many contacts the DHT keeps at each distance
+	DefaultBucketSize = 20
+	// DefaultRepublish is how often we tell the DHT where we are
+	DefaultRepublish = time.Hour
+	// alpha is how many nodes a lookup asks at once
+	alpha = 3
+	// findTimeout is how long a lookup waits on any one node
+	findTimeout = time.Second
+	// locateTimeout is how long a lookup on goracle's behalf can take altogether
+	locateTimeout = 10 * time.Second
+	// staleAfter is how long a contact can go unheard from before a newcomer can take its place in a full bucket
+	staleAfter = time.Hour
+	// maxRecords is the most records we'll hold for others
+	maxRecords = 4096
+	// maxRecordsPerHost is the most records we'll hold that came from any one address
+	maxRecordsPerHost = 64
+)
+
+// a contact is a node in the DHT
+type contact struct {
+	id   gork.NodeID
+	pub  delphi.Key
+	addr string
+	seen time.Time
+}
+
+func newContact(pub delphi.Key, addr string, seen time.Time) contact {
+	return contact{gork.IDOf(pub), pub, addr, seen}
+}
+
+// a table is a Kademlia routing table.
+// Contacts are kept in buckets by how many leading bits their IDs share with ours, least recently seen first.
+type table struct {
+	mu      sync.Mutex
+	self    gork.NodeID
+	k       int
+	buckets [len(gork.NodeID{})*8 + 1][]contact
+}
+
+func newTable(self gork.NodeID, k int) *table {
+	return &table{self: self, k: k}
+}
+
+// update records that we've heard from c.
+// A full bucket only makes room for c if its least recently seen contact has gone quiet,
+// since nodes that have been around a while tend to stay around.
+func (t *table) update(c contact) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	i := t.self.CommonPrefix(c.id)
+	b := t.buckets[i]
+	for j, o := range b {
+		if o.pub.Equal(c.pub) {
+			t.buckets[i] = append(slices.Delete(b, j, j+1), c)
+			return
+		}
+	}
+	switch {
+	case len(b) < t.k:
+		t.buckets[i] = append(b, c)
+	case c.seen.Sub(b[0].seen) > staleAfter:
+		t.buckets[i] = append(b[1:], c)
+	}
+}
+
+// has reports whether pub is in the table
+func (t *table) has(pub delphi.Key) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return slices.ContainsFunc(t.buckets[t.self.CommonPrefix(gork.IDOf(pub))], func(o contact) bool {
+		return o.pub.Equal(pub)
+	})
+}
+
+// drop forgets a contact that didn't answer
+func (t *table) drop(pub delphi.Key) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	i := t.self.CommonPrefix(gork.IDOf(pub))
+	t.buckets[i] = slices.DeleteFunc(t.buckets[i], func(o contact) bool {
+		return o.pub.Equal(pub)
+	})
+}
+
+// closest returns up to n contacts, nearest to target first
+func (t *table) closest(target gork.NodeID, n int) []contact {
+	t.mu.Lock()
+	all := []contact{}
+	for _, b := range t.buckets {
+		all = append(all, b...)
+	}
+	t.mu.Unlock()
+	byDistance(target, all)
+	return all[:min(n, len(all))]
+}
+
+func (t *table) len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := 0
+	for _, b := range t.buckets {
+		n += len(b)
+	}
+	return n
+}
+
+func byDistance(target gork.NodeID, cs []contact) {
+	slices.SortFunc(cs, func(a, b contact) int {
+		switch {
+		case target.Closer(a.id, b.id):
+			return -1
+		case target.Closer(b.id, a.id):
+			return 1
+		}
+		return 0
+	})
+}
+
+// records are address records we hold for others, by where they are in the DHT.
+// Each is held along with the address it came from, so that no one address can take more than its share.
+type records struct {
+	mu      sync.Mutex
+	byID    map[gork.NodeID]held
+	perHost map[string]int
+}
+
+// a held record, and the address it came from
+type held struct {
+	rec  gork.AddrRecord
+	from string
+}
+
+func newRecords() *records {
+	return &records{byID: map[gork.NodeID]held{}, perHost: map[string]int{}}
+}
+
+// put holds a verified record that came from the address from,
+// unless we hold a newer one for the same key, or it's expired, or we're full, or from has had its share
+func (r *records) put(rec gork.AddrRecord, from string, now time.Time) bool {
+	if rec.Expired(now) {
+		return false
+	}
+	id := gork.IDOf(delphi.KeyFromHex(rec.Pub))
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	old, ok := r.byID[id]
+	if ok && !rec.Newer(old.rec) {
+		return false
+	}
+	full := func() bool {
+		return (!ok && len(r.byID) >= maxRecords) || (from != old.from && r.perHost[from] >= maxRecordsPerHost)
+	}
+	if full() {
+		r.dropExpired(now)
+		old, ok = r.byID[id]
+		if full() {
+			return false
+		}
+	}
+	if ok {
+		r.release(old.from)
+	}
+	r.byID[id] = held{rec, from}
+	r.perHost[from]++
+	return true
+}
+
+// get returns the record we hold for id, if it hasn't expired
+func (r *records) get(id gork.NodeID, now time.Time) []gork.AddrRecord {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	recs := []gork.AddrRecord{}
+	if h, ok := r.byID[id]; ok && !h.rec.Expired(now) {
+		recs = append(recs, h.rec)
+	}
+	return recs
+}
+
+// withGrip returns up to n records we hold, that haven't expired, of keys with that grip, in order of key
+func (r *records) withGrip(grip string, now time.Time, n int) []gork.AddrRecord {
+	r.mu.Lock()
+	recs := []gork.AddrRecord{}
+	for _, h := range r.byID {
+		if !h.rec.Expired(now) && (gork.Peer{Key: delphi.KeyFromHex(h.rec.Pub)}).Grip() == grip {
+			recs = append(recs, h.rec)
+		}
+	}
+	r.mu.Unlock()
+	slices.SortFunc(recs, func(a, b gork.AddrRecord) int {
+		return strings.Compare(a.Pub, b.Pub)
+	})
+	return recs[:min(n, len(recs))]
+}
+
+// expire forgets records that have expired
+func (r *records) expire(now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.dropExpired(now)
+}
+
+// dropExpired is expire, with the lock held
+func (r *records) dropExpired(now time.Time) {
+	for id, h := range r.byID {
+		if h.rec.Expired(now) {
+			delete(r.byID, id)
+			r.release(h.from)
+		}
+	}
+}
+
+// release gives back the share of a record that came from the address from, with the lock held
+func (r *records) release(from string) {
+	r.perHost[from]--
+	if r.perHost[from] <= 0 {
+		delete(r.perHost, from)
+	}
+}
+
+// calls are FINDs waiting on a FOUND, along with who they were sent to.
+// Ids travel in the clear, so a FOUND only counts if it's from whoever the FIND was sent to.
+type calls struct {
+	mu      sync.Mutex
+	pending map[string]call
+}
+
+// a call is a FIND waiting on a FOUND
+type call struct {
+	to     delphi.Key
+	answer chan gork.Found
+}
+
+func newCalls() *calls {
+	return &calls{pending: map[string]call{}}
+}
+
+// open waits on an answer to the FIND with that id, sent to to
+func (c *calls) open(id string, to delphi.Key) chan gork.Found {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan gork.Found, 1)
+	c.pending[id] = call{to, ch}
+	return ch
+}
+
+func (c *calls) close(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.pending, id)
+}
+
+// answer hands a FOUND from from to whoever's waiting on it, reporting whether anyone was
+func (c *calls) answer(id string, from delphi.Key, found gork.Found) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	pending, ok := c.pending[id]
+	if !ok || !pending.to.Equal(from) {
+		return false
+	}
+	delete(c.pending, id)
+	pending.answer <- found
+	return true
+}
+
+// a dht is our part in a Kademlia-style distributed hash table of address records
+type dht struct {
+	table   *table
+	records *records
+	calls   *calls
+	// strangers limits how fast each address can introduce nodes we've not heard of
+	strangers *limiter
+}
+
+func newDHT(self gork.NodeID, k int, rate float64, burst int) *dht {
+	return &dht{newTable(self, k), newRecords(), newCalls(), newLimiter(rate, burst)}
+}
+
+// welcome reports whether a node that's contacted us from addr can have a place in the table, or a record held.
+// Contacts and peers always can. Strangers cost addr a token, since keys are free, and there's no telling them apart otherwise.
+func welcome(s state, pub delphi.Key, addr net.Addr) bool {
+	if s.dht.table.has(pub) || s.node.HasPeer(gork.Peer{Key: pub}) {
+		return true
+	}
+	return s.dht.strangers.allow(host(addr))
+}
+
+// join seeds the table with our peers, and then tells the DHT where we are, every so often, until ctx is done
+func join(ctx context.Context, s state, every time.Duration, errs chan error, outbox chan Envelope) {
+	s.node.Do(func(me *gork.Principal) error {
+		for _, peer := range me.Peers {
+			if addr, hasAddr := peer.Properties.Get("addr"); hasAddr {
+				s.dht.table.update(newContact(peer.Key, addr, time.Time{}))
+			}
+		}
+		return nil
+	})
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		err := publish(ctx, s, outbox)
+		if err != nil {
+			errs <- err
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.dht.records.expire(now)
+		}
+	}
+}
+
+// publish looks ourselves up, which fills out our part of the table,
+// and asks the nodes closest to us to hold our address record, if we have one
+func publish(ctx context.Context, s state, outbox chan Envelope) error {
+	var rec gork.AddrRecord
+	var changed bool
+	err := s.node.Do(func(me *gork.Principal) error {
+		var err error
+		rec, changed, err = me.AddrRecord(time.Now())
+		return err
+	})
+	if changed {
+		s.node.touch()
+	}
+	if err != nil {
+		return err
+	}
+	closest, _ := lookup(ctx, s, s.dht.table.self, false, outbox)
+	if rec.Addr == "" {
+		return nil
+	}
+	for _, c := range closest {
+		var msg *delphi.Message
+		err := s.node.Do(func(me *gork.Principal) error {
+			var err error
+			msg, err = me.Store(gork.Peer{Key: c.pub}, rec)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		to, err := net.ResolveUDPAddr("udp", c.addr)
+		if err != nil {
+			continue
+		}
+		outbox <- Envelope{Message: msg, SenderAddress: s.localAddr, RecipientAddress: to}
+	}
+	return nil
+}
+
+// lookup walks the DHT toward target, asking the closest nodes it knows of what they know, until it runs out of closer nodes to ask.
+// It returns the closest nodes that answered, and any records for target found along the way.
+// If value is set, it stops as soon as it finds a record.
+func lookup(ctx context.Context, s state, target gork.NodeID, value bool, outbox chan Envelope) ([]contact, []gork.AddrRecord) {
+	k := s.dht.table.k
+	shortlist := s.dht.table.closest(target, k)
+	asked := map[string]bool{}
+	var answered []contact
+	found := map[string]gork.AddrRecord{}
+	self := s.node.self.PublicKey()
+
+	type answer struct {
+		c     contact
+		found gork.Found
+		ok    bool
+	}
+	for ctx.Err() == nil && !(value && len(found) > 0) {
+		round := []contact{}
+		for _, c := range shortlist {
+			if len(round) == alpha {
+				break
+			}
+			if !asked[c.pub.ToHex()] {
+				asked[c.pub.ToHex()] = true
+				round = append(round, c)
+			}
+		}
+		if len(round) == 0 {
+			break
+		}
+		answers := make(chan answer, len(round))
+		for _, c := range round {
+			go func() {
+				f, ok := find(ctx, s, c, func(me *gork.Principal, to gork.Peer) (*delphi.Message, error) {
+					return me.Find(to, target, nil)
+				}, outbox)
+				answers <- answer{c, f, ok}
+			}()
+		}
+		for range round {
+			a := <-answers
+			if !a.ok {
+				shortlist = slices.DeleteFunc(shortlist, func(o contact) bool {
+					return o.pub.Equal(a.c.pub)
+				})
+				continue
+			}
+			answered = append(answered, a.c)
+			for _, rec := range a.found.Records {
+				if rec.Verify() == nil && !rec.Expired(time.Now()) && gork.IDOf(delphi.KeyFromHex(rec.Pub)) == target {
+					if held, ok := found[rec.Pub]; !ok || rec.Newer(held) {
+						found[rec.Pub] = rec
+					}
+				}
+			}
+			for _, fc := range a.found.Contacts {
+				pub := delphi.KeyFromHex(fc.Pub)
+				if pub.IsZero() || pub.Equal(self) || fc.Addr == "" {
+					continue
+				}
+				if !slices.ContainsFunc(shortlist, func(o contact) bool { return o.pub.Equal(pub) }) {
+					shortlist = append(shortlist, newContact(pub, fc.Addr, time.Time{}))
+				}
+			}
+		}
+		byDistance(target, shortlist)
+		shortlist = shortlist[:min(k, len(shortlist))]
+	}
+
+	byDistance(target, answered)
+	recs := make([]gork.AddrRecord, 0, len(found))
+	for _, rec := range found {
+		recs = append(recs, rec)
+	}
+	return answered[:min(k, len(answered))], recs
+}
+
+// find sends c the FIND that ask makes, and waits for the FOUND. A node that doesn't answer is dropped from the table.
+func find(ctx context.Context, s state, c contact, ask func(*gork.Principal, gork.Peer) (*delphi.Message, error), outbox chan Envelope) (gork.Found, bool) {
+	to, err := net.ResolveUDPAddr("udp", c.addr)
+	if err != nil {
+		s.dht.table.drop(c.pub)
+		return gork.Found{}, false
+	}
+	var msg *delphi.Message
+	err = s.node.Do(func(me *gork.Principal) error {
+		var err error
+		msg, err = ask(me, gork.Peer{Key: c.pub})
+		return err
+	})
+	if err != nil {
+		return gork.Found{}, false
+	}
+	id, _ := msg.Headers.Get("find")
+	answered := s.dht.calls.open(id, c.pub)
+	defer s.dht.calls.close(id)
+	outbox <- Envelope{Message: msg, SenderAddress: s.localAddr, RecipientAddress: to}
+	timer := time.NewTimer(findTimeout)
+	defer timer.Stop()
+	select {
+	case f := <-answered:
+		return f, true
+	case <-timer.C:
+		s.dht.table.drop(c.pub)
+	case <-ctx.Done():
+	}
+	return gork.Found{}, false
+}
+
+// recordsFor is what we can say of target without asking anyone: our own record if it's us, or the one we hold for it
+func recordsFor(s state, target gork.NodeID, now time.Time) []gork.AddrRecord {
+	if target == s.dht.table.self {
+		if own, ok := ownRecord(s, now); ok {
+			return []gork.AddrRecord{own}
+		}
+	}
+	return s.dht.records.get(target, now)
+}
+
+// recordsWithGrip is what we can say of a grip without asking anyone: our own record if it's ours, and those we hold of keys with it
+func recordsWithGrip(s state, grip string, now time.Time) []gork.AddrRecord {
+	recs := []gork.AddrRecord{}
+	if own, ok := ownRecord(s, now); ok && (gork.Peer{Key: s.node.self.PublicKey()}).Grip() == grip {
+		recs = append(recs, own)
+	}
+	return append(recs, s.dht.records.withGrip(grip, now, gork.FoundRecordLimit-len(recs))...)
+}
+
+// ownRecord is our own address record, if we've signed one that hasn't expired
+func ownRecord(s state, now time.Time) (gork.AddrRecord, bool) {
+	var own gork.AddrRecord
+	var ok bool
+	s.node.Do(func(me *gork.Principal) error {
+		own, ok = gork.AddrRecordOf(me.AsPeer())
+		return nil
+	})
+	return own, ok && !own.Expired(now)
+}
+
+// processFind tells a node what we know of the place in the DHT it asks about.
+// A FIND from ourselves is goracle asking us to look something up.
+func processFind(s state, e Envelope, errs chan error, outbox chan Envelope) {
+	if gork.ByGrip(e.Message) {
+		processFindGrip(s, e, errs, outbox)
+		return
+	}
+	_, target, err := gork.FindTarget(e.Message)
+	if err != nil {
+		errs <- err
+		return
+	}
+	sender := e.Message.Sender
+	if sender.Equal(s.node.self.PublicKey()) {
+		//	anyone who's seen it could replay it from anywhere, and have the answer sent there
+		if err := s.replays.take(e.Message, time.Now()); err != nil {
+			errs <- fmt.Errorf("find from goracle: %w", err)
+			return
+		}
+		go locate(s, e, target, outbox)
+		return
+	}
+	if s.dht == nil {
+		errs <- fmt.Errorf("ignoring find from %s: not in the dht", gork.Peer{Key: sender}.Nickname())
+		return
+	}
+	now := time.Now()
+	if welcome(s, sender, e.SenderAddress) {
+		s.dht.table.update(newContact(sender, e.SenderAddress.String(), now))
+	}
+	found := gork.Found{Records: recordsFor(s, target, now)}
+	for _, c := range s.dht.table.closest(target, min(s.dht.table.k+1, gork.FoundContactLimit)) {
+		if !c.pub.Equal(sender) && len(found.Contacts) < gork.FoundContactLimit {
+			found.Contacts = append(found.Contacts, gork.Contact{Pub: c.pub.ToHex(), Addr: c.addr})
+		}
+	}
+	respond(s, e, found, nil, errs, outbox)
+}
+
+// processFindGrip tells a node which records we hold of keys with the grip it asks about.
+// A FIND by grip from ourselves is goracle asking us to ask around.
+func processFindGrip(s state, e Envelope, errs chan error, outbox chan Envelope) {
+	_, grip, err := gork.FindGripOf(e.Message)
+	if err != nil {
+		errs <- err
+		return
+	}
+	sender := e.Message.Sender
+	if sender.Equal(s.node.self.PublicKey()) {
+		if err := s.replays.take(e.Message, time.Now()); err != nil {
+			errs <- fmt.Errorf("find from goracle: %w", err)
+			return
+		}
+		go locateGrip(s, e, grip, outbox)
+		return
+	}
+	if s.dht == nil {
+		errs <- fmt.Errorf("ignoring find from %s: not in the dht", gork.Peer{Key: sender}.Nickname())
+		return
+	}
+	now := time.Now()
+	if welcome(s, sender, e.SenderAddress) {
+		s.dht.table.update(newContact(sender, e.SenderAddress.String(), now))
+	}
+	respond(s, e, gork.Found{Records: recordsWithGrip(s, grip, now)}, nil, errs, outbox)
+}
+
+// outside tells goracle that we can't look anything up, since we're not in the DHT
+func outside(s state, e Envelope, outbox chan Envelope) {
+	headers := gork.NewKV()
+	headers.Set("error", "goracled isn't in the dht. Start it with --dht")
+	respond(s, e, gork.Found{}, headers, nil, outbox)
+}
+
+// locate looks target up on goracle's behalf, and answers with whatever records turn up
+func locate(s state, e Envelope, target gork.NodeID, outbox chan Envelope) {
+	if s.dht == nil {
+		outside(s, e, outbox)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), locateTimeout)
+	defer cancel()
+	found := gork.Found{Records: recordsFor(s, target, time.Now())}
+	if len(found.Records) == 0 {
+		_, found.Records = lookup(ctx, s, target, true, outbox)
+	}
+	found.Records = found.Records[:min(gork.FoundRecordLimit, len(found.Records))]
+	respond(s, e, found, nil, nil, outbox)
+}
+
+// locateGrip looks for keys with grip on goracle's behalf, and answers with whatever records turn up.
+// A grip has no place in the DHT, so the best we can do is ask the nodes nearest us what they hold.
+func locateGrip(s state, e Envelope, grip string, outbox chan Envelope) {
+	if s.dht == nil {
+		outside(s, e, outbox)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), locateTimeout)
+	defer cancel()
+	found := gork.Found{Records: recordsWithGrip(s, grip, time.Now())}
+	if len(found.Records) == 0 {
+		found.Records = askAround(ctx, s, grip, outbox)
+	}
+	respond(s, e, found, nil, nil, outbox)
+}
+
+// askAround asks the nodes nearest us, all at once, for records of keys with grip, returning the newest of each, in order of key
+func askAround(ctx context.Context, s state, grip string, outbox chan Envelope) []gork.AddrRecord {
+	nearest := s.dht.table.closest(s.dht.table.self, s.dht.table.k)
+	answers := make(chan gork.Found, len(nearest))
+	for _, c := range nearest {
+		go func() {
+			f, _ := find(ctx, s, c, func(me *gork.Principal, to gork.Peer) (*delphi.Message, error) {
+				return me.FindGrip(to, grip, nil)
+			}, outbox)
+			answers <- f
+		}()
+	}
+	found := map[string]gork.AddrRecord{}
+	now := time.Now()
+	for range nearest {
+		for _, rec := range (<-answers).Records {
+			if rec.Verify() != nil || rec.Expired(now) || (gork.Peer{Key: delphi.KeyFromHex(rec.Pub)}).Grip() != grip {
+				continue
+			}
+			if held, ok := found[rec.Pub]; !ok || rec.Newer(held) {
+				found[rec.Pub] = rec
+			}
+		}
+	}
+	recs := make([]gork.AddrRecord, 0, len(found))
+	for _, rec := range found {
+		recs = append(recs, rec)
+	}
+	slices.SortFunc(recs, func(a, b gork.AddrRecord) int {
+		return strings.Compare(a.Pub, b.Pub)
+	})
+	return recs[:min(gork.FoundRecordLimit, len(recs))]
+}
+
+// respond answers a FIND
+func respond(s state, e Envelope, found gork.Found, headers *gork.KV, errs chan error, outbox chan Envelope) {
+	var msg *delphi.Message
+	err := s.node.Do(func(me *gork.Principal) error {
+		var err error
+		msg, err = me.Found(e.Message, found, headers)
+		return err
+	})
+	if err != nil {
+		if errs != nil {
+			errs <- err
+		}
+		return
+	}
+	outbox <- Envelope{Message: msg, SenderAddress: s.localAddr, RecipientAddress: e.SenderAddress}
+}
+
+// processFound hands a FOUND to the lookup waiting on it, if it's from the node that was asked
+func processFound(s state, e Envelope, errs chan error, outbox chan Envelope) {
+	id, found, err := gork.ParseFound(e.Message)
+	if err != nil {
+		errs <- err
+		return
+	}
+	if s.dht == nil {
+		return
+	}
+	if s.dht.calls.answer(id, e.Message.Sender, found) {
+		s.dht.table.update(newContact(e.Message.Sender, e.SenderAddress.String(), time.Now()))
+	}
+}
+
+// processStore holds a node's address record
+func processStore(s state, e Envelope, errs chan error, outbox chan Envelope) {
+	rec, err := gork.ParseStore(e.Message)
+	if err != nil {
+		errs <- err
+		return
+	}
+	if s.dht == nil {
+		errs <- fmt.Errorf("ignoring store from %s: not in the dht", gork.Peer{Key: e.Message.Sender}.Nickname())
+		return
+	}
+	if !welcome(s, e.Message.Sender, e.SenderAddress) {
+		errs <- fmt.Errorf("ignoring store from %s at %s: too many strangers from there", gork.Peer{Key: e.Message.Sender}.Nickname(), e.SenderAddress)
+		return
+	}
+	now := time.Now()
+	s.dht.table.update(newContact(e.Message.Sender, e.SenderAddress.String(), now))
+	s.dht.records.put(rec, host(e.SenderAddress), now)
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sean9999/go-delphi"
+	"github.com/sean9999/gork"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTable(t *testing.T) {
+
+	check := assert.New(t)
+	var self gork.NodeID
+	tab := newTable(self, 2)
+	now := time.Now()
+
+	keys := make([]delphi.Key, 8)
+	for i := range keys {
+		p := gork.NewPrincipal(rand.Reader, nil, nil)
+		keys[i] = p.PublicKey()
+		tab.update(newContact(keys[i], "127.0.0.1:1", now))
+	}
+	check.LessOrEqual(tab.len(), len(keys))
+
+	//	nearest first
+	target := gork.IDOf(keys[0])
+	closest := tab.closest(target, 3)
+	for i := 1; i < len(closest); i++ {
+		check.False(target.Closer(closest[i].id, closest[i-1].id))
+	}
+
+	//	a full bucket keeps who it has, unless they've gone quiet
+	var a, b, c delphi.Key
+	for _, k := range keys {
+		if gork.IDOf(k)[0]&0x80 == 0 {
+			continue
+		}
+		switch {
+		case a.IsZero():
+			a = k
+		case b.IsZero():
+			b = k
+		case c.IsZero():
+			c = k
+		}
+	}
+	if c.IsZero() {
+		t.Skip("not enough keys in the far bucket")
+	}
+	far := newTable(self, 2)
+	far.update(newContact(a, "127.0.0.1:1", now))
+	far.update(newContact(b, "127.0.0.1:2", now))
+	far.update(newContact(c, "127.0.0.1:3", now))
+	check.Equal(2, far.len())
+	check.True(far.buckets[0][0].pub.Equal(a))
+	far.update(newContact(c, "127.0.0.1:3", now.Add(2*staleAfter)))
+	check.Equal(2, far.len())
+	check.True(far.buckets[0][0].pub.Equal(b))
+	check.True(far.buckets[0][1].pub.Equal(c))
+
+	//	hearing from someone again makes them the most recently seen
+	far.update(newContact(b, "127.0.0.1:2", now.Add(3*staleAfter)))
+	check.True(far.buckets[0][1].pub.Equal(b))
+
+	far.drop(b)
+	check.Equal(1, far.len())
+
+}
+
+func TestRecords(t *testing.T) {
+
+	check := assert.New(t)
+	alice := gork.NewPrincipal(rand.Reader, map[string]string{"addr": "10.0.0.1:5656"}, nil)
+	now := time.Now()
+	first, _, err := alice.AddrRecord(now)
+	check.NoError(err)
+	alice.SetProp("addr", "10.0.0.2:5656")
+	second, _, err := alice.AddrRecord(now)
+	check.NoError(err)
+
+	r := newRecords()
+	id := gork.IDOf(alice.PublicKey())
+	check.True(r.put(first, "10.0.0.1", now))
+	check.True(r.put(second, "10.0.0.2", now))
+	check.False(r.put(first, "10.0.0.1", now), "an older record doesn't win")
+	check.Equal([]gork.AddrRecord{second}, r.get(id, now))
+	check.Equal(map[string]int{"10.0.0.2": 1}, r.perHost)
+
+	//	and can be found by grip, along with any others that share it
+	grip := alice.AsPeer().Grip()
+	check.Equal([]gork.AddrRecord{second}, r.withGrip(grip, now, gork.FoundRecordLimit))
+	check.Empty(r.withGrip("0", now, gork.FoundRecordLimit))
+
+	//	no one address can fill us up
+	for range maxRecordsPerHost {
+		p := gork.NewPrincipal(rand.Reader, map[string]string{"addr": "10.0.0.3:5656"}, nil)
+		rec, _, err := p.AddrRecord(now)
+		check.NoError(err)
+		check.True(r.put(rec, "10.0.0.3", now))
+	}
+	p := gork.NewPrincipal(rand.Reader, map[string]string{"addr": "10.0.0.3:5656"}, nil)
+	rec, _, err := p.AddrRecord(now)
+	check.NoError(err)
+	check.False(r.put(rec, "10.0.0.3", now))
+	check.True(r.put(rec, "10.0.0.4", now))
+
+	//	expired records are forgotten
+	later := now.Add(gork.AddrRecordTTL + time.Second)
+	check.Empty(r.get(id, later))
+	check.False(r.put(second, "10.0.0.2", later))
+	r.expire(later)
+	check.Empty(r.byID)
+	check.Empty(r.perHost)
+
+}
+
+func TestCalls(t *testing.T) {
+
+	check := assert.New(t)
+	bob := gork.NewPrincipal(rand.Reader, nil, nil)
+	mallory := gork.NewPrincipal(rand.Reader, nil, nil)
+	c := newCalls()
+	answered := c.open("1234", bob.PublicKey())
+
+	//	anyone can see the id, but only bob can answer
+	check.False(c.answer("1234", mallory.PublicKey(), gork.Found{Contacts: []gork.Contact{{Pub: "mallory"}}}))
+	check.False(c.answer("5678", bob.PublicKey(), gork.Found{}))
+	check.True(c.answer("1234", bob.PublicKey(), gork.Found{}))
+	check.Empty((<-answered).Contacts)
+	check.False(c.answer("1234", bob.PublicKey(), gork.Found{}), "only once")
+
+}
+
+// TestDHT spins up dozens of nodes on loopback, which only know one other to start with,
+// and checks that every node can locate others by key once everyone has published
+func TestDHT(t *testing.T) {
+
+	check := assert.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const n = 40
+	nodes := make([]state, n)
+	outboxes := make([]chan Envelope, n)
+	for i := range nodes {
+		s, pc := listening(t, ctx, options{dht: true, bucket: 8})
+		defer pc.Close()
+		s.node.Do(func(me *gork.Principal) error {
+			return me.SetProp("addr", pc.LocalAddr().String())
+		})
+		nodes[i], outboxes[i] = s, sendingFrom(ctx, pc)
+		if i > 0 {
+			seed := nodes[i-1]
+			s.dht.table.update(newContact(seed.node.self.PublicKey(), seed.localAddr.String(), time.Now()))
+		}
+	}
+	for i, s := range nodes {
+		check.NoError(publish(ctx, s, outboxes[i]))
+	}
+
+	pick := func() int {
+		i, _ := rand.Int(rand.Reader, big.NewInt(n))
+		return int(i.Int64())
+	}
+	for range n {
+		from, to := pick(), pick()
+		target := nodes[to].node.self.PublicKey()
+		_, recs := lookup(ctx, nodes[from], gork.IDOf(target), true, outboxes[from])
+		if from == to {
+			continue
+		}
+		if check.Len(recs, 1, "node %d looking for node %d", from, to) {
+			check.Equal(target.ToHex(), recs[0].Pub)
+			check.Equal(nodes[to].localAddr.String(), recs[0].Addr)
+		}
+	}
+
+}
+
+func TestLocate(t *testing.T) {
+
+	check := assert.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	alice, alicePC := listening(t, ctx, options{dht: true, bucket: DefaultBucketSize})
+	defer alicePC.Close()
+	bob, bobPC := listening(t, ctx, options{dht: true, bucket: DefaultBucketSize})
+	defer bobPC.Close()
+	carol, carolPC := listening(t, ctx, options{})
+	defer carolPC.Close()
+	dave, davePC := listening(t, ctx, options{dht: true, bucket: DefaultBucketSize})
+	defer davePC.Close()
+
+	bob.node.Do(func(me *gork.Principal) error {
+		return me.SetProp("addr", bobPC.LocalAddr().String())
+	})
+	bob.dht.table.update(newContact(alice.node.self.PublicKey(), alicePC.LocalAddr().String(), time.Now()))
+	check.NoError(publish(ctx, bob, sendingFrom(ctx, bobPC)))
+	check.Eventually(func() bool {
+		return len(alice.dht.records.get(bob.dht.table.self, time.Now())) == 1
+	}, 2*time.Second, 5*time.Millisecond)
+
+	//	goracle asks its daemon to locate someone
+	ask := func(s state, pc net.PacketConn, find func(*gork.Principal, gork.Peer) (*delphi.Message, error)) *delphi.Message {
+		cli, err := net.ListenPacket("udp4", "127.0.0.1:0")
+		check.NoError(err)
+		defer cli.Close()
+		var msg *delphi.Message
+		s.node.Do(func(me *gork.Principal) error {
+			msg, err = find(me, me.AsPeer())
+			return err
+		})
+		check.NoError(err)
+		_, err = cli.WriteTo([]byte(msg.String()), pc.LocalAddr())
+		check.NoError(err)
+		buf := make([]byte, bufSize)
+		cli.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, _, err := cli.ReadFrom(buf)
+		check.NoError(err)
+		block, _ := pem.Decode(buf[:n])
+		answer := new(delphi.Message)
+		check.NoError(answer.FromPEM(*block))
+		check.Equal(gork.SubjectFound, answer.Subject)
+		return answer
+	}
+
+	bobPeer := bob.node.self.AsPeer()
+	byKey := func(me *gork.Principal, to gork.Peer) (*delphi.Message, error) {
+		return me.Find(to, gork.IDOf(bobPeer.Key), nil)
+	}
+	byGrip := func(me *gork.Principal, to gork.Peer) (*delphi.Message, error) {
+		return me.FindGrip(to, bobPeer.Grip(), nil)
+	}
+	located := func(answer *delphi.Message) {
+		_, found, err := gork.ParseFound(answer)
+		check.NoError(err)
+		if check.Len(found.Records, 1) {
+			check.Equal(bobPC.LocalAddr().String(), found.Records[0].Addr)
+		}
+	}
+	located(ask(alice, alicePC, byKey))
+	located(ask(alice, alicePC, byGrip))
+
+	//	dave holds nothing, but knows to ask alice
+	dave.dht.table.update(newContact(alice.node.self.PublicKey(), alicePC.LocalAddr().String(), time.Now()))
+	located(ask(dave, davePC, byGrip))
+
+	//	carol isn't in the DHT
+	answer := ask(carol, carolPC, byGrip)
+	problem, _ := answer.Headers.Get("error")
+	check.Contains(problem, "--dht")
+
+	//	a request from goracle is answered once, and can't be replayed to have the answer sent elsewhere
+	errs := make(chan error, 16)
+	outbox := make(chan Envelope, 16)
+	elsewhere := &net.UDPAddr{IP: net.IPv4(10, 6, 6, 6), Port: 5656}
+	for _, find := range []func(*gork.Principal, gork.Peer) (*delphi.Message, error){byKey, byGrip} {
+		var msg *delphi.Message
+		check.NoError(carol.node.Do(func(me *gork.Principal) error {
+			var err error
+			msg, err = find(me, me.AsPeer())
+			return err
+		}))
+		processFind(carol, Envelope{Message: msg, SenderAddress: carolPC.LocalAddr()}, errs, outbox)
+		check.Equal(carolPC.LocalAddr(), (<-outbox).RecipientAddress)
+		processFind(carol, Envelope{Message: msg, SenderAddress: elsewhere}, errs, outbox)
+		check.ErrorIs(<-errs, gork.ErrStaleRequest)
+	}
+
+}
+
+func TestStrangers(t *testing.T) {
+
+	check := assert.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	alice, alicePC := listening(t, ctx, options{dht: true, bucket: DefaultBucketSize, dhtRate: 0.001, dhtBurst: 2})
+	defer alicePC.Close()
+	errs := make(chan error, 16)
+	outbox := make(chan Envelope, 16)
+	from := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 3), Port: 5656}
+
+	//	anyone can make up keys, so an address can only introduce so many
+	store := func(p *gork.Principal, from net.Addr) {
+		p.SetProp("addr", from.String())
+		rec, _, err := p.AddrRecord(time.Now())
+		check.NoError(err)
+		msg, err := p.Store(alice.node.self.AsPeer(), rec)
+		check.NoError(err)
+		processStore(alice, Envelope{Message: msg, SenderAddress: from}, errs, outbox)
+	}
+	strangers := make([]gork.Principal, 3)
+	for i := range strangers {
+		strangers[i] = gork.NewPrincipal(rand.Reader, nil, nil)
+		store(&strangers[i], from)
+	}
+	check.Equal(2, alice.dht.table.len())
+	check.Len(alice.dht.records.byID, 2)
+	if check.Len(errs, 1) {
+		check.ErrorContains(<-errs, "too many strangers")
+	}
+
+	//	those it has introduced are no longer strangers, and nor are peers
+	store(&strangers[0], from)
+	peer := gork.NewPrincipal(rand.Reader, nil, nil)
+	check.NoError(alice.node.AddPeer(peer.AsPeer()))
+	store(&peer, from)
+	check.Equal(3, alice.dht.table.len())
+	check.Empty(errs)
+
+	//	and other addresses have their own allowance
+	store(&strangers[2], &net.UDPAddr{IP: net.IPv4(10, 0, 0, 4), Port: 5656})
+	check.Equal(4, alice.dht.table.len())
+	check.Empty(errs)
+
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/sean9999/go-delphi"
+	"github.com/sean9999/gork"
+)
+
+const (
+	// DefaultGroup is the multicast group that nodes announce themselves on
+	DefaultGroup = "239.255.86.86:5657"
+	// DefaultAnnounceInterval is how often nodes announce themselves
+	DefaultAnnounceInterval = 30 * time.Second
+	// announceRate and announceBurst limit how often we listen to an address, or a node, announcing itself.
+	// Nodes only need to announce themselves every so often, so these are tighter than the limits on other traffic.
+	announceRate  = 0.2
+	announceBurst = 4
+)
+
+// announce sends our assertion to a multicast group, and again every so often, until ctx is done.
+// It's sent from conn, so that anyone who hears it knows where to reach us.
+func announce(ctx context.Context, s state, conn net.PacketConn, group net.Addr, every time.Duration, errs chan error) {
+	send := func() {
+		var msg *delphi.Message
+		err := s.node.Do(func(me *gork.Principal) error {
+			var err error
+			msg, err = me.Assert()
+			return err
+		})
+		if err == nil {
+			_, err = conn.WriteTo([]byte(msg.String()), group)
+		}
+		if err != nil {
+			errs <- err
+		}
+	}
+	send()
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			send()
+		}
+	}
+}
+
+// discover listens for announcements on a multicast group until ctx is done or conn is closed.
+// Announcements are let in the way other traffic is, so that a flood of them costs us little, and can't churn the roster.
+func discover(ctx context.Context, s state, conn net.PacketConn, errs chan error) {
+	gate := spool{
+		conn:   conn,
+		stats:  new(counters),
+		byAddr: newLimiter(announceRate, announceBurst),
+		byKey:  newLimiter(announceRate, announceBurst),
+	}
+	buf := make([]byte, bufSize)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			errs <- err
+			continue
+		}
+		if e, ok := gate.admit(buf[:n], addr); !ok || !gate.signed(e) {
+			continue
+		}
+		err = sighted(s, buf[:n], addr, time.Now())
+		if err != nil {
+			errs <- err
+		}
+	}
+}
+
+// sighted handles an announcement. Nodes that aren't ourselves, and aren't already our peers, are nearby.
+func sighted(s state, b []byte, from net.Addr, now time.Time) error {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return gork.ErrBadPem
+	}
+	msg := new(delphi.Message)
+	err := msg.FromPEM(*block)
+	if err != nil {
+		return err
+	}
+	_, err = gork.FreshAssertion(msg, now)
+	if err != nil {
+		return err
+	}
+	sighting, err := gork.NewSighting(msg, from.String(), now)
+	if err != nil {
+		return err
+	}
+	if msg.Sender.Equal(s.node.self.PublicKey()) || s.node.HasPeer(gork.NewPeer(msg.Sender.Bytes())) {
+		return nil
+	}
+	return s.nearby.Sight(sighting)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sean9999/gork"
+	"github.com/sean9999/hermeti"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiscovery(t *testing.T) {
+
+	check := assert.New(t)
+
+	//	a node, with one friend
+	node := func() state {
+		fs := afero.NewMemMapFs()
+		prov := gork.FileBasedConfigProvider{Fs: fs, Name: "conf.json"}
+		me := gork.NewPrincipal(rand.Reader, nil, prov)
+		return state{
+			conf:        prov,
+			nearby:      gork.NearbyRoster(fs, "conf.json"),
+			node:        newNode(&me, prov),
+			environment: hermeti.TestEnv(),
+		}
+	}
+	alice, bob := node(), node()
+	friend := gork.NewPrincipal(rand.Reader, nil, nil)
+	alice.node.AddPeer(friend.AsPeer())
+
+	//	stand-ins for the multicast group and bob's unicast socket
+	group, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	check.NoError(err)
+	defer group.Close()
+	pc, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	check.NoError(err)
+	defer pc.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errs := make(chan error, 8)
+	go discover(ctx, alice, group, errs)
+	go announce(ctx, bob, pc, group.LocalAddr(), time.Hour, errs)
+
+	var sightings []gork.Sighting
+	check.Eventually(func() bool {
+		sightings, _ = alice.nearby.Sightings()
+		return len(sightings) == 1
+	}, 2*time.Second, 10*time.Millisecond)
+
+	//	bob is reachable where he announced from
+	peer, err := sightings[0].Peer()
+	check.NoError(err)
+	check.True(peer.Equal(bob.node.self.AsPeer()))
+	addr, _ := peer.Properties.Get("addr")
+	check.Equal(pc.LocalAddr().String(), addr)
+
+	//	ourselves and our peers aren't nearby
+	for _, p := range []*gork.Principal{alice.node.self, &friend} {
+		p.WithRand(rand.Reader)
+		msg, err := p.Assert()
+		check.NoError(err)
+		check.NoError(sighted(alice, []byte(msg.String()), pc.LocalAddr(), time.Now()))
+	}
+	sightings, _ = alice.nearby.Sightings()
+	check.Len(sightings, 1)
+
+	check.Error(sighted(alice, []byte("hello"), pc.LocalAddr(), time.Now()))
+
+	//	an announcement replayed long after it was made is refused
+	bob.node.self.WithRand(rand.Reader)
+	msg, err := bob.node.self.Assert()
+	check.NoError(err)
+	check.ErrorIs(sighted(alice, []byte(msg.String()), pc.LocalAddr(), time.Now().Add(time.Hour)), gork.ErrStaleAssertion)
+
+	//	a flood of announcements from one address is mostly ignored
+	for range 10 {
+		stranger := gork.NewPrincipal(rand.Reader, nil, nil)
+		msg, err := stranger.Assert()
+		check.NoError(err)
+		_, err = pc.WriteTo([]byte(msg.String()), group.LocalAddr())
+		check.NoError(err)
+	}
+	check.Eventually(func() bool {
+		sightings, _ = alice.nearby.Sightings()
+		return len(sightings) == announceBurst
+	}, 2*time.Second, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	sightings, _ = alice.nearby.Sightings()
+	check.Len(sightings, announceBurst, "bob's announcement, and three of the flood, use up the address's burst")
+	check.Empty(errs)
+
+}
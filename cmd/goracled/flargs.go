@@ -1,43 +1,170 @@
 package main
 
 import (
+	"errors"
 	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"runtime"
+	"time"
 
 	"github.com/sean9999/gork"
 	"github.com/sean9999/hermeti"
 	"github.com/spf13/afero"
 )
 
-func flargs(args []string) (port uint, conf string, priv string, err error) {
-	flagset := flag.NewFlagSet("flagset", flag.PanicOnError)
-	flagset.UintVar(&port, "port", 5656, "specify port")
-	flagset.StringVar(&conf, "config", "config.json", "config file")
-	flagset.StringVar(&priv, "priv", "key.pem", "private key")
-	err = flagset.Parse(args)
-	return port, conf, priv, err
+// passphraseVar names the environment variable holding the passphrase that protects our private key, if it has one
+const passphraseVar = "GORACLE_PASSPHRASE"
+
+// options are what goracled is told on the command line
+type options struct {
+	port       uint
+	conf       string
+	priv       string
+	discover   bool
+	group      string
+	every      time.Duration
+	policy     policy
+	rate       float64
+	burst      int
+	workers    int
+	queue      int
+	beat       time.Duration
+	misses     int
+	mailbox    bool
+	ttl        time.Duration
+	quota      int
+	gossip     time.Duration
+	fanout     int
+	dht        bool
+	bucket     int
+	dhtRate    float64
+	dhtBurst   int
+	publish    time.Duration
+	relay      bool
+	relayRate  float64
+	relayBurst int
+}
+
+// errUsage is what's wrong with the command line
+var errUsage = errors.New("usage")
+
+// flargs parses the command line. What's wrong with it is an errUsage, and usage has been printed.
+func flargs(args []string) (options, error) {
+	var o options
+	flagset := flag.NewFlagSet("goracled", flag.ContinueOnError)
+	flagset.UintVar(&o.port, "port", 5656, "specify port")
+	flagset.StringVar(&o.conf, "config", "config.json", "config file")
+	flagset.StringVar(&o.priv, "priv", "key.pem", "private key")
+	flagset.BoolVar(&o.discover, "discover", false, "announce ourselves on the local network, and listen for others doing the same")
+	flagset.StringVar(&o.group, "group", DefaultGroup, "multicast group for announcements")
+	flagset.DurationVar(&o.every, "every", DefaultAnnounceInterval, "how often to announce ourselves")
+	flagset.Float64Var(&o.rate, "rate", 5, "messages per second allowed from each address, and from each sender")
+	flagset.IntVar(&o.burst, "burst", 20, "messages allowed from each address, and from each sender, in a burst")
+	flagset.IntVar(&o.workers, "workers", runtime.NumCPU(), "how many messages to process at once")
+	flagset.IntVar(&o.queue, "queue", 256, "how many messages may wait to be processed before more are dropped")
+	flagset.DurationVar(&o.beat, "heartbeat", DefaultHeartbeat, "how often to ping peers, or 0 not to")
+	flagset.IntVar(&o.misses, "misses", DefaultMisses, "how many pings in a row a peer can miss before it's unreachable")
+	flagset.BoolVar(&o.mailbox, "mailbox", false, "hold mail for peers who ask")
+	flagset.DurationVar(&o.ttl, "ttl", gork.DefaultParcelTTL, "how long a message waits to be delivered")
+	flagset.IntVar(&o.quota, "quota", gork.DefaultQueueLimit, "how many messages can wait for any one recipient")
+	flagset.DurationVar(&o.gossip, "gossip", DefaultGossip, "how often to compare notes with peers on where everyone is, or 0 not to")
+	flagset.IntVar(&o.fanout, "fanout", DefaultFanout, "how many peers to gossip with each time")
+	flagset.BoolVar(&o.dht, "dht", false, "take part in the DHT, so that nodes can be located by key")
+	flagset.IntVar(&o.bucket, "bucket", DefaultBucketSize, "how many DHT contacts to keep at each distance")
+	flagset.Float64Var(&o.dhtRate, "dht-rate", 0.1, "strangers per second each address may introduce to the DHT")
+	flagset.IntVar(&o.dhtBurst, "dht-burst", 20, "strangers each address may introduce to the DHT in a burst")
+	flagset.DurationVar(&o.publish, "republish", DefaultRepublish, "how often to tell the DHT where we are")
+	flagset.BoolVar(&o.relay, "relay", false, "pass messages on between peers who can't reach each other")
+	flagset.Float64Var(&o.relayRate, "relay-rate", 1, "messages per second each peer may have relayed")
+	flagset.IntVar(&o.relayBurst, "relay-burst", 60, "messages each peer may have relayed in a burst")
+	o.policy = policyManual
+	flagset.Func("policy", "what to do with assertions from strangers: auto, known, or manual", func(s string) error {
+		var err error
+		o.policy, err = parsePolicy(s)
+		return err
+	})
+	err := flagset.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return o, err
+	}
+	if err != nil {
+		return o, fmt.Errorf("%w: %w", errUsage, err)
+	}
+	if o.workers < 1 || o.burst < 1 || o.rate <= 0 || o.ttl <= 0 || o.quota < 1 || o.fanout < 1 || o.bucket < 1 || o.dhtRate <= 0 || o.dhtBurst < 1 || o.publish <= 0 || o.relayRate <= 0 || o.relayBurst < 1 {
+		err = fmt.Errorf("%w: --workers, --burst, --rate, --ttl, --quota, --fanout, --bucket, --dht-rate, --dht-burst, --republish, --relay-rate, and --relay-burst must be positive", errUsage)
+		fmt.Fprintln(flagset.Output(), err)
+		flagset.Usage()
+	}
+	return o, err
 }
 
 func initialize(filesystem afero.Fs, env hermeti.Env) (state, error) {
 	s := state{}
-	port, confName, privName, err := flargs(env.Args)
+	opts, err := flargs(env.Args)
 	if err != nil {
 		return s, err
 	}
-	s.port = port
-	prov := gork.FileBasedConfigProvider{
-		Fs:   env.Filesystem,
-		Name: confName,
-	}
-	s.conf = prov
+	s.opts = opts
+	s.port = opts.port
+	confName, privName := opts.conf, opts.priv
 	s.environment = env
 	priv, err := filesystem.Open(privName)
 	if err != nil {
 		return s, err
 	}
+	pemBytes, err := io.ReadAll(priv)
+	if err != nil {
+		return s, err
+	}
 	p := new(gork.Principal)
-	err = p.FromPem(priv)
+	if gork.IsEncryptedPEM(pemBytes) {
+		err = p.UnmarshalEncryptedPEM(pemBytes, []byte(env.Vars[passphraseVar]))
+	} else {
+		err = p.UnmarshalPEM(pemBytes)
+	}
+	if err != nil {
+		return s, err
+	}
 	p.WithRand(env.Randomness)
-	p.WithConfigProvider(prov)
-	s.self = p
-	return s, err
+	prov := gork.OpenConfigFile(env.Filesystem, confName, p)
+	if _, encrypted := prov.(gork.EncryptedConfigProvider); !encrypted {
+		p.Journal = gork.FileJournal{
+			Fs:     env.Filesystem,
+			Name:   confName + ".log",
+			Origin: "goracled",
+		}
+	}
+	s.conf = prov
+	s.nearby = gork.NearbyRoster(env.Filesystem, confName)
+	s.requests = gork.RequestRoster(env.Filesystem, confName)
+	s.pings = newPings()
+	s.queue = gork.FileQueue{Fs: env.Filesystem, Name: confName + ".outbox", Limit: opts.quota}
+	s.clients = newLeases()
+	s.replays = newReplays()
+	s.waiting = newLeases()
+	s.relayed = newLeases()
+	s.quotas = newLimiter(opts.relayRate, opts.relayBurst)
+	s.inbox = gork.InboxFile(env.Filesystem, confName)
+	if opts.mailbox {
+		s.held = gork.FileQueue{Fs: env.Filesystem, Name: confName + ".mailbox", Limit: opts.quota}
+		s.registry = gork.MailboxRegistry(env.Filesystem, confName)
+	}
+	if opts.dht {
+		s.dht = newDHT(gork.IDOf(p.PublicKey()), opts.bucket, opts.dhtRate, opts.dhtBurst)
+	}
+	//	no config yet is fine: we'll write one. One we can't trust, or understand, we leave alone.
+	err = p.WithConfigProvider(prov)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return s, err
+	}
+	s.node = newNode(p, prov)
+	//	what we observe of our peers is written down next to plain-text configs, for goracle to show.
+	//	It would leak who our peers are if it were next to an encrypted one.
+	if _, encrypted := prov.(gork.EncryptedConfigProvider); !encrypted {
+		observed := gork.ObservationsFile(env.Filesystem, confName)
+		s.node.sidecar = &observed
+	}
+	return s, nil
 }
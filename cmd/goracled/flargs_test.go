@@ -0,0 +1,72 @@
+package main
+
+import (
+	"crypto/rand"
+	"flag"
+	"testing"
+
+	"github.com/sean9999/gork"
+	"github.com/sean9999/hermeti"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInitialize(t *testing.T) {
+
+	check := assert.New(t)
+	me := gork.NewPrincipal(rand.Reader, nil, nil)
+	pemBytes, err := me.MarshalPEM()
+	check.NoError(err)
+
+	start := func(conf *gork.Config) error {
+		env := hermeti.TestEnv()
+		env.Randomness = rand.Reader
+		env.Filesystem = afero.NewMemMapFs()
+		afero.WriteFile(env.Filesystem, "key.pem", pemBytes, 0600)
+		if conf != nil {
+			prov := gork.FileBasedConfigProvider{Fs: env.Filesystem, Name: "config.json"}
+			check.NoError(prov.Set(conf))
+		}
+		env.Args = []string{"--config", "config.json", "--priv", "key.pem"}
+		_, err := initialize(env.Filesystem, env)
+		return err
+	}
+
+	//	no config yet is fine
+	check.NoError(start(nil))
+	check.NoError(start(me.Export()))
+
+	//	but one that's newer than we are, or that's been tampered with, isn't
+	conf := me.Export()
+	conf.Version = gork.ConfigVersion + 1
+	check.ErrorIs(start(conf), gork.ErrConfigTooNew)
+	conf = me.Export()
+	conf.Props.Set("addr", "10.6.6.6:5656")
+	check.ErrorIs(start(conf), gork.ErrBadSignature)
+
+}
+
+func TestFlargs(t *testing.T) {
+
+	check := assert.New(t)
+	o, err := flargs([]string{"--policy", "auto", "--dht"})
+	check.NoError(err)
+	check.Equal(policyAuto, o.policy)
+	check.True(o.dht)
+
+	//	what's wrong with the command line is said, rather than panicked over
+	for _, args := range [][]string{
+		{"--policy", "whatever"},
+		{"--workers", "0"},
+		{"--ttl", "0s"},
+		{"--ttl", "-1h"},
+		{"--quota", "-1"},
+		{"--no-such-flag"},
+	} {
+		_, err := flargs(args)
+		check.ErrorIs(err, errUsage, "%v", args)
+	}
+	_, err = flargs([]string{"-h"})
+	check.ErrorIs(err, flag.ErrHelp)
+
+}
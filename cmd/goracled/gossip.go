@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"time"
+
+	"github.com/sean9999/go-delphi"
+	"github.com/sean9999/gork"
+)
+
+const (
+	// DefaultGossip is how often we gossip
+	DefaultGossip = time.Minute
+	// DefaultFanout is how many peers we gossip with each time
+	DefaultFanout = 3
+)
+
+// gossip compares notes with a few peers, every so often, until ctx is done.
+// Each round goes to at most fanout peers, picked at random, so that address changes spread without flooding the mesh.
+func gossip(ctx context.Context, s state, every time.Duration, fanout int, errs chan error, outbox chan Envelope) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			gossipRound(s, now, fanout, errs, outbox)
+		}
+	}
+}
+
+// gossipRound sends a digest of what we know, along with our own record, to at most fanout peers
+func gossipRound(s state, now time.Time, fanout int, errs chan error, outbox chan Envelope) {
+	type target struct {
+		addr string
+		msg  *delphi.Message
+	}
+	var targets []target
+	changed := false
+	err := s.node.Do(func(me *gork.Principal) error {
+		own, signed, err := me.AddrRecord(now)
+		if err != nil {
+			return err
+		}
+		changed = signed
+		peers := make(gork.PeerList, 0, len(me.Peers))
+		for _, peer := range me.Peers {
+			if _, hasAddr := peer.Properties.Get("addr"); hasAddr {
+				peers = append(peers, peer)
+			}
+		}
+		rand.Shuffle(len(peers), func(i, j int) {
+			peers[i], peers[j] = peers[j], peers[i]
+		})
+		for _, peer := range peers[:min(fanout, len(peers))] {
+			gs := gork.Gossip{Digest: digest(me, peer)}
+			if own.Addr != "" {
+				gs.Records = []gork.AddrRecord{own}
+			}
+			if gs.Empty() {
+				continue
+			}
+			msg, err := me.Gossip(peer, gs)
+			if err != nil {
+				return err
+			}
+			addr, _ := peer.Properties.Get("addr")
+			targets = append(targets, target{addr, msg})
+		}
+		return nil
+	})
+	if changed {
+		s.node.touch()
+	}
+	if err != nil {
+		errs <- err
+		return
+	}
+	for _, t := range targets {
+		to, err := net.ResolveUDPAddr("udp", t.addr)
+		if err != nil {
+			errs <- fmt.Errorf("can't gossip: %w", err)
+			continue
+		}
+		outbox <- Envelope{
+			Message:          t.msg,
+			SenderAddress:    s.localAddr,
+			RecipientAddress: to,
+		}
+	}
+}
+
+// digest says which version of each peer's record we have, for every peer with an addr except the one we're telling
+func digest(me *gork.Principal, except gork.Peer) map[string]int64 {
+	d := map[string]int64{}
+	//	in no particular order, so that every peer gets its turn when there are too many to fit
+	for _, i := range rand.Perm(len(me.Peers)) {
+		peer := me.Peers[i]
+		if len(d) == gork.GossipLimit {
+			break
+		}
+		if peer.Equal(except) {
+			continue
+		}
+		if rec, ok := gork.AddrRecordOf(peer); ok {
+			d[gork.GossipKey(peer.Key)] = rec.Version
+		} else if _, hasAddr := peer.Properties.Get("addr"); hasAddr {
+			d[gork.GossipKey(peer.Key)] = 0
+		}
+	}
+	return d
+}
+
+// processGossip applies the records a peer sends us, and answers its digest with records it's missing and wants of our own.
+// Answers carry no digest, so an exchange is over in at most three messages.
+func processGossip(s state, e Envelope, errs chan error, outbox chan Envelope) {
+	gs, err := gork.ParseGossip(e.Message)
+	if err != nil {
+		errs <- err
+		return
+	}
+	sender := gork.NewPeer(e.Message.Sender.Bytes())
+	if !s.node.HasPeer(sender) {
+		errs <- fmt.Errorf("ignoring gossip from stranger %s at %s", sender.Nickname(), e.SenderAddress)
+		return
+	}
+	var answer *delphi.Message
+	var problems []error
+	changed := false
+	var moved []string
+	now := time.Now()
+	err = s.node.Do(func(me *gork.Principal) error {
+		for _, rec := range gs.Records {
+			var before string
+			if peer, err := me.FindPeer(rec.Pub); err == nil {
+				before, _ = peer.Properties.Get("addr")
+			}
+			applied, err := me.ApplyAddrRecord(rec, now)
+			if err != nil {
+				problems = append(problems, fmt.Errorf("from %s: %w", sender.Nickname(), err))
+			}
+			changed = changed || applied
+			if applied && before != rec.Addr {
+				moved = append(moved, rec.Pub)
+			}
+		}
+		//	only peers we both know are talked about
+		mutual := map[string]gork.Peer{}
+		for _, peer := range me.Peers {
+			if !peer.Equal(sender) {
+				mutual[gork.GossipKey(peer.Key)] = peer
+			}
+		}
+		reply := gork.Gossip{}
+		for key, theirs := range gs.Digest {
+			peer, ok := mutual[key]
+			if !ok {
+				continue
+			}
+			//	expired records are no use to anyone, so they're not passed on
+			ours, ok := gork.AddrRecordOf(peer)
+			switch {
+			case ok && ours.Version > theirs && !ours.Expired(now):
+				reply.Records = append(reply.Records, ours)
+			case theirs > ours.Version:
+				reply.Want = append(reply.Want, key)
+			}
+		}
+		for _, key := range gs.Want {
+			if ours, ok := gork.AddrRecordOf(mutual[key]); ok && !ours.Expired(now) {
+				reply.Records = append(reply.Records, ours)
+			}
+		}
+		if len(reply.Records) > gork.GossipLimit {
+			reply.Records = reply.Records[:gork.GossipLimit]
+		}
+		if reply.Empty() {
+			return nil
+		}
+		var err error
+		answer, err = me.Gossip(sender, reply)
+		return err
+	})
+	if changed {
+		s.node.touch()
+	}
+	//	what we saw of a peer at its old address says nothing about its new one
+	for _, pub := range moved {
+		s.node.forget(pub)
+	}
+	for _, problem := range problems {
+		errs <- problem
+	}
+	if err != nil {
+		errs <- err
+		return
+	}
+	if answer != nil {
+		outbox <- Envelope{
+			Message:          answer,
+			SenderAddress:    s.localAddr,
+			RecipientAddress: e.SenderAddress,
+		}
+	}
+}
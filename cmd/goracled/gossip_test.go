@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sean9999/gork"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGossip(t *testing.T) {
+
+	check := assert.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	alice, alicePC := listening(t, ctx, options{})
+	defer alicePC.Close()
+	bob, bobPC := listening(t, ctx, options{})
+	defer bobPC.Close()
+	carol, carolPC := listening(t, ctx, options{})
+	defer carolPC.Close()
+
+	//	everyone knows everyone. alice and bob have carol's old address
+	befriend := func(s state, other state, addr net.Addr) gork.Peer {
+		peer := gork.NewPeer(other.node.self.PublicKey().Bytes())
+		peer.Properties.Set("addr", addr.String())
+		check.NoError(s.node.AddPeer(peer))
+		return peer
+	}
+	befriend(alice, bob, bobPC.LocalAddr())
+	befriend(bob, alice, alicePC.LocalAddr())
+	befriend(carol, bob, bobPC.LocalAddr())
+	carolPeer := befriend(alice, carol, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1})
+	befriend(bob, carol, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1})
+
+	addrOf := func(s state, peer gork.Peer) string {
+		var addr string
+		s.node.Do(func(me *gork.Principal) error {
+			p, err := me.FindPeer(peer.ToHex())
+			addr, _ = p.Properties.Get("addr")
+			return err
+		})
+		return addr
+	}
+
+	errs := make(chan error, 16)
+
+	//	bob couldn't reach carol at the old address
+	bob.node.observe(carolPeer.ToHex(), func(props *gork.KV) {
+		props.Set("status", "unreachable")
+	})
+	check.Equal("unreachable", bob.node.status(carolPeer.ToHex()))
+
+	//	carol moves, and tells bob
+	carol.node.Do(func(me *gork.Principal) error {
+		return me.SetProp("addr", carolPC.LocalAddr().String())
+	})
+	gossipRound(carol, time.Now(), DefaultFanout, errs, sendingFrom(ctx, carolPC))
+	check.Eventually(func() bool {
+		return addrOf(bob, carolPeer) == carolPC.LocalAddr().String()
+	}, 2*time.Second, 5*time.Millisecond)
+	check.Eventually(func() bool {
+		return bob.node.status(carolPeer.ToHex()) == ""
+	}, 2*time.Second, 5*time.Millisecond)
+
+	//	alice hears of it from bob, having never heard from carol
+	gossipRound(alice, time.Now(), DefaultFanout, errs, sendingFrom(ctx, alicePC))
+	check.Eventually(func() bool {
+		return addrOf(alice, carolPeer) == carolPC.LocalAddr().String()
+	}, 2*time.Second, 5*time.Millisecond)
+	var rec gork.AddrRecord
+	alice.node.Do(func(me *gork.Principal) error {
+		p, err := me.FindPeer(carolPeer.ToHex())
+		rec, _ = gork.AddrRecordOf(p)
+		return err
+	})
+	check.NoError(rec.Verify())
+	check.Empty(errs)
+
+}
+
+func TestGossipFromStranger(t *testing.T) {
+
+	check := assert.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	alice, alicePC := listening(t, ctx, options{})
+	defer alicePC.Close()
+	mallory, malloryPC := listening(t, ctx, options{})
+	defer malloryPC.Close()
+
+	//	mallory knows alice, but alice doesn't know mallory
+	alicePeer := gork.NewPeer(alice.node.self.PublicKey().Bytes())
+	alicePeer.Properties.Set("addr", alicePC.LocalAddr().String())
+	mallory.node.AddPeer(alicePeer)
+	mallory.node.Do(func(me *gork.Principal) error {
+		return me.SetProp("addr", "6.6.6.6:5656")
+	})
+
+	errs := make(chan error, 16)
+	outbox := make(chan Envelope, 16)
+	gossipRound(mallory, time.Now(), DefaultFanout, errs, outbox)
+	e := <-outbox
+	e.SenderAddress = malloryPC.LocalAddr()
+	processGossip(alice, e, errs, outbox)
+	check.ErrorContains(<-errs, "stranger")
+	check.Empty(outbox)
+
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// maxBuckets is how many sources a limiter keeps track of before it forgets those that have been quiet
+const maxBuckets = 4096
+
+// a limiter is a set of token buckets, one per key, such as a source address or a sender's public key.
+// Each bucket holds up to burst tokens, and refills at rate tokens per second.
+type limiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[string]*bucket
+	now     func() time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newLimiter(rate float64, burst int) *limiter {
+	return &limiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: map[string]*bucket{},
+		now:     time.Now,
+	}
+}
+
+// allow takes a token from key's bucket, reporting whether there was one to take
+func (l *limiter) allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxBuckets {
+			l.prune(now)
+		}
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// prune forgets buckets that would be full by now, since forgetting them changes nothing.
+// If that's not enough, it forgets the quarter of them that have been quiet longest,
+// so that those over their limit stay there, rather than being handed a fresh burst.
+func (l *limiter) prune(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+	if len(l.buckets) < maxBuckets {
+		return
+	}
+	keys := make([]string, 0, len(l.buckets))
+	for key := range l.buckets {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b string) int {
+		return l.buckets[a].last.Compare(l.buckets[b].last)
+	})
+	for _, key := range keys[:len(keys)/4] {
+		delete(l.buckets, key)
+	}
+}
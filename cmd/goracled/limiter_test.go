@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiter(t *testing.T) {
+
+	check := assert.New(t)
+	now := time.Now()
+	l := newLimiter(2, 3)
+	l.now = func() time.Time { return now }
+
+	//	a burst, then nothing
+	for range 3 {
+		check.True(l.allow("a"))
+	}
+	check.False(l.allow("a"))
+
+	//	others have buckets of their own
+	check.True(l.allow("b"))
+
+	//	tokens come back at the rate given
+	now = now.Add(500 * time.Millisecond)
+	check.True(l.allow("a"))
+	check.False(l.allow("a"))
+
+	//	but no more than a burst's worth
+	now = now.Add(time.Hour)
+	for range 3 {
+		check.True(l.allow("a"))
+	}
+	check.False(l.allow("a"))
+
+	//	quiet sources are forgotten, once there are too many to keep track of
+	for i := range maxBuckets - len(l.buckets) {
+		l.allow(string(rune(i + 'c')))
+	}
+	now = now.Add(time.Hour)
+	l.allow("latecomer")
+	check.LessOrEqual(len(l.buckets), 2)
+
+	//	when nobody is quiet, those quiet longest are forgotten, and a source over its limit stays there
+	l = newLimiter(2, 3)
+	l.now = func() time.Time { return now }
+	for i := range maxBuckets - 1 {
+		l.allow(string(rune(i + 'c')))
+	}
+	now = now.Add(time.Millisecond)
+	for range 3 {
+		check.True(l.allow("a"))
+	}
+	l.allow("latecomer")
+	check.Less(len(l.buckets), maxBuckets)
+	check.False(l.allow("a"))
+
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/sean9999/go-delphi"
+	"github.com/sean9999/gork"
+)
+
+const (
+	// DefaultHeartbeat is how often peers are pinged
+	DefaultHeartbeat = time.Minute
+	// DefaultMisses is how many PINGs in a row a peer can leave unanswered before it's unreachable
+	DefaultMisses = 3
+	// askedPingTimeout is how long a PING goracle asked for is waited on. The heartbeat's own are waited on until its next round.
+	askedPingTimeout = time.Minute
+)
+
+// pings are PINGs we've sent, and haven't heard back about
+type pings struct {
+	mu      sync.Mutex
+	pending map[string]pendingPing
+}
+
+type pendingPing struct {
+	pub  string
+	sent time.Time
+	// answer, if there is one, is told the round-trip time when the PONG arrives
+	answer func(time.Duration)
+}
+
+func newPings() *pings {
+	return &pings{pending: map[string]pendingPing{}}
+}
+
+// add records a PING we've sent.
+// Those goracle asked for that have gone unanswered too long are given up on, since there may be no heartbeat to do it.
+func (p *pings) add(id string, pp pendingPing) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for old, q := range p.pending {
+		if q.answer != nil && pp.sent.Sub(q.sent) > askedPingTimeout {
+			delete(p.pending, old)
+		}
+	}
+	p.pending[id] = pp
+}
+
+// take removes a PING, reporting whether we were waiting on it
+func (p *pings) take(id string) (pendingPing, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pp, ok := p.pending[id]
+	delete(p.pending, id)
+	return pp, ok
+}
+
+// expire gives up on PINGs sent before t, returning who they were sent to
+func (p *pings) expire(t time.Time) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pubs := []string{}
+	for id, pp := range p.pending {
+		if pp.sent.Before(t) {
+			pubs = append(pubs, pp.pub)
+			delete(p.pending, id)
+		}
+	}
+	return pubs
+}
+
+// heartbeat pings every peer with an address, every so often, until ctx is done.
+// A PING that's still unanswered when the next round goes out is a miss.
+func heartbeat(ctx context.Context, s state, every time.Duration, misses int, errs chan error, outbox chan Envelope) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, pub := range s.pings.expire(now) {
+				s.node.missed(pub, misses)
+			}
+			err := askMailbox(s, outbox)
+			if err != nil {
+				errs <- err
+			}
+			err = askRelay(s, outbox)
+			if err != nil {
+				errs <- err
+			}
+			redeliver(s, errs, outbox)
+			//	props change under lock, so addresses are read under lock
+			var peers gork.PeerList
+			var addrs []string
+			s.node.Do(func(me *gork.Principal) error {
+				for _, peer := range me.Peers {
+					if addr, hasAddr := peer.Properties.Get("addr"); hasAddr {
+						peers = append(peers, peer)
+						addrs = append(addrs, addr)
+					}
+				}
+				return nil
+			})
+			for i, peer := range peers {
+				err := ping(s, peer, addrs[i], nil, outbox)
+				if err != nil {
+					errs <- err
+				}
+			}
+		}
+	}
+}
+
+// ping sends a PING to a peer at addr, and waits for the PONG
+func ping(s state, peer gork.Peer, addr string, answer func(time.Duration), outbox chan Envelope) error {
+	to, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return fmt.Errorf("can't ping %s: %w", peer.Nickname(), err)
+	}
+	var msg *delphi.Message
+	err = s.node.Do(func(me *gork.Principal) error {
+		var err error
+		msg, err = me.Ping(peer, nil)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	id, _ := msg.Headers.Get("ping")
+	s.pings.add(id, pendingPing{peer.ToHex(), time.Now(), answer})
+	outbox <- Envelope{
+		Message:          msg,
+		SenderAddress:    s.localAddr,
+		RecipientAddress: to,
+	}
+	return nil
+}
+
+// processPing answers a PING from a peer.
+// A PING from ourselves is goracle asking us to ping the peer named in its "for" header, and tell it the round-trip time.
+func processPing(s state, e Envelope, errs chan error, outbox chan Envelope) {
+	_, err := gork.PingID(e.Message)
+	if err != nil {
+		errs <- err
+		return
+	}
+	sender := gork.NewPeer(e.Message.Sender.Bytes())
+	if e.Message.Sender.Equal(s.node.self.PublicKey()) {
+		//	anyone who's seen it could replay it from anywhere, and have the answer sent there
+		if err := s.replays.take(e.Message, time.Now()); err != nil {
+			errs <- fmt.Errorf("ping from goracle: %w", err)
+			return
+		}
+		relayPing(s, e, outbox)
+		return
+	}
+	if !s.node.HasPeer(sender) {
+		errs <- fmt.Errorf("ignoring ping from stranger %s at %s", sender.Nickname(), e.SenderAddress)
+		return
+	}
+	pong(s, e, nil, errs, outbox)
+}
+
+// relayPing pings a peer on goracle's behalf
+func relayPing(s state, e Envelope, outbox chan Envelope) {
+	fail := func(err error) {
+		headers := gork.NewKV()
+		headers.Set("error", err.Error())
+		pong(s, e, headers, nil, outbox)
+	}
+	ref, _ := e.Message.Headers.Get("for")
+	var peer gork.Peer
+	var addr string
+	err := s.node.Do(func(me *gork.Principal) error {
+		var err error
+		peer, err = me.FindPeer(ref)
+		if err != nil {
+			return err
+		}
+		addr, _ = peer.Properties.Get("addr")
+		if addr == "" {
+			return fmt.Errorf("%s has no addr", peer.Nickname())
+		}
+		return nil
+	})
+	if err != nil {
+		fail(err)
+		return
+	}
+	err = ping(s, peer, addr, func(rtt time.Duration) {
+		headers := gork.NewKV()
+		headers.Set("rtt", rtt.String())
+		pong(s, e, headers, nil, outbox)
+	}, outbox)
+	if err != nil {
+		fail(err)
+	}
+}
+
+// pong answers a PING
+func pong(s state, e Envelope, headers *gork.KV, errs chan error, outbox chan Envelope) {
+	var msg *delphi.Message
+	err := s.node.Do(func(me *gork.Principal) error {
+		var err error
+		msg, err = me.Pong(e.Message, headers)
+		return err
+	})
+	if err != nil {
+		if errs != nil {
+			errs <- err
+		}
+		return
+	}
+	outbox <- Envelope{
+		Message:          msg,
+		SenderAddress:    s.localAddr,
+		RecipientAddress: e.SenderAddress,
+	}
+}
+
+// redeliver retries what's waiting for unreachable peers, by way of our relay or their mailboxes.
+// What's waiting for everyone else is retried when they answer a PING.
+func redeliver(s state, errs chan error, outbox chan Envelope) {
+	if s.queue == nil {
+		return
+	}
+	recipients, err := s.queue.Recipients()
+	if err != nil {
+		errs <- err
+		return
+	}
+	for _, pub := range recipients {
+		if s.node.status(pub) == "unreachable" {
+			deliver(s, pub, errs, outbox)
+		}
+	}
+}
+
+// processPong records that a peer answered our PING, and how long it took, and delivers what's waiting for it
+func processPong(s state, e Envelope, errs chan error, outbox chan Envelope) {
+	id, err := gork.PingID(e.Message)
+	if err != nil {
+		errs <- err
+		return
+	}
+	pp, ok := s.pings.take(id)
+	if !ok {
+		errs <- fmt.Errorf("unsolicited pong from %s", e.SenderAddress)
+		return
+	}
+	if pp.pub != e.Message.Sender.ToHex() {
+		errs <- fmt.Errorf("pong from %s answers a ping sent to someone else", e.SenderAddress)
+		return
+	}
+	now := time.Now()
+	rtt := now.Sub(pp.sent)
+	s.node.seen(pp.pub, now, rtt)
+	if pp.answer != nil {
+		pp.answer(rtt)
+	}
+	if s.queue != nil {
+		deliver(s, pp.pub, errs, outbox)
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/pem"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sean9999/go-delphi"
+	"github.com/sean9999/gork"
+	"github.com/sean9999/hermeti"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+// listening starts a node listening on loopback, processing what it hears, and sending what it says
+func listening(t testing.TB, ctx context.Context, opts options) (state, net.PacketConn) {
+	t.Helper()
+	fs := afero.NewMemMapFs()
+	prov := gork.FileBasedConfigProvider{Fs: fs, Name: "conf.json"}
+	me := gork.NewPrincipal(rand.Reader, nil, prov)
+	pc, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.ttl == 0 {
+		opts.ttl = gork.DefaultParcelTTL
+	}
+	if opts.relayBurst == 0 {
+		opts.relayRate, opts.relayBurst = 1000, 1000
+	}
+	if opts.dhtBurst == 0 {
+		opts.dhtRate, opts.dhtBurst = 1000, 1000
+	}
+	s := state{
+		conf:        prov,
+		opts:        opts,
+		node:        newNode(&me, prov),
+		pings:       newPings(),
+		queue:       gork.OutboxQueue(fs, "conf.json"),
+		clients:     newLeases(),
+		replays:     newReplays(),
+		waiting:     newLeases(),
+		relayed:     newLeases(),
+		quotas:      newLimiter(opts.relayRate, opts.relayBurst),
+		inbox:       gork.InboxFile(fs, "conf.json"),
+		localAddr:   pc.LocalAddr(),
+		environment: hermeti.TestEnv(),
+	}
+	if opts.mailbox {
+		s.held = gork.MailboxQueue(fs, "conf.json")
+		s.registry = gork.MailboxRegistry(fs, "conf.json")
+	}
+	if opts.dht {
+		s.dht = newDHT(gork.IDOf(me.PublicKey()), opts.bucket, opts.dhtRate, opts.dhtBurst)
+	}
+	sp := NewSpool(pc, options{rate: 1000, burst: 1000, queue: 16})
+	go work(s, sp)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-sp.errors:
+			case e := <-sp.outbox:
+				sp.Send(*e.Message, e.RecipientAddress)
+			}
+		}
+	}()
+	return s, pc
+}
+
+// sendingFrom is an outbox whose envelopes go out from pc, until ctx is done
+func sendingFrom(ctx context.Context, pc net.PacketConn) chan Envelope {
+	outbox := make(chan Envelope)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case e := <-outbox:
+				pc.WriteTo([]byte(e.Message.String()), e.RecipientAddress)
+			}
+		}
+	}()
+	return outbox
+}
+
+func TestHeartbeat(t *testing.T) {
+
+	check := assert.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	//	alice and bob are each other's peers
+	alice, alicePC := listening(t, ctx, options{})
+	defer alicePC.Close()
+	bob, bobPC := listening(t, ctx, options{})
+	bobAsPeer := gork.NewPeer(bob.node.self.PublicKey().Bytes())
+	bobAsPeer.Properties.Set("addr", bobPC.LocalAddr().String())
+	alice.node.AddPeer(bobAsPeer)
+	bob.node.AddPeer(gork.NewPeer(alice.node.self.PublicKey().Bytes()))
+
+	status := func(prop string) string {
+		var v string
+		alice.node.Do(func(me *gork.Principal) error {
+			if props := alice.node.observed[bobAsPeer.ToHex()]; props != nil {
+				v, _ = props.Get(prop)
+			}
+			return nil
+		})
+		return v
+	}
+
+	errs := make(chan error, 16)
+	outbox := make(chan Envelope)
+	go func() {
+		for e := range outbox {
+			alicePC.WriteTo([]byte(e.Message.String()), e.RecipientAddress)
+		}
+	}()
+	go heartbeat(ctx, alice, 20*time.Millisecond, 2, errs, outbox)
+
+	check.Eventually(func() bool {
+		return status("status") == "online"
+	}, 2*time.Second, 5*time.Millisecond)
+	check.NotEmpty(status("last_seen"))
+	_, err := time.ParseDuration(status("rtt"))
+	check.NoError(err)
+
+	//	once bob goes quiet, he's unreachable
+	bobPC.Close()
+	check.Eventually(func() bool {
+		return status("status") == "unreachable"
+	}, 2*time.Second, 5*time.Millisecond)
+
+}
+
+func TestPings(t *testing.T) {
+
+	check := assert.New(t)
+	p := newPings()
+	now := time.Now()
+	answer := func(time.Duration) {}
+
+	//	pings goracle asked for are given up on as others are sent, with or without a heartbeat
+	p.add("asked", pendingPing{"bob", now, answer})
+	p.add("beat", pendingPing{"bob", now, nil})
+	p.add("recent", pendingPing{"carol", now.Add(askedPingTimeout), answer})
+	check.Len(p.pending, 3)
+	p.add("later", pendingPing{"carol", now.Add(askedPingTimeout + time.Second), answer})
+	_, asked := p.take("asked")
+	check.False(asked)
+	_, beat := p.take("beat")
+	check.True(beat, "the heartbeat gives up on its own")
+	check.Len(p.pending, 2)
+
+}
+
+func TestRelayPing(t *testing.T) {
+
+	check := assert.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	alice, alicePC := listening(t, ctx, options{})
+	defer alicePC.Close()
+	bob, bobPC := listening(t, ctx, options{})
+	defer bobPC.Close()
+	bobAsPeer := gork.NewPeer(bob.node.self.PublicKey().Bytes())
+	bobAsPeer.Properties.Set("addr", bobPC.LocalAddr().String())
+	alice.node.AddPeer(bobAsPeer)
+	bob.node.AddPeer(gork.NewPeer(alice.node.self.PublicKey().Bytes()))
+
+	//	goracle asks alice's daemon to ping bob, and someone nobody knows
+	cli, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	check.NoError(err)
+	defer cli.Close()
+	ask := func(ref string) *delphi.Message {
+		headers := gork.NewKV()
+		headers.Set("for", ref)
+		var msg *delphi.Message
+		alice.node.Do(func(me *gork.Principal) error {
+			msg, err = me.Ping(me.AsPeer(), headers)
+			return err
+		})
+		check.NoError(err)
+		_, err = cli.WriteTo([]byte(msg.String()), alicePC.LocalAddr())
+		check.NoError(err)
+		buf := make([]byte, bufSize)
+		cli.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, _, err := cli.ReadFrom(buf)
+		check.NoError(err)
+		block, _ := pem.Decode(buf[:n])
+		answer := new(delphi.Message)
+		check.NoError(answer.FromPEM(*block))
+		check.Equal(gork.SubjectPong, answer.Subject)
+		check.True(answer.Sender.Equal(alice.node.self.PublicKey()))
+		return answer
+	}
+
+	answer := ask(bobAsPeer.Nickname())
+	rtt, _ := answer.Headers.Get("rtt")
+	_, err = time.ParseDuration(rtt)
+	check.NoError(err)
+
+	answer = ask("nobody")
+	problem, _ := answer.Headers.Get("error")
+	check.Contains(problem, "no such peer")
+
+	//	a request from goracle can't be replayed to have the answer sent elsewhere
+	errs := make(chan error, 16)
+	outbox := make(chan Envelope, 16)
+	headers := gork.NewKV()
+	headers.Set("for", "nobody")
+	var msg *delphi.Message
+	check.NoError(alice.node.Do(func(me *gork.Principal) error {
+		var err error
+		msg, err = me.Ping(me.AsPeer(), headers)
+		return err
+	}))
+	processPing(alice, Envelope{Message: msg, SenderAddress: cli.LocalAddr()}, errs, outbox)
+	check.Equal(cli.LocalAddr(), (<-outbox).RecipientAddress)
+	processPing(alice, Envelope{Message: msg, SenderAddress: &net.UDPAddr{IP: net.IPv4(10, 6, 6, 6), Port: 5656}}, errs, outbox)
+	check.ErrorIs(<-errs, gork.ErrStaleRequest)
+
+}
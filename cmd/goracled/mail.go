@@ -0,0 +1,402 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/sean9999/go-delphi"
+	"github.com/sean9999/gork"
+)
+
+// mailboxLease is how long a mailbox passes mail straight on to a peer after it last asked.
+// Peers with a mailbox ask again with every heartbeat. Mail is held for them for as long as it lasts, whether it can be passed on or not.
+const mailboxLease = 10 * time.Minute
+
+var errNotMailbox = errors.New("not holding mail")
+
+// handoffLease is how long goracle waits to hear that a message it handed us was delivered
+const handoffLease = time.Minute
+
+// leases remember where someone can be reached, for a while.
+// They're kept for peers we're holding mail for, and for goracle, waiting to hear what became of its messages.
+type leases struct {
+	mu    sync.Mutex
+	lease map[string]lease
+}
+
+type lease struct {
+	addr  net.Addr
+	until time.Time
+}
+
+func newLeases() *leases {
+	return &leases{lease: map[string]lease{}}
+}
+
+// register remembers that key can be reached at addr, until a time
+func (l *leases) register(key string, addr net.Addr, until time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lease[key] = lease{addr, until}
+}
+
+// at reports where key can be reached, if it's still leased
+func (l *leases) at(key string) (net.Addr, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	le, ok := l.lease[key]
+	if !ok || time.Now().After(le.until) {
+		delete(l.lease, key)
+		return nil, false
+	}
+	return le.addr, true
+}
+
+// replays remember the requests we've taken, until they're too old to be taken anyway, so that none is taken twice
+type replays struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+func newReplays() *replays {
+	return &replays{seen: map[string]time.Time{}}
+}
+
+// take checks that a verified request is fresh, and that we haven't taken it before, and remembers that we have
+func (r *replays) take(msg *delphi.Message, now time.Time) error {
+	err := gork.CheckFresh(msg, now)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for id, until := range r.seen {
+		if now.After(until) {
+			delete(r.seen, id)
+		}
+	}
+	id := gork.MessageID(msg)
+	if _, taken := r.seen[id]; taken {
+		return fmt.Errorf("%w: it's been taken already", gork.ErrStaleRequest)
+	}
+	r.seen[id] = now.Add(2 * gork.RequestWindow)
+	return nil
+}
+
+// post queues a signed message for delivery, and tries to deliver it
+func post(s state, msg *delphi.Message, errs chan error, outbox chan Envelope) error {
+	p, err := gork.NewParcel(msg, time.Now(), s.opts.ttl)
+	if err != nil {
+		return err
+	}
+	err = s.queue.Put(p)
+	if err != nil {
+		return err
+	}
+	deliver(s, p.To, errs, outbox)
+	return nil
+}
+
+// deliver sends what's waiting for a peer, directly, or if it's unreachable, by way of our relay or its mailbox.
+// With both, each parcel goes the other way from last time.
+// Parcels stay queued until a receipt arrives.
+func deliver(s state, pub string, errs chan error, outbox chan Envelope) {
+	parcels, err := s.queue.For(pub)
+	if err != nil {
+		errs <- err
+		return
+	}
+	if len(parcels) == 0 {
+		return
+	}
+
+	//	props change under lock, so they're read under lock
+	var addr, mailbox, relay string
+	found := false
+	s.node.Do(func(me *gork.Principal) error {
+		relay, _ = me.Props.Get("relay")
+		for _, peer := range me.Peers {
+			if peer.ToHex() == pub {
+				addr, _ = peer.Properties.Get("addr")
+				mailbox, _ = peer.Properties.Get("mailbox")
+				found = true
+			}
+		}
+		return nil
+	})
+	status := s.node.status(pub)
+	if !found {
+		errs <- fmt.Errorf("%d messages for %.16s, who isn't a peer", len(parcels), pub)
+		return
+	}
+
+	for _, p := range parcels {
+		target, via := addr, ""
+		if status == "unreachable" {
+			via = relay
+			if relay == "" || (p.Via == relay && mailbox != "") {
+				via = mailbox
+			}
+			target = via
+		}
+		if target == "" {
+			return
+		}
+		to, err := net.ResolveUDPAddr("udp", target)
+		if err != nil {
+			errs <- err
+			return
+		}
+		msg, err := p.Decode()
+		if err != nil {
+			s.queue.Remove(pub, p.ID)
+			errs <- err
+			continue
+		}
+		p.Attempts++
+		p.Via = via
+		err = s.queue.Put(p)
+		if err != nil {
+			errs <- err
+		}
+		outbox <- Envelope{
+			Message:          msg,
+			SenderAddress:    s.localAddr,
+			RecipientAddress: to,
+		}
+	}
+}
+
+// askMailbox asks our mailbox, if we have one, to hold our mail, and send us what it's holding
+func askMailbox(s state, outbox chan Envelope) error {
+	var mailbox string
+	var msg *delphi.Message
+	err := s.node.Do(func(me *gork.Principal) error {
+		mailbox, _ = me.Props.Get("mailbox")
+		if mailbox == "" {
+			return nil
+		}
+		var err error
+		msg, err = me.AskMailbox()
+		return err
+	})
+	if err != nil || mailbox == "" {
+		return err
+	}
+	to, err := net.ResolveUDPAddr("udp", mailbox)
+	if err != nil {
+		return fmt.Errorf("can't reach mailbox: %w", err)
+	}
+	outbox <- Envelope{
+		Message:          msg,
+		SenderAddress:    s.localAddr,
+		RecipientAddress: to,
+	}
+	return nil
+}
+
+// processMessage handles a MESSAGE. Those from our peers, for us, go in our inbox.
+// Those from us are goracle handing us something to deliver.
+// Those for someone else are held for them, if we're their mailbox.
+func processMessage(s state, e Envelope, errs chan error, outbox chan Envelope) {
+	if !e.Message.Recipient.Equal(s.node.self.PublicKey()) {
+		if e.Message.Sender.Equal(s.node.self.PublicKey()) {
+			handoff(s, e, errs, outbox)
+		} else {
+			hold(s, e, errs, outbox)
+		}
+		return
+	}
+	sender := gork.NewPeer(e.Message.Sender.Bytes())
+	if !s.node.HasPeer(sender) {
+		errs <- fmt.Errorf("ignoring message from stranger %s at %s", sender.Nickname(), e.SenderAddress)
+		return
+	}
+
+	//	it's kept as it arrived, but opened first, to be sure it's for us
+	mail := gork.NewMail(e.Message, time.Now())
+	err := s.node.Do(func(me *gork.Principal) error {
+		return me.Open(e.Message)
+	})
+	if err == nil {
+		_, err = s.inbox.Deliver(mail)
+	}
+	if err != nil {
+		errs <- fmt.Errorf("message from %s: %w", sender.Nickname(), err)
+		return
+	}
+
+	//	a message we already had is receipted again, since the first receipt must have gone astray
+	receipt(s, e, gork.StatusDelivered, errs, outbox)
+}
+
+// handoff queues a MESSAGE that goracle gave us to deliver, and tells it so.
+// goracle is told again when the recipient, or its mailbox, sends a receipt.
+func handoff(s state, e Envelope, errs chan error, outbox chan Envelope) {
+	err := post(s, e.Message, errs, outbox)
+	if err != nil {
+		errs <- err
+		return
+	}
+	s.waiting.register(gork.MessageID(e.Message), e.SenderAddress, time.Now().Add(handoffLease))
+	receipt(s, e, gork.StatusQueued, errs, outbox)
+}
+
+// hold keeps a MESSAGE for a peer we're a mailbox for, and passes it on if we know where they are
+func hold(s state, e Envelope, errs chan error, outbox chan Envelope) {
+	pub := e.Message.Recipient.ToHex()
+	if !s.opts.mailbox {
+		errs <- fmt.Errorf("%w for %.16s: not a mailbox", errNotMailbox, pub)
+		return
+	}
+	registered, err := s.registry.Registered(pub)
+	if err != nil {
+		errs <- err
+		return
+	}
+	if !registered {
+		errs <- fmt.Errorf("%w for %.16s", errNotMailbox, pub)
+		return
+	}
+	p, err := gork.NewParcel(e.Message, time.Now(), s.opts.ttl)
+	if err == nil {
+		err = s.held.Put(p)
+	}
+	if err != nil {
+		errs <- err
+		return
+	}
+	receipt(s, e, gork.StatusHeld, errs, outbox)
+	if addr, ok := s.clients.at(pub); ok {
+		outbox <- Envelope{
+			Message:          e.Message,
+			SenderAddress:    s.localAddr,
+			RecipientAddress: addr,
+		}
+	}
+}
+
+// receipt tells the sender of a MESSAGE that we've taken it, and what's become of it
+func receipt(s state, e Envelope, status string, errs chan error, outbox chan Envelope) {
+	var msg *delphi.Message
+	err := s.node.Do(func(me *gork.Principal) error {
+		var err error
+		msg, err = me.Receipt(e.Message, status)
+		return err
+	})
+	if err != nil {
+		errs <- err
+		return
+	}
+	outbox <- Envelope{
+		Message:          msg,
+		SenderAddress:    s.localAddr,
+		RecipientAddress: e.SenderAddress,
+	}
+}
+
+// processMailbox handles a peer asking us to hold its mail, and sends it what we're holding.
+// We hold its mail for as long as mail lasts, and pass it straight on for as long as it's likely to be where it asked from.
+func processMailbox(s state, e Envelope, errs chan error, outbox chan Envelope) {
+	if !s.opts.mailbox {
+		errs <- fmt.Errorf("%w: not a mailbox", errNotMailbox)
+		return
+	}
+	sender := gork.NewPeer(e.Message.Sender.Bytes())
+	if !s.node.HasPeer(sender) {
+		errs <- fmt.Errorf("%w for stranger %s", errNotMailbox, sender.Nickname())
+		return
+	}
+	now := time.Now()
+	err := s.replays.take(e.Message, now)
+	if err != nil {
+		errs <- fmt.Errorf("mailbox request from %s: %w", sender.Nickname(), err)
+		return
+	}
+	err = s.registry.Register(sender.ToHex(), now.Add(s.opts.ttl))
+	if err != nil {
+		errs <- err
+		return
+	}
+	s.clients.register(sender.ToHex(), e.SenderAddress, now.Add(mailboxLease))
+	parcels, err := s.held.For(sender.ToHex())
+	if err != nil {
+		errs <- err
+		return
+	}
+	for _, p := range parcels {
+		msg, err := p.Decode()
+		if err != nil {
+			errs <- err
+			continue
+		}
+		outbox <- Envelope{
+			Message:          msg,
+			SenderAddress:    s.localAddr,
+			RecipientAddress: e.SenderAddress,
+		}
+	}
+}
+
+// processReceipt forgets a message that's been taken.
+// Receipts come from a message's recipient, or from the mailbox it was left at, but never from a relay.
+func processReceipt(s state, e Envelope, errs chan error, outbox chan Envelope) {
+	id, _ := e.Message.Headers.Get("receipt")
+	to, _ := e.Message.Headers.Get("to")
+	fromRecipient := e.Message.Sender.ToHex() == to
+	//	a relay passes on receipts, but doesn't write them
+	var relay string
+	s.node.Do(func(me *gork.Principal) error {
+		relay, _ = me.Props.Get("relay")
+		return nil
+	})
+	for _, q := range []gork.Queue{s.queue, s.held} {
+		if q == nil {
+			continue
+		}
+		parcels, err := q.For(to)
+		if err != nil {
+			errs <- err
+			return
+		}
+		for _, p := range parcels {
+			if p.ID != id {
+				continue
+			}
+			if !fromRecipient && (!sameAddr(p.Via, e.SenderAddress) || p.Via == relay) {
+				errs <- fmt.Errorf("receipt from %s for a message it wasn't given", e.SenderAddress)
+				return
+			}
+			err = q.Remove(to, id)
+			if err != nil {
+				errs <- err
+			}
+			//	if goracle handed it to us, and is still waiting, tell it
+			if addr, waiting := s.waiting.at(id); waiting && q == s.queue {
+				status := gork.StatusHeld
+				if fromRecipient {
+					status = gork.StatusDelivered
+				}
+				msg, err := p.Decode()
+				if err != nil {
+					errs <- err
+					return
+				}
+				receipt(s, Envelope{Message: msg, SenderAddress: addr}, status, errs, outbox)
+			}
+			return
+		}
+	}
+}
+
+// sameAddr reports whether an address, as written in a prop, is the one a datagram came from
+func sameAddr(written string, addr net.Addr) bool {
+	if written == "" {
+		return false
+	}
+	resolved, err := net.ResolveUDPAddr("udp", written)
+	return err == nil && resolved.String() == addr.String()
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/pem"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sean9999/go-delphi"
+	"github.com/sean9999/gork"
+	"github.com/stretchr/testify/assert"
+)
+
+// stranded is a peer whose daemon we can't see, only its socket
+type stranded struct {
+	gork.Principal
+	conn net.PacketConn
+}
+
+func strand(t testing.TB) stranded {
+	t.Helper()
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stranded{gork.NewPrincipal(rand.Reader, nil, nil), conn}
+}
+
+// next reads the next message that arrives
+func (p stranded) next(t testing.TB) *delphi.Message {
+	t.Helper()
+	buf := make([]byte, bufSize)
+	p.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := p.conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(buf[:n])
+	msg := new(delphi.Message)
+	if err := msg.FromPEM(*block); err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func (p stranded) send(t testing.TB, msg *delphi.Message, to net.Addr) {
+	t.Helper()
+	if _, err := p.conn.WriteTo([]byte(msg.String()), to); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// letter is a MESSAGE from one principal to another
+func letter(t testing.TB, from *gork.Principal, to gork.Peer, body string) *delphi.Message {
+	t.Helper()
+	msg := from.Compose([]byte(body), nil, to)
+	msg.Subject = gork.SubjectMessage
+	if err := msg.Sign(rand.Reader, from); err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func TestStoreAndForward(t *testing.T) {
+
+	check := assert.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	alice, alicePC := listening(t, ctx, options{})
+	defer alicePC.Close()
+	bob := strand(t)
+	defer bob.conn.Close()
+	bobAsPeer := bob.AsPeer()
+	bobAsPeer.Properties.Set("addr", bob.conn.LocalAddr().String())
+	alice.node.AddPeer(bobAsPeer)
+
+	errs := make(chan error, 16)
+	outbox := make(chan Envelope, 16)
+	flush := func() {
+		for len(outbox) > 0 {
+			e := <-outbox
+			alicePC.WriteTo([]byte(e.Message.String()), e.RecipientAddress)
+		}
+	}
+	waiting := func() int {
+		parcels, err := alice.queue.For(bobAsPeer.ToHex())
+		check.NoError(err)
+		return len(parcels)
+	}
+
+	//	bob misses it the first time, so it's sent again when he's next seen
+	msg := letter(t, alice.node.self, bobAsPeer, "hello bob")
+	check.NoError(post(alice, msg, errs, outbox))
+	flush()
+	check.Equal("hello bob", string(bob.next(t).PlainText))
+	deliver(alice, bobAsPeer.ToHex(), errs, outbox)
+	flush()
+	check.Equal("hello bob", string(bob.next(t).PlainText))
+	parcels, _ := alice.queue.For(bobAsPeer.ToHex())
+	check.Equal(2, parcels[0].Attempts)
+
+	//	a receipt from someone else doesn't count
+	mallory := strand(t)
+	defer mallory.conn.Close()
+	forged, err := mallory.Receipt(msg, "")
+	check.NoError(err)
+	forged.Headers.Set("to", bobAsPeer.ToHex())
+	check.NoError(forged.Sign(rand.Reader, &mallory.Principal))
+	mallory.send(t, forged, alicePC.LocalAddr())
+	check.Never(func() bool { return waiting() == 0 }, 100*time.Millisecond, 5*time.Millisecond)
+
+	//	bob's receipt does
+	receipt, err := bob.Receipt(msg, "")
+	check.NoError(err)
+	bob.send(t, receipt, alicePC.LocalAddr())
+	check.Eventually(func() bool { return waiting() == 0 }, 2*time.Second, 5*time.Millisecond)
+	check.Empty(errs)
+
+}
+
+func TestMailbox(t *testing.T) {
+
+	check := assert.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	//	bob's mailbox is a node that's always on
+	mailbox, mailboxPC := listening(t, ctx, options{mailbox: true})
+	defer mailboxPC.Close()
+	bob := strand(t)
+	defer bob.conn.Close()
+	mailbox.node.AddPeer(bob.AsPeer())
+	ask, err := bob.AskMailbox()
+	check.NoError(err)
+	bob.send(t, ask, mailboxPC.LocalAddr())
+	check.Eventually(func() bool {
+		_, ok := mailbox.clients.at(bob.AsPeer().ToHex())
+		return ok
+	}, 2*time.Second, 5*time.Millisecond)
+
+	//	alice can't reach bob, so she leaves a message at his mailbox
+	alice, alicePC := listening(t, ctx, options{})
+	defer alicePC.Close()
+	bobAsPeer := bob.AsPeer()
+	bobAsPeer.Properties.Set("addr", "127.0.0.1:9")
+	bobAsPeer.Properties.Set("mailbox", mailboxPC.LocalAddr().String())
+	alice.node.AddPeer(bobAsPeer)
+	alice.node.missed(bobAsPeer.ToHex(), 1)
+	errs := make(chan error, 16)
+	outbox := make(chan Envelope, 16)
+	msg := letter(t, alice.node.self, bobAsPeer, "hello bob")
+	check.NoError(post(alice, msg, errs, outbox))
+	e := <-outbox
+	check.Equal(mailboxPC.LocalAddr().String(), e.RecipientAddress.String())
+	alicePC.WriteTo([]byte(e.Message.String()), e.RecipientAddress)
+
+	//	the mailbox passes it on, and alice has her receipt
+	check.Equal("hello bob", string(bob.next(t).PlainText))
+	check.Eventually(func() bool {
+		parcels, _ := alice.queue.For(bobAsPeer.ToHex())
+		return len(parcels) == 0
+	}, 2*time.Second, 5*time.Millisecond)
+
+	//	it's held until bob takes it, so he gets it again when he asks
+	held, err := mailbox.held.For(bobAsPeer.ToHex())
+	check.NoError(err)
+	check.Len(held, 1)
+	ask, err = bob.AskMailbox()
+	check.NoError(err)
+	bob.send(t, ask, mailboxPC.LocalAddr())
+	check.Equal("hello bob", string(bob.next(t).PlainText))
+	receipt, err := bob.Receipt(msg, "")
+	check.NoError(err)
+	bob.send(t, receipt, mailboxPC.LocalAddr())
+	check.Eventually(func() bool {
+		held, _ := mailbox.held.For(bobAsPeer.ToHex())
+		return len(held) == 0
+	}, 2*time.Second, 5*time.Millisecond)
+
+	//	asking again with the same request gets nothing
+	processMailbox(mailbox, Envelope{Message: ask, SenderAddress: alicePC.LocalAddr()}, errs, outbox)
+	check.ErrorIs(<-errs, gork.ErrStaleRequest)
+
+	//	once bob's been away a while, his mail is still held, though it can't be passed on
+	mailbox.clients.register(bobAsPeer.ToHex(), bob.conn.LocalAddr(), time.Now().Add(-time.Second))
+	hold(mailbox, Envelope{Message: letter(t, alice.node.self, bobAsPeer, "are you there?"), SenderAddress: alicePC.LocalAddr()}, errs, outbox)
+	check.Empty(errs)
+	check.Len(outbox, 1, "alice's receipt, and nothing for bob")
+	<-outbox
+	held, err = mailbox.held.For(bobAsPeer.ToHex())
+	check.NoError(err)
+	check.Len(held, 1)
+
+	//	nothing is held for strangers
+	carol := gork.NewPrincipal(rand.Reader, nil, nil)
+	hold(mailbox, Envelope{Message: letter(t, alice.node.self, carol.AsPeer(), "hi"), SenderAddress: alicePC.LocalAddr()}, errs, outbox)
+	check.ErrorIs(<-errs, errNotMailbox)
+
+}
+
+func TestInbox(t *testing.T) {
+
+	check := assert.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	alice, alicePC := listening(t, ctx, options{})
+	defer alicePC.Close()
+	bob, bobPC := listening(t, ctx, options{})
+	defer bobPC.Close()
+	bobAsPeer := gork.NewPeer(bob.node.self.PublicKey().Bytes())
+	bobAsPeer.Properties.Set("addr", bobPC.LocalAddr().String())
+	alice.node.AddPeer(bobAsPeer)
+	bob.node.AddPeer(gork.NewPeer(alice.node.self.PublicKey().Bytes()))
+
+	errs := make(chan error, 16)
+	outbox := make(chan Envelope, 16)
+	var msg *delphi.Message
+	alice.node.Do(func(me *gork.Principal) error {
+		var err error
+		msg, err = me.Seal(bobAsPeer, []byte("hello bob"), nil)
+		return err
+	})
+	check.NoError(post(alice, msg, errs, outbox))
+
+	//	send it twice. It's only kept once, and receipted both times.
+	e := <-outbox
+	alicePC.WriteTo([]byte(e.Message.String()), e.RecipientAddress)
+	alicePC.WriteTo([]byte(e.Message.String()), e.RecipientAddress)
+	check.Eventually(func() bool {
+		parcels, _ := alice.queue.For(bobAsPeer.ToHex())
+		return len(parcels) == 0
+	}, 2*time.Second, 5*time.Millisecond)
+
+	mail, err := bob.inbox.List()
+	check.NoError(err)
+	check.Len(mail, 1)
+	check.Equal(gork.MessageID(msg), mail[0].ID)
+	check.False(mail[0].Read)
+	opened, err := bob.node.self.OpenMail(mail[0])
+	check.NoError(err)
+	check.Equal("hello bob", string(opened.PlainText))
+
+	//	strangers can't fill our inbox
+	carol := gork.NewPrincipal(rand.Reader, nil, nil)
+	spam, err := carol.Seal(bobAsPeer, []byte("buy now"), nil)
+	check.NoError(err)
+	processMessage(bob, Envelope{Message: spam, SenderAddress: alicePC.LocalAddr()}, errs, outbox)
+	check.Len(errs, 1)
+	mail, _ = bob.inbox.List()
+	check.Len(mail, 1)
+
+}
+
+func TestHandoff(t *testing.T) {
+
+	check := assert.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	alice, alicePC := listening(t, ctx, options{})
+	defer alicePC.Close()
+	bob, bobPC := listening(t, ctx, options{})
+	defer bobPC.Close()
+	bobAsPeer := gork.NewPeer(bob.node.self.PublicKey().Bytes())
+	bobAsPeer.Properties.Set("addr", bobPC.LocalAddr().String())
+	alice.node.AddPeer(bobAsPeer)
+	bob.node.AddPeer(gork.NewPeer(alice.node.self.PublicKey().Bytes()))
+
+	//	goracle, holding alice's key, hands her daemon a message for bob
+	cli := strand(t)
+	defer cli.conn.Close()
+	var msg *delphi.Message
+	alice.node.Do(func(me *gork.Principal) error {
+		var err error
+		msg, err = me.Seal(bobAsPeer, []byte("hello bob"), nil)
+		return err
+	})
+	cli.send(t, msg, alicePC.LocalAddr())
+
+	//	and hears that it's queued, then delivered
+	for _, want := range []string{gork.StatusQueued, gork.StatusDelivered} {
+		receipt := cli.next(t)
+		check.Equal(gork.SubjectReceipt, receipt.Subject)
+		check.True(receipt.Sender.Equal(alice.node.self.PublicKey()))
+		status, _ := receipt.Headers.Get("status")
+		check.Equal(want, status)
+		id, _ := receipt.Headers.Get("receipt")
+		check.Equal(gork.MessageID(msg), id)
+	}
+	mail, err := bob.inbox.List()
+	check.NoError(err)
+	check.Len(mail, 1)
+
+}
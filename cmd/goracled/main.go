@@ -1,20 +1,44 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/sean9999/gork"
 	"github.com/sean9999/hermeti"
 	"github.com/spf13/afero"
 )
 
+// statsInterval is how often dropped traffic is reported
+const statsInterval = time.Minute
+
 type state struct {
 	conf        gork.ConfigProvider
+	nearby      gork.Roster
+	requests    gork.Roster
+	pings       *pings
+	queue       gork.Queue
+	held        gork.Queue
+	clients     *leases
+	registry    gork.Registry
+	replays     *replays
+	waiting     *leases
+	relayed     *leases
+	quotas      *limiter
+	inbox       gork.Inbox
+	dht         *dht
+	opts        options
 	port        uint
-	self        *gork.Principal
+	node        *node
 	localAddr   net.Addr
 	environment hermeti.Env
 }
@@ -25,15 +49,25 @@ func main() {
 	env.Args = env.Args[1:]
 	filesystem := afero.NewOsFs()
 	exe, err := initialize(filesystem, env)
-	if err != nil {
+	switch {
+	case errors.Is(err, flag.ErrHelp):
+		return
+	case errors.Is(err, errUsage):
+		//	flargs has said what's wrong, and printed usage
+		os.Exit(2)
+	case err != nil:
 		log.Fatal(err)
 	}
 
-	me := exe.self
+	me := exe.node.self
 
 	fmt.Fprintln(env.OutStream, me.Nickname())
 	io.Copy(env.OutStream, me.Export())
 
+	//	run until we're interrupted or terminated
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	// listen to incoming UDP packets
 	pc, err := net.ListenPacket("udp", fmt.Sprintf(":%d", exe.port))
 	if err != nil {
@@ -42,19 +76,79 @@ func main() {
 	defer pc.Close()
 
 	exe.localAddr = pc.LocalAddr()
-	spool := NewSpool(pc)
+	spool := NewSpool(pc, exe.opts)
+	for range exe.opts.workers {
+		go work(exe, spool)
+	}
+
+	//	all writes to config go through here, including a last one on the way out
+	flushed := make(chan struct{})
+	go func() {
+		exe.node.persist(ctx, spool.errors)
+		close(flushed)
+	}()
+
+	//	find and be found by nodes on the local network
+	if exe.opts.discover {
+		group, err := net.ResolveUDPAddr("udp4", exe.opts.group)
+		if err != nil {
+			log.Fatal(err)
+		}
+		mc, err := net.ListenMulticastUDP("udp4", nil, group)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer mc.Close()
+		go discover(ctx, exe, mc, spool.errors)
+		go announce(ctx, exe, pc, group, exe.opts.every, spool.errors)
+	}
+
+	//	pick up whatever our mailbox is holding for us, and have our relay pass things on to us
+	go func() {
+		if err := askMailbox(exe, spool.outbox); err != nil {
+			spool.errors <- err
+		}
+		if err := askRelay(exe, spool.outbox); err != nil {
+			spool.errors <- err
+		}
+	}()
+
+	//	keep track of which peers are online, and deliver what's waiting for them
+	if exe.opts.beat > 0 {
+		go heartbeat(ctx, exe, exe.opts.beat, exe.opts.misses, spool.errors, spool.outbox)
+	}
+
+	//	keep up with where peers have moved to, and tell them where we are
+	if exe.opts.gossip > 0 {
+		go gossip(ctx, exe, exe.opts.gossip, exe.opts.fanout, spool.errors, spool.outbox)
+	}
+
+	//	be findable by key, by nodes that don't know where we are
+	if exe.dht != nil {
+		go join(ctx, exe, exe.opts.publish, spool.errors, spool.outbox)
+	}
+
+	//	every so often, say how much traffic was dropped, if any
+	ticker := time.NewTicker(statsInterval)
+	defer ticker.Stop()
+	var dropped uint64
 
 	for {
 		select {
-		case inEnv := <-spool.inbox:
-			//	do something with a well-formed message
-			go processEnvelope(exe, inEnv, spool.errors, spool.outbox)
-
+		case <-flushed:
+			return
+		case <-ticker.C:
+			if d := spool.stats.dropped(); d > dropped {
+				dropped = d
+				fmt.Println("stats", spool.stats)
+			}
 		case err := <-spool.errors:
 			fmt.Println("error", err)
 		case outEnv := <-spool.outbox:
-			//spool.Send(outMsg, outMsg.ToPEM())
-			fmt.Println(outEnv)
+			err := spool.Send(*outEnv.Message, outEnv.RecipientAddress)
+			if err != nil {
+				fmt.Println("error", err)
+			}
 		}
 	}
 
@@ -64,12 +158,54 @@ func serve(pc net.PacketConn, addr net.Addr, buf []byte) {
 	pc.WriteTo(buf, addr)
 }
 
+// subjects are those processEnvelope handles. Messages about anything else are dropped before their signatures are checked.
+var subjects = map[string]bool{
+	gork.SubjectAssertion: true,
+	gork.SubjectAck:       true,
+	gork.SubjectPing:      true,
+	gork.SubjectPong:      true,
+	gork.SubjectMessage:   true,
+	gork.SubjectReceipt:   true,
+	gork.SubjectMailbox:   true,
+	gork.SubjectGossip:    true,
+	gork.SubjectFind:      true,
+	gork.SubjectFound:     true,
+	gork.SubjectStore:     true,
+	gork.SubjectRelay:     true,
+}
+
 // process an envelope and push messages to outbox and/or errs, if you want
 func processEnvelope(s state, e Envelope, errs chan error, outbox chan Envelope) {
 
+	if relaying(s, e) && relay(s, e, errs, outbox) {
+		return
+	}
+
 	switch e.Message.Subject {
-	case "ASSERTION":
+	case gork.SubjectAssertion:
 		processAssertion(s, e, errs, outbox)
+	case gork.SubjectAck:
+		processAck(s, e, errs, outbox)
+	case gork.SubjectPing:
+		processPing(s, e, errs, outbox)
+	case gork.SubjectPong:
+		processPong(s, e, errs, outbox)
+	case gork.SubjectMessage:
+		processMessage(s, e, errs, outbox)
+	case gork.SubjectReceipt:
+		processReceipt(s, e, errs, outbox)
+	case gork.SubjectMailbox:
+		processMailbox(s, e, errs, outbox)
+	case gork.SubjectGossip:
+		processGossip(s, e, errs, outbox)
+	case gork.SubjectFind:
+		processFind(s, e, errs, outbox)
+	case gork.SubjectFound:
+		processFound(s, e, errs, outbox)
+	case gork.SubjectStore:
+		processStore(s, e, errs, outbox)
+	case gork.SubjectRelay:
+		processRelay(s, e, errs, outbox)
 	default:
 		err := fmt.Errorf("unrecognized subject: %q", e.Message.Subject)
 		errs <- err
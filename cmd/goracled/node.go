@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sean9999/go-delphi"
+	"github.com/sean9999/gork"
+)
+
+// a node wraps a [gork.Principal], making it safe for concurrent use.
+// Mutations happen under lock, and persistence is serialized through a single goroutine
+// which coalesces many changes into one write.
+type node struct {
+	mu    sync.Mutex
+	self  *gork.Principal
+	conf  gork.ConfigProvider
+	dirty chan struct{}
+	// observed is what we've observed of our peers. It's kept out of the config, and written to sidecar, if there is one.
+	observed gork.Observations
+	sidecar  *gork.FileObservations
+	noted    chan struct{}
+}
+
+func newNode(self *gork.Principal, conf gork.ConfigProvider) *node {
+	n := node{
+		self:     self,
+		conf:     conf,
+		dirty:    make(chan struct{}, 1),
+		observed: gork.Observations{},
+		noted:    make(chan struct{}, 1),
+	}
+	return &n
+}
+
+// Do runs fn with exclusive access to the underlying [gork.Principal]
+func (n *node) Do(fn func(*gork.Principal) error) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return fn(n.self)
+}
+
+// AddPeer adds a peer and schedules a save
+func (n *node) AddPeer(p gork.Peer) error {
+	err := n.Do(func(me *gork.Principal) error {
+		return me.AddPeer(p)
+	})
+	if err == nil {
+		n.touch()
+	}
+	return err
+}
+
+// DropPeer drops a peer and schedules a save
+func (n *node) DropPeer(p gork.Peer) {
+	n.Do(func(me *gork.Principal) error {
+		me.DropPeer(p)
+		return nil
+	})
+	n.touch()
+}
+
+// HasPeer reports whether the peer is in our address book
+func (n *node) HasPeer(p gork.Peer) bool {
+	var has bool
+	n.Do(func(me *gork.Principal) error {
+		has = me.HasPeer(p)
+		return nil
+	})
+	return has
+}
+
+// Compose composes a message under lock
+func (n *node) Compose(body []byte, headers *delphi.KV, recipient gork.Peer) *delphi.Message {
+	var msg *delphi.Message
+	n.Do(func(me *gork.Principal) error {
+		msg = me.Compose(body, headers, recipient)
+		return nil
+	})
+	return msg
+}
+
+// seen records that a peer answered a PING, and how long it took
+func (n *node) seen(pub string, at time.Time, rtt time.Duration) {
+	n.observe(pub, func(props *gork.KV) {
+		props.Set("last_seen", at.UTC().Format(time.RFC3339))
+		props.Set("rtt", rtt.Round(time.Microsecond).String())
+		props.Set("misses", "0")
+		props.Set("status", "online")
+	})
+}
+
+// missed records that a peer didn't answer a PING, marking it unreachable after limit misses in a row
+func (n *node) missed(pub string, limit int) {
+	n.observe(pub, func(props *gork.KV) {
+		misses, _ := props.Get("misses")
+		m, _ := strconv.Atoi(misses)
+		m++
+		props.Set("misses", strconv.Itoa(m))
+		if m >= limit {
+			props.Set("status", "unreachable")
+		}
+	})
+}
+
+// status is what's been observed of a peer: online, unreachable, or nothing yet
+func (n *node) status(pub string) string {
+	var status string
+	n.Do(func(me *gork.Principal) error {
+		if props := n.observed[pub]; props != nil {
+			status, _ = props.Get("status")
+		}
+		return nil
+	})
+	return status
+}
+
+// observe changes what we've observed of the peer with public key pub, if it's still our peer, and schedules it to be written down
+func (n *node) observe(pub string, fn func(*gork.KV)) {
+	found := false
+	n.Do(func(me *gork.Principal) error {
+		for _, peer := range me.Peers {
+			if peer.ToHex() == pub {
+				if n.observed[pub] == nil {
+					n.observed[pub] = gork.NewKV()
+				}
+				fn(n.observed[pub])
+				found = true
+				return nil
+			}
+		}
+		return nil
+	})
+	if found {
+		select {
+		case n.noted <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// forget drops what we've observed of the peer with public key pub, which no longer holds once it's moved, and schedules that to be written down
+func (n *node) forget(pub string) {
+	n.Do(func(me *gork.Principal) error {
+		delete(n.observed, pub)
+		return nil
+	})
+	select {
+	case n.noted <- struct{}{}:
+	default:
+	}
+}
+
+// writeObservations writes down what we've observed of those who are still our peers, if there's somewhere to write it
+func (n *node) writeObservations() error {
+	if n.sidecar == nil {
+		return nil
+	}
+	o := gork.Observations{}
+	n.Do(func(me *gork.Principal) error {
+		for _, peer := range me.Peers {
+			props := n.observed[peer.ToHex()]
+			if props == nil {
+				continue
+			}
+			//	copied, since it keeps changing once we let go of the lock
+			c := gork.NewKV()
+			for pair := props.Oldest(); pair != nil; pair = pair.Next() {
+				c.Set(pair.Key, pair.Value)
+			}
+			o[peer.ToHex()] = c
+		}
+		return nil
+	})
+	return n.sidecar.Set(o)
+}
+
+// touch marks the node as needing to be saved.
+// If a save is already pending, this is a no-op.
+func (n *node) touch() {
+	select {
+	case n.dirty <- struct{}{}:
+	default:
+	}
+}
+
+// Flush writes the config, synchronously.
+// Whatever goracle has changed since we last saved is merged in first, rather than overwritten.
+func (n *node) Flush() error {
+	return n.Do(func(me *gork.Principal) error {
+		return me.Save(n.conf)
+	})
+}
+
+// persist saves the config whenever it's dirty, and writes down what we've observed whenever that changes,
+// until ctx is done, at which point a final save happens
+func (n *node) persist(ctx context.Context, errs chan error) {
+	for {
+		select {
+		case <-ctx.Done():
+			select {
+			case <-n.noted:
+				if err := n.writeObservations(); err != nil {
+					errs <- err
+				}
+			default:
+			}
+			select {
+			case <-n.dirty:
+				if err := n.Flush(); err != nil {
+					errs <- err
+				}
+			default:
+			}
+			return
+		case <-n.noted:
+			if err := n.writeObservations(); err != nil {
+				errs <- err
+			}
+		case <-n.dirty:
+			if err := n.Flush(); err != nil {
+				errs <- err
+			}
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sean9999/gork"
+	"github.com/sean9999/hermeti"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+// assertionFrom produces a signed ASSERTION from a freshly minted principal
+func assertionFrom(t testing.TB, port int) Envelope {
+	t.Helper()
+	stranger := gork.NewPrincipal(rand.Reader, nil, nil)
+	msg, err := stranger.Assert()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Envelope{
+		Message:       msg,
+		SenderAddress: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port},
+	}
+}
+
+func TestConcurrentAssertions(t *testing.T) {
+
+	check := assert.New(t)
+
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "conf.json", []byte("{}"), 0640)
+	prov := gork.FileBasedConfigProvider{Fs: fs, Name: "conf.json"}
+
+	me := gork.NewPrincipal(rand.Reader, nil, prov)
+	env := hermeti.TestEnv()
+	env.Randomness = rand.Reader
+
+	exe := state{
+		conf:        prov,
+		node:        newNode(&me, prov),
+		localAddr:   &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5656},
+		environment: env,
+	}
+
+	const n = 64
+	errs := make(chan error, n)
+	outbox := make(chan Envelope, n)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		exe.node.persist(ctx, errs)
+		close(done)
+	}()
+
+	envelopes := make([]Envelope, n)
+	for i := range envelopes {
+		envelopes[i] = assertionFrom(t, 6000+i)
+	}
+
+	wg := new(sync.WaitGroup)
+	for _, e := range envelopes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			processEnvelope(exe, e, errs, outbox)
+		}()
+	}
+	wg.Wait()
+	cancel()
+	<-done
+	close(errs)
+
+	for err := range errs {
+		check.NoError(err)
+	}
+	check.Len(outbox, n)
+	check.Len(me.Peers, n)
+
+	//	the persisted config reflects every peer
+	check.NoError(exe.node.Flush())
+	conf, err := prov.Get()
+	check.NoError(err)
+	check.Len(*conf.Peers, n, fmt.Sprintf("%d peers", len(*conf.Peers)))
+
+}
+
+func TestFlushKeepsOthersChanges(t *testing.T) {
+
+	check := assert.New(t)
+
+	fs := afero.NewMemMapFs()
+	prov := gork.FileBasedConfigProvider{Fs: fs, Name: "conf.json"}
+	me := gork.NewPrincipal(rand.Reader, nil, prov)
+	alice := gork.NewPrincipal(rand.Reader, nil, nil)
+	bob := gork.NewPrincipal(rand.Reader, nil, nil)
+	carol := gork.NewPrincipal(rand.Reader, nil, nil)
+	check.NoError(me.AddPeer(alice.AsPeer()))
+	check.NoError(me.Save(prov))
+
+	n := newNode(&me, prov)
+
+	//	meanwhile, goracle drops alice, adds bob, and sets a prop
+	cli, err := gork.PrincipalFrom(bytes.NewReader(me.ToBin()))
+	check.NoError(err)
+	cli.WithRand(rand.Reader)
+	check.NoError(cli.WithConfigProvider(prov))
+	cli.DropPeer(alice.AsPeer())
+	check.NoError(cli.AddPeer(bob.AsPeer()))
+	check.NoError(cli.SetProp("hometown", "wonderland"))
+	check.NoError(cli.Save(prov))
+
+	//	and goracled adds carol
+	check.NoError(n.AddPeer(carol.AsPeer()))
+	check.NoError(n.Flush())
+
+	conf, err := prov.Get()
+	check.NoError(err)
+	check.Len(*conf.Peers, 2)
+	check.False(me.HasPeer(alice.AsPeer()))
+	check.True(me.HasPeer(bob.AsPeer()))
+	check.True(me.HasPeer(carol.AsPeer()))
+	hometown, _ := conf.Props.Get("hometown")
+	check.Equal("wonderland", hometown)
+
+}
+
+func TestObservations(t *testing.T) {
+
+	check := assert.New(t)
+
+	fs := afero.NewMemMapFs()
+	prov := gork.FileBasedConfigProvider{Fs: fs, Name: "conf.json"}
+	me := gork.NewPrincipal(rand.Reader, nil, prov)
+	n := newNode(&me, prov)
+	sidecar := gork.ObservationsFile(fs, "conf.json")
+	n.sidecar = &sidecar
+	bob := gork.NewPrincipal(rand.Reader, nil, nil)
+	check.NoError(n.AddPeer(bob.AsPeer()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 4)
+	done := make(chan struct{})
+	go func() {
+		n.persist(ctx, errs)
+		close(done)
+	}()
+	n.seen(bob.AsPeer().ToHex(), time.Now(), time.Millisecond)
+	n.missed(bob.AsPeer().ToHex(), 3)
+	stranger := gork.NewPrincipal(rand.Reader, nil, nil)
+	n.seen(stranger.AsPeer().ToHex(), time.Now(), time.Millisecond)
+	cancel()
+	<-done
+	check.Empty(errs)
+
+	//	what's observed is written down beside the config, and only for our peers
+	observed, err := sidecar.Get()
+	check.NoError(err)
+	check.Len(observed, 1)
+	peer := observed.Observe(bob.AsPeer())
+	status, _ := peer.Properties.Get("status")
+	check.Equal("online", status)
+	misses, _ := peer.Properties.Get("misses")
+	check.Equal("1", misses)
+
+	//	and not in the config
+	conf, err := prov.Get()
+	check.NoError(err)
+	for _, k := range gork.ObservedProps {
+		_, has := (*conf.Peers)[0].Properties.Get(k)
+		check.False(has, k)
+	}
+
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// a policy decides what happens when a node we don't know asserts who it is
+type policy string
+
+const (
+	// policyAuto befriends anyone who asserts who they are
+	policyAuto policy = "auto"
+	// policyKnown acknowledges our peers, and ignores everyone else
+	policyKnown policy = "known"
+	// policyManual queues requests from strangers, to be approved or denied with "goracle requests"
+	policyManual policy = "manual"
+)
+
+var policies = []policy{policyAuto, policyKnown, policyManual}
+
+func parsePolicy(s string) (policy, error) {
+	for _, p := range policies {
+		if string(p) == s {
+			return p, nil
+		}
+	}
+	names := make([]string, len(policies))
+	for i, p := range policies {
+		names[i] = string(p)
+	}
+	return "", fmt.Errorf("unknown policy %q. Use one of %s", s, strings.Join(names, ", "))
+}
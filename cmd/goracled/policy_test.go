@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"net"
+	"testing"
+
+	"github.com/sean9999/gork"
+	"github.com/sean9999/hermeti"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicy(t *testing.T) {
+
+	node := func(p policy) state {
+		fs := afero.NewMemMapFs()
+		prov := gork.FileBasedConfigProvider{Fs: fs, Name: "conf.json"}
+		me := gork.NewPrincipal(rand.Reader, nil, prov)
+		return state{
+			conf:        prov,
+			requests:    gork.RequestRoster(fs, "conf.json"),
+			opts:        options{policy: p},
+			node:        newNode(&me, prov),
+			localAddr:   &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5656},
+			environment: hermeti.TestEnv(),
+		}
+	}
+
+	tests := []struct {
+		policy   policy
+		peers    int
+		requests int
+		acks     int
+	}{
+		{policyAuto, 1, 0, 1},
+		{policyKnown, 0, 0, 0},
+		{policyManual, 0, 1, 0},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			check := assert.New(t)
+			exe := node(tt.policy)
+			errs := make(chan error, 4)
+			outbox := make(chan Envelope, 4)
+
+			e := assertionFrom(t, 6000)
+			processEnvelope(exe, e, errs, outbox)
+			check.Len(exe.node.self.Peers, tt.peers)
+			check.Len(outbox, tt.acks)
+			requests, err := exe.requests.Sightings()
+			check.NoError(err)
+			check.Len(requests, tt.requests)
+			if tt.requests > 0 {
+				check.Equal(e.SenderAddress.String(), requests[0].Addr)
+			}
+
+			//	peers are always acknowledged
+			stranger := gork.NewPeer(e.Message.Sender.Bytes())
+			if !exe.node.HasPeer(stranger) {
+				exe.node.AddPeer(stranger)
+			}
+			for len(outbox) > 0 {
+				<-outbox
+			}
+			processEnvelope(exe, e, errs, outbox)
+			check.Len(outbox, 1)
+			ack := <-outbox
+			check.Equal(gork.SubjectAck, ack.Message.Subject)
+			check.True(ack.Message.Verify())
+		})
+	}
+
+	_, err := parsePolicy("everyone")
+	assert.Error(t, err)
+}
+
+func TestAcknowledge(t *testing.T) {
+
+	check := assert.New(t)
+	fs := afero.NewMemMapFs()
+	prov := gork.FileBasedConfigProvider{Fs: fs, Name: "conf.json"}
+	me := gork.NewPrincipal(rand.Reader, nil, prov)
+	check.NoError(me.Save(prov))
+	exe := state{
+		conf:        prov,
+		node:        newNode(&me, prov),
+		localAddr:   &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5656},
+		environment: hermeti.TestEnv(),
+	}
+	errs := make(chan error, 4)
+	outbox := make(chan Envelope, 4)
+	goracle := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40000}
+
+	//	goracle approves bob's request, and asks us to acknowledge him where he asked from
+	bob := gork.NewPrincipal(rand.Reader, nil, nil)
+	cli, err := gork.PrincipalFrom(bytes.NewReader(me.ToBin()))
+	check.NoError(err)
+	cli.WithRand(rand.Reader)
+	check.NoError(cli.WithConfigProvider(prov))
+	check.NoError(cli.AddPeer(bob.AsPeer()))
+	check.NoError(cli.Save(prov))
+	ask, err := cli.AskAcknowledge(bob.AsPeer(), "127.0.0.1:7000")
+	check.NoError(err)
+	processEnvelope(exe, Envelope{Message: ask, SenderAddress: goracle}, errs, outbox)
+	check.Empty(errs)
+	check.Len(outbox, 1)
+	ack := <-outbox
+	check.Equal("127.0.0.1:7000", ack.RecipientAddress.String())
+	check.Equal(gork.SubjectAck, ack.Message.Subject)
+	check.True(ack.Message.Recipient.Equal(bob.PublicKey()))
+	check.True(ack.Message.Verify())
+	contact, _ := ack.Message.Headers.Get("you_can_contact_me_at")
+	check.Equal("127.0.0.1:5656", contact)
+
+	//	nor anyone the request wasn't signed for
+	cliAsPeer := cli.AsPeer()
+	ask.Recipient = cliAsPeer.Key
+	processEnvelope(exe, Envelope{Message: ask, SenderAddress: goracle}, errs, outbox)
+	check.Len(errs, 1)
+	check.Empty(outbox)
+	<-errs
+
+	//	we don't acknowledge strangers on anyone's behalf
+	carol := gork.NewPrincipal(rand.Reader, nil, nil)
+	ask, err = cli.AskAcknowledge(carol.AsPeer(), "127.0.0.1:7001")
+	check.NoError(err)
+	processEnvelope(exe, Envelope{Message: ask, SenderAddress: goracle}, errs, outbox)
+	check.Len(errs, 1)
+	check.Empty(outbox)
+	<-errs
+
+	//	an ACK from a peer is taken quietly, and one from a stranger isn't
+	fromBob, err := bob.Acknowledge(me.AsPeer(), "")
+	check.NoError(err)
+	processEnvelope(exe, Envelope{Message: fromBob, SenderAddress: goracle}, errs, outbox)
+	check.Empty(errs)
+	fromCarol, err := carol.Acknowledge(me.AsPeer(), "")
+	check.NoError(err)
+	processEnvelope(exe, Envelope{Message: fromCarol, SenderAddress: goracle}, errs, outbox)
+	check.Len(errs, 1)
+	check.Empty(outbox)
+
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/sean9999/go-delphi"
+	"github.com/sean9999/gork"
+)
+
+// relayLease is how long a relay passes things on to a peer after it last asked.
+// Peers with a relay ask again with every heartbeat, which also keeps their NAT open.
+const relayLease = 3 * time.Minute
+
+var errNotRelay = errors.New("not relaying")
+
+// askRelay asks our relay, if we have one, to pass on what's sent to us
+func askRelay(s state, outbox chan Envelope) error {
+	var relay string
+	var msg *delphi.Message
+	err := s.node.Do(func(me *gork.Principal) error {
+		relay, _ = me.Props.Get("relay")
+		if relay == "" {
+			return nil
+		}
+		var err error
+		msg, err = me.AskRelay()
+		return err
+	})
+	if err != nil || relay == "" {
+		return err
+	}
+	to, err := net.ResolveUDPAddr("udp", relay)
+	if err != nil {
+		return fmt.Errorf("can't reach relay: %w", err)
+	}
+	outbox <- Envelope{
+		Message:          msg,
+		SenderAddress:    s.localAddr,
+		RecipientAddress: to,
+	}
+	return nil
+}
+
+// processRelay handles a peer asking us to pass on what's sent to it
+func processRelay(s state, e Envelope, errs chan error, outbox chan Envelope) {
+	if !s.opts.relay {
+		errs <- fmt.Errorf("%w: not a relay", errNotRelay)
+		return
+	}
+	sender := gork.NewPeer(e.Message.Sender.Bytes())
+	if !s.node.HasPeer(sender) {
+		errs <- fmt.Errorf("%w for stranger %s", errNotRelay, sender.Nickname())
+		return
+	}
+	now := time.Now()
+	err := s.replays.take(e.Message, now)
+	if err != nil {
+		errs <- fmt.Errorf("relay request from %s: %w", sender.Nickname(), err)
+		return
+	}
+	s.relayed.register(sender.ToHex(), e.SenderAddress, now.Add(relayLease))
+}
+
+// relaying reports whether an envelope is one for us to pass on, rather than process:
+// we're a relay, and it's addressed to someone, but not to us, and not from us.
+// Mail for a peer we're a mailbox for is held, rather than passed on.
+func relaying(s state, e Envelope) bool {
+	if !s.opts.relay {
+		return false
+	}
+	me := s.node.self.PublicKey()
+	to := e.Message.Recipient
+	if to.IsZero() || to.Equal(me) || e.Message.Sender.Equal(me) {
+		return false
+	}
+	if s.opts.mailbox && e.Message.Subject == gork.SubjectMessage {
+		if holding, _ := s.registry.Registered(to.ToHex()); holding {
+			return false
+		}
+	}
+	return true
+}
+
+// relay passes an envelope on to its recipient, as it is, since it's none of our business what's in it.
+// Only our peers can have things passed on, and only so many, and only to where the recipient asked us to send them,
+// or failing that, to where we know the recipient to be.
+// It reports whether the envelope was passed on. If not, it's processed as though it were for us.
+func relay(s state, e Envelope, errs chan error, outbox chan Envelope) bool {
+	sender := gork.NewPeer(e.Message.Sender.Bytes())
+	if !s.node.HasPeer(sender) {
+		errs <- fmt.Errorf("%w for stranger %s", errNotRelay, sender.Nickname())
+		return true
+	}
+	pub := e.Message.Recipient.ToHex()
+	addr, ok := s.relayed.at(pub)
+	if !ok {
+		var known string
+		s.node.Do(func(me *gork.Principal) error {
+			for _, peer := range me.Peers {
+				if peer.ToHex() == pub {
+					known, _ = peer.Properties.Get("addr")
+				}
+			}
+			return nil
+		})
+		to, err := net.ResolveUDPAddr("udp", known)
+		if known == "" || err != nil {
+			return false
+		}
+		addr = to
+	}
+	if !s.quotas.allow(sender.ToHex()) {
+		errs <- fmt.Errorf("%w for %s: over quota", errNotRelay, sender.Nickname())
+		return true
+	}
+	outbox <- Envelope{
+		Message:          e.Message,
+		SenderAddress:    s.localAddr,
+		RecipientAddress: addr,
+	}
+	return true
+}
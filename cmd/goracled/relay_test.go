@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sean9999/go-delphi"
+	"github.com/sean9999/gork"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRelay(t *testing.T) {
+
+	check := assert.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	//	bob is behind NAT, so he has the relay pass things on to him
+	relayer, relayPC := listening(t, ctx, options{relay: true, relayRate: 0.001, relayBurst: 4})
+	defer relayPC.Close()
+	bob := strand(t)
+	defer bob.conn.Close()
+	relayer.node.AddPeer(bob.AsPeer())
+	ask, err := bob.AskRelay()
+	check.NoError(err)
+	bob.send(t, ask, relayPC.LocalAddr())
+	check.Eventually(func() bool {
+		_, ok := relayer.relayed.at(bob.AsPeer().ToHex())
+		return ok
+	}, 2*time.Second, 5*time.Millisecond)
+
+	//	a request can't be replayed to take bob's messages elsewhere, then or later
+	errs := make(chan error, 16)
+	outbox := make(chan Envelope, 16)
+	elsewhere := &net.UDPAddr{IP: net.IPv4(10, 6, 6, 6), Port: 5656}
+	processRelay(relayer, Envelope{Message: ask, SenderAddress: elsewhere}, errs, outbox)
+	check.ErrorIs(<-errs, gork.ErrStaleRequest)
+	stale := delphi.NewMessage(rand.Reader, []byte("pass it on"))
+	stale.Sender = bob.PublicKey()
+	stale.Subject = gork.SubjectRelay
+	stale.Headers.Set("time", time.Now().Add(-time.Hour).UTC().Format(time.RFC3339Nano))
+	check.NoError(stale.Sign(rand.Reader, &bob.Principal))
+	processRelay(relayer, Envelope{Message: stale, SenderAddress: elsewhere}, errs, outbox)
+	check.ErrorIs(<-errs, gork.ErrStaleRequest)
+	addr, _ := relayer.relayed.at(bob.AsPeer().ToHex())
+	check.Equal(bob.conn.LocalAddr().String(), addr.String())
+
+	//	alice can't reach bob directly, so she falls back to her relay
+	alice, alicePC := listening(t, ctx, options{})
+	defer alicePC.Close()
+	alice.node.Do(func(me *gork.Principal) error {
+		return me.SetProp("relay", relayPC.LocalAddr().String())
+	})
+	aliceAsPeer := gork.NewPeer(alice.node.self.PublicKey().Bytes())
+	aliceAsPeer.Properties.Set("addr", alicePC.LocalAddr().String())
+	relayer.node.AddPeer(aliceAsPeer)
+	bobAsPeer := bob.AsPeer()
+	bobAsPeer.Properties.Set("addr", "127.0.0.1:9")
+	alice.node.AddPeer(bobAsPeer)
+	alice.node.missed(bobAsPeer.ToHex(), 1)
+	sealed, err := alice.node.self.Seal(bobAsPeer, []byte("hello bob"), nil)
+	check.NoError(err)
+	check.NoError(post(alice, sealed, errs, outbox))
+	e := <-outbox
+	check.Equal(relayPC.LocalAddr().String(), e.RecipientAddress.String())
+	alicePC.WriteTo([]byte(e.Message.String()), e.RecipientAddress)
+
+	//	the relay passes it on as it is, and bob's receipt makes its way back the same way
+	got := bob.next(t)
+	check.True(got.Encrypted())
+	check.Equal(gork.MessageID(sealed), gork.MessageID(got))
+	check.NoError(bob.Open(got))
+	check.Equal("hello bob", string(got.PlainText))
+	receipt, err := bob.Receipt(got, gork.StatusDelivered)
+	check.NoError(err)
+	bob.send(t, receipt, relayPC.LocalAddr())
+	check.Eventually(func() bool {
+		parcels, _ := alice.queue.For(bobAsPeer.ToHex())
+		return len(parcels) == 0
+	}, 2*time.Second, 5*time.Millisecond)
+
+	//	peers only get so much relayed
+	letters := 0
+	for range 4 {
+		passed := relay(relayer, Envelope{Message: letter(t, alice.node.self, bobAsPeer, "again"), SenderAddress: alicePC.LocalAddr()}, errs, outbox)
+		check.True(passed)
+		select {
+		case <-outbox:
+			letters++
+		case err := <-errs:
+			check.ErrorIs(err, errNotRelay)
+			check.ErrorContains(err, "quota")
+		}
+	}
+	check.Equal(3, letters)
+
+	//	strangers get nothing relayed
+	carol := gork.NewPrincipal(rand.Reader, nil, nil)
+	relay(relayer, Envelope{Message: letter(t, &carol, bobAsPeer, "hi"), SenderAddress: alicePC.LocalAddr()}, errs, outbox)
+	check.ErrorIs(<-errs, errNotRelay)
+
+	//	and only relays relay
+	check.False(relaying(alice, Envelope{Message: letter(t, &carol, bobAsPeer, "hi")}))
+	processRelay(alice, Envelope{Message: ask, SenderAddress: bob.conn.LocalAddr()}, errs, outbox)
+	check.ErrorIs(<-errs, errNotRelay)
+
+}
@@ -5,12 +5,18 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"sync/atomic"
+	"time"
 
 	"github.com/sean9999/go-delphi"
 	"github.com/sean9999/gork"
 )
 
-const bufSize = 1024
+// bufSize is as big as a datagram gets
+const bufSize = gork.MaxDatagram
+
+// pemType is how delphi messages are PEM-encoded
+const pemType = "ORACLE MESSAGE"
 
 type blob []byte
 
@@ -24,6 +30,9 @@ type spool struct {
 	inbox  chan Envelope
 	outbox chan Envelope
 	errors chan error
+	stats  *counters
+	byAddr *limiter
+	byKey  *limiter
 }
 
 func (s spool) Consume(b []byte) (*delphi.Message, error) {
@@ -32,12 +41,9 @@ func (s spool) Consume(b []byte) (*delphi.Message, error) {
 	return msg, err
 }
 
+// Send writes a message to addr, PEM-encoded, the way it's read
 func (s spool) Send(msg delphi.Message, addr net.Addr) error {
-	msgAsBytes, err := msg.MarshalBinary()
-	if err != nil {
-		return err
-	}
-	_, err = s.conn.WriteTo(msgAsBytes, addr)
+	_, err := s.conn.WriteTo([]byte(msg.String()), addr)
 	return err
 }
 
@@ -51,87 +57,262 @@ func (c spoolError) Error() string {
 	return c.err.Error()
 }
 
-func NewSpool(conn net.PacketConn) spool {
+// counters tally inbound datagrams, and those dropped before they were processed, by reason
+type counters struct {
+	received  atomic.Uint64
+	malformed atomic.Uint64
+	byAddr    atomic.Uint64
+	byKey     atomic.Uint64
+	overflow  atomic.Uint64
+}
+
+// dropped is how many datagrams were dropped, for any reason
+func (c *counters) dropped() uint64 {
+	return c.malformed.Load() + c.byAddr.Load() + c.byKey.Load() + c.overflow.Load()
+}
+
+func (c *counters) String() string {
+	return fmt.Sprintf("received %d, dropped %d malformed, %d over address limit, %d over key limit, %d over queue limit",
+		c.received.Load(), c.malformed.Load(), c.byAddr.Load(), c.byKey.Load(), c.overflow.Load())
+}
+
+var (
+	errNotMessage = errors.New("not an oracle message")
+	errUnhandled  = errors.New("unhandled subject")
+	errUnsigned   = errors.New("unsigned or malformed message")
+)
+
+// prefilter decodes a datagram, and rejects what isn't worth checking the signature of.
+// It's cheap, so that a flood of junk costs us little.
+func prefilter(b []byte) (*delphi.Message, error) {
+	block, _ := pem.Decode(b)
+	if block == nil || block.Type != pemType {
+		return nil, errNotMessage
+	}
+	msg := new(delphi.Message)
+	err := msg.FromPEM(*block)
+	if err != nil {
+		return nil, err
+	}
+	if !subjects[msg.Subject] {
+		return nil, errUnhandled
+	}
+	if msg.Sender.IsZero() || len(msg.Signature()) == 0 || !msg.Valid() {
+		return nil, errUnsigned
+	}
+	return msg, nil
+}
+
+// NewSpool reads datagrams from conn, and queues well-formed messages to its inbox, for workers to process.
+// Sources are limited by address as they're read. Senders are limited by public key once workers have checked their signatures.
+// What's over the limit, or doesn't fit the queue, is dropped and counted.
+func NewSpool(conn net.PacketConn, opts options) spool {
 
-	inbox := make(chan Envelope)
+	inbox := make(chan Envelope, opts.queue)
 	outbox := make(chan Envelope)
 	errs := make(chan error)
 	s := spool{
-		conn, inbox, outbox, errs,
+		conn, inbox, outbox, errs, new(counters),
+		newLimiter(opts.rate, opts.burst),
+		newLimiter(opts.rate, opts.burst),
 	}
 
 	go func() {
+		defer close(inbox)
+		buf := make([]byte, bufSize)
 		for {
 			//	read in messages and spool them to inbox channel.
-			//	anything not well-formed as a delphi.Message is spooled to errors channel.
-			buf := make([]byte, bufSize)
+			//	anything not well-formed as a delphi.Message is dropped.
 			n, addr, err := conn.ReadFrom(buf)
 			if err != nil {
+				if errors.Is(err, net.ErrClosed) {
+					return
+				}
 				errs <- spoolError{err, n, addr}
 				continue
 			}
-			msg := new(delphi.Message)
-			pemblock, _ := pem.Decode(buf[:n])
-			err = msg.FromPEM(*pemblock)
-			if err != nil {
-				errs <- spoolError{err, n, addr}
+			env, ok := s.admit(buf[:n], addr)
+			if !ok {
 				continue
 			}
-			env := Envelope{
-				Message:          msg,
-				SenderAddress:    addr,
-				RecipientAddress: conn.LocalAddr(),
+			select {
+			case inbox <- env:
+			default:
+				s.stats.overflow.Add(1)
 			}
-			inbox <- env
 		}
 	}()
 	return s
 }
 
-func processAssertion(exe state, inEnv Envelope, errs chan error, outbox chan Envelope) {
-	if inEnv.Message.Subject != "ASSERTION" {
-		errs <- errors.New("bad subject")
+// admit decides whether a datagram is worth queueing, counting it either way.
+// It's done as datagrams are read, so it only does what's cheap.
+func (s spool) admit(b []byte, addr net.Addr) (Envelope, bool) {
+	s.stats.received.Add(1)
+	if !s.byAddr.allow(host(addr)) {
+		s.stats.byAddr.Add(1)
+		return Envelope{}, false
+	}
+	msg, err := prefilter(b)
+	if err != nil {
+		s.stats.malformed.Add(1)
+		return Envelope{}, false
 	}
+	env := Envelope{
+		Message:          msg,
+		SenderAddress:    addr,
+		RecipientAddress: s.conn.LocalAddr(),
+	}
+	return env, true
+}
 
-	valid := inEnv.Message.Valid()
-	verified := inEnv.Message.Verify()
-	if !valid || !verified {
-		errs <- errors.New("not valid or verified")
+// signed decides whether an admitted envelope is worth processing, by checking its signature and charging its sender, counting it either way.
+// It's done by workers, since checking signatures is what's expensive.
+// Handlers can count on what they're given being signed, and parsing it doesn't check the signature again.
+func (s spool) signed(e Envelope) bool {
+	//	anyone can claim to be anyone, so senders are only charged for what they've signed
+	if !gork.Verify(e.Message) {
+		s.stats.malformed.Add(1)
+		return false
 	}
+	if !s.byKey.allow(e.Message.Sender.ToHex()) {
+		s.stats.byKey.Add(1)
+		return false
+	}
+	return true
+}
 
-	me := exe.self
-	conf := exe.conf
+// host is the address a datagram came from, without the port, which is free for a sender to vary
+func host(addr net.Addr) string {
+	if udp, ok := addr.(*net.UDPAddr); ok {
+		return udp.IP.String()
+	}
+	h, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return h
+}
 
-	//	extract peer and ensure it comes with an address
-	peerKey := inEnv.Message.Sender
-	peer := gork.NewPeer(peerKey.Bytes())
-	peer.Properties.Set("addr", inEnv.SenderAddress.String())
+// work processes signed envelopes from the inbox, one at a time, until it's closed
+func work(exe state, s spool) {
+	for e := range s.inbox {
+		if s.signed(e) {
+			processEnvelope(exe, e, s.errors, s.outbox)
+		}
+	}
+}
 
-	//	add peer
-	err := me.AddPeer(peer)
+// processAssertion handles a node asserting who it is.
+// Our peers are acknowledged. Strangers are befriended, ignored, or queued for approval, according to our policy.
+func processAssertion(exe state, inEnv Envelope, errs chan error, outbox chan Envelope) {
+	from := inEnv.SenderAddress.String()
+	now := time.Now()
+	peer, err := gork.FreshAssertion(inEnv.Message, now)
 	if err != nil {
 		errs <- err
+		return
 	}
+	peer.Properties.Set("addr", from)
 
-	//	save to config
-	err = me.Save(conf)
-	if err != nil {
-		errs <- err
+	me := exe.node
+	if !me.HasPeer(peer) {
+		switch exe.opts.policy {
+		case policyKnown:
+			errs <- fmt.Errorf("ignoring assertion from stranger %s at %s", peer.Nickname(), from)
+			return
+		case policyManual:
+			request, err := gork.NewSighting(inEnv.Message, from, now)
+			if err == nil {
+				err = exe.requests.Sight(request)
+			}
+			if err != nil {
+				errs <- err
+			}
+			return
+		default:
+			//	add peer. Saving to config happens asynchronously
+			err = me.AddPeer(peer)
+			if err != nil && !errors.Is(err, gork.ErrPeerExists) {
+				errs <- err
+				return
+			}
+		}
 	}
 
 	//	let's send an ACK back
-	msg := me.Compose([]byte("I friended you."), nil, peer)
-	msg.Subject = "ORACLE MESSAGE"
-	msg.Headers.Set("you_can_contact_me_at", exe.localAddr.String())
-	msg.Sign(exe.environment.Randomness, me)
-
-	outEnv := Envelope{
+	contact := ""
+	if exe.localAddr != nil {
+		contact = exe.localAddr.String()
+	}
+	var msg *delphi.Message
+	err = me.Do(func(self *gork.Principal) error {
+		msg, err = self.Acknowledge(peer, contact)
+		return err
+	})
+	if err != nil {
+		errs <- err
+		return
+	}
+	outbox <- Envelope{
 		Message:          msg,
 		SenderAddress:    exe.localAddr,
 		RecipientAddress: inEnv.SenderAddress,
 	}
+}
 
-	fmt.Println(outEnv)
-
-	outbox <- outEnv
+// processAck handles an ACK. One from a peer tells us they've accepted our assertion, and there's nothing more to do.
+// One from ourselves is goracle asking us to acknowledge the peer it's addressed to, at the address in its "at" header,
+// so that the peer hears back from where we can be reached.
+func processAck(s state, e Envelope, errs chan error, outbox chan Envelope) {
+	sender := gork.NewPeer(e.Message.Sender.Bytes())
+	if !e.Message.Sender.Equal(s.node.self.PublicKey()) {
+		if !e.Message.Recipient.Equal(s.node.self.PublicKey()) || !s.node.HasPeer(sender) {
+			errs <- fmt.Errorf("ignoring ack from stranger %s at %s", sender.Nickname(), e.SenderAddress)
+		}
+		return
+	}
+	peer := gork.NewPeer(e.Message.Recipient.Bytes())
+	if ref, _ := e.Message.Headers.Get("for"); ref != peer.ToHex() {
+		errs <- fmt.Errorf("won't acknowledge %s, who goracle didn't ask us to", peer.Nickname())
+		return
+	}
+	if !s.node.HasPeer(peer) {
+		//	goracle has only just added them, and we haven't seen the config since
+		err := s.node.Do(func(me *gork.Principal) error {
+			return me.Reload(s.node.conf)
+		})
+		if err != nil {
+			errs <- err
+			return
+		}
+	}
+	if !s.node.HasPeer(peer) {
+		errs <- fmt.Errorf("won't acknowledge %s, who isn't our peer", peer.Nickname())
+		return
+	}
+	at, _ := e.Message.Headers.Get("at")
+	to, err := net.ResolveUDPAddr("udp", at)
+	if err != nil {
+		errs <- fmt.Errorf("can't acknowledge %s: %w", peer.Nickname(), err)
+		return
+	}
+	contact := ""
+	if s.localAddr != nil {
+		contact = s.localAddr.String()
+	}
+	var msg *delphi.Message
+	err = s.node.Do(func(me *gork.Principal) error {
+		msg, err = me.Acknowledge(peer, contact)
+		return err
+	})
+	if err != nil {
+		errs <- err
+		return
+	}
+	outbox <- Envelope{
+		Message:          msg,
+		SenderAddress:    s.localAddr,
+		RecipientAddress: to,
+	}
 }
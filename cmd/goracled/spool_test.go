@@ -0,0 +1,109 @@
+package main
+
+import (
+	"crypto/rand"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sean9999/gork"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdmit(t *testing.T) {
+
+	check := assert.New(t)
+
+	pc, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	check.NoError(err)
+	defer pc.Close()
+	s := NewSpool(pc, options{rate: 0.001, burst: 4, queue: 2})
+	from := func(i int) net.Addr {
+		return &net.UDPAddr{IP: net.IPv4(10, 0, 0, byte(i)), Port: 5656}
+	}
+	//	what's read is admitted, and then a worker checks it's signed
+	let := func(b []byte, addr net.Addr) bool {
+		e, ok := s.admit(b, addr)
+		return ok && s.signed(e)
+	}
+
+	//	junk, and messages we don't handle, are dropped before their signatures are checked
+	alice := gork.NewPrincipal(rand.Reader, nil, nil)
+	assertion, err := alice.Assert()
+	check.NoError(err)
+	greeting := alice.Compose([]byte("hello"), nil, alice.AsPeer())
+	greeting.Subject = "GREETING"
+	check.NoError(greeting.Sign(rand.Reader, &alice))
+	check.False(let([]byte("hello"), from(1)))
+	check.False(let([]byte(greeting.String()), from(1)))
+	check.Equal(uint64(2), s.stats.malformed.Load())
+
+	//	forgeries don't use up the allowance of whoever they claim to be from
+	forged, err := alice.Assert()
+	check.NoError(err)
+	forged.PlainText = []byte(`{"msg":"i assert that I am mallory"}`)
+	for range 4 {
+		_, ok := s.admit([]byte(forged.String()), from(2))
+		check.True(ok, "signatures aren't checked as datagrams are read")
+		check.False(s.signed(Envelope{Message: forged}))
+	}
+	check.Equal(uint64(6), s.stats.malformed.Load())
+
+	//	a sender gets a burst's worth, no matter how many addresses it sends from
+	admitted := 0
+	for i := range 6 {
+		if let([]byte(assertion.String()), from(10+i)) {
+			admitted++
+		}
+	}
+	check.Equal(4, admitted)
+	check.Equal(uint64(2), s.stats.byKey.Load())
+
+	//	an address gets a burst's worth, no matter who it claims to be. Junk counts.
+	admitted = 0
+	for range 4 {
+		bob := gork.NewPrincipal(rand.Reader, nil, nil)
+		msg, err := bob.Assert()
+		check.NoError(err)
+		if let([]byte(msg.String()), from(1)) {
+			admitted++
+		}
+	}
+	check.Equal(2, admitted)
+	check.Equal(uint64(2), s.stats.byAddr.Load())
+	check.Equal(uint64(16), s.stats.received.Load())
+	check.Equal(uint64(10), s.stats.dropped())
+
+}
+
+func TestSpool(t *testing.T) {
+
+	check := assert.New(t)
+
+	pc, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	check.NoError(err)
+	defer pc.Close()
+	s := NewSpool(pc, options{rate: 1, burst: 100, queue: 2})
+
+	//	nobody is working the inbox, so all but two are dropped
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	check.NoError(err)
+	defer conn.Close()
+	for range 5 {
+		alice := gork.NewPrincipal(rand.Reader, nil, nil)
+		msg, err := alice.Assert()
+		check.NoError(err)
+		_, err = conn.WriteTo([]byte(msg.String()), pc.LocalAddr())
+		check.NoError(err)
+	}
+	check.Eventually(func() bool {
+		return s.stats.overflow.Load() == 3
+	}, 2*time.Second, time.Millisecond)
+	check.Len(s.inbox, 2)
+
+	//	the inbox is closed along with the connection
+	pc.Close()
+	for range s.inbox {
+	}
+
+}
@@ -1,10 +1,12 @@
 package gork
 
 import (
-	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"strconv"
+	"strings"
 
 	"github.com/google/uuid"
 	"github.com/sean9999/go-delphi"
@@ -13,12 +15,20 @@ import (
 
 var ErrNoPrivKey = pear.Defer("no private key")
 var ErrNoPubKey = pear.Defer("no public key")
+var ErrNilConfig = pear.Defer("nil config")
+var ErrBadSignature = pear.Defer("bad signature")
 
 type ConfigProvider interface {
 	Get() (*Config, error)
 	Set(*Config) error
 }
 
+// a ConfigUpdater is a [ConfigProvider] that can read and replace its [Config] without anyone else writing in between.
+// fn is passed the config as it is, or nil if there isn't one yet, and returns what to replace it with.
+type ConfigUpdater interface {
+	Update(fn func(current *Config) (*Config, error)) error
+}
+
 // type propsAndVerity struct {
 // 	Props  KV     `yaml:"props,omitempty" json:"props,omitempty" msgpack:"props,omitempty"`
 // 	Verity Verity `yaml:"ver" json:"ver" msgpack:"ver"`
@@ -27,9 +37,11 @@ type ConfigProvider interface {
 // a Config is an object suitable for serializing and storing [Peer]s and key-value pairs
 type Config struct {
 	readBuf []byte
+	Version int        `yaml:"version" json:"version" msgpack:"version"`
 	Pub     delphi.Key `yaml:"pub" json:"pub" msgpack:"pub"`
 	Props   *KV        `yaml:"props,omitempty" json:"props,omitempty" msgpack:"props,omitempty"`
 	Peers   *PeerList  `yaml:"peers,omitempty" json:"peers,omitempty" msgpack:"peers,omitempty"`
+	Log     string     `yaml:"log,omitempty" json:"log,omitempty" msgpack:"log,omitempty"`
 	Verity  *Verity    `yaml:"ver" json:"ver" msgpack:"ver"`
 }
 
@@ -41,6 +53,31 @@ func (c Config) Verify(p Principal) (bool, error) {
 	return p.Verify(p.PublicKey(), dig, c.Verity.Signature), nil
 }
 
+// withoutPeers is a shallow copy of a [Config], minus its peers
+func (c *Config) withoutPeers() Config {
+	return Config{
+		Version: c.Version,
+		Pub:     c.Pub,
+		Props:   c.Props,
+		Log:     c.Log,
+		Verity:  c.Verity,
+	}
+}
+
+// clone is a deep copy of a [Config], which shares nothing with a live [Principal]
+func (c *Config) clone() (*Config, error) {
+	buf, err := FormatJSON.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	d := new(Config)
+	err = FormatJSON.Unmarshal(buf, d)
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
 // Hydrate fills a [Config] with information from a [Principal]
 func (c *Config) Hydrate(p *Principal) {
 	c.Pub = p.PublicKey()
@@ -59,7 +96,7 @@ func (c *Config) ensureNonce(randy io.Reader) error {
 	}
 	id, err := uuid.NewRandomFromReader(randy)
 	if err != nil {
-		return pear.Errorf("can't ensure nonce: %w", err)
+		return fmt.Errorf("can't ensure nonce: %w", err)
 	}
 	c.Verity.Nonce = id[:]
 	return nil
@@ -78,60 +115,29 @@ type Verity struct {
 }
 
 func (v *Verity) MarshalJSON() ([]byte, error) {
-	nonce := hex.EncodeToString(v.Nonce)
-	sig := hex.EncodeToString(v.Signature)
-	m := map[string]string{
-		"nonce": nonce,
-		"sig":   sig,
-	}
-	return json.Marshal(m)
+	return json.Marshal(v.asMap())
 }
 
 func (v *Verity) UnmarshalJSON(b []byte) error {
-	var m map[string]string
 
-	err := json.Unmarshal(b, &m)
-	if err != nil {
-		return err
+	//	legacy configs represent Verity as a "nonce.sig" slug
+	var slug string
+	if json.Unmarshal(b, &slug) == nil {
+		nonce, sig, found := strings.Cut(slug, ".")
+		if !found {
+			return errors.New("bad slug")
+		}
+		return v.fromMap(map[string]string{"nonce": nonce, "sig": sig})
 	}
 
-	nonce, err := hex.DecodeString(m["nonce"])
-	if err != nil {
-		return err
-	}
-	sig, err := hex.DecodeString(m["sig"])
+	var m map[string]string
+	err := json.Unmarshal(b, &m)
 	if err != nil {
 		return err
 	}
-
-	v.Nonce = nonce
-	v.Signature = sig
-	return nil
+	return v.fromMap(m)
 }
 
-// func (v Verity) MarshalJSON() ([]byte, error) {
-// 	str := fmt.Sprintf("%x.%x", v.Nonce, v.Signature)
-// 	return []byte(str), nil
-// }
-
-// func (v *Verity) UnmarshalJSON(b []byte) error {
-// 	slug := strings.Split(string(b), ".")
-// 	if len(slug) != 2 {
-// 		return errors.New("bad slug length")
-// 	}
-// 	nonce, err := hex.DecodeString(slug[0])
-// 	if err != nil {
-// 		return err
-// 	}
-// 	sig, err := hex.DecodeString(slug[1])
-// 	if err != nil {
-// 		return err
-// 	}
-// 	v.Nonce = nonce
-// 	v.Signature = sig
-// 	return nil
-// }
-
 // produce a digest, for signing
 func (c *Config) Digest() (digest []byte, err error) {
 
@@ -139,10 +145,12 @@ func (c *Config) Digest() (digest []byte, err error) {
 		return nil, pear.New("nil nonce")
 	}
 
-	fields := [3][]byte{}
+	fields := [5][]byte{}
 	//	0 : pub key
 	//	1 : props
-	//	3 : nonce
+	//	2 : nonce
+	//	3 : version (absent in legacy configs)
+	//	4 : head of the change log, if there is one
 
 	props, err := c.Props.MarshalJSON()
 	if err != nil {
@@ -158,6 +166,11 @@ func (c *Config) Digest() (digest []byte, err error) {
 
 	fields[2] = c.Verity.Nonce
 
+	if c.Version > 0 {
+		fields[3] = []byte(strconv.Itoa(c.Version))
+	}
+	fields[4] = []byte(c.Log)
+
 	for _, field := range fields {
 		digest = append(digest, field...)
 	}
@@ -179,7 +192,7 @@ func (c *Config) Write(b []byte) (int, error) {
 	//d := new(Config)
 	err := json.Unmarshal(b, c)
 	if err != nil {
-		return 0, pear.Errorf("could not write to config. %w", err)
+		return 0, fmt.Errorf("could not write to config. %w", err)
 	}
 	return len(b), io.EOF
 }
@@ -187,11 +200,10 @@ func (c *Config) Write(b []byte) (int, error) {
 func (c *Config) Read(b []byte) (int, error) {
 
 	if c.readBuf == nil {
-		buf, err := json.MarshalIndent(c, "", "\t")
+		buf, err := FormatJSON.Marshal(c)
 		if err != nil {
 			return 0, err
 		}
-		buf = append(buf, []byte("\n")...)
 		c.readBuf = buf
 	}
 
@@ -270,9 +282,10 @@ func NewConfig() *Config {
 
 	peerlist := make(PeerList, 0)
 	c := Config{
-		Pub:   delphi.Key{},
-		Props: NewKV(),
-		Peers: &peerlist,
+		Version: ConfigVersion,
+		Pub:     delphi.Key{},
+		Props:   NewKV(),
+		Peers:   &peerlist,
 	}
 	return &c
 }
@@ -0,0 +1,274 @@
+package gork
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/bits"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/sean9999/go-delphi"
+	"github.com/sean9999/pear"
+)
+
+const (
+	// SubjectFind is the subject of a message asking a node what it knows of some place in the DHT
+	SubjectFind = "FIND"
+	// SubjectFound is the subject of the answer to a FIND
+	SubjectFound = "FOUND"
+	// SubjectStore is the subject of a message asking a node to hold the sender's address record
+	SubjectStore = "STORE"
+)
+
+const (
+	// FoundContactLimit is the most contacts a FOUND carries
+	FoundContactLimit = 32
+	// FoundRecordLimit is the most records a FOUND carries
+	FoundRecordLimit = 8
+)
+
+var ErrBadDHT = pear.Defer("bad dht message")
+var ErrBadNodeID = pear.Defer("bad node id")
+
+// a NodeID is a place in the distributed hash table.
+// A key's place is a hash of the whole key. Grips are too short to be places: they collide, and anyone can make a key with whatever grip they like.
+type NodeID [sha256.Size]byte
+
+// IDOf is the place of a key in the DHT
+func IDOf(k delphi.Key) NodeID {
+	return sha256.Sum256(k.Bytes())
+}
+
+// isGrip matches what a grip looks like. Grips aren't zero-padded, so they can be shorter than 8.
+var isGrip = regexp.MustCompile(`^[0-9a-f]{1,8}$`)
+
+// IsGrip reports whether s looks like a grip
+func IsGrip(s string) bool {
+	return isGrip.MatchString(s)
+}
+
+// ParseNodeID parses a NodeID from hex
+func ParseNodeID(s string) (NodeID, error) {
+	var id NodeID
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != len(id) {
+		return id, pear.Errorf("%w: %q", ErrBadNodeID, s)
+	}
+	copy(id[:], b)
+	return id, nil
+}
+
+func (id NodeID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// Distance is how far other is from id, by the XOR metric
+func (id NodeID) Distance(other NodeID) NodeID {
+	var d NodeID
+	for i := range id {
+		d[i] = id[i] ^ other[i]
+	}
+	return d
+}
+
+// Closer reports whether a is closer to id than b is
+func (id NodeID) Closer(a, b NodeID) bool {
+	da, db := id.Distance(a), id.Distance(b)
+	return bytes.Compare(da[:], db[:]) < 0
+}
+
+// CommonPrefix is how many leading bits id and other have in common
+func (id NodeID) CommonPrefix(other NodeID) int {
+	d := id.Distance(other)
+	for i, b := range d {
+		if b != 0 {
+			return i*8 + bits.LeadingZeros8(b)
+		}
+	}
+	return len(d) * 8
+}
+
+// a Contact is a node in the DHT, and where it can be reached
+type Contact struct {
+	Pub  string `json:"pub"`
+	Addr string `json:"addr"`
+}
+
+// Found is what a node knows of some place in the DHT: the nodes closest to it, and any records it holds for it
+type Found struct {
+	Contacts []Contact    `json:"contacts,omitempty"`
+	Records  []AddrRecord `json:"records,omitempty"`
+}
+
+// Find produces a signed FIND for the node at to, asking about target.
+// It carries an id that the FOUND answering it will carry.
+func (g *Principal) Find(to Peer, target NodeID, headers *KV) (*delphi.Message, error) {
+	return g.find(to, target.String(), headers, false)
+}
+
+// FindGrip produces a signed FIND for the node at to, asking for records it holds of keys with that grip.
+// A grip isn't a place in the DHT, so there's nowhere to look: it's answered from whatever the node happens to hold.
+func (g *Principal) FindGrip(to Peer, grip string, headers *KV) (*delphi.Message, error) {
+	if !IsGrip(grip) {
+		return nil, pear.Errorf("%w: %q isn't a grip", ErrBadDHT, grip)
+	}
+	return g.find(to, grip, headers, true)
+}
+
+// find produces a signed FIND, asking about a place, or a grip.
+// It says when it was made, so that one goracle sends its daemon can't be replayed.
+func (g *Principal) find(to Peer, body string, headers *KV, byGrip bool) (*delphi.Message, error) {
+	id := make([]byte, 16)
+	_, err := io.ReadFull(g.randomness, id)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBadDHT, err)
+	}
+	msg := g.Compose([]byte(body), headers, to)
+	msg.Subject = SubjectFind
+	msg.Headers.Set("find", hex.EncodeToString(id))
+	if byGrip {
+		msg.Headers.Set("by", "grip")
+	}
+	stamp(msg, time.Now())
+	err = msg.Sign(g.randomness, g)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBadDHT, err)
+	}
+	return msg, nil
+}
+
+// ByGrip reports whether a FIND asks about a grip, rather than a place
+func ByGrip(msg *delphi.Message) bool {
+	if msg == nil || msg.Headers == nil {
+		return false
+	}
+	by, _ := msg.Headers.Get("by")
+	return by == "grip"
+}
+
+// FindTarget verifies a FIND, and returns its id and the place it asks about
+func FindTarget(msg *delphi.Message) (string, NodeID, error) {
+	id, err := dhtID(msg, SubjectFind)
+	if err != nil {
+		return "", NodeID{}, err
+	}
+	if ByGrip(msg) {
+		return "", NodeID{}, pear.Errorf("%w: find is by grip", ErrBadDHT)
+	}
+	target, err := ParseNodeID(string(msg.PlainText))
+	if err != nil {
+		return "", NodeID{}, fmt.Errorf("%w: %w", ErrBadDHT, err)
+	}
+	return id, target, nil
+}
+
+// FindGripOf verifies a FIND by grip, and returns its id and the grip it asks about
+func FindGripOf(msg *delphi.Message) (string, string, error) {
+	id, err := dhtID(msg, SubjectFind)
+	if err != nil {
+		return "", "", err
+	}
+	grip := string(msg.PlainText)
+	if !ByGrip(msg) || !IsGrip(grip) {
+		return "", "", pear.Errorf("%w: not a find by grip", ErrBadDHT)
+	}
+	return id, grip, nil
+}
+
+// Found produces a signed answer to a FIND
+func (g *Principal) Found(find *delphi.Message, found Found, headers *KV) (*delphi.Message, error) {
+	id, err := dhtID(find, SubjectFind)
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(found)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBadDHT, err)
+	}
+	msg := g.Compose(body, headers, NewPeer(find.Sender.Bytes()))
+	msg.Subject = SubjectFound
+	msg.Headers.Set("find", id)
+	err = msg.Sign(g.randomness, g)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBadDHT, err)
+	}
+	return msg, nil
+}
+
+// ParseFound verifies a FOUND, and returns the id of the FIND it answers, and what it says.
+// Records in it are not verified here.
+func ParseFound(msg *delphi.Message) (string, Found, error) {
+	var found Found
+	id, err := dhtID(msg, SubjectFound)
+	if err != nil {
+		return "", found, err
+	}
+	err = json.Unmarshal(msg.PlainText, &found)
+	if err != nil {
+		return "", found, fmt.Errorf("%w: %w", ErrBadDHT, err)
+	}
+	if len(found.Contacts) > FoundContactLimit || len(found.Records) > FoundRecordLimit {
+		return "", Found{}, pear.Errorf("%w: too long", ErrBadDHT)
+	}
+	return id, found, nil
+}
+
+// Store produces a signed STORE, asking the node at to to hold our address record
+func (g *Principal) Store(to Peer, rec AddrRecord) (*delphi.Message, error) {
+	body, err := json.Marshal(rec)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBadDHT, err)
+	}
+	msg := g.Compose(body, nil, to)
+	msg.Subject = SubjectStore
+	err = msg.Sign(g.randomness, g)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBadDHT, err)
+	}
+	return msg, nil
+}
+
+// ParseStore verifies a STORE, and the record in it. A node can only store its own record.
+func ParseStore(msg *delphi.Message) (AddrRecord, error) {
+	var rec AddrRecord
+	if msg == nil || msg.Subject != SubjectStore {
+		return rec, pear.Errorf("%w: not a store", ErrBadDHT)
+	}
+	if !Verify(msg) {
+		return rec, fmt.Errorf("%w: %w", ErrBadDHT, ErrBadSignature)
+	}
+	err := json.Unmarshal(msg.PlainText, &rec)
+	if err != nil {
+		return rec, fmt.Errorf("%w: %w", ErrBadDHT, err)
+	}
+	if err := rec.Verify(); err != nil {
+		return AddrRecord{}, err
+	}
+	if rec.Pub != msg.Sender.ToHex() {
+		return AddrRecord{}, pear.Errorf("%w: record isn't the sender's own", ErrBadDHT)
+	}
+	return rec, nil
+}
+
+// dhtID verifies a FIND or FOUND, and returns the id that ties them together
+func dhtID(msg *delphi.Message, subject string) (string, error) {
+	if msg == nil || msg.Subject != subject {
+		return "", pear.Errorf("%w: not %s", ErrBadDHT, strings.ToLower(subject))
+	}
+	if !Verify(msg) {
+		return "", fmt.Errorf("%w: %w", ErrBadDHT, ErrBadSignature)
+	}
+	id := ""
+	if msg.Headers != nil {
+		id, _ = msg.Headers.Get("find")
+	}
+	if id == "" {
+		return "", pear.Errorf("%w: no id", ErrBadDHT)
+	}
+	return id, nil
+}
@@ -0,0 +1,127 @@
+package gork
+
+import (
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNodeID(t *testing.T) {
+
+	check := assert.New(t)
+	alice := NewPrincipal(rand.Reader, nil, nil)
+	bob := NewPrincipal(rand.Reader, nil, nil)
+
+	id := IDOf(alice.PublicKey())
+	check.NotEqual(id, IDOf(bob.PublicKey()))
+	parsed, err := ParseNodeID(id.String())
+	check.NoError(err)
+	check.Equal(id, parsed)
+	_, err = ParseNodeID("abc")
+	check.ErrorIs(err, ErrBadNodeID)
+
+	var zero, one, two NodeID
+	one[31], two[31] = 1, 2
+	check.Equal(256, zero.CommonPrefix(zero))
+	check.Equal(255, zero.CommonPrefix(one))
+	check.Equal(254, one.CommonPrefix(two))
+	check.Equal(NodeID{31: 3}, one.Distance(two))
+	check.True(zero.Closer(one, two))
+	check.False(zero.Closer(two, one))
+	check.False(zero.Closer(one, one))
+
+}
+
+func TestFind(t *testing.T) {
+
+	check := assert.New(t)
+	alice := NewPrincipal(rand.Reader, nil, nil)
+	bob := NewPrincipal(rand.Reader, nil, nil)
+	target := IDOf(bob.PublicKey())
+
+	find, err := alice.Find(bob.AsPeer(), target, nil)
+	check.NoError(err)
+	id, got, err := FindTarget(find)
+	check.NoError(err)
+	check.Equal(target, got)
+	check.NoError(CheckFresh(find, time.Now()))
+
+	bob.SetProp("addr", "10.0.0.2:5656")
+	rec, _, err := bob.AddrRecord(time.Now())
+	check.NoError(err)
+	found := Found{
+		Contacts: []Contact{{Pub: alice.AsPeer().ToHex(), Addr: "10.0.0.1:5656"}},
+		Records:  []AddrRecord{rec},
+	}
+	answer, err := bob.Found(find, found, nil)
+	check.NoError(err)
+	check.True(answer.Recipient.Equal(alice.PublicKey()))
+	answerID, gotFound, err := ParseFound(answer)
+	check.NoError(err)
+	check.Equal(id, answerID)
+	check.Equal(found, gotFound)
+
+	//	ids are signed
+	answer.Headers.Set("find", "0000")
+	_, _, err = ParseFound(answer)
+	check.ErrorIs(err, ErrBadSignature)
+	_, _, err = FindTarget(answer)
+	check.ErrorIs(err, ErrBadDHT)
+
+	//	a find by grip is a different question
+	grip := bob.AsPeer().Grip()
+	byGrip, err := alice.FindGrip(bob.AsPeer(), grip, nil)
+	check.NoError(err)
+	check.True(ByGrip(byGrip))
+	_, gotGrip, err := FindGripOf(byGrip)
+	check.NoError(err)
+	check.Equal(grip, gotGrip)
+	_, _, err = FindTarget(byGrip)
+	check.ErrorIs(err, ErrBadDHT)
+	_, _, err = FindGripOf(find)
+	check.ErrorIs(err, ErrBadDHT)
+	_, err = alice.FindGrip(bob.AsPeer(), "not a grip", nil)
+	check.ErrorIs(err, ErrBadDHT)
+	answer, err = bob.Found(byGrip, Found{Records: []AddrRecord{rec}}, nil)
+	check.NoError(err)
+	answerID, _, err = ParseFound(answer)
+	check.NoError(err)
+	gripID, _ := byGrip.Headers.Get("find")
+	check.Equal(gripID, answerID)
+
+}
+
+func TestStore(t *testing.T) {
+
+	check := assert.New(t)
+	alice := NewPrincipal(rand.Reader, nil, nil)
+	bob := NewPrincipal(rand.Reader, nil, nil)
+	alice.SetProp("addr", "10.0.0.1:5656")
+	bob.SetProp("addr", "10.0.0.2:5656")
+	aliceRec, _, err := alice.AddrRecord(time.Now())
+	check.NoError(err)
+	bobRec, _, err := bob.AddrRecord(time.Now())
+	check.NoError(err)
+
+	msg, err := alice.Store(bob.AsPeer(), aliceRec)
+	check.NoError(err)
+	got, err := ParseStore(msg)
+	check.NoError(err)
+	check.Equal(aliceRec, got)
+
+	//	only our own record can be stored
+	msg, err = alice.Store(bob.AsPeer(), bobRec)
+	check.NoError(err)
+	_, err = ParseStore(msg)
+	check.ErrorIs(err, ErrBadDHT)
+
+	forged := aliceRec
+	forged.Addr = "6.6.6.6:5656"
+	msg, err = alice.Store(bob.AsPeer(), forged)
+	check.NoError(err)
+	_, err = ParseStore(msg)
+	check.ErrorIs(err, ErrBadAddrRecord)
+
+}
@@ -0,0 +1,30 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package gork
+
+import (
+	"errors"
+	"os"
+	"syscall"
+
+	"github.com/spf13/afero"
+)
+
+// syncDir fsyncs a directory, so that whatever was renamed into it is durable.
+// Only real directories need to be synced.
+func syncDir(fs afero.Fs, dir string) error {
+	if _, onDisk := fs.(*afero.OsFs); !onDisk {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	err = d.Sync()
+	if errors.Is(err, syscall.EINVAL) {
+		//	some filesystems don't sync directories
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,10 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package gork
+
+import "github.com/spf13/afero"
+
+// syncDir is a no-op where directories can't be opened to be synced
+func syncDir(afero.Fs, string) error {
+	return nil
+}
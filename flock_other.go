@@ -0,0 +1,14 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package gork
+
+import (
+	"errors"
+	"os"
+)
+
+func flock(*os.File) (bool, error) {
+	return false, errors.New("flock is not supported")
+}
+
+const canFlock = false
@@ -0,0 +1,382 @@
+package gork
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/sean9999/go-delphi"
+	"github.com/sean9999/pear"
+	"github.com/vmihailenco/msgpack/v5"
+	omap "github.com/wk8/go-ordered-map/v2"
+	"gopkg.in/yaml.v3"
+)
+
+var ErrUnknownFormat = pear.Defer("unknown config format")
+
+// Format is a serialization format for a [Config].
+// Whatever the format, keys render as hex, [Verity] as a nonce/sig pair of hex strings,
+// and [PeerList] as a map of hex keys to ordered properties.
+type Format string
+
+const (
+	FormatJSON    Format = "json"
+	FormatYAML    Format = "yaml"
+	FormatMsgpack Format = "msgpack"
+)
+
+// FormatFromName infers a [Format] from a file extension, defaulting to JSON
+func FormatFromName(name string) Format {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".yaml", ".yml":
+		return FormatYAML
+	case ".msgpack", ".mpk", ".mp":
+		return FormatMsgpack
+	default:
+		return FormatJSON
+	}
+}
+
+// ParseFormat converts a string such as "yml" or "JSON" to a [Format]
+func ParseFormat(s string) (Format, error) {
+	switch strings.ToLower(s) {
+	case "json":
+		return FormatJSON, nil
+	case "yaml", "yml":
+		return FormatYAML, nil
+	case "msgpack", "mpk", "mp":
+		return FormatMsgpack, nil
+	default:
+		return "", fmt.Errorf("%w: %q", ErrUnknownFormat, s)
+	}
+}
+
+func (f Format) Marshal(c *Config) ([]byte, error) {
+	switch f {
+	case FormatJSON, "":
+		buf, err := json.MarshalIndent(c, "", "\t")
+		if err != nil {
+			return nil, err
+		}
+		return append(buf, '\n'), nil
+	case FormatYAML:
+		buf := new(bytes.Buffer)
+		enc := yaml.NewEncoder(buf)
+		enc.SetIndent(2)
+		err := enc.Encode(c)
+		if err != nil {
+			return nil, err
+		}
+		err = enc.Close()
+		return buf.Bytes(), err
+	case FormatMsgpack:
+		return msgpack.Marshal(c)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownFormat, f)
+	}
+}
+
+func (f Format) Unmarshal(b []byte, c *Config) error {
+	switch f {
+	case FormatJSON, "":
+		return json.Unmarshal(b, c)
+	case FormatYAML:
+		return yaml.Unmarshal(b, c)
+	case FormatMsgpack:
+		return msgpack.Unmarshal(b, c)
+	default:
+		return fmt.Errorf("%w: %q", ErrUnknownFormat, f)
+	}
+}
+
+// configDoc is the on-the-wire shape of a [Config], for formats that can't marshal a [delphi.Key] on their own
+type configDoc struct {
+	Version int       `yaml:"version"`
+	Pub     string    `yaml:"pub"`
+	Props   *KV       `yaml:"props,omitempty"`
+	Peers   *PeerList `yaml:"peers,omitempty"`
+	Log     string    `yaml:"log,omitempty"`
+	Verity  *Verity   `yaml:"ver"`
+}
+
+func (c *Config) MarshalYAML() (any, error) {
+	doc := configDoc{
+		Version: c.Version,
+		Pub:     hex.EncodeToString(c.Pub.Bytes()),
+		Props:   c.Props,
+		Peers:   c.Peers,
+		Log:     c.Log,
+		Verity:  c.Verity,
+	}
+	return doc, nil
+}
+
+func (c *Config) UnmarshalYAML(node *yaml.Node) error {
+	doc := configDoc{}
+	err := node.Decode(&doc)
+	if err != nil {
+		return err
+	}
+	return c.fromDoc(doc)
+}
+
+func (c *Config) fromDoc(doc configDoc) error {
+	k := delphi.KeyFromHex(doc.Pub)
+	if k.IsZero() {
+		return fmt.Errorf("%w: pub", ErrBadHex)
+	}
+	c.Version = doc.Version
+	c.Pub = k
+	c.Props = doc.Props
+	c.Peers = doc.Peers
+	c.Log = doc.Log
+	c.Verity = doc.Verity
+	return nil
+}
+
+func (c *Config) EncodeMsgpack(enc *msgpack.Encoder) error {
+	n := 3
+	if c.Props != nil {
+		n++
+	}
+	if c.Peers != nil {
+		n++
+	}
+	if c.Log != "" {
+		n++
+	}
+	err := enc.EncodeMapLen(n)
+	if err == nil {
+		err = enc.EncodeString("version")
+	}
+	if err == nil {
+		err = enc.EncodeInt(int64(c.Version))
+	}
+	if err == nil {
+		err = encodeStrings(enc, "pub", hex.EncodeToString(c.Pub.Bytes()))
+	}
+	if err == nil && c.Props != nil {
+		err = enc.EncodeString("props")
+		if err == nil {
+			err = encodeKV(enc, c.Props)
+		}
+	}
+	if err == nil && c.Peers != nil {
+		err = enc.EncodeString("peers")
+		if err == nil {
+			err = c.Peers.EncodeMsgpack(enc)
+		}
+	}
+	if err == nil && c.Log != "" {
+		err = encodeStrings(enc, "log", c.Log)
+	}
+	if err == nil {
+		err = enc.EncodeString("ver")
+	}
+	if err == nil {
+		err = enc.Encode(c.Verity)
+	}
+	return err
+}
+
+func (c *Config) DecodeMsgpack(dec *msgpack.Decoder) error {
+	n, err := dec.DecodeMapLen()
+	if err != nil {
+		return err
+	}
+	doc := configDoc{}
+	for range n {
+		key, err := dec.DecodeString()
+		if err != nil {
+			return err
+		}
+		switch key {
+		case "version":
+			doc.Version, err = dec.DecodeInt()
+		case "pub":
+			doc.Pub, err = dec.DecodeString()
+		case "props":
+			doc.Props, err = decodeKV(dec)
+		case "peers":
+			doc.Peers = new(PeerList)
+			err = doc.Peers.DecodeMsgpack(dec)
+		case "log":
+			doc.Log, err = dec.DecodeString()
+		case "ver":
+			doc.Verity = new(Verity)
+			err = dec.Decode(doc.Verity)
+		default:
+			err = dec.Skip()
+		}
+		if err != nil {
+			return fmt.Errorf("could not decode %q: %w", key, err)
+		}
+	}
+	return c.fromDoc(doc)
+}
+
+func (v *Verity) asMap() map[string]string {
+	return map[string]string{
+		"nonce": hex.EncodeToString(v.Nonce),
+		"sig":   hex.EncodeToString(v.Signature),
+	}
+}
+
+func (v *Verity) fromMap(m map[string]string) error {
+	nonce, err := hex.DecodeString(m["nonce"])
+	if err != nil {
+		return err
+	}
+	sig, err := hex.DecodeString(m["sig"])
+	if err != nil {
+		return err
+	}
+	v.Nonce = nonce
+	v.Signature = sig
+	return nil
+}
+
+func (v *Verity) MarshalYAML() (any, error) {
+	return v.asMap(), nil
+}
+
+func (v *Verity) UnmarshalYAML(node *yaml.Node) error {
+	var m map[string]string
+	err := node.Decode(&m)
+	if err != nil {
+		return err
+	}
+	return v.fromMap(m)
+}
+
+func (v *Verity) EncodeMsgpack(enc *msgpack.Encoder) error {
+	m := v.asMap()
+	err := enc.EncodeMapLen(2)
+	if err == nil {
+		err = encodeStrings(enc, "nonce", m["nonce"], "sig", m["sig"])
+	}
+	return err
+}
+
+func (v *Verity) DecodeMsgpack(dec *msgpack.Decoder) error {
+	kv, err := decodeKV(dec)
+	if err != nil {
+		return err
+	}
+	return v.fromMap(asMap(kv))
+}
+
+// asOrderedMap converts a PeerList to an ordered map of hex keys to properties
+func (pl PeerList) asOrderedMap() *omap.OrderedMap[string, *KV] {
+	m := omap.New[string, *KV](len(pl))
+	for _, peer := range pl {
+		peer.Expand()
+		m.Set(peer.ToHex(), peer.Properties)
+	}
+	return m
+}
+
+func (pl *PeerList) fromOrderedMap(m *omap.OrderedMap[string, *KV]) error {
+	peers := make(PeerList, 0, m.Len())
+	for pair := m.Oldest(); pair != nil; pair = pair.Next() {
+		k := delphi.KeyFromHex(pair.Key)
+		if k.IsZero() {
+			return fmt.Errorf("%w: %q", ErrBadHex, pair.Key)
+		}
+		props := pair.Value
+		if props == nil {
+			props = NewKV()
+		}
+		peers = append(peers, Peer{k, props})
+	}
+	*pl = peers
+	return nil
+}
+
+func (pl PeerList) MarshalYAML() (any, error) {
+	return pl.asOrderedMap(), nil
+}
+
+func (pl *PeerList) UnmarshalYAML(node *yaml.Node) error {
+	m := omap.New[string, *KV]()
+	err := node.Decode(m)
+	if err != nil {
+		return err
+	}
+	return pl.fromOrderedMap(m)
+}
+
+func (pl PeerList) EncodeMsgpack(enc *msgpack.Encoder) error {
+	m := pl.asOrderedMap()
+	err := enc.EncodeMapLen(m.Len())
+	for pair := m.Oldest(); err == nil && pair != nil; pair = pair.Next() {
+		err = enc.EncodeString(pair.Key)
+		if err == nil {
+			err = encodeKV(enc, pair.Value)
+		}
+	}
+	return err
+}
+
+func (pl *PeerList) DecodeMsgpack(dec *msgpack.Decoder) error {
+	n, err := dec.DecodeMapLen()
+	if err != nil {
+		return err
+	}
+	m := omap.New[string, *KV]()
+	for range n {
+		k, err := dec.DecodeString()
+		if err != nil {
+			return err
+		}
+		props, err := decodeKV(dec)
+		if err != nil {
+			return err
+		}
+		m.Set(k, props)
+	}
+	return pl.fromOrderedMap(m)
+}
+
+// encodeKV encodes a [KV] as a msgpack map, preserving order
+func encodeKV(enc *msgpack.Encoder, kv *KV) error {
+	err := enc.EncodeMapLen(kv.Len())
+	for pair := kv.Oldest(); err == nil && pair != nil; pair = pair.Next() {
+		err = encodeStrings(enc, pair.Key, pair.Value)
+	}
+	return err
+}
+
+// decodeKV decodes a msgpack map into a [KV], preserving order
+func decodeKV(dec *msgpack.Decoder) (*KV, error) {
+	n, err := dec.DecodeMapLen()
+	if err != nil {
+		return nil, err
+	}
+	kv := NewKV()
+	for range n {
+		k, err := dec.DecodeString()
+		if err != nil {
+			return nil, err
+		}
+		v, err := dec.DecodeString()
+		if err != nil {
+			return nil, err
+		}
+		kv.Set(k, v)
+	}
+	return kv, nil
+}
+
+func encodeStrings(enc *msgpack.Encoder, strs ...string) error {
+	for _, s := range strs {
+		err := enc.EncodeString(s)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package gork
+
+import (
+	"crypto/rand"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormats(t *testing.T) {
+
+	alice := NewPrincipal(rand.Reader, map[string]string{"hometown": "wonderland", "age": "7"}, nil)
+	bob := NewPrincipal(rand.Reader, nil, nil)
+	carol := NewPrincipal(rand.Reader, nil, nil)
+	alice.AddPeer(bob.AsPeer())
+	alice.AddPeer(carol.AsPeer())
+
+	conf := alice.Export()
+	want, err := FormatJSON.Marshal(conf)
+	assert.NoError(t, err)
+
+	for _, format := range []Format{FormatJSON, FormatYAML, FormatMsgpack} {
+		t.Run(string(format), func(t *testing.T) {
+			b, err := format.Marshal(conf)
+			assert.NoError(t, err)
+			conf2 := new(Config)
+			err = format.Unmarshal(b, conf2)
+			assert.NoError(t, err)
+			assert.NoError(t, alice.VerifyConfig(conf2))
+			got, err := FormatJSON.Marshal(conf2)
+			assert.NoError(t, err)
+			assert.Equal(t, string(want), string(got))
+		})
+	}
+
+	t.Run("yaml renders keys and verity as hex", func(t *testing.T) {
+		b, err := FormatYAML.Marshal(conf)
+		assert.NoError(t, err)
+		assert.Contains(t, string(b), "pub: "+alice.PublicKey().ToHex())
+		assert.Contains(t, string(b), bob.PublicKey().ToHex()+":")
+		assert.Contains(t, string(b), "nonce: ")
+	})
+
+	t.Run("format is inferred from file extension", func(t *testing.T) {
+		assert.Equal(t, FormatYAML, FormatFromName("conf.yml"))
+		assert.Equal(t, FormatMsgpack, FormatFromName("conf.msgpack"))
+		assert.Equal(t, FormatJSON, FormatFromName("conf.json"))
+		fs := afero.NewMemMapFs()
+		prov := FileBasedConfigProvider{Fs: fs, Name: "conf.yaml"}
+		assert.NoError(t, alice.Save(prov))
+		b, err := afero.ReadFile(fs, "conf.yaml")
+		assert.NoError(t, err)
+		assert.Contains(t, string(b), "peers:")
+		conf2, err := prov.Get()
+		assert.NoError(t, err)
+		assert.Len(t, *conf2.Peers, 2)
+	})
+
+	t.Run("unknown format", func(t *testing.T) {
+		_, err := ParseFormat("toml")
+		assert.ErrorIs(t, err, ErrUnknownFormat)
+	})
+
+}
@@ -0,0 +1,222 @@
+package gork
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/sean9999/go-delphi"
+	"github.com/sean9999/pear"
+)
+
+// SubjectGossip is the subject of a message in which nodes compare notes on where their mutual peers are
+const SubjectGossip = "GOSSIP"
+
+// GossipLimit is the most digest entries, records, or wants a single GOSSIP carries, so that it fits in a datagram
+const GossipLimit = 64
+
+// AddrRecordTTL is how long an address record is good for. We sign a fresh one when ours is half way there.
+const AddrRecordTTL = 24 * time.Hour
+
+var ErrBadGossip = pear.Defer("bad gossip")
+var ErrBadAddrRecord = pear.Defer("bad address record")
+
+// RecordProps hold the address record behind a peer's addr. Like [ObservedProps], assertions can't set them.
+var RecordProps = []string{"addr_version", "addr_expires", "addr_sig"}
+
+// IsRecordProp reports whether k is one of [RecordProps]
+func IsRecordProp(k string) bool {
+	for _, r := range RecordProps {
+		if k == r {
+			return true
+		}
+	}
+	return false
+}
+
+// an AddrRecord is a node's own word on where it can be reached.
+// It's signed by that node, so anyone can pass it along, and versioned, so that the newest one wins.
+// Version and Expires are in nanoseconds since the epoch.
+type AddrRecord struct {
+	Pub     string `json:"pub"`
+	Addr    string `json:"addr"`
+	Version int64  `json:"version"`
+	Expires int64  `json:"expires"`
+	Sig     string `json:"sig"`
+}
+
+func (r AddrRecord) digest() []byte {
+	sum := sha256.Sum256(fmt.Appendf(nil, "%s\n%s\n%d\n%d", r.Pub, r.Addr, r.Version, r.Expires))
+	return sum[:]
+}
+
+// Expired reports whether r is no longer good at t
+func (r AddrRecord) Expired(t time.Time) bool {
+	return t.UnixNano() >= r.Expires
+}
+
+// Verify checks that r is signed by the key it's about
+func (r AddrRecord) Verify() error {
+	pub, err := hex.DecodeString(r.Pub)
+	if err != nil || len(pub) != 64 {
+		return pear.Errorf("%w: bad public key", ErrBadAddrRecord)
+	}
+	sig, err := hex.DecodeString(r.Sig)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrBadAddrRecord, ErrBadSignature)
+	}
+	key := delphi.KeyFromBytes(pub)
+	if key.IsZero() || r.Addr == "" || !ed25519.Verify(ed25519.PublicKey(key[1][:]), r.digest(), sig) {
+		return fmt.Errorf("%w: %w", ErrBadAddrRecord, ErrBadSignature)
+	}
+	return nil
+}
+
+// Newer reports whether r supersedes other
+func (r AddrRecord) Newer(other AddrRecord) bool {
+	return r.Version > other.Version
+}
+
+// GossipKey is how a peer is referred to in gossip.
+// It's a hash of the peer's public key, so that a node only learns of peers it already knows.
+func GossipKey(k delphi.Key) string {
+	sum := sha256.Sum256(k.Bytes())
+	return hex.EncodeToString(sum[:8])
+}
+
+// AddrRecordOf returns the record behind a peer's addr, if its addr came from one
+func AddrRecordOf(p Peer) (AddrRecord, bool) {
+	if p.Properties == nil {
+		return AddrRecord{}, false
+	}
+	addr, _ := p.Properties.Get("addr")
+	version, _ := p.Properties.Get("addr_version")
+	expires, _ := p.Properties.Get("addr_expires")
+	sig, _ := p.Properties.Get("addr_sig")
+	v, verr := strconv.ParseInt(version, 10, 64)
+	x, xerr := strconv.ParseInt(expires, 10, 64)
+	if addr == "" || sig == "" || verr != nil || xerr != nil {
+		return AddrRecord{}, false
+	}
+	return AddrRecord{Pub: p.ToHex(), Addr: addr, Version: v, Expires: x, Sig: sig}, true
+}
+
+func setAddrRecord(props *KV, r AddrRecord) {
+	props.Set("addr", r.Addr)
+	props.Set("addr_version", strconv.FormatInt(r.Version, 10))
+	props.Set("addr_expires", strconv.FormatInt(r.Expires, 10))
+	props.Set("addr_sig", r.Sig)
+}
+
+// AddrRecord returns the record for our own addr, signing a new one if our addr has changed since the last,
+// or the last is half way to expiring.
+// changed reports whether a new one was signed. If we have no addr, the record is empty.
+func (g *Principal) AddrRecord(now time.Time) (rec AddrRecord, changed bool, err error) {
+	me := g.AsPeer()
+	addr, _ := g.Props.Get("addr")
+	if addr == "" {
+		return AddrRecord{}, false, nil
+	}
+	last, signed := AddrRecordOf(me)
+	if signed && last.Addr == addr && last.Verify() == nil && !last.Expired(now.Add(AddrRecordTTL/2)) {
+		return last, false, nil
+	}
+	//	versions only ever go up, even if our clock doesn't
+	version := max(now.UnixNano(), last.Version+1)
+	rec = AddrRecord{Pub: me.ToHex(), Addr: addr, Version: version, Expires: version + int64(AddrRecordTTL)}
+	sig, err := g.Sign(g.randomness, rec.digest(), nil)
+	if err != nil {
+		return AddrRecord{}, false, fmt.Errorf("%w: %w", ErrBadAddrRecord, err)
+	}
+	rec.Sig = hex.EncodeToString(sig)
+	setAddrRecord(g.Props, rec)
+	return rec, true, nil
+}
+
+// ApplyAddrRecord sets the addr of the peer that r is about, if r is signed by that peer, hasn't expired by now, and is newer than what we have.
+// It reports whether anything changed. Records about anyone other than our peers are ignored.
+// What we'd observed of a peer at its old address is forgotten.
+func (g *Principal) ApplyAddrRecord(r AddrRecord, now time.Time) (bool, error) {
+	if err := r.Verify(); err != nil {
+		return false, err
+	}
+	//	an expired record can't win, however new it is, or an old one could be replayed to take a peer back to where it was
+	if r.Expired(now) {
+		return false, pear.Errorf("%w: expired", ErrBadAddrRecord)
+	}
+	for i, peer := range g.Peers {
+		if peer.ToHex() != r.Pub {
+			continue
+		}
+		if current, ok := AddrRecordOf(peer); ok && !r.Newer(current) {
+			return false, nil
+		}
+		if peer.Properties == nil {
+			g.Peers[i].Properties = NewKV()
+		}
+		props := g.Peers[i].Properties
+		if old, _ := props.Get("addr"); old != r.Addr {
+			for _, o := range ObservedProps {
+				props.Delete(o)
+			}
+		}
+		setAddrRecord(props, r)
+		return true, nil
+	}
+	return false, nil
+}
+
+// Gossip is what one node tells another about the address records of peers they both know.
+// Peers are referred to by [GossipKey].
+type Gossip struct {
+	// Digest is which version of each peer's record the sender has. 0 means it has an addr, but no record.
+	Digest map[string]int64 `json:"digest,omitempty"`
+	// Records are newer than what the receiver has, going by its digest
+	Records []AddrRecord `json:"records,omitempty"`
+	// Want is peers the sender would like the receiver's records of
+	Want []string `json:"want,omitempty"`
+}
+
+// Empty reports whether there's nothing to tell
+func (gs Gossip) Empty() bool {
+	return len(gs.Digest) == 0 && len(gs.Records) == 0 && len(gs.Want) == 0
+}
+
+// Gossip produces a signed GOSSIP for peer
+func (g *Principal) Gossip(peer Peer, gs Gossip) (*delphi.Message, error) {
+	body, err := json.Marshal(gs)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBadGossip, err)
+	}
+	msg := g.Compose(body, nil, peer)
+	msg.Subject = SubjectGossip
+	err = msg.Sign(g.randomness, g)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBadGossip, err)
+	}
+	return msg, nil
+}
+
+// ParseGossip verifies a GOSSIP, and returns what it says.
+// Records in it are not verified here; [Principal.ApplyAddrRecord] does that.
+func ParseGossip(msg *delphi.Message) (Gossip, error) {
+	var gs Gossip
+	if msg == nil || msg.Subject != SubjectGossip {
+		return gs, pear.Errorf("%w: not gossip", ErrBadGossip)
+	}
+	if !Verify(msg) {
+		return gs, fmt.Errorf("%w: %w", ErrBadGossip, ErrBadSignature)
+	}
+	err := json.Unmarshal(msg.PlainText, &gs)
+	if err != nil {
+		return gs, fmt.Errorf("%w: %w", ErrBadGossip, err)
+	}
+	if len(gs.Digest) > GossipLimit || len(gs.Records) > GossipLimit || len(gs.Want) > GossipLimit {
+		return Gossip{}, pear.Errorf("%w: too long", ErrBadGossip)
+	}
+	return gs, nil
+}
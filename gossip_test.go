@@ -0,0 +1,133 @@
+package gork
+
+import (
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddrRecord(t *testing.T) {
+
+	check := assert.New(t)
+	alice := NewPrincipal(rand.Reader, nil, nil)
+	bob := NewPrincipal(rand.Reader, nil, nil)
+	now := time.Now()
+
+	//	no addr, no record
+	rec, changed, err := alice.AddrRecord(now)
+	check.NoError(err)
+	check.False(changed)
+	check.Empty(rec.Addr)
+
+	alice.SetProp("addr", "10.0.0.1:5656")
+	first, changed, err := alice.AddrRecord(now)
+	check.NoError(err)
+	check.True(changed)
+	check.NoError(first.Verify())
+
+	//	the same addr keeps the same record
+	again, changed, err := alice.AddrRecord(now.Add(time.Hour))
+	check.NoError(err)
+	check.False(changed)
+	check.Equal(first, again)
+
+	//	an old record is replaced before it expires
+	check.False(first.Expired(now.Add(AddrRecordTTL - time.Minute)))
+	check.True(first.Expired(now.Add(AddrRecordTTL)))
+	fresh, changed, err := alice.AddrRecord(now.Add(AddrRecordTTL * 2 / 3))
+	check.NoError(err)
+	check.True(changed)
+	check.True(fresh.Newer(first))
+	check.Equal(first.Addr, fresh.Addr)
+
+	//	a new addr gets a newer version, even if the clock went backwards
+	alice.SetProp("addr", "10.0.0.2:5656")
+	second, changed, err := alice.AddrRecord(now.Add(-time.Hour))
+	check.NoError(err)
+	check.True(changed)
+	check.True(second.Newer(first))
+
+	//	assertions don't carry records
+	msg, err := alice.Assert()
+	check.NoError(err)
+	asserted, err := ParseAssertion(msg)
+	check.NoError(err)
+	_, hasVersion := asserted.Properties.Get("addr_version")
+	check.False(hasVersion)
+
+	//	only peers' records are applied
+	applied, err := bob.ApplyAddrRecord(first, now)
+	check.NoError(err)
+	check.False(applied)
+
+	alicePeer := NewPeer(alice.PublicKey().Bytes())
+	alicePeer.Properties.Set("addr", "10.0.0.0:5656")
+	alicePeer.Properties.Set("status", "unreachable")
+	bob.AddPeer(alicePeer)
+	applied, err = bob.ApplyAddrRecord(first, now)
+	check.NoError(err)
+	check.True(applied)
+	got, _ := bob.FindPeer(alicePeer.ToHex())
+	held, _ := AddrRecordOf(got)
+	check.Equal(first, held)
+	_, hasStatus := got.Properties.Get("status")
+	check.False(hasStatus, "what was observed at the old addr is forgotten")
+
+	applied, err = bob.ApplyAddrRecord(second, now)
+	check.NoError(err)
+	check.True(applied)
+	applied, err = bob.ApplyAddrRecord(first, now)
+	check.NoError(err)
+	check.False(applied, "an older record doesn't win")
+	got, _ = bob.FindPeer(alicePeer.ToHex())
+	addr, _ := got.Properties.Get("addr")
+	check.Equal("10.0.0.2:5656", addr)
+
+	//	an expired record is refused, however new it is
+	_, err = bob.ApplyAddrRecord(second, time.Unix(0, second.Expires))
+	check.ErrorIs(err, ErrBadAddrRecord)
+
+	//	a record has to be signed by the key it's about
+	forged := second
+	forged.Addr = "6.6.6.6:5656"
+	forged.Version++
+	_, err = bob.ApplyAddrRecord(forged, now)
+	check.ErrorIs(err, ErrBadSignature)
+	forged.Sig = bob.AsPeer().ToHex()
+	_, err = bob.ApplyAddrRecord(forged, now)
+	check.ErrorIs(err, ErrBadAddrRecord)
+
+}
+
+func TestGossip(t *testing.T) {
+
+	check := assert.New(t)
+	alice := NewPrincipal(rand.Reader, nil, nil)
+	bob := NewPrincipal(rand.Reader, nil, nil)
+
+	gs := Gossip{Digest: map[string]int64{GossipKey(bob.PublicKey()): 7}, Want: []string{"abc"}}
+	msg, err := alice.Gossip(bob.AsPeer(), gs)
+	check.NoError(err)
+	got, err := ParseGossip(msg)
+	check.NoError(err)
+	check.Equal(gs, got)
+	check.False(got.Empty())
+	check.True(Gossip{}.Empty())
+
+	ping, err := alice.Ping(bob.AsPeer(), nil)
+	check.NoError(err)
+	_, err = ParseGossip(ping)
+	check.ErrorIs(err, ErrBadGossip)
+
+	digest := map[string]int64{}
+	for i := range GossipLimit + 1 {
+		digest[string(rune('a'+i))] = 1
+	}
+	msg, err = alice.Gossip(bob.AsPeer(), Gossip{Digest: digest})
+	check.NoError(err)
+	_, err = ParseGossip(msg)
+	check.ErrorIs(err, ErrBadGossip)
+
+}
@@ -0,0 +1,219 @@
+package gork
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sean9999/go-delphi"
+	"github.com/sean9999/pear"
+	"github.com/spf13/afero"
+)
+
+var (
+	ErrMailNotFound  = pear.Defer("no such message")
+	ErrAmbiguousMail = pear.Defer("more than one message matches")
+	ErrNotEncrypted  = pear.Defer("message isn't encrypted")
+	ErrNotMessage    = pear.Defer("not a message for us")
+)
+
+// Mail is a MESSAGE we've received.
+// It's kept as it arrived, signed and encrypted to us, and is verified and decrypted again whenever it's opened.
+type Mail struct {
+	ID   string `json:"id"`
+	From string `json:"from"`
+	// Thread is the thread the message belongs to. A message that doesn't say starts a thread of its own.
+	Thread   string    `json:"thread"`
+	Received time.Time `json:"received"`
+	Read     bool      `json:"read"`
+	Message  string    `json:"message"`
+}
+
+// NewMail records a MESSAGE received now. It's not verified or decrypted here; see [Principal.Open].
+func NewMail(msg *delphi.Message, now time.Time) Mail {
+	m := Mail{
+		ID:       MessageID(msg),
+		From:     msg.Sender.ToHex(),
+		Received: now,
+		Message:  msg.String(),
+	}
+	if msg.Headers != nil {
+		m.Thread, _ = msg.Headers.Get("thread")
+	}
+	if m.Thread == "" {
+		m.Thread = m.ID
+	}
+	return m
+}
+
+// Seal composes a MESSAGE to a peer, encrypted to them, and signed by us
+func (g *Principal) Seal(to Peer, body []byte, headers *KV) (*delphi.Message, error) {
+	msg := g.Compose(body, headers, to)
+	msg.Subject = SubjectMessage
+	err := g.Encrypt(g.randomness, msg, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = msg.Sign(g.randomness, g)
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// Open verifies a MESSAGE that was sent to us, and decrypts it
+func (g *Principal) Open(msg *delphi.Message) error {
+	if msg.Subject != SubjectMessage || !msg.Recipient.Equal(g.PublicKey()) {
+		return ErrNotMessage
+	}
+	if !msg.Encrypted() {
+		return ErrNotEncrypted
+	}
+	if !Verify(msg) {
+		return ErrBadSignature
+	}
+	return g.Decrypt(msg, nil)
+}
+
+// OpenMail verifies and decrypts a Mail
+func (g *Principal) OpenMail(m Mail) (*delphi.Message, error) {
+	msg, err := Parcel{Message: m.Message}.Decode()
+	if err != nil {
+		return nil, err
+	}
+	if msg.Sender.ToHex() != m.From {
+		return nil, pear.Errorf("%w: sent by someone else", ErrBadSignature)
+	}
+	err = g.Open(msg)
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// an Inbox keeps the messages we've received
+type Inbox interface {
+	// Deliver adds Mail, reporting false if we already had it
+	Deliver(Mail) (bool, error)
+	// List lists our mail, newest first
+	List() ([]Mail, error)
+	// Get finds mail by its ID, or a prefix of it
+	Get(id string) (Mail, error)
+	// MarkRead marks mail as read
+	MarkRead(id string) error
+}
+
+// FileInbox stores an [Inbox] as a JSON file
+type FileInbox struct {
+	Fs   afero.Fs
+	Name string
+}
+
+// InboxFile is where goracled keeps the messages it receives
+func InboxFile(fs afero.Fs, config string) FileInbox {
+	return FileInbox{Fs: fs, Name: config + ".inbox"}
+}
+
+func (f FileInbox) read() ([]Mail, error) {
+	b, err := afero.ReadFile(f.Fs, f.Name)
+	if errors.Is(err, os.ErrNotExist) {
+		return []Mail{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	all := []Mail{}
+	err = json.Unmarshal(b, &all)
+	return all, err
+}
+
+// update does a read-modify-write under lock
+func (f FileInbox) update(fn func([]Mail) ([]Mail, error)) error {
+	unlock, err := lockFile(f.Fs, f.Name+".lock", 0)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	all, err := f.read()
+	if err != nil {
+		return err
+	}
+	all, err = fn(all)
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(all, "", "\t")
+	if err != nil {
+		return err
+	}
+	return writeAtomic(f.Fs, f.Name, b)
+}
+
+func (f FileInbox) Deliver(m Mail) (bool, error) {
+	added := false
+	err := f.update(func(all []Mail) ([]Mail, error) {
+		for _, old := range all {
+			if old.ID == m.ID {
+				return all, nil
+			}
+		}
+		added = true
+		return append(all, m), nil
+	})
+	return added, err
+}
+
+func (f FileInbox) List() ([]Mail, error) {
+	all, err := f.read()
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(all, func(i, j int) bool {
+		return all[i].Received.After(all[j].Received)
+	})
+	return all, nil
+}
+
+// find finds mail by its ID, or a prefix of it
+func find(all []Mail, id string) (int, error) {
+	id = strings.ToLower(strings.TrimSpace(id))
+	found := -1
+	for i, m := range all {
+		if id != "" && strings.HasPrefix(m.ID, id) {
+			if found >= 0 {
+				return -1, pear.Errorf("%w: %q", ErrAmbiguousMail, id)
+			}
+			found = i
+		}
+	}
+	if found < 0 {
+		return -1, pear.Errorf("%w: %q", ErrMailNotFound, id)
+	}
+	return found, nil
+}
+
+func (f FileInbox) Get(id string) (Mail, error) {
+	all, err := f.read()
+	if err != nil {
+		return Mail{}, err
+	}
+	i, err := find(all, id)
+	if err != nil {
+		return Mail{}, err
+	}
+	return all[i], nil
+}
+
+func (f FileInbox) MarkRead(id string) error {
+	return f.update(func(all []Mail) ([]Mail, error) {
+		i, err := find(all, id)
+		if err != nil {
+			return nil, err
+		}
+		all[i].Read = true
+		return all, nil
+	})
+}
@@ -0,0 +1,69 @@
+package gork
+
+import (
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInbox(t *testing.T) {
+
+	check := assert.New(t)
+	alice := NewPrincipal(rand.Reader, nil, nil)
+	bob := NewPrincipal(rand.Reader, nil, nil)
+	inbox := FileInbox{Fs: afero.NewMemMapFs(), Name: "conf.json.inbox"}
+
+	//	a message starts a thread, and a reply carries it on
+	first, err := alice.Seal(bob.AsPeer(), []byte("hello bob"), nil)
+	check.NoError(err)
+	check.True(first.Encrypted())
+	headers := NewKV()
+	headers.Set("thread", MessageID(first))
+	second, err := alice.Seal(bob.AsPeer(), []byte("are you there?"), headers)
+	check.NoError(err)
+
+	now := time.Now()
+	for i, m := range []Mail{NewMail(first, now), NewMail(second, now.Add(time.Minute))} {
+		added, err := inbox.Deliver(m)
+		check.NoError(err)
+		check.True(added, i)
+	}
+	added, err := inbox.Deliver(NewMail(first, now))
+	check.NoError(err)
+	check.False(added)
+
+	all, err := inbox.List()
+	check.NoError(err)
+	check.Len(all, 2)
+	check.Equal(MessageID(second), all[0].ID)
+	check.Equal(all[0].Thread, all[1].Thread)
+
+	//	only bob can open it
+	m, err := inbox.Get(MessageID(first)[:8])
+	check.NoError(err)
+	msg, err := bob.OpenMail(m)
+	check.NoError(err)
+	check.Equal("hello bob", string(msg.PlainText))
+	_, err = alice.OpenMail(m)
+	check.ErrorIs(err, ErrNotMessage)
+
+	//	and it can't be tampered with
+	tampered := m
+	tampered.From = bob.AsPeer().ToHex()
+	_, err = bob.OpenMail(tampered)
+	check.ErrorIs(err, ErrBadSignature)
+
+	check.NoError(inbox.MarkRead(m.ID))
+	m, err = inbox.Get(m.ID)
+	check.NoError(err)
+	check.True(m.Read)
+
+	_, err = inbox.Get("nope")
+	check.ErrorIs(err, ErrMailNotFound)
+	_, err = inbox.Get("")
+	check.ErrorIs(err, ErrMailNotFound)
+
+}
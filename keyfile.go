@@ -0,0 +1,113 @@
+package gork
+
+import (
+	"crypto/cipher"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io"
+
+	"github.com/sean9999/go-delphi"
+	"github.com/sean9999/pear"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+)
+
+const encryptedKeyPemType = "ORACLE ENCRYPTED PRIVATE KEY"
+
+var ErrNeedPassphrase = pear.Defer("private key is protected by a passphrase")
+var ErrPassphrase = pear.Defer("wrong passphrase")
+
+// scrypt cost parameters for new key files. Key files record the parameters they were made with.
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// IsEncryptedPEM reports whether b is a private key protected by a passphrase
+func IsEncryptedPEM(b []byte) bool {
+	block, _ := pem.Decode(b)
+	return block != nil && block.Type == encryptedKeyPemType
+}
+
+// MarshalEncryptedPEM marshals a Principal to PEM format, with its private key sealed by a passphrase.
+// The sealing key is derived from the passphrase with scrypt, and the private key is sealed with XChaCha20-Poly1305.
+func (g *Principal) MarshalEncryptedPEM(randy io.Reader, passphrase []byte) ([]byte, error) {
+	if len(passphrase) == 0 {
+		return nil, pear.Errorf("%w: empty passphrase", ErrPassphrase)
+	}
+	salt := make([]byte, 16)
+	nonce := make([]byte, chacha20poly1305.NonceSizeX)
+	for _, b := range [][]byte{salt, nonce} {
+		_, err := io.ReadFull(randy, b)
+		if err != nil {
+			return nil, err
+		}
+	}
+	kdf := fmt.Sprintf("scrypt N=%d r=%d p=%d", scryptN, scryptR, scryptP)
+	aead, err := sealer(passphrase, salt, kdf)
+	if err != nil {
+		return nil, err
+	}
+	block := &pem.Block{
+		Type: encryptedKeyPemType,
+		Headers: map[string]string{
+			"grip":  g.AsPeer().Grip(),
+			"nick":  g.AsPeer().Nickname(),
+			"kdf":   kdf,
+			"salt":  hex.EncodeToString(salt),
+			"nonce": hex.EncodeToString(nonce),
+		},
+		Bytes: aead.Seal(nil, nonce, g.Bytes(), []byte(encryptedKeyPemType)),
+	}
+	return pem.EncodeToMemory(block), nil
+}
+
+// UnmarshalEncryptedPEM converts a PEM made by [Principal.MarshalEncryptedPEM] to a Principal
+func (g *Principal) UnmarshalEncryptedPEM(b []byte, passphrase []byte) error {
+	block, _ := pem.Decode(b)
+	if block == nil || block.Type != encryptedKeyPemType {
+		return fmt.Errorf("could not decode pem. %w", ErrBadPem)
+	}
+	if len(passphrase) == 0 {
+		return ErrNeedPassphrase
+	}
+	salt, err := hex.DecodeString(block.Headers["salt"])
+	if err != nil {
+		return fmt.Errorf("%w: salt: %w", ErrBadPem, err)
+	}
+	nonce, err := hex.DecodeString(block.Headers["nonce"])
+	if err != nil || len(nonce) != chacha20poly1305.NonceSizeX {
+		return pear.Errorf("%w: bad nonce", ErrBadPem)
+	}
+	aead, err := sealer(passphrase, salt, block.Headers["kdf"])
+	if err != nil {
+		return err
+	}
+	privkey, err := aead.Open(nil, nonce, block.Bytes, []byte(encryptedKeyPemType))
+	if err != nil {
+		return ErrPassphrase
+	}
+	prince, err := delphi.Principal{}.From(privkey)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrBadPem, err)
+	}
+	g.Principal = prince
+	g.Props = NewKV()
+	return nil
+}
+
+// sealer derives an AEAD from a passphrase, as described by a "kdf" header
+func sealer(passphrase, salt []byte, kdf string) (cipher.AEAD, error) {
+	var n, r, p int
+	_, err := fmt.Sscanf(kdf, "scrypt N=%d r=%d p=%d", &n, &r, &p)
+	if err != nil {
+		return nil, pear.Errorf("%w: unsupported kdf: %q", ErrBadPem, kdf)
+	}
+	key, err := scrypt.Key(passphrase, salt, n, r, p, chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBadPem, err)
+	}
+	return chacha20poly1305.NewX(key)
+}
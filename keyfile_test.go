@@ -0,0 +1,36 @@
+package gork
+
+import (
+	"crypto/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncryptedPEM(t *testing.T) {
+
+	check := assert.New(t)
+	alice := NewPrincipal(rand.Reader, nil, nil)
+
+	b, err := alice.MarshalEncryptedPEM(rand.Reader, []byte("jabberwock"))
+	check.NoError(err)
+	check.True(IsEncryptedPEM(b))
+	check.NotContains(string(b), "ORACLE PRIVATE KEY")
+
+	plain, _ := alice.MarshalPEM()
+	check.False(IsEncryptedPEM(plain))
+
+	//	a protected key can't be read as if it weren't
+	bob := new(Principal)
+	check.ErrorIs(bob.UnmarshalPEM(b), ErrNeedPassphrase)
+	check.ErrorIs(bob.UnmarshalEncryptedPEM(b, nil), ErrNeedPassphrase)
+	check.ErrorIs(bob.UnmarshalEncryptedPEM(b, []byte("bandersnatch")), ErrPassphrase)
+
+	check.NoError(bob.UnmarshalEncryptedPEM(b, []byte("jabberwock")))
+	check.True(bob.PublicKey().Equal(alice.PublicKey()))
+	check.Equal(alice.Nickname(), bob.Nickname())
+
+	_, err = alice.MarshalEncryptedPEM(rand.Reader, nil)
+	check.ErrorIs(err, ErrPassphrase)
+
+}
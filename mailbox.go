@@ -0,0 +1,378 @@
+package gork
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/sean9999/go-delphi"
+	"github.com/sean9999/pear"
+	"github.com/spf13/afero"
+)
+
+const (
+	// SubjectMessage is the subject of a message from one person to another
+	SubjectMessage = "MESSAGE"
+	// SubjectReceipt is the subject of a message saying that a MESSAGE was taken, by its recipient or by a mailbox holding it for them
+	SubjectReceipt = "RECEIPT"
+	// SubjectMailbox is the subject of a message asking a node to hold our mail, and send us what it's holding
+	SubjectMailbox = "MAILBOX"
+)
+
+// MaxDatagram is the most a UDP datagram can carry, and so the most a PEM-encoded message can be
+const MaxDatagram = 65507
+
+// These are what a receipt can say has become of a MESSAGE
+const (
+	// StatusQueued means our own goracled has it, and will keep trying to deliver it
+	StatusQueued = "queued"
+	// StatusHeld means the recipient's mailbox has it
+	StatusHeld = "held"
+	// StatusDelivered means the recipient has it
+	StatusDelivered = "delivered"
+)
+
+const (
+	// DefaultParcelTTL is how long a message waits to be delivered before it's given up on
+	DefaultParcelTTL = 7 * 24 * time.Hour
+	// DefaultQueueLimit is how many messages can wait for any one recipient
+	DefaultQueueLimit = 256
+)
+
+// RequestWindow is how far the time a request says it was made can be from ours when we get it
+const RequestWindow = 5 * time.Minute
+
+var ErrQueueFull = pear.Defer("too many messages waiting")
+var ErrNoRecipient = pear.Defer("message has no recipient")
+var ErrStaleRequest = pear.Defer("stale request")
+
+// MessageID identifies a signed message, so that receipts can say which one they're for
+func MessageID(msg *delphi.Message) string {
+	sum := sha256.Sum256(msg.Signature())
+	return hex.EncodeToString(sum[:16])
+}
+
+// a Parcel is a signed message on its way to someone.
+// It's kept until its recipient, or a mailbox holding it for them, sends a receipt, or until it expires.
+type Parcel struct {
+	ID      string    `json:"id"`
+	To      string    `json:"to"`
+	Queued  time.Time `json:"queued"`
+	Expires time.Time `json:"expires"`
+	// Attempts is how many times delivery has been tried
+	Attempts int `json:"attempts"`
+	// Via is the address of the mailbox it was last left at, if it was
+	Via string `json:"via,omitempty"`
+	// Message is PEM-encoded
+	Message string `json:"message"`
+}
+
+// NewParcel wraps a signed message for delivery within ttl
+func NewParcel(msg *delphi.Message, now time.Time, ttl time.Duration) (Parcel, error) {
+	if msg.Recipient.IsZero() {
+		return Parcel{}, ErrNoRecipient
+	}
+	p := Parcel{
+		ID:      MessageID(msg),
+		To:      msg.Recipient.ToHex(),
+		Queued:  now,
+		Expires: now.Add(ttl),
+		Message: msg.String(),
+	}
+	return p, nil
+}
+
+// Decode returns the message a Parcel carries
+func (p Parcel) Decode() (*delphi.Message, error) {
+	block, _ := pem.Decode([]byte(p.Message))
+	if block == nil {
+		return nil, ErrBadPem
+	}
+	msg := new(delphi.Message)
+	err := msg.FromPEM(*block)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBadPem, err)
+	}
+	return msg, nil
+}
+
+// a Queue holds parcels for each recipient until they're taken, or they expire
+type Queue interface {
+	// Put adds a Parcel, or replaces the one with the same ID
+	Put(Parcel) error
+	// For lists what's waiting for a recipient, oldest first
+	For(to string) ([]Parcel, error)
+	// Recipients lists who has something waiting for them
+	Recipients() ([]string, error)
+	// Remove takes parcels out of the queue
+	Remove(to string, ids ...string) error
+}
+
+// FileQueue stores a [Queue] as a JSON file
+type FileQueue struct {
+	Fs   afero.Fs
+	Name string
+	// Limit overrides [DefaultQueueLimit]
+	Limit int
+}
+
+// OutboxQueue is where goracled keeps messages until they're delivered
+func OutboxQueue(fs afero.Fs, config string) FileQueue {
+	return FileQueue{Fs: fs, Name: config + ".outbox"}
+}
+
+// MailboxQueue is where goracled keeps messages it holds for others, when it's their mailbox
+func MailboxQueue(fs afero.Fs, config string) FileQueue {
+	return FileQueue{Fs: fs, Name: config + ".mailbox"}
+}
+
+func (f FileQueue) limit() int {
+	if f.Limit == 0 {
+		return DefaultQueueLimit
+	}
+	return f.Limit
+}
+
+// read returns every parcel that hasn't expired, by recipient
+func (f FileQueue) read() (map[string][]Parcel, error) {
+	b, err := afero.ReadFile(f.Fs, f.Name)
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string][]Parcel{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	all := map[string][]Parcel{}
+	err = json.Unmarshal(b, &all)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for to, parcels := range all {
+		fresh := make([]Parcel, 0, len(parcels))
+		for _, p := range parcels {
+			if now.Before(p.Expires) {
+				fresh = append(fresh, p)
+			}
+		}
+		if len(fresh) == 0 {
+			delete(all, to)
+		} else {
+			all[to] = fresh
+		}
+	}
+	return all, nil
+}
+
+// update does a read-modify-write under lock
+func (f FileQueue) update(fn func(map[string][]Parcel) error) error {
+	unlock, err := lockFile(f.Fs, f.Name+".lock", 0)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	all, err := f.read()
+	if err != nil {
+		return err
+	}
+	err = fn(all)
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(all, "", "\t")
+	if err != nil {
+		return err
+	}
+	return writeAtomic(f.Fs, f.Name, b)
+}
+
+func (f FileQueue) Put(p Parcel) error {
+	return f.update(func(all map[string][]Parcel) error {
+		parcels := all[p.To]
+		for i, old := range parcels {
+			if old.ID == p.ID {
+				parcels[i] = p
+				return nil
+			}
+		}
+		if len(parcels) >= f.limit() {
+			return pear.Errorf("%w for %.16s", ErrQueueFull, p.To)
+		}
+		all[p.To] = append(parcels, p)
+		return nil
+	})
+}
+
+func (f FileQueue) For(to string) ([]Parcel, error) {
+	all, err := f.read()
+	if err != nil {
+		return nil, err
+	}
+	parcels := all[to]
+	sort.SliceStable(parcels, func(i, j int) bool {
+		return parcels[i].Queued.Before(parcels[j].Queued)
+	})
+	return parcels, nil
+}
+
+func (f FileQueue) Recipients() ([]string, error) {
+	all, err := f.read()
+	if err != nil {
+		return nil, err
+	}
+	recipients := make([]string, 0, len(all))
+	for to := range all {
+		recipients = append(recipients, to)
+	}
+	sort.Strings(recipients)
+	return recipients, nil
+}
+
+func (f FileQueue) Remove(to string, ids ...string) error {
+	return f.update(func(all map[string][]Parcel) error {
+		kept := []Parcel{}
+		for _, p := range all[to] {
+			removed := false
+			for _, id := range ids {
+				if p.ID == id {
+					removed = true
+				}
+			}
+			if !removed {
+				kept = append(kept, p)
+			}
+		}
+		if len(kept) == 0 {
+			delete(all, to)
+		} else {
+			all[to] = kept
+		}
+		return nil
+	})
+}
+
+// Receipt produces a signed receipt for a MESSAGE, telling its sender that we've taken it.
+// It says who the message was for, which is us, unless we're holding it for them.
+// status, if there is one, says what's become of it.
+func (g *Principal) Receipt(msg *delphi.Message, status string) (*delphi.Message, error) {
+	receipt := g.Compose([]byte("got it"), nil, NewPeer(msg.Sender.Bytes()))
+	receipt.Subject = SubjectReceipt
+	receipt.Headers.Set("receipt", MessageID(msg))
+	receipt.Headers.Set("to", msg.Recipient.ToHex())
+	if status != "" {
+		receipt.Headers.Set("status", status)
+	}
+	err := receipt.Sign(g.randomness, g)
+	if err != nil {
+		return nil, err
+	}
+	return receipt, nil
+}
+
+// stamp says when a request was made, in a header, so that it's signed along with everything else
+func stamp(msg *delphi.Message, now time.Time) {
+	msg.Headers.Set("time", now.UTC().Format(time.RFC3339Nano))
+}
+
+// CheckFresh checks that a request says it was made within [RequestWindow] of now, so that an old one can't be replayed.
+// It's only to be trusted once the request is verified. A request replayed while it's still fresh has the same [MessageID], by which it can be told apart.
+func CheckFresh(msg *delphi.Message, now time.Time) error {
+	made, _ := msg.Headers.Get("time")
+	at, err := time.Parse(time.RFC3339Nano, made)
+	if err != nil {
+		return pear.Errorf("%w: it doesn't say when it was made", ErrStaleRequest)
+	}
+	if at.Before(now.Add(-RequestWindow)) || at.After(now.Add(RequestWindow)) {
+		return pear.Errorf("%w: made at %s", ErrStaleRequest, at.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// AskMailbox produces a signed request for a mailbox to hold our mail, and send us what it's holding.
+// It says when it was made, so that it can't be replayed later.
+func (g *Principal) AskMailbox() (*delphi.Message, error) {
+	msg := delphi.NewMessage(g.randomness, []byte("hold my mail"))
+	msg.Sender = g.PublicKey()
+	msg.Subject = SubjectMailbox
+	stamp(msg, time.Now())
+	err := msg.Sign(g.randomness, g)
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// a Registry remembers who we're a mailbox for, until their registration runs out
+type Registry interface {
+	// Register makes us pub's mailbox until a time
+	Register(pub string, until time.Time) error
+	// Registered reports whether we're still pub's mailbox
+	Registered(pub string) (bool, error)
+}
+
+// FileRegistry stores a [Registry] as a JSON file, so that mail is still held for those registered after a restart
+type FileRegistry struct {
+	Fs   afero.Fs
+	Name string
+}
+
+// MailboxRegistry is where goracled remembers who it's a mailbox for
+func MailboxRegistry(fs afero.Fs, config string) FileRegistry {
+	return FileRegistry{Fs: fs, Name: config + ".registry"}
+}
+
+// read returns every registration that hasn't run out
+func (f FileRegistry) read() (map[string]time.Time, error) {
+	b, err := afero.ReadFile(f.Fs, f.Name)
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]time.Time{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	all := map[string]time.Time{}
+	err = json.Unmarshal(b, &all)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for pub, until := range all {
+		if !now.Before(until) {
+			delete(all, pub)
+		}
+	}
+	return all, nil
+}
+
+func (f FileRegistry) Register(pub string, until time.Time) error {
+	unlock, err := lockFile(f.Fs, f.Name+".lock", 0)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	all, err := f.read()
+	if err != nil {
+		return err
+	}
+	all[pub] = until
+	b, err := json.MarshalIndent(all, "", "\t")
+	if err != nil {
+		return err
+	}
+	return writeAtomic(f.Fs, f.Name, b)
+}
+
+func (f FileRegistry) Registered(pub string) (bool, error) {
+	all, err := f.read()
+	if err != nil {
+		return false, err
+	}
+	_, ok := all[pub]
+	return ok, nil
+}
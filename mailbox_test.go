@@ -0,0 +1,104 @@
+package gork
+
+import (
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueue(t *testing.T) {
+
+	check := assert.New(t)
+	alice := NewPrincipal(rand.Reader, nil, nil)
+	bob := NewPrincipal(rand.Reader, nil, nil)
+	q := FileQueue{Fs: afero.NewMemMapFs(), Name: "conf.json.outbox", Limit: 2}
+
+	parcel := func(body string, ttl time.Duration) Parcel {
+		msg := alice.Compose([]byte(body), nil, bob.AsPeer())
+		msg.Subject = SubjectMessage
+		check.NoError(msg.Sign(rand.Reader, &alice))
+		p, err := NewParcel(msg, time.Now(), ttl)
+		check.NoError(err)
+		return p
+	}
+
+	first, second := parcel("hi", time.Hour), parcel("there", time.Hour)
+	check.NoError(q.Put(first))
+	check.NoError(q.Put(second))
+	check.ErrorIs(q.Put(parcel("again", time.Hour)), ErrQueueFull)
+
+	//	replacing a parcel doesn't count against the limit
+	first.Attempts = 1
+	check.NoError(q.Put(first))
+	parcels, err := q.For(bob.AsPeer().ToHex())
+	check.NoError(err)
+	check.Len(parcels, 2)
+	check.Equal(first.ID, parcels[0].ID)
+	check.Equal(1, parcels[0].Attempts)
+	msg, err := parcels[0].Decode()
+	check.NoError(err)
+	check.Equal("hi", string(msg.PlainText))
+
+	recipients, err := q.Recipients()
+	check.NoError(err)
+	check.Equal([]string{bob.AsPeer().ToHex()}, recipients)
+
+	check.NoError(q.Remove(bob.AsPeer().ToHex(), first.ID, second.ID))
+	recipients, err = q.Recipients()
+	check.NoError(err)
+	check.Empty(recipients)
+
+	//	expired parcels are gone
+	check.NoError(q.Put(parcel("too late", -time.Second)))
+	parcels, err = q.For(bob.AsPeer().ToHex())
+	check.NoError(err)
+	check.Empty(parcels)
+
+	//	receipts say which message they're for, and who it was for
+	receipt, err := bob.Receipt(msg, "")
+	check.NoError(err)
+	check.True(receipt.Verify())
+	id, _ := receipt.Headers.Get("receipt")
+	check.Equal(first.ID, id)
+	to, _ := receipt.Headers.Get("to")
+	check.Equal(bob.AsPeer().ToHex(), to)
+
+	_, err = NewParcel(alice.Compose([]byte("to nobody"), nil, Peer{}), time.Now(), time.Hour)
+	check.ErrorIs(err, ErrNoRecipient)
+
+}
+
+func TestAskMailbox(t *testing.T) {
+
+	check := assert.New(t)
+	bob := NewPrincipal(rand.Reader, nil, nil)
+	ask, err := bob.AskMailbox()
+	check.NoError(err)
+	check.True(ask.Verify())
+	check.NoError(CheckFresh(ask, time.Now()))
+	check.ErrorIs(CheckFresh(ask, time.Now().Add(time.Hour)), ErrStaleRequest)
+
+	//	when it was made is signed
+	ask.Headers.Set("time", time.Now().Add(time.Hour).UTC().Format(time.RFC3339Nano))
+	check.False(ask.Verify())
+
+}
+
+func TestRegistry(t *testing.T) {
+
+	check := assert.New(t)
+	reg := MailboxRegistry(afero.NewMemMapFs(), "conf.json")
+	check.NoError(reg.Register("bob", time.Now().Add(time.Hour)))
+	check.NoError(reg.Register("carol", time.Now().Add(-time.Second)))
+
+	registered, err := reg.Registered("bob")
+	check.NoError(err)
+	check.True(registered)
+	registered, err = reg.Registered("carol")
+	check.NoError(err)
+	check.False(registered, "carol's registration has run out")
+
+}
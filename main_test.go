@@ -87,10 +87,13 @@ func TestNewGork(t *testing.T) {
 		assert.ErrorIs(t, err, ErrBadPem)
 	})
 
+	//	testdata, as far as we can tell, but the changes we make to it stay in memory
+	testdata := afero.NewCopyOnWriteFs(afero.NewReadOnlyFs(afero.NewOsFs()), afero.NewMemMapFs())
+
 	t.Run("adding props and peers, exporting data", func(t *testing.T) {
 		alice.Props.Set("name", "Alice")
 		prov := FileBasedConfigProvider{
-			Fs:   afero.NewOsFs(),
+			Fs:   testdata,
 			Name: "testdata/late-silence.config.json",
 		}
 		alice.WithConfigProvider(prov)
@@ -101,7 +104,7 @@ func TestNewGork(t *testing.T) {
 	})
 
 	t.Run("validate signature", func(t *testing.T) {
-		alice.WithConfigFile(afero.NewOsFs(), "testdata/late-silence.config.json")
+		alice.WithConfigFile(testdata, "testdata/late-silence.config.json")
 		conf := alice.Export()
 		err := alice.SignConfig(conf)
 		assert.NoError(t, err)
@@ -0,0 +1,228 @@
+package gork
+
+import (
+	"fmt"
+
+	"github.com/sean9999/pear"
+)
+
+var ErrMergeIdentity = pear.Defer("cannot merge configs belonging to different identities")
+
+// a Conflict is a property that was changed in different ways on both sides of a merge.
+// An empty Ours or Theirs means that side removed it.
+// A Conflict with no Key is about a peer that one side dropped and the other changed.
+type Conflict struct {
+	Peer     string `json:"peer,omitempty" yaml:"peer,omitempty"`
+	Key      string `json:"key" yaml:"key"`
+	Base     string `json:"base,omitempty" yaml:"base,omitempty"`
+	Ours     string `json:"ours,omitempty" yaml:"ours,omitempty"`
+	Theirs   string `json:"theirs,omitempty" yaml:"theirs,omitempty"`
+	Resolved string `json:"resolved,omitempty" yaml:"resolved,omitempty"`
+}
+
+func (c Conflict) String() string {
+	subject := c.Key
+	if c.Peer != "" {
+		subject = c.Peer
+		if len(subject) > 16 {
+			subject = subject[:16]
+		}
+		if c.Key != "" {
+			subject += " " + c.Key
+		}
+	}
+	return fmt.Sprintf("%s: ours=%q theirs=%q, kept %q", subject, c.Ours, c.Theirs, c.Resolved)
+}
+
+// MergeConfigs does a three-way merge of two configs that descend from a common ancestor.
+// A nil base means there is no common ancestor.
+//
+// Peers are unioned. A peer is dropped only if one side dropped it and the other left it untouched.
+// A property changed on one side only takes that side's value.
+// A property changed on both sides is a [Conflict], resolved so that the result doesn't depend on which side is "ours":
+// a value beats a removal, and otherwise the greater value wins.
+//
+// The result is not signed.
+func MergeConfigs(base, ours, theirs *Config) (*Config, []Conflict, error) {
+	if ours == nil || theirs == nil {
+		return nil, nil, ErrNilConfig
+	}
+	if !ours.Pub.Equal(theirs.Pub) {
+		return nil, nil, ErrMergeIdentity
+	}
+	if base == nil {
+		base = NewConfig()
+	}
+
+	merged := NewConfig()
+	merged.Pub = ours.Pub
+	merged.Version = max(ours.Version, theirs.Version)
+
+	conflicts := []Conflict{}
+	merged.Props = merge3(base.Props, ours.Props, theirs.Props, "", &conflicts)
+
+	byKey := func(c *Config) map[string]Peer {
+		m := map[string]Peer{}
+		if c.Peers != nil {
+			for _, p := range *c.Peers {
+				m[p.ToHex()] = p
+			}
+		}
+		return m
+	}
+	basePeers, ourPeers, theirPeers := byKey(base), byKey(ours), byKey(theirs)
+
+	//	ours first, then whatever is new in theirs
+	order := []Peer{}
+	if ours.Peers != nil {
+		order = append(order, *ours.Peers...)
+	}
+	if theirs.Peers != nil {
+		for _, p := range *theirs.Peers {
+			if _, seen := ourPeers[p.ToHex()]; !seen {
+				order = append(order, p)
+			}
+		}
+	}
+
+	peers := PeerList{}
+	for _, p := range order {
+		k := p.ToHex()
+		b, inBase := basePeers[k]
+		o, inOurs := ourPeers[k]
+		t, inTheirs := theirPeers[k]
+		if inBase && inOurs != inTheirs {
+			//	one side dropped this peer. Respect that, unless the other side has since changed it.
+			kept := o
+			if inTheirs {
+				kept = t
+			}
+			if sameKV(b.Properties, kept.Properties) {
+				continue
+			}
+			conflicts = append(conflicts, Conflict{Peer: k, Ours: presence(inOurs), Theirs: presence(inTheirs), Resolved: "present"})
+			peers = append(peers, Peer{p.Key, copyKV(kept.Properties)})
+			continue
+		}
+		peers = append(peers, Peer{p.Key, merge3(b.Properties, o.Properties, t.Properties, k, &conflicts)})
+	}
+	merged.Peers = &peers
+
+	return merged, conflicts, nil
+}
+
+// Merge merges another copy of our own config into ours, using base as the common ancestor if there is one.
+// Both configs must be signed by us. The result replaces our peers and props, and is signed the next time we Save.
+func (g *Principal) Merge(base, theirs *Config) ([]Conflict, error) {
+	for _, c := range []*Config{base, theirs} {
+		if c == nil {
+			continue
+		}
+		if !c.Pub.Equal(g.PublicKey()) {
+			return nil, ErrMergeIdentity
+		}
+		err := g.VerifyConfig(c)
+		if err != nil {
+			return nil, fmt.Errorf("could not verify config: %w", err)
+		}
+		_, err = Migrate(c)
+		if err != nil {
+			return nil, err
+		}
+	}
+	merged, conflicts, err := MergeConfigs(base, g.Export(), theirs)
+	if err != nil {
+		return nil, err
+	}
+	g.Props = merged.Props
+	g.Peers = *merged.Peers
+	return conflicts, nil
+}
+
+// merge3 does a three-way merge of two KVs, ours first
+func merge3(base, ours, theirs *KV, peer string, conflicts *[]Conflict) *KV {
+	if base == nil {
+		base = NewKV()
+	}
+	if ours == nil {
+		ours = NewKV()
+	}
+	if theirs == nil {
+		theirs = NewKV()
+	}
+
+	keys := []string{}
+	for pair := ours.Oldest(); pair != nil; pair = pair.Next() {
+		keys = append(keys, pair.Key)
+	}
+	for pair := theirs.Oldest(); pair != nil; pair = pair.Next() {
+		if _, seen := ours.Get(pair.Key); !seen {
+			keys = append(keys, pair.Key)
+		}
+	}
+	//	keys that were removed on both sides stay removed, because they appear in neither
+
+	merged := NewKV()
+	for _, k := range keys {
+		b, inBase := base.Get(k)
+		o, inOurs := ours.Get(k)
+		t, inTheirs := theirs.Get(k)
+
+		var v string
+		var keep bool
+		switch {
+		case inOurs == inTheirs && o == t:
+			v, keep = o, inOurs
+		case inOurs == inBase && o == b:
+			v, keep = t, inTheirs
+		case inTheirs == inBase && t == b:
+			v, keep = o, inOurs
+		default:
+			//	both sides changed it
+			v, keep = o, true
+			if !inOurs || (inTheirs && t > o) {
+				v = t
+			}
+			*conflicts = append(*conflicts, Conflict{Peer: peer, Key: k, Base: b, Ours: o, Theirs: t, Resolved: v})
+		}
+		if keep {
+			merged.Set(k, v)
+		}
+	}
+	return merged
+}
+
+func presence(b bool) string {
+	if b {
+		return "present"
+	}
+	return ""
+}
+
+func sameKV(a, b *KV) bool {
+	if a == nil {
+		a = NewKV()
+	}
+	if b == nil {
+		b = NewKV()
+	}
+	if a.Len() != b.Len() {
+		return false
+	}
+	for pair := a.Oldest(); pair != nil; pair = pair.Next() {
+		if v, ok := b.Get(pair.Key); !ok || v != pair.Value {
+			return false
+		}
+	}
+	return true
+}
+
+func copyKV(kv *KV) *KV {
+	c := NewKV()
+	if kv != nil {
+		for pair := kv.Oldest(); pair != nil; pair = pair.Next() {
+			c.Set(pair.Key, pair.Value)
+		}
+	}
+	return c
+}
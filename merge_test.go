@@ -0,0 +1,109 @@
+package gork
+
+import (
+	"crypto/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMergeConfigs(t *testing.T) {
+
+	alice := NewPrincipal(rand.Reader, nil, nil)
+	bob := NewPrincipal(rand.Reader, nil, nil)
+	carol := NewPrincipal(rand.Reader, nil, nil)
+	dave := NewPrincipal(rand.Reader, nil, nil)
+
+	peer := func(p Principal, kv ...string) Peer {
+		props := NewKV()
+		for i := 0; i+1 < len(kv); i += 2 {
+			props.Set(kv[i], kv[i+1])
+		}
+		return Peer{p.PublicKey(), props}
+	}
+	config := func(props map[string]string, peers ...Peer) *Config {
+		c := NewConfig()
+		c.Pub = alice.PublicKey()
+		incorporate(c.Props, props)
+		pl := PeerList(peers)
+		c.Peers = &pl
+		return c
+	}
+	find := func(c *Config, p Principal) (Peer, bool) {
+		for _, q := range *c.Peers {
+			if q.Key.Equal(p.PublicKey()) {
+				return q, true
+			}
+		}
+		return Peer{}, false
+	}
+
+	base := config(map[string]string{"hometown": "wonderland", "mood": "calm"},
+		peer(bob, "addr", "[::1]:1"),
+		peer(carol, "addr", "[::1]:2"),
+	)
+	//	ours moved, dropped carol, and changed mood
+	ours := config(map[string]string{"hometown": "looking-glass", "mood": "curious"},
+		peer(bob, "addr", "[::1]:1"),
+	)
+	//	theirs changed bob's address, added dave, and also changed mood
+	theirs := config(map[string]string{"hometown": "wonderland", "mood": "anxious"},
+		peer(bob, "addr", "[::1]:3"),
+		peer(carol, "addr", "[::1]:2"),
+		peer(dave),
+	)
+
+	merged, conflicts, err := MergeConfigs(base, ours, theirs)
+	assert.NoError(t, err)
+
+	hometown, _ := merged.Props.Get("hometown")
+	assert.Equal(t, "looking-glass", hometown)
+	mood, _ := merged.Props.Get("mood")
+	assert.Equal(t, "curious", mood)
+	assert.Len(t, conflicts, 1)
+	assert.Equal(t, "mood", conflicts[0].Key)
+
+	b, _ := find(merged, bob)
+	addr, _ := b.Properties.Get("addr")
+	assert.Equal(t, "[::1]:3", addr)
+	_, hasCarol := find(merged, carol)
+	assert.False(t, hasCarol)
+	_, hasDave := find(merged, dave)
+	assert.True(t, hasDave)
+
+	t.Run("the result doesn't depend on which side is ours", func(t *testing.T) {
+		flipped, flippedConflicts, err := MergeConfigs(base, theirs, ours)
+		assert.NoError(t, err)
+		assert.True(t, sameKV(merged.Props, flipped.Props))
+		assert.Len(t, *flipped.Peers, len(*merged.Peers))
+		for _, p := range *merged.Peers {
+			var q Peer
+			for _, candidate := range *flipped.Peers {
+				if candidate.Equal(p) {
+					q = candidate
+				}
+			}
+			assert.True(t, sameKV(p.Properties, q.Properties))
+		}
+		assert.Len(t, flippedConflicts, 1)
+	})
+
+	t.Run("a dropped peer that was changed elsewhere is kept", func(t *testing.T) {
+		theirs := config(nil, peer(carol, "addr", "[::1]:4"))
+		merged, conflicts, err := MergeConfigs(base, ours, theirs)
+		assert.NoError(t, err)
+		c, hasCarol := find(merged, carol)
+		assert.True(t, hasCarol)
+		addr, _ := c.Properties.Get("addr")
+		assert.Equal(t, "[::1]:4", addr)
+		assert.NotEmpty(t, conflicts)
+	})
+
+	t.Run("different identities don't merge", func(t *testing.T) {
+		other := config(nil)
+		other.Pub = bob.PublicKey()
+		_, _, err := MergeConfigs(nil, ours, other)
+		assert.ErrorIs(t, err, ErrMergeIdentity)
+	})
+
+}
@@ -0,0 +1,98 @@
+package gork
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/sean9999/pear"
+)
+
+// ConfigVersion is the version of the [Config] schema written by this code.
+// Configs without a version field are version 0.
+const ConfigVersion = 1
+
+var ErrConfigTooNew = pear.Defer("config was written by a newer version of gork")
+var ErrMigration = pear.Defer("could not migrate config")
+
+// a Migration upgrades a [Config] from version From to version From+1
+type Migration struct {
+	From        int
+	Description string
+	Up          func(*Config) error
+}
+
+var migrations = map[int]Migration{}
+
+// RegisterMigration adds a [Migration] to the registry. It panics on duplicates.
+func RegisterMigration(m Migration) {
+	if _, exists := migrations[m.From]; exists {
+		panic(fmt.Sprintf("duplicate migration from version %d", m.From))
+	}
+	migrations[m.From] = m
+}
+
+// Migrations returns registered migrations, oldest first
+func Migrations() []Migration {
+	ms := make([]Migration, 0, len(migrations))
+	for _, m := range migrations {
+		ms = append(ms, m)
+	}
+	sort.Slice(ms, func(i, j int) bool {
+		return ms[i].From < ms[j].From
+	})
+	return ms
+}
+
+// Migrate upgrades a [Config] to [ConfigVersion] in place, reporting whether anything changed.
+// A migrated config's signature is no longer valid, and it should be re-signed by its owner.
+func Migrate(c *Config) (changed bool, err error) {
+	if c == nil {
+		return false, ErrNilConfig
+	}
+	err = checkVersion(c)
+	if err != nil {
+		return false, err
+	}
+	for c.Version < ConfigVersion {
+		m, exists := migrations[c.Version]
+		if !exists {
+			return changed, fmt.Errorf("%w: no migration from version %d", ErrMigration, c.Version)
+		}
+		err = m.Up(c)
+		if err != nil {
+			return changed, fmt.Errorf("%w: from version %d (%s): %w", ErrMigration, c.Version, m.Description, err)
+		}
+		c.Version = m.From + 1
+		changed = true
+	}
+	return changed, nil
+}
+
+// checkVersion refuses configs written by newer code, which we can't make sense of, let alone verify
+func checkVersion(c *Config) error {
+	if c.Version > ConfigVersion {
+		return fmt.Errorf("%w: config is version %d, but this code understands up to %d", ErrConfigTooNew, c.Version, ConfigVersion)
+	}
+	return nil
+}
+
+func init() {
+	RegisterMigration(Migration{
+		From:        0,
+		Description: "drop redundant pubkey properties",
+		Up: func(c *Config) error {
+			//	early versions stored a "pubkey" alongside props that are already keyed by public key
+			if c.Props == nil {
+				c.Props = NewKV()
+			}
+			c.Props.Delete("pubkey")
+			if c.Peers == nil {
+				c.Peers = new(PeerList)
+			}
+			for _, peer := range *c.Peers {
+				peer.Properties.Delete("pubkey")
+			}
+			return nil
+		},
+	})
+}
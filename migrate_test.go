@@ -0,0 +1,112 @@
+package gork
+
+import (
+	"crypto/rand"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrate(t *testing.T) {
+
+	alice := NewPrincipal(rand.Reader, nil, nil)
+	bob := NewPrincipal(rand.Reader, nil, nil)
+
+	//	a legacy config, as signed by alice before there were versions
+	legacy := func(addr string) string {
+		props := NewKV()
+		props.Set("grip", alice.AsPeer().Grip())
+		props.Set("pubkey", alice.PublicKey().ToHex())
+		old := &Config{Pub: alice.PublicKey(), Props: props, Verity: &Verity{Nonce: make([]byte, 16)}}
+		digest, err := old.Digest()
+		assert.NoError(t, err)
+		sig, err := alice.Principal.Sign(nil, digest, nil)
+		assert.NoError(t, err)
+		return fmt.Sprintf(`{
+	"pub": %q,
+	"props": {"grip": %q, "pubkey": %q},
+	"peers": {
+		%q: {"pubkey": %q, "addr": %q}
+	},
+	"ver": "%x.%x"
+}`, alice.PublicKey().ToHex(), alice.AsPeer().Grip(), alice.PublicKey().ToHex(), bob.PublicKey().ToHex(), bob.PublicKey().ToHex(), addr, old.Verity.Nonce, sig)
+	}
+
+	t.Run("legacy config is upgraded and re-signed on load", func(t *testing.T) {
+		fs := afero.NewMemMapFs()
+		afero.WriteFile(fs, "conf.json", []byte(legacy("[::1]:5656")), 0600)
+		prov := FileBasedConfigProvider{Fs: fs, Name: "conf.json"}
+
+		me := alice
+		err := me.WithConfigProvider(prov)
+		assert.NoError(t, err)
+
+		conf, err := prov.Get()
+		assert.NoError(t, err)
+		assert.Equal(t, ConfigVersion, conf.Version)
+		assert.NoError(t, alice.VerifyConfig(conf))
+		_, hasPubkey := conf.Props.Get("pubkey")
+		assert.False(t, hasPubkey)
+		assert.Len(t, *conf.Peers, 1)
+		_, hasPubkey = (*conf.Peers)[0].Properties.Get("pubkey")
+		assert.False(t, hasPubkey)
+		addr, _ := (*conf.Peers)[0].Properties.Get("addr")
+		assert.Equal(t, "[::1]:5656", addr)
+	})
+
+	t.Run("tampered legacy config is refused, not re-signed", func(t *testing.T) {
+		tampered := strings.Replace(legacy("[::1]:5656"), alice.AsPeer().Grip(), "666", 1)
+		fs := afero.NewMemMapFs()
+		afero.WriteFile(fs, "conf.json", []byte(tampered), 0600)
+		prov := FileBasedConfigProvider{Fs: fs, Name: "conf.json"}
+
+		me := alice
+		err := me.WithConfigProvider(prov)
+		assert.ErrorIs(t, err, ErrBadSignature)
+		after, _ := afero.ReadFile(fs, "conf.json")
+		assert.Equal(t, tampered, string(after))
+
+		//	nor is someone else's
+		me = bob
+		afero.WriteFile(fs, "conf.json", []byte(legacy("[::1]:5656")), 0600)
+		assert.ErrorIs(t, me.WithConfigProvider(prov), ErrBadSignature)
+	})
+
+	t.Run("newer configs are refused", func(t *testing.T) {
+		conf := alice.Export()
+		conf.Version = ConfigVersion + 1
+		prov := NewMemoryConfigProvider()
+		assert.NoError(t, prov.Set(conf))
+		me := alice
+		err := me.WithConfigProvider(prov)
+		assert.ErrorIs(t, err, ErrConfigTooNew)
+	})
+
+	t.Run("version is covered by the signature", func(t *testing.T) {
+		conf := alice.Export()
+		assert.NoError(t, alice.VerifyConfig(conf))
+		conf.Version++
+		assert.Error(t, alice.VerifyConfig(conf))
+
+		//	unversioned configs still verify
+		legacy := &Config{}
+		assert.NoError(t, alice.SignConfig(legacy))
+		assert.Equal(t, 0, legacy.Version)
+		assert.NoError(t, alice.VerifyConfig(legacy))
+	})
+
+	t.Run("registry is ordered and complete", func(t *testing.T) {
+		ms := Migrations()
+		assert.Len(t, ms, ConfigVersion)
+		for i, m := range ms {
+			assert.Equal(t, i, m.From)
+		}
+		assert.Panics(t, func() {
+			RegisterMigration(Migration{From: 0})
+		})
+	})
+
+}
@@ -6,10 +6,10 @@ import (
 	"fmt"
 	"hash/adler32"
 
-	"github.com/eloonstra/go-little-drunken-bishop/pkg/drunkenbishop"
 	"github.com/goombaio/namegenerator"
 	"github.com/sean9999/go-delphi"
 	"github.com/vmihailenco/msgpack/v5"
+	omap "github.com/wk8/go-ordered-map/v2"
 )
 
 type Serde interface {
@@ -26,7 +26,7 @@ type IPeer interface {
 
 type Peer struct {
 	delphi.Key `msgpack:"pub" json:"pub" yaml:"pub"`
-	Properties *KV `msgpack:"props" json:"props" yaml:"yaml"`
+	Properties *KV `msgpack:"props" json:"props" yaml:"props"`
 }
 
 type IPeerList interface {
@@ -40,12 +40,16 @@ type IPeerList interface {
 type PeerList []Peer
 
 func (pl PeerList) MarshalJSON() ([]byte, error) {
-	m := map[string]*KV{}
-	for _, peer := range pl {
-		peer.Expand()
-		m[peer.ToHex()] = peer.Properties
+	return json.Marshal(pl.asOrderedMap())
+}
+
+func (pl *PeerList) UnmarshalJSON(b []byte) error {
+	m := omap.New[string, *KV]()
+	err := json.Unmarshal(b, m)
+	if err != nil {
+		return err
 	}
-	return json.Marshal(m)
+	return pl.fromOrderedMap(m)
 }
 
 // Expand sets inferred properties
@@ -138,7 +142,7 @@ func (p Peer) Grip() string {
 // Art returns ASCII art for a Peer
 func (p Peer) Art() string {
 	title := fmt.Sprintf("ORACLE PEER %s", p.Grip())
-	return drunkenbishop.GenerateRandomArt(34, 18, p.Bytes(), true, title)
+	return randomArt(artWidth, artHeight, p.Bytes(), title)
 }
 
 // MarshalPEM marshals a PEM to a Peer.
@@ -0,0 +1,147 @@
+package gork
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/sean9999/go-delphi"
+	"github.com/sean9999/pear"
+	"github.com/spf13/afero"
+)
+
+const (
+	// SubjectPing is the subject of a message asking a peer whether it's there
+	SubjectPing = "PING"
+	// SubjectPong is the subject of the answer to a PING
+	SubjectPong = "PONG"
+)
+
+var ErrBadPing = pear.Defer("bad ping")
+
+// ObservedProps are what we observe about a peer, rather than what it asserts. Assertions can't set them.
+var ObservedProps = []string{"last_seen", "rtt", "misses", "status"}
+
+// IsObservedProp reports whether k is one of [ObservedProps]
+func IsObservedProp(k string) bool {
+	for _, o := range ObservedProps {
+		if k == o {
+			return true
+		}
+	}
+	return false
+}
+
+// Observations are the [ObservedProps] of each of our peers, by public key.
+// They change with every PING, so they're kept beside the config rather than in it, where every change would be signed and logged.
+type Observations map[string]*KV
+
+// FileObservations stores [Observations] as a JSON file.
+// goracled writes it, and goracle reads it to show what's been observed of our peers.
+type FileObservations struct {
+	Fs   afero.Fs
+	Name string
+}
+
+// ObservationsFile is where goracled writes down what it observes of our peers
+func ObservationsFile(fs afero.Fs, config string) FileObservations {
+	return FileObservations{Fs: fs, Name: config + ".observed"}
+}
+
+func (f FileObservations) Get() (Observations, error) {
+	b, err := afero.ReadFile(f.Fs, f.Name)
+	if errors.Is(err, os.ErrNotExist) {
+		return Observations{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	o := Observations{}
+	err = json.Unmarshal(b, &o)
+	return o, err
+}
+
+func (f FileObservations) Set(o Observations) error {
+	b, err := json.MarshalIndent(o, "", "\t")
+	if err != nil {
+		return err
+	}
+	return writeAtomic(f.Fs, f.Name, b)
+}
+
+// Observe returns a copy of peer, with what's been observed of it in place of any observed props it came with
+func (o Observations) Observe(peer Peer) Peer {
+	props := NewKV()
+	if peer.Properties != nil {
+		for pair := peer.Properties.Oldest(); pair != nil; pair = pair.Next() {
+			if !IsObservedProp(pair.Key) {
+				props.Set(pair.Key, pair.Value)
+			}
+		}
+	}
+	if seen := o[peer.ToHex()]; seen != nil {
+		for pair := seen.Oldest(); pair != nil; pair = pair.Next() {
+			if IsObservedProp(pair.Key) {
+				props.Set(pair.Key, pair.Value)
+			}
+		}
+	}
+	return Peer{peer.Key, props}
+}
+
+// Ping produces a signed PING for peer, with an id that the PONG answering it will carry.
+// It says when it was made, so that one goracle sends its daemon can't be replayed.
+func (g *Principal) Ping(peer Peer, headers *KV) (*delphi.Message, error) {
+	id := make([]byte, 16)
+	_, err := io.ReadFull(g.randomness, id)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBadPing, err)
+	}
+	msg := g.Compose([]byte("are you there?"), headers, peer)
+	msg.Subject = SubjectPing
+	msg.Headers.Set("ping", hex.EncodeToString(id))
+	stamp(msg, time.Now())
+	err = msg.Sign(g.randomness, g)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBadPing, err)
+	}
+	return msg, nil
+}
+
+// Pong produces a signed answer to a PING
+func (g *Principal) Pong(ping *delphi.Message, headers *KV) (*delphi.Message, error) {
+	id, err := PingID(ping)
+	if err != nil {
+		return nil, err
+	}
+	msg := g.Compose([]byte("i am here"), headers, NewPeer(ping.Sender.Bytes()))
+	msg.Subject = SubjectPong
+	msg.Headers.Set("ping", id)
+	err = msg.Sign(g.randomness, g)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBadPing, err)
+	}
+	return msg, nil
+}
+
+// PingID verifies a PING or PONG, and returns the id that ties them together
+func PingID(msg *delphi.Message) (string, error) {
+	if msg == nil || (msg.Subject != SubjectPing && msg.Subject != SubjectPong) {
+		return "", pear.Errorf("%w: neither ping nor pong", ErrBadPing)
+	}
+	if !Verify(msg) {
+		return "", fmt.Errorf("%w: %w", ErrBadPing, ErrBadSignature)
+	}
+	id := ""
+	if msg.Headers != nil {
+		id, _ = msg.Headers.Get("ping")
+	}
+	if id == "" {
+		return "", pear.Errorf("%w: no id", ErrBadPing)
+	}
+	return id, nil
+}
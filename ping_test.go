@@ -0,0 +1,55 @@
+package gork
+
+import (
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPing(t *testing.T) {
+
+	check := assert.New(t)
+	alice := NewPrincipal(rand.Reader, nil, nil)
+	bob := NewPrincipal(rand.Reader, nil, nil)
+
+	headers := NewKV()
+	headers.Set("for", "someone")
+	ping, err := alice.Ping(bob.AsPeer(), headers)
+	check.NoError(err)
+	id, err := PingID(ping)
+	check.NoError(err)
+	check.Len(id, 32)
+	who, _ := ping.Headers.Get("for")
+	check.Equal("someone", who)
+	check.NoError(CheckFresh(ping, time.Now()))
+
+	pong, err := bob.Pong(ping, nil)
+	check.NoError(err)
+	check.Equal(SubjectPong, pong.Subject)
+	check.True(pong.Recipient.Equal(alice.PublicKey()))
+	pongID, err := PingID(pong)
+	check.NoError(err)
+	check.Equal(id, pongID)
+
+	//	ids are signed
+	pong.Headers.Set("ping", "0000")
+	_, err = PingID(pong)
+	check.ErrorIs(err, ErrBadSignature)
+
+	assertion, err := alice.Assert()
+	check.NoError(err)
+	_, err = PingID(assertion)
+	check.ErrorIs(err, ErrBadPing)
+
+	//	assertions can't say how a peer has been observed
+	carol := NewPrincipal(rand.Reader, map[string]string{"status": "online", "hometown": "wonderland"}, nil)
+	msg, err := carol.Assert()
+	check.NoError(err)
+	peer, err := ParseAssertion(msg)
+	check.NoError(err)
+	_, hasStatus := peer.Properties.Get("status")
+	check.False(hasStatus)
+
+}
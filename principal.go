@@ -1,12 +1,13 @@
 package gork
 
 import (
-	"encoding/json"
+	"bytes"
 	"encoding/pem"
 	"errors"
 	"fmt"
 	"io"
-	"os"
+	"io/fs"
+	"strings"
 
 	"github.com/sean9999/go-delphi"
 	"github.com/sean9999/pear"
@@ -21,13 +22,18 @@ func NewKV() *KV {
 	return omap.New[string, string]()
 }
 
-// a Principal is a public/private key-pair with some properties, and knowlege of [Peer]s
+// a Principal is a public/private key-pair with some properties, and knowlege of [Peer]s.
+// It is not safe for concurrent use.
 type Principal struct {
 	delphi.Principal `msgpack:"priv" json:"priv" yaml:"priv"`
 	Props            *KV            `msgpack:"props" json:"props" yaml:"props"`
 	Peers            PeerList       `msgpack:"peers" json:"peers" yaml:"peers"`
 	randomness       io.Reader      `msgpack:"-" json:"-" yaml:"-"`
 	ConfigProvider   ConfigProvider `msgpack:"-" json:"-" yaml:"-"`
+	// Journal, if set, receives a signed record of every change made by Save
+	Journal Journal `msgpack:"-" json:"-" yaml:"-"`
+	// base is the config as we last loaded or saved it, so that Save can tell what others have changed since
+	base *Config
 }
 
 // Export produces a *Config from a *Principal
@@ -65,6 +71,9 @@ func (g *Principal) SignConfig(conf *Config) error {
 
 func (g *Principal) VerifyConfig(c *Config) error {
 
+	if c == nil || c.Verity == nil {
+		return pear.Errorf("%w: config is not signed", ErrBadSignature)
+	}
 	pub := g.PublicKey()
 	dig, err := c.Digest()
 	if err != nil {
@@ -73,7 +82,7 @@ func (g *Principal) VerifyConfig(c *Config) error {
 	sig := c.Verity.Signature
 	ok := g.Principal.Verify(pub, dig, sig)
 	if !ok {
-		return errors.New("verification failed")
+		return pear.Errorf("%w: config verification failed", ErrBadSignature)
 	}
 	return nil
 }
@@ -129,7 +138,13 @@ func NewPrincipal(randy io.Reader, m map[string]string, prov ConfigProvider) Pri
 	prince := delphi.NewPrincipal(randy)
 	peers := make(PeerList, 0)
 	sm := NewKV()
-	king := Principal{*prince, sm, peers, randy, prov}
+	king := Principal{
+		Principal:      *prince,
+		Props:          sm,
+		Peers:          peers,
+		randomness:     randy,
+		ConfigProvider: prov,
+	}
 	err := king.ensureGrip()
 	if err != nil {
 		panic(err)
@@ -144,51 +159,44 @@ func (g *Principal) ensureGrip() error {
 	return nil
 }
 
-func (g *Principal) WithRand(randy io.Reader) {
-	g.randomness = randy
-}
-
-type FileBasedConfigProvider struct {
-	Fs   afero.Fs
-	Name string
-}
+var ErrReservedProp = pear.Defer("property is reserved")
 
-func (f FileBasedConfigProvider) openForReading() (afero.File, error) {
-	return f.Fs.Open(f.Name)
-}
+// ReservedProps are derived from a public key, and so can't be set or unset by hand
+var ReservedProps = []string{"nick", "grip"}
 
-func (f FileBasedConfigProvider) openForWriting() (afero.File, error) {
-	return f.Fs.OpenFile(f.Name, os.O_RDWR|os.O_TRUNC, 0640)
+// IsReservedProp reports whether k is one of [ReservedProps]
+func IsReservedProp(k string) bool {
+	for _, r := range ReservedProps {
+		if k == r {
+			return true
+		}
+	}
+	return false
 }
 
-func (f FileBasedConfigProvider) Get() (*Config, error) {
-	fd, err := f.openForReading()
-	if err != nil {
-		return nil, err
-	}
-	defer fd.Close()
-	fileBytes, err := io.ReadAll(fd)
-	if err != nil {
-		return nil, err
+// SetProp sets one of our own properties. Reserved properties can't be set.
+func (g *Principal) SetProp(k, v string) error {
+	if IsReservedProp(k) {
+		return pear.Errorf("%w: %q", ErrReservedProp, k)
 	}
-	conf := new(Config)
-	err = json.Unmarshal(fileBytes, conf)
-	if err != nil {
-		return nil, err
+	if k == "" {
+		return pear.New("empty property name")
 	}
-	return conf, nil
+	g.Props.Set(k, v)
+	return nil
 }
 
-func (f FileBasedConfigProvider) Set(c *Config) error {
-	if c == nil {
-		return errors.New("nil config")
+// UnsetProp removes one of our own properties. Reserved properties can't be unset.
+func (g *Principal) UnsetProp(k string) error {
+	if IsReservedProp(k) {
+		return pear.Errorf("%w: %q", ErrReservedProp, k)
 	}
-	fd, err := f.openForWriting()
-	if err != nil {
-		return err
-	}
-	_, err = io.Copy(fd, c)
-	return err
+	g.Props.Delete(k)
+	return nil
+}
+
+func (g *Principal) WithRand(randy io.Reader) {
+	g.randomness = randy
 }
 
 func (g *Principal) WithConfigFile(filesytem afero.Fs, fileName string) error {
@@ -199,7 +207,7 @@ func (g *Principal) WithConfigFile(filesytem afero.Fs, fileName string) error {
 	g.ConfigProvider = prov
 	conf, err := prov.Get()
 	if err != nil {
-		return pear.Errorf("could not get config file. %w", err)
+		return fmt.Errorf("could not get config file. %w", err)
 	}
 	return g.LoadConfig(conf)
 }
@@ -208,21 +216,59 @@ func (g *Principal) WithConfigProvider(prov ConfigProvider) error {
 	g.ConfigProvider = prov
 	conf, err := prov.Get()
 	if err != nil {
-		return pear.Errorf("could not get config file. %w", err)
+		return fmt.Errorf("could not get config file. %w", err)
 	}
 	return g.LoadConfig(conf)
 }
 
-// load a config file and attach data to a [Principal]
+// load a config file and attach data to a [Principal].
+// The config must be ours, and signed by us.
+// Configs written by older versions are migrated, re-signed, and saved back to the Principal's [ConfigProvider].
 func (g *Principal) LoadConfig(c *Config) error {
-	//	TODO: we could verify that pubkeys match
+	if c == nil {
+		return ErrNilConfig
+	}
+	if !c.Pub.Equal(g.PublicKey()) {
+		return pear.Errorf("%w: config belongs to someone else", ErrBadSignature)
+	}
+	err := checkVersion(c)
+	if err != nil {
+		return err
+	}
+	//	verify before migrating, since migrating re-signs
+	err = g.VerifyConfig(c)
+	if err != nil {
+		return err
+	}
+
+	migrated, err := Migrate(c)
+	if err != nil {
+		return err
+	}
 
-	g.Peers = *c.Peers
-	g.Props = c.Props
+	if c.Peers != nil {
+		g.Peers = *c.Peers
+	}
+	if c.Props != nil {
+		g.Props = c.Props
+	}
+	g.base, err = c.clone()
+	if err != nil {
+		return err
+	}
+
+	if migrated && g.ConfigProvider != nil {
+		err = g.Save(g.ConfigProvider)
+		if err != nil {
+			return fmt.Errorf("could not save migrated config: %w", err)
+		}
+	}
 	return nil
 }
 
-// Save writes the Principal's Peers and custom properties to a config file
+// Save writes the Principal's Peers and custom properties to a config file.
+// Whatever's been saved by someone else since we last loaded or saved, such as by goracled while goracle was running, is merged in first, rather than overwritten.
+// If prov is a [ConfigUpdater], nobody else can save in between.
 func (g *Principal) Save(prov ConfigProvider) error {
 	if prov == nil {
 		return pear.New("nil config provider")
@@ -230,8 +276,94 @@ func (g *Principal) Save(prov ConfigProvider) error {
 	if g == nil {
 		return pear.New("nil principal")
 	}
-	conf := g.Export()
-	return prov.Set(conf)
+	var changes []Change
+	if g.Journal != nil {
+		unlock, err := g.Journal.Lock()
+		if err != nil {
+			return fmt.Errorf("could not record changes: %w", err)
+		}
+		defer unlock()
+	}
+	var saved *Config
+	prepare := func(current *Config) (*Config, error) {
+		err := g.catchUp(current)
+		if err != nil {
+			return nil, err
+		}
+		conf := NewConfig()
+		conf.Hydrate(g)
+		if g.Journal != nil {
+			//	work out what changed since the last save, and anchor the log in the signed config
+			var head string
+			changes, head, err = g.chain(g.Journal, DiffConfigs(current, conf))
+			if err != nil {
+				return nil, fmt.Errorf("could not record changes: %w", err)
+			}
+			conf.Log = head
+		}
+		err = g.SignConfig(conf)
+		if err != nil {
+			return nil, err
+		}
+		saved = conf
+		return conf, nil
+	}
+	var err error
+	if u, ok := prov.(ConfigUpdater); ok {
+		err = u.Update(prepare)
+	} else {
+		current, _ := prov.Get()
+		var conf *Config
+		conf, err = prepare(current)
+		if err == nil {
+			err = prov.Set(conf)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	g.base, err = saved.clone()
+	if err != nil {
+		return err
+	}
+	//	the changes only happened if the config was saved
+	if len(changes) > 0 {
+		err = g.Journal.Append(changes...)
+		if err != nil {
+			return fmt.Errorf("could not record changes: %w", err)
+		}
+	}
+	return nil
+}
+
+// catchUp merges in current, if it's changed since we last loaded or saved
+func (g *Principal) catchUp(current *Config) error {
+	if g.base == nil || current == nil || current.Pub.IsZero() {
+		//	there's nothing to catch up with
+		return nil
+	}
+	if current.Verity != nil && g.base.Verity != nil && bytes.Equal(current.Verity.Signature, g.base.Verity.Signature) {
+		//	nobody else has saved
+		return nil
+	}
+	_, err := g.Merge(g.base, current)
+	if err != nil {
+		return fmt.Errorf("could not merge in changes to config: %w", err)
+	}
+	g.base, err = current.clone()
+	return err
+}
+
+// Reload merges in whatever's been saved to prov by someone else since we last loaded or saved, without saving
+func (g *Principal) Reload(prov ConfigProvider) error {
+	current, err := prov.Get()
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not reload config: %w", err)
+	}
+	return g.catchUp(current)
 }
 
 // HasPeer returns true if the Principal has knowlege of that Peer
@@ -250,12 +382,45 @@ var ErrPeerExists = pear.Defer("peer already exists")
 func (g *Principal) DropPeer(p Peer) {
 	for i, thisPeer := range g.Peers {
 		if thisPeer.Equal(p) {
-			g.Peers = append(g.Peers[i+1:], g.Peers[:i]...)
+			g.Peers = append(g.Peers[:i:i], g.Peers[i+1:]...)
 			return
 		}
 	}
 }
 
+var ErrPeerNotFound = pear.Defer("no such peer")
+var ErrAmbiguousPeer = pear.Defer("more than one peer matches")
+
+// FindPeer looks up one of our peers by nickname, public key, or a prefix of its grip
+func (g *Principal) FindPeer(ref string) (Peer, error) {
+	return g.Peers.Find(ref)
+}
+
+// Find looks up a Peer by nickname, public key, or a prefix of its grip
+func (pl PeerList) Find(ref string) (Peer, error) {
+	ref = strings.ToLower(strings.TrimSpace(ref))
+	if ref == "" {
+		return Peer{}, ErrPeerNotFound
+	}
+	matches := []Peer{}
+	for _, peer := range pl {
+		if ref == peer.ToHex() || ref == peer.Nickname() {
+			return peer, nil
+		}
+		if strings.HasPrefix(peer.Grip(), ref) {
+			matches = append(matches, peer)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return Peer{}, pear.Errorf("%w: %q", ErrPeerNotFound, ref)
+	case 1:
+		return matches[0], nil
+	default:
+		return Peer{}, pear.Errorf("%w: %q matches %d peers", ErrAmbiguousPeer, ref, len(matches))
+	}
+}
+
 // AddPeer adds a Peer to a Principal's address book.
 func (g *Principal) AddPeer(p Peer) error {
 	if g.HasPeer(p) {
@@ -305,6 +470,9 @@ func (g *Principal) UnmarshalPEM(b []byte) error {
 	if block == nil {
 		return fmt.Errorf("could not decode pem. %w", ErrBadPem)
 	}
+	if block.Type == encryptedKeyPemType {
+		return ErrNeedPassphrase
+	}
 	privkey := block.Bytes
 	// pub64, exists := block.Headers["pubkey"]
 	// if !exists {
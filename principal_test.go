@@ -1,6 +1,7 @@
 package gork
 
 import (
+	"crypto/rand"
 	"encoding/hex"
 	"strings"
 	"testing"
@@ -40,3 +41,48 @@ func TestNewPrincipal(t *testing.T) {
 	// 	t.Error(p.PrivateKey().ToHex())
 	// }
 }
+
+func TestFindPeer(t *testing.T) {
+
+	alice := NewPrincipal(rand.Reader, nil, nil)
+	bob := NewPrincipal(rand.Reader, nil, nil)
+	carol := NewPrincipal(rand.Reader, nil, nil)
+	alice.AddPeer(bob.AsPeer())
+	alice.AddPeer(carol.AsPeer())
+
+	for _, ref := range []string{bob.Nickname(), bob.PublicKey().ToHex(), bob.AsPeer().Grip(), bob.AsPeer().Grip()[:6]} {
+		p, err := alice.FindPeer(ref)
+		assert.NoError(t, err, ref)
+		assert.True(t, p.Equal(bob.AsPeer()), ref)
+	}
+
+	_, err := alice.FindPeer("nobody")
+	assert.ErrorIs(t, err, ErrPeerNotFound)
+	_, err = alice.FindPeer("")
+	assert.ErrorIs(t, err, ErrPeerNotFound)
+
+	t.Run("dropping keeps the order of the rest", func(t *testing.T) {
+		dave := NewPrincipal(rand.Reader, nil, nil)
+		alice.AddPeer(dave.AsPeer())
+		alice.DropPeer(bob.AsPeer())
+		assert.Len(t, alice.Peers, 2)
+		assert.True(t, alice.Peers[0].Equal(carol.AsPeer()))
+		assert.True(t, alice.Peers[1].Equal(dave.AsPeer()))
+	})
+
+}
+
+func TestSetProp(t *testing.T) {
+	alice := NewPrincipal(rand.Reader, nil, nil)
+	assert.NoError(t, alice.SetProp("hometown", "wonderland"))
+	v, _ := alice.Props.Get("hometown")
+	assert.Equal(t, "wonderland", v)
+	assert.NoError(t, alice.UnsetProp("hometown"))
+	_, exists := alice.Props.Get("hometown")
+	assert.False(t, exists)
+
+	assert.ErrorIs(t, alice.SetProp("grip", "deadbeef"), ErrReservedProp)
+	assert.ErrorIs(t, alice.UnsetProp("grip"), ErrReservedProp)
+	grip, _ := alice.Props.Get("grip")
+	assert.Equal(t, alice.AsPeer().Grip(), grip)
+}
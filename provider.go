@@ -0,0 +1,302 @@
+package gork
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/sean9999/pear"
+	"github.com/spf13/afero"
+)
+
+var ErrLocked = pear.Defer("config file is locked")
+
+const (
+	// DefaultLockTimeout is how long Set waits for another process to release the lock
+	DefaultLockTimeout = 5 * time.Second
+	// a lock file older than this is assumed to have been abandoned by a crashed process
+	staleLockAge = 30 * time.Second
+	lockPoll     = 10 * time.Millisecond
+	filePerm     = 0600
+	dirPerm      = 0700
+)
+
+// FileBasedConfigProvider stores a [Config] as a file, in JSON, YAML, or msgpack.
+// Writes are atomic (temp file, fsync, rename, fsync of the directory) and guarded by an advisory lock file,
+// which Update holds from read to write, so that goracle and goracled can share a config without clobbering each other.
+type FileBasedConfigProvider struct {
+	Fs   afero.Fs
+	Name string
+	// Backups is the number of previous versions to keep, as Name.1, Name.2 ... Name.N
+	Backups int
+	// LockTimeout overrides [DefaultLockTimeout]
+	LockTimeout time.Duration
+	// Format overrides the format inferred from Name's extension
+	Format Format
+}
+
+func (f FileBasedConfigProvider) format() Format {
+	if f.Format != "" {
+		return f.Format
+	}
+	return FormatFromName(f.Name)
+}
+
+func (f FileBasedConfigProvider) openForReading() (afero.File, error) {
+	return f.Fs.Open(f.Name)
+}
+
+func (f FileBasedConfigProvider) lockName() string {
+	return f.Name + ".lock"
+}
+
+func (f FileBasedConfigProvider) backupName(i int) string {
+	return f.Name + "." + strconv.Itoa(i)
+}
+
+// lock acquires an advisory lock on the config file
+func (f FileBasedConfigProvider) lock() (unlock func(), err error) {
+	return lockFile(f.Fs, f.lockName(), f.LockTimeout)
+}
+
+// lockFile acquires an advisory lock, which works across processes and on any [afero.Fs].
+// Real files are locked with flock, which can't outlive its process.
+// Anything else is locked by exclusively creating the lock file, and breaking it if it's gone stale.
+func lockFile(fs afero.Fs, name string, timeout time.Duration) (unlock func(), err error) {
+	if timeout == 0 {
+		timeout = DefaultLockTimeout
+	}
+	deadline := time.Now().Add(timeout)
+	if _, onDisk := fs.(*afero.OsFs); onDisk && canFlock {
+		fd, err := fs.OpenFile(name, os.O_CREATE|os.O_RDWR, filePerm)
+		if err != nil {
+			return nil, err
+		}
+		return flockFile(fd.(*os.File), deadline)
+	}
+	for {
+		fd, err := fs.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, filePerm)
+		if err == nil {
+			fmt.Fprintf(fd, "%d\n", os.Getpid())
+			fd.Close()
+			return func() { fs.Remove(name) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+		if breakStale(fs, name) {
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("%w: %s", ErrLocked, name)
+		}
+		time.Sleep(lockPoll)
+	}
+}
+
+// flockFile waits until deadline to flock f.
+// The lock file is left in place when unlocked, since removing it would let a waiter lock a file nobody else can see.
+func flockFile(f *os.File, deadline time.Time) (unlock func(), err error) {
+	for {
+		locked, err := flock(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if locked {
+			f.Truncate(0)
+			fmt.Fprintf(f, "%d\n", os.Getpid())
+			return func() { f.Close() }, nil
+		}
+		if time.Now().After(deadline) {
+			f.Close()
+			return nil, fmt.Errorf("%w: %s", ErrLocked, f.Name())
+		}
+		time.Sleep(lockPoll)
+	}
+}
+
+// breakStale removes a lock file abandoned by a crashed process, reporting whether it did.
+// Those breaking locks take turns, so that none of them can mistake a lock just taken by someone else for the stale one.
+func breakStale(fs afero.Fs, name string) bool {
+	info, err := fs.Stat(name)
+	if err != nil || time.Since(info.ModTime()) <= staleLockAge {
+		return false
+	}
+	brk, err := fs.OpenFile(name+".break", os.O_CREATE|os.O_EXCL|os.O_WRONLY, filePerm)
+	if err != nil {
+		return false
+	}
+	brk.Close()
+	defer fs.Remove(name + ".break")
+	//	now that it's our turn, make sure it's still the stale one
+	info, err = fs.Stat(name)
+	if err != nil || time.Since(info.ModTime()) <= staleLockAge {
+		return false
+	}
+	return fs.Remove(name) == nil
+}
+
+// writeAtomic replaces a file by writing to a temp file, syncing it, renaming it into place, and syncing the directory,
+// so that the rename survives a crash
+func writeAtomic(fs afero.Fs, name string, buf []byte) error {
+	dir := filepath.Dir(name)
+	tmp, err := afero.TempFile(fs, dir, "."+filepath.Base(name)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	//	after a successful rename, this is a no-op
+	defer fs.Remove(tmp.Name())
+
+	_, err = tmp.Write(buf)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	err = fs.Chmod(tmp.Name(), filePerm)
+	if err != nil {
+		return err
+	}
+	err = fs.Rename(tmp.Name(), name)
+	if err != nil {
+		return err
+	}
+	return syncDir(fs, dir)
+}
+
+// rotate shifts Name.1 .. Name.N-1 up by one and copies the current file to Name.1
+func (f FileBasedConfigProvider) rotate() error {
+	if f.Backups <= 0 {
+		return nil
+	}
+	current, err := afero.ReadFile(f.Fs, f.Name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	f.Fs.Remove(f.backupName(f.Backups))
+	for i := f.Backups - 1; i > 0; i-- {
+		err := f.Fs.Rename(f.backupName(i), f.backupName(i+1))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return afero.WriteFile(f.Fs, f.backupName(1), current, filePerm)
+}
+
+// read returns the raw contents of the config file
+func (f FileBasedConfigProvider) read() ([]byte, error) {
+	fd, err := f.openForReading()
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+	return io.ReadAll(fd)
+}
+
+// locked runs fn holding the lock, creating the config file's directory first if necessary
+func (f FileBasedConfigProvider) locked(fn func() error) error {
+	err := f.Fs.MkdirAll(filepath.Dir(f.Name), dirPerm)
+	if err != nil {
+		return err
+	}
+
+	unlock, err := f.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return fn()
+}
+
+// replace rotates backups and atomically replaces the config file. It must be called under lock.
+func (f FileBasedConfigProvider) replace(buf []byte) error {
+	err := f.rotate()
+	if err != nil {
+		return fmt.Errorf("could not rotate backups: %w", err)
+	}
+	return writeAtomic(f.Fs, f.Name, buf)
+}
+
+// write atomically replaces the config file, creating it (and its directory) if necessary
+func (f FileBasedConfigProvider) write(buf []byte) error {
+	return f.locked(func() error {
+		return f.replace(buf)
+	})
+}
+
+// update does a read-modify-write under lock, reading with get, and writing fn's result as encode encodes it.
+// fn is passed nil if there's no config yet.
+func (f FileBasedConfigProvider) update(get func() (*Config, error), encode func(*Config) ([]byte, error), fn func(*Config) (*Config, error)) error {
+	return f.locked(func() error {
+		current, err := get()
+		if errors.Is(err, os.ErrNotExist) {
+			current, err = nil, nil
+		}
+		if err != nil {
+			return err
+		}
+		c, err := fn(current)
+		if err != nil {
+			return err
+		}
+		if c == nil {
+			return ErrNilConfig
+		}
+		buf, err := encode(c)
+		if err != nil {
+			return err
+		}
+		return f.replace(buf)
+	})
+}
+
+func (f FileBasedConfigProvider) Get() (*Config, error) {
+	fileBytes, err := f.read()
+	if err != nil {
+		return nil, err
+	}
+	conf := new(Config)
+	err = f.format().Unmarshal(fileBytes, conf)
+	if err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+// Set atomically replaces the config file, creating it (and its directory) if necessary
+func (f FileBasedConfigProvider) Set(c *Config) error {
+	if c == nil {
+		return ErrNilConfig
+	}
+	buf, err := f.format().Marshal(c)
+	if err != nil {
+		return err
+	}
+	return f.write(buf)
+}
+
+// Update replaces the config file with what fn makes of it, holding the lock from read to write
+func (f FileBasedConfigProvider) Update(fn func(current *Config) (*Config, error)) error {
+	return f.update(f.current, f.format().Marshal, fn)
+}
+
+// current is the config as it is.
+// One that's encrypted can't be read without its owner, so it's treated as if there were none, and replaced.
+func (f FileBasedConfigProvider) current() (*Config, error) {
+	if f.IsEncrypted() {
+		return nil, nil
+	}
+	return f.Get()
+}
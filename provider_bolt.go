@@ -0,0 +1,123 @@
+package gork
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+
+	"github.com/sean9999/go-delphi"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	boltSelfBucket  = []byte("self")
+	boltPeersBucket = []byte("peers")
+	boltSelfKey     = []byte("config")
+)
+
+// BoltConfigProvider stores a [Config] in an embedded bbolt database, with one record per peer.
+// It's suited to large address books.
+// bbolt holds an exclusive lock on the database file for as long as it's open,
+// so call Close when done.
+type BoltConfigProvider struct {
+	db *bolt.DB
+}
+
+// NewBoltConfigProvider opens (or creates) a database at path
+func NewBoltConfigProvider(path string) (*BoltConfigProvider, error) {
+	db, err := bolt.Open(path, filePerm, &bolt.Options{Timeout: DefaultLockTimeout})
+	if err != nil {
+		return nil, err
+	}
+	return &BoltConfigProvider{db}, nil
+}
+
+func (b *BoltConfigProvider) Close() error {
+	return b.db.Close()
+}
+
+func (b *BoltConfigProvider) Get() (*Config, error) {
+	conf := new(Config)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		selfBucket := tx.Bucket(boltSelfBucket)
+		if selfBucket == nil {
+			return os.ErrNotExist
+		}
+		selfBytes := selfBucket.Get(boltSelfKey)
+		if selfBytes == nil {
+			return os.ErrNotExist
+		}
+		err := json.Unmarshal(selfBytes, conf)
+		if err != nil {
+			return err
+		}
+		peers := make(PeerList, 0)
+		peersBucket := tx.Bucket(boltPeersBucket)
+		if peersBucket != nil {
+			err = peersBucket.ForEach(func(k, v []byte) error {
+				props := NewKV()
+				err := json.Unmarshal(v, props)
+				if err != nil {
+					return err
+				}
+				peers = append(peers, Peer{delphi.KeyFromBytes(k), props})
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		conf.Peers = &peers
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+func (b *BoltConfigProvider) Set(c *Config) error {
+	if c == nil {
+		return ErrNilConfig
+	}
+	self := c.withoutPeers()
+	selfBytes, err := json.Marshal(self)
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		selfBucket, err := tx.CreateBucketIfNotExists(boltSelfBucket)
+		if err != nil {
+			return err
+		}
+		err = selfBucket.Put(boltSelfKey, selfBytes)
+		if err != nil {
+			return err
+		}
+
+		//	peers are rewritten wholesale, inside the same transaction
+		err = tx.DeleteBucket(boltPeersBucket)
+		if err != nil && !errors.Is(err, bolt.ErrBucketNotFound) {
+			return err
+		}
+		peersBucket, err := tx.CreateBucket(boltPeersBucket)
+		if err != nil {
+			return err
+		}
+		if c.Peers == nil {
+			return nil
+		}
+		for _, peer := range *c.Peers {
+			peer.Expand()
+			v, err := json.Marshal(peer.Properties)
+			if err != nil {
+				return err
+			}
+			err = peersBucket.Put(peer.Key.Bytes(), v)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
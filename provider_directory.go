@@ -0,0 +1,178 @@
+package gork
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sean9999/go-delphi"
+	"github.com/sean9999/pear"
+	"github.com/spf13/afero"
+)
+
+// DirectoryConfigProvider stores a [Config] as a directory with one file per peer:
+//
+//	<Dir>/self.json              our public key, props, and signature
+//	<Dir>/peers/<pubkey>.json    the props of one peer
+//
+// Adding or changing a peer touches only that peer's file,
+// which makes the layout friendly to git and dotfile managers.
+type DirectoryConfigProvider struct {
+	Fs  afero.Fs
+	Dir string
+	// LockTimeout overrides [DefaultLockTimeout]
+	LockTimeout time.Duration
+}
+
+func (d DirectoryConfigProvider) selfName() string {
+	return filepath.Join(d.Dir, "self.json")
+}
+
+func (d DirectoryConfigProvider) peersDir() string {
+	return filepath.Join(d.Dir, "peers")
+}
+
+func (d DirectoryConfigProvider) peerName(k delphi.Key) string {
+	return filepath.Join(d.peersDir(), k.ToHex()+".json")
+}
+
+func (d DirectoryConfigProvider) Get() (*Config, error) {
+	selfBytes, err := afero.ReadFile(d.Fs, d.selfName())
+	if err != nil {
+		return nil, err
+	}
+	conf := new(Config)
+	err = json.Unmarshal(selfBytes, conf)
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s: %w", d.selfName(), err)
+	}
+
+	peers := make(PeerList, 0)
+	entries, err := afero.ReadDir(d.Fs, d.peersDir())
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	for _, entry := range entries {
+		hex, isJson := strings.CutSuffix(entry.Name(), ".json")
+		if entry.IsDir() || !isJson {
+			continue
+		}
+		k := delphi.KeyFromHex(hex)
+		if k.IsZero() {
+			return nil, pear.Errorf("%w: %s", ErrBadHex, entry.Name())
+		}
+		b, err := afero.ReadFile(d.Fs, filepath.Join(d.peersDir(), entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		props := NewKV()
+		err = json.Unmarshal(b, props)
+		if err != nil {
+			return nil, fmt.Errorf("could not read %s: %w", entry.Name(), err)
+		}
+		peers = append(peers, Peer{k, props})
+	}
+	conf.Peers = &peers
+	return conf, nil
+}
+
+func (d DirectoryConfigProvider) Set(c *Config) error {
+	if c == nil {
+		return ErrNilConfig
+	}
+
+	return d.locked(func() error {
+		return d.set(c)
+	})
+}
+
+// Update replaces the config with what fn makes of it, holding the lock from read to write.
+// fn is passed nil if there's no config yet.
+func (d DirectoryConfigProvider) Update(fn func(current *Config) (*Config, error)) error {
+	return d.locked(func() error {
+		current, err := d.Get()
+		if errors.Is(err, os.ErrNotExist) {
+			current, err = nil, nil
+		}
+		if err != nil {
+			return err
+		}
+		c, err := fn(current)
+		if err != nil {
+			return err
+		}
+		if c == nil {
+			return ErrNilConfig
+		}
+		return d.set(c)
+	})
+}
+
+// locked runs fn holding the lock, creating the directory first if necessary
+func (d DirectoryConfigProvider) locked(fn func() error) error {
+	err := d.Fs.MkdirAll(d.peersDir(), dirPerm)
+	if err != nil {
+		return err
+	}
+
+	unlock, err := lockFile(d.Fs, filepath.Join(d.Dir, ".lock"), d.LockTimeout)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return fn()
+}
+
+// set writes out c. It must be called under lock.
+func (d DirectoryConfigProvider) set(c *Config) error {
+	self := c.withoutPeers()
+	selfBytes, err := json.MarshalIndent(self, "", "\t")
+	if err != nil {
+		return err
+	}
+	err = writeAtomic(d.Fs, d.selfName(), append(selfBytes, '\n'))
+	if err != nil {
+		return err
+	}
+
+	keep := map[string]bool{}
+	if c.Peers != nil {
+		for _, peer := range *c.Peers {
+			name := d.peerName(peer.Key)
+			keep[filepath.Base(name)] = true
+			peer.Expand()
+			b, err := json.MarshalIndent(peer.Properties, "", "\t")
+			if err != nil {
+				return err
+			}
+			b = append(b, '\n')
+			//	leave unchanged files alone, so as not to generate noise
+			if old, err := afero.ReadFile(d.Fs, name); err == nil && string(old) == string(b) {
+				continue
+			}
+			err = writeAtomic(d.Fs, name, b)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	//	forget dropped peers
+	entries, err := afero.ReadDir(d.Fs, d.peersDir())
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), ".json") && !keep[entry.Name()] {
+			err = d.Fs.Remove(filepath.Join(d.peersDir(), entry.Name()))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package gork
+
+import (
+	"bytes"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	"github.com/sean9999/go-delphi"
+	"github.com/sean9999/pear"
+	"github.com/spf13/afero"
+)
+
+const encryptedConfigPemType = "ORACLE ENCRYPTED CONFIG"
+
+var ErrConfigDecrypt = pear.Defer("could not decrypt config")
+
+// EncryptedConfigProvider is a [FileBasedConfigProvider] whose contents are encrypted to the owner's own key.
+// Get transparently reads both encrypted and plain files, and verifies the decrypted [Config]'s signature.
+// Set always encrypts.
+type EncryptedConfigProvider struct {
+	FileBasedConfigProvider
+	Owner *Principal
+}
+
+// IsEncrypted reports whether the config file exists and is encrypted
+func (f FileBasedConfigProvider) IsEncrypted() bool {
+	b, err := f.read()
+	return err == nil && isEncryptedConfig(b)
+}
+
+func isEncryptedConfig(b []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(b), []byte("-----BEGIN "+encryptedConfigPemType+"-----"))
+}
+
+// OpenConfigFile returns a provider for a config file, which stays encrypted if it already is
+func OpenConfigFile(fs afero.Fs, name string, owner *Principal) ConfigProvider {
+	f := FileBasedConfigProvider{
+		Fs:   fs,
+		Name: name,
+	}
+	if owner != nil && f.IsEncrypted() {
+		return EncryptedConfigProvider{f, owner}
+	}
+	return f
+}
+
+func (e EncryptedConfigProvider) Get() (*Config, error) {
+	if e.Owner == nil {
+		return nil, ErrNoPrivKey
+	}
+	fileBytes, err := e.read()
+	if err != nil {
+		return nil, err
+	}
+
+	plain := fileBytes
+	if isEncryptedConfig(fileBytes) {
+		plain, err = e.decrypt(fileBytes)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	conf := new(Config)
+	err = e.format().Unmarshal(plain, conf)
+	if err != nil {
+		return nil, err
+	}
+	err = e.Owner.VerifyConfig(conf)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrConfigDecrypt, err)
+	}
+	return conf, nil
+}
+
+func (e EncryptedConfigProvider) Set(c *Config) error {
+	if c == nil {
+		return ErrNilConfig
+	}
+	buf, err := e.encode(c)
+	if err != nil {
+		return err
+	}
+	return e.write(buf)
+}
+
+// Update replaces the config file with what fn makes of it, holding the lock from read to write
+func (e EncryptedConfigProvider) Update(fn func(current *Config) (*Config, error)) error {
+	return e.update(e.Get, e.encode, fn)
+}
+
+// encode marshals and encrypts a config
+func (e EncryptedConfigProvider) encode(c *Config) ([]byte, error) {
+	if e.Owner == nil {
+		return nil, ErrNoPrivKey
+	}
+	plain, err := e.format().Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	return e.encrypt(plain)
+}
+
+func (e EncryptedConfigProvider) encrypt(plain []byte) ([]byte, error) {
+	randy := e.Owner.randomness
+	if randy == nil {
+		return nil, errors.New("nil randomness")
+	}
+	me := e.Owner.PublicKey()
+	msg := delphi.NewMessage(randy, plain)
+	msg.Sender = me
+	msg.Recipient = me
+	err := e.Owner.Encrypt(randy, msg, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not encrypt config: %w", err)
+	}
+	err = msg.Sign(randy, e.Owner)
+	if err != nil {
+		return nil, fmt.Errorf("could not sign config: %w", err)
+	}
+	bin, err := msg.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	block := &pem.Block{
+		Type:  encryptedConfigPemType,
+		Bytes: bin,
+	}
+	return pem.EncodeToMemory(block), nil
+}
+
+func (e EncryptedConfigProvider) decrypt(b []byte) ([]byte, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, pear.Errorf("%w: %w", ErrConfigDecrypt, ErrBadPem)
+	}
+	msg := new(delphi.Message)
+	err := msg.UnmarshalBinary(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrConfigDecrypt, err)
+	}
+	if !msg.Sender.Equal(e.Owner.PublicKey()) {
+		return nil, pear.Errorf("%w: not written by its owner", ErrConfigDecrypt)
+	}
+	dig, err := msg.Digest()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrConfigDecrypt, err)
+	}
+	if !e.Owner.Verify(msg.Sender, dig, msg.Signature()) {
+		return nil, pear.Errorf("%w: %w", ErrConfigDecrypt, ErrBadSignature)
+	}
+	err = e.Owner.Decrypt(msg, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrConfigDecrypt, err)
+	}
+	return msg.PlainText, nil
+}
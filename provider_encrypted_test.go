@@ -0,0 +1,71 @@
+package gork
+
+import (
+	"crypto/rand"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncryptedConfigProvider(t *testing.T) {
+
+	alice := NewPrincipal(rand.Reader, map[string]string{"hometown": "wonderland"}, nil)
+	bob := NewPrincipal(rand.Reader, nil, nil)
+	eve := NewPrincipal(rand.Reader, nil, nil)
+
+	fs := afero.NewMemMapFs()
+	plain := FileBasedConfigProvider{Fs: fs, Name: "conf.json"}
+	enc := EncryptedConfigProvider{plain, &alice}
+
+	t.Run("turn encryption on", func(t *testing.T) {
+		alice.AddPeer(bob.AsPeer())
+		assert.NoError(t, alice.Save(plain))
+		assert.False(t, plain.IsEncrypted())
+
+		//	a plain config can be read through an encrypted provider
+		conf, err := enc.Get()
+		assert.NoError(t, err)
+		assert.Len(t, *conf.Peers, 1)
+
+		assert.NoError(t, alice.Save(enc))
+		assert.True(t, plain.IsEncrypted())
+		b, err := afero.ReadFile(fs, "conf.json")
+		assert.NoError(t, err)
+		assert.NotContains(t, string(b), bob.PublicKey().ToHex())
+		assert.NotContains(t, string(b), "wonderland")
+
+		_, err = plain.Get()
+		assert.Error(t, err)
+
+		conf, err = enc.Get()
+		assert.NoError(t, err)
+		hometown, _ := conf.Props.Get("hometown")
+		assert.Equal(t, "wonderland", hometown)
+		assert.Len(t, *conf.Peers, 1)
+	})
+
+	t.Run("OpenConfigFile keeps encrypted files encrypted", func(t *testing.T) {
+		prov := OpenConfigFile(fs, "conf.json", &alice)
+		assert.IsType(t, EncryptedConfigProvider{}, prov)
+		prov = OpenConfigFile(fs, "nothing-here.json", &alice)
+		assert.IsType(t, FileBasedConfigProvider{}, prov)
+	})
+
+	t.Run("only the owner can read it", func(t *testing.T) {
+		_, err := EncryptedConfigProvider{plain, &eve}.Get()
+		assert.ErrorIs(t, err, ErrConfigDecrypt)
+	})
+
+	t.Run("turn encryption off", func(t *testing.T) {
+		conf, err := enc.Get()
+		assert.NoError(t, err)
+		assert.NoError(t, alice.LoadConfig(conf))
+		assert.NoError(t, alice.Save(plain))
+		assert.False(t, plain.IsEncrypted())
+		conf, err = plain.Get()
+		assert.NoError(t, err)
+		assert.NoError(t, alice.VerifyConfig(conf))
+	})
+
+}
@@ -0,0 +1,48 @@
+package gork
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+)
+
+// MemoryConfigProvider keeps a [Config] in memory. It's useful for tests and for embedding.
+// Configs are stored in serialized form, so that a stored Config shares no state with a live [Principal].
+type MemoryConfigProvider struct {
+	mu  sync.RWMutex
+	buf []byte
+}
+
+func NewMemoryConfigProvider() *MemoryConfigProvider {
+	return new(MemoryConfigProvider)
+}
+
+// Get returns a copy of the stored config, or [os.ErrNotExist] if none has been set
+func (m *MemoryConfigProvider) Get() (*Config, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.buf == nil {
+		return nil, os.ErrNotExist
+	}
+	conf := new(Config)
+	err := json.Unmarshal(m.buf, conf)
+	if err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+func (m *MemoryConfigProvider) Set(c *Config) error {
+	if c == nil {
+		return ErrNilConfig
+	}
+	buf, err := io.ReadAll(c)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.buf = buf
+	return nil
+}
@@ -0,0 +1,237 @@
+package gork
+
+import (
+	"bytes"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileBasedConfigProvider(t *testing.T) {
+
+	alice := NewPrincipal(rand.Reader, nil, nil)
+	bob := NewPrincipal(rand.Reader, nil, nil)
+	carol := NewPrincipal(rand.Reader, nil, nil)
+
+	t.Run("creates missing file and directory", func(t *testing.T) {
+		fs := afero.NewMemMapFs()
+		prov := FileBasedConfigProvider{Fs: fs, Name: "gork/conf.json"}
+		err := alice.Save(prov)
+		assert.NoError(t, err)
+		info, err := fs.Stat("gork/conf.json")
+		assert.NoError(t, err)
+		assert.Equal(t, os.FileMode(filePerm), info.Mode().Perm())
+		conf, err := prov.Get()
+		assert.NoError(t, err)
+		assert.True(t, conf.Pub.Equal(alice.PublicKey()))
+		exists, _ := afero.Exists(fs, prov.lockName())
+		assert.False(t, exists)
+	})
+
+	t.Run("rolling backups", func(t *testing.T) {
+		fs := afero.NewMemMapFs()
+		prov := FileBasedConfigProvider{Fs: fs, Name: "conf.json", Backups: 2}
+		alice.Peers = PeerList{}
+		for _, p := range []Principal{bob, carol} {
+			assert.NoError(t, alice.Save(prov))
+			alice.AddPeer(p.AsPeer())
+		}
+		assert.NoError(t, alice.Save(prov))
+
+		// conf.json has 2 peers, conf.json.1 has 1, conf.json.2 has 0
+		for i, want := range []int{2, 1, 0} {
+			name := prov.Name
+			if i > 0 {
+				name = prov.backupName(i)
+			}
+			c, err := FileBasedConfigProvider{Fs: fs, Name: name}.Get()
+			assert.NoError(t, err)
+			assert.Len(t, *c.Peers, want, name)
+		}
+		exists, _ := afero.Exists(fs, prov.backupName(3))
+		assert.False(t, exists)
+	})
+
+	t.Run("locked", func(t *testing.T) {
+		fs := afero.NewMemMapFs()
+		prov := FileBasedConfigProvider{Fs: fs, Name: "conf.json", LockTimeout: 50 * time.Millisecond}
+		unlock, err := prov.lock()
+		assert.NoError(t, err)
+		err = alice.Save(prov)
+		assert.ErrorIs(t, err, ErrLocked)
+		unlock()
+		err = alice.Save(prov)
+		assert.NoError(t, err)
+	})
+
+	t.Run("saves merge in each other's changes", func(t *testing.T) {
+		fs := afero.NewMemMapFs()
+		prov := FileBasedConfigProvider{Fs: fs, Name: "conf.json"}
+		me := NewPrincipal(rand.Reader, nil, nil)
+		assert.NoError(t, me.Save(prov))
+
+		//	goracle and goracled both load it, and each add a peer
+		load := func() *Principal {
+			p, err := PrincipalFrom(bytes.NewReader(me.ToBin()))
+			assert.NoError(t, err)
+			p.WithRand(rand.Reader)
+			assert.NoError(t, p.WithConfigProvider(prov))
+			return p
+		}
+		goracle, goracled := load(), load()
+		assert.NoError(t, goracle.AddPeer(bob.AsPeer()))
+		assert.NoError(t, goracled.AddPeer(carol.AsPeer()))
+		assert.NoError(t, goracle.Save(prov))
+		assert.NoError(t, goracled.Save(prov))
+
+		conf, err := prov.Get()
+		assert.NoError(t, err)
+		assert.NoError(t, me.VerifyConfig(conf))
+		assert.Len(t, *conf.Peers, 2)
+
+		//	and goracle can catch up without saving
+		assert.False(t, goracle.HasPeer(carol.AsPeer()))
+		assert.NoError(t, goracle.Reload(prov))
+		assert.True(t, goracle.HasPeer(carol.AsPeer()))
+	})
+
+	t.Run("update holds the lock from read to write", func(t *testing.T) {
+		fs := afero.NewMemMapFs()
+		prov := FileBasedConfigProvider{Fs: fs, Name: "conf.json", LockTimeout: 50 * time.Millisecond}
+		assert.NoError(t, alice.Save(prov))
+		err := prov.Update(func(current *Config) (*Config, error) {
+			assert.NotNil(t, current)
+			_, err := prov.lock()
+			assert.ErrorIs(t, err, ErrLocked)
+			return current, nil
+		})
+		assert.NoError(t, err)
+	})
+
+	t.Run("stale lock is broken", func(t *testing.T) {
+		fs := afero.NewMemMapFs()
+		prov := FileBasedConfigProvider{Fs: fs, Name: "conf.json", LockTimeout: 50 * time.Millisecond}
+		afero.WriteFile(fs, prov.lockName(), []byte("1\n"), filePerm)
+		then := time.Now().Add(-2 * staleLockAge)
+		fs.Chtimes(prov.lockName(), then, then)
+		err := alice.Save(prov)
+		assert.NoError(t, err)
+	})
+
+	t.Run("stale lock is only broken by one of those waiting on it", func(t *testing.T) {
+		fs := afero.NewMemMapFs()
+		prov := FileBasedConfigProvider{Fs: fs, Name: "conf.json", LockTimeout: 50 * time.Millisecond}
+		afero.WriteFile(fs, prov.lockName(), []byte("1\n"), filePerm)
+		then := time.Now().Add(-2 * staleLockAge)
+		fs.Chtimes(prov.lockName(), then, then)
+
+		//	someone else is breaking it, and will take it
+		afero.WriteFile(fs, prov.lockName()+".break", nil, filePerm)
+		assert.False(t, breakStale(fs, prov.lockName()))
+		assert.ErrorIs(t, alice.Save(prov), ErrLocked)
+		fs.Remove(prov.lockName() + ".break")
+		assert.True(t, breakStale(fs, prov.lockName()))
+		assert.False(t, breakStale(fs, prov.lockName()))
+	})
+
+	t.Run("locks on disk", func(t *testing.T) {
+		if !canFlock {
+			t.Skip("no flock here")
+		}
+		prov := FileBasedConfigProvider{Fs: afero.NewOsFs(), Name: filepath.Join(t.TempDir(), "conf.json"), LockTimeout: 50 * time.Millisecond}
+		unlock, err := prov.lock()
+		assert.NoError(t, err)
+		assert.ErrorIs(t, alice.Save(prov), ErrLocked)
+		unlock()
+		assert.NoError(t, alice.Save(prov))
+
+		//	a lock file left behind by a crashed process holds nobody up
+		then := time.Now()
+		afero.WriteFile(prov.Fs, prov.lockName(), []byte("1\n"), filePerm)
+		assert.NoError(t, alice.Save(prov))
+		assert.Less(t, time.Since(then), prov.LockTimeout)
+	})
+
+}
+
+// testConfigProvider is a conformance suite that any [ConfigProvider] should pass
+func testConfigProvider(t *testing.T, prov ConfigProvider) {
+	t.Helper()
+
+	alice := NewPrincipal(rand.Reader, map[string]string{"hometown": "wonderland"}, nil)
+	bob := NewPrincipal(rand.Reader, nil, nil)
+	carol := NewPrincipal(rand.Reader, nil, nil)
+
+	_, err := prov.Get()
+	assert.Error(t, err, "get before set")
+
+	err = prov.Set(nil)
+	assert.ErrorIs(t, err, ErrNilConfig)
+
+	bobPeer := bob.AsPeer()
+	bobPeer.Properties = NewKV()
+	bobPeer.Properties.Set("addr", "[::1]:5656")
+	assert.NoError(t, alice.AddPeer(bobPeer))
+	assert.NoError(t, alice.AddPeer(carol.AsPeer()))
+	assert.NoError(t, alice.Save(prov))
+
+	conf, err := prov.Get()
+	assert.NoError(t, err)
+	assert.True(t, conf.Pub.Equal(alice.PublicKey()))
+	assert.NoError(t, alice.VerifyConfig(conf))
+	hometown, _ := conf.Props.Get("hometown")
+	assert.Equal(t, "wonderland", hometown)
+	assert.Len(t, *conf.Peers, 2)
+	for _, peer := range *conf.Peers {
+		if peer.Equal(bobPeer) {
+			addr, _ := peer.Properties.Get("addr")
+			assert.Equal(t, "[::1]:5656", addr)
+		}
+	}
+
+	//	dropped peers stay dropped
+	alice.DropPeer(bobPeer)
+	assert.NoError(t, alice.Save(prov))
+	conf, err = prov.Get()
+	assert.NoError(t, err)
+	assert.Len(t, *conf.Peers, 1)
+	assert.True(t, (*conf.Peers)[0].Equal(carol.AsPeer()))
+
+	//	a loaded config can be attached to a principal
+	dave := alice
+	dave.Peers = nil
+	assert.NoError(t, dave.WithConfigProvider(prov))
+	assert.Len(t, dave.Peers, 1)
+}
+
+func TestConfigProviders(t *testing.T) {
+
+	t.Run("file", func(t *testing.T) {
+		testConfigProvider(t, FileBasedConfigProvider{Fs: afero.NewMemMapFs(), Name: "conf.json"})
+	})
+
+	t.Run("memory", func(t *testing.T) {
+		testConfigProvider(t, NewMemoryConfigProvider())
+	})
+
+	t.Run("directory", func(t *testing.T) {
+		fs := afero.NewMemMapFs()
+		testConfigProvider(t, DirectoryConfigProvider{Fs: fs, Dir: ".gork"})
+		entries, err := afero.ReadDir(fs, ".gork/peers")
+		assert.NoError(t, err)
+		assert.Len(t, entries, 1)
+	})
+
+	t.Run("bolt", func(t *testing.T) {
+		prov, err := NewBoltConfigProvider(filepath.Join(t.TempDir(), "gork.db"))
+		assert.NoError(t, err)
+		defer prov.Close()
+		testConfigProvider(t, prov)
+	})
+
+}
@@ -0,0 +1,25 @@
+package gork
+
+import (
+	"time"
+
+	"github.com/sean9999/go-delphi"
+)
+
+// SubjectRelay is the subject of a message asking a relay to pass on what's sent to us
+const SubjectRelay = "RELAY"
+
+// AskRelay produces a signed request for a relay to pass on what's sent to us, to wherever the request came from.
+// Asking again keeps the way open, for those of us behind NAT.
+// It says when it was made, so that it can't be replayed later to take our messages somewhere else.
+func (g *Principal) AskRelay() (*delphi.Message, error) {
+	msg := delphi.NewMessage(g.randomness, []byte("pass it on"))
+	msg.Sender = g.PublicKey()
+	msg.Subject = SubjectRelay
+	stamp(msg, time.Now())
+	err := msg.Sign(g.randomness, g)
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
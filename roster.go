@@ -0,0 +1,195 @@
+package gork
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/sean9999/go-delphi"
+	"github.com/sean9999/pear"
+	"github.com/spf13/afero"
+)
+
+const (
+	// DefaultSightingAge is how long a node stays on a [Roster] after it was last heard from
+	DefaultSightingAge = 10 * time.Minute
+	// RequestAge is how long a friend request waits to be approved or denied
+	RequestAge = 30 * 24 * time.Hour
+	// DefaultRosterSize is how many nodes a [Roster] keeps. When it's full, the least recently heard from is dropped.
+	DefaultRosterSize = 256
+)
+
+// a Sighting is a node that asserted who it is
+type Sighting struct {
+	Pub       string    `json:"pub"`
+	Addr      string    `json:"addr"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	// Asserted is when the node made its assertion
+	Asserted time.Time `json:"asserted"`
+	// Assertion is the PEM-encoded assertion the node announced itself with. It's verified again whenever it's used.
+	Assertion string `json:"assertion"`
+}
+
+// NewSighting verifies an assertion heard from addr, and records it as a Sighting
+func NewSighting(msg *delphi.Message, addr string, now time.Time) (Sighting, error) {
+	peer, err := ParseAssertion(msg)
+	if err != nil {
+		return Sighting{}, err
+	}
+	s := Sighting{
+		Pub:       peer.ToHex(),
+		Addr:      addr,
+		FirstSeen: now,
+		LastSeen:  now,
+		Asserted:  AssertedAt(msg),
+		Assertion: msg.String(),
+	}
+	return s, nil
+}
+
+// Peer verifies the Sighting's assertion, and returns the Peer it describes, reachable at the address it was heard from
+func (s Sighting) Peer() (Peer, error) {
+	block, _ := pem.Decode([]byte(s.Assertion))
+	if block == nil {
+		return Peer{}, fmt.Errorf("%w: %w", ErrBadAssertion, ErrBadPem)
+	}
+	msg := new(delphi.Message)
+	err := msg.FromPEM(*block)
+	if err != nil {
+		return Peer{}, pear.Errorf("%w: %s", ErrBadAssertion, err)
+	}
+	peer, err := ParseAssertion(msg)
+	if err != nil {
+		return Peer{}, err
+	}
+	if peer.ToHex() != s.Pub {
+		return Peer{}, pear.Errorf("%w: asserted by someone else", ErrBadAssertion)
+	}
+	if s.Addr != "" {
+		peer.Properties.Set("addr", s.Addr)
+	}
+	return peer, nil
+}
+
+// a Roster is a list of nodes we've heard from that aren't our peers, such as those nearby, or waiting for us to befriend them
+type Roster interface {
+	// Sightings lists nodes heard from recently, most recent first
+	Sightings() ([]Sighting, error)
+	// Sight records a Sighting, merging it with any earlier one of the same node.
+	// A replayed assertion, being older than the one we have or the same one heard from somewhere else, is ignored.
+	Sight(Sighting) error
+	// Forget removes a node
+	Forget(pub string) error
+}
+
+// FileRoster stores a [Roster] as a JSON file.
+// It's shared between goracled, which hears from nodes, and goracle, which befriends them.
+type FileRoster struct {
+	Fs   afero.Fs
+	Name string
+	// MaxAge overrides [DefaultSightingAge]
+	MaxAge time.Duration
+	// MaxSize overrides [DefaultRosterSize]
+	MaxSize int
+}
+
+// NearbyRoster is where goracled writes down the nodes it hears announce themselves on the local network
+func NearbyRoster(fs afero.Fs, config string) FileRoster {
+	return FileRoster{Fs: fs, Name: config + ".nearby"}
+}
+
+// RequestRoster is where goracled queues friend requests for approval
+func RequestRoster(fs afero.Fs, config string) FileRoster {
+	return FileRoster{Fs: fs, Name: config + ".requests", MaxAge: RequestAge}
+}
+
+func (f FileRoster) maxSize() int {
+	if f.MaxSize == 0 {
+		return DefaultRosterSize
+	}
+	return f.MaxSize
+}
+
+func (f FileRoster) maxAge() time.Duration {
+	if f.MaxAge == 0 {
+		return DefaultSightingAge
+	}
+	return f.MaxAge
+}
+
+// read returns every sighting that hasn't gone stale
+func (f FileRoster) read() ([]Sighting, error) {
+	b, err := afero.ReadFile(f.Fs, f.Name)
+	if errors.Is(err, os.ErrNotExist) {
+		return []Sighting{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	all := []Sighting{}
+	err = json.Unmarshal(b, &all)
+	if err != nil {
+		return nil, err
+	}
+	fresh := make([]Sighting, 0, len(all))
+	for _, s := range all {
+		if time.Since(s.LastSeen) <= f.maxAge() {
+			fresh = append(fresh, s)
+		}
+	}
+	return fresh, nil
+}
+
+// update does a read-modify-write under lock
+func (f FileRoster) update(fn func([]Sighting) []Sighting) error {
+	unlock, err := lockFile(f.Fs, f.Name+".lock", 0)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	sightings, err := f.read()
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(fn(sightings), "", "\t")
+	if err != nil {
+		return err
+	}
+	return writeAtomic(f.Fs, f.Name, b)
+}
+
+func (f FileRoster) Sightings() ([]Sighting, error) {
+	return f.read()
+}
+
+func (f FileRoster) Sight(s Sighting) error {
+	return f.update(func(sightings []Sighting) []Sighting {
+		for i, old := range sightings {
+			if old.Pub == s.Pub {
+				if s.Asserted.Before(old.Asserted) || (s.Assertion == old.Assertion && s.Addr != old.Addr) {
+					return sightings
+				}
+				s.FirstSeen = old.FirstSeen
+				sightings = append(sightings[:i:i], sightings[i+1:]...)
+				break
+			}
+		}
+		sightings = append([]Sighting{s}, sightings...)
+		return sightings[:min(len(sightings), f.maxSize())]
+	})
+}
+
+func (f FileRoster) Forget(pub string) error {
+	return f.update(func(sightings []Sighting) []Sighting {
+		for i, s := range sightings {
+			if s.Pub == pub {
+				return append(sightings[:i:i], sightings[i+1:]...)
+			}
+		}
+		return sightings
+	})
+}
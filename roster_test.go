@@ -0,0 +1,116 @@
+package gork
+
+import (
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAssertion(t *testing.T) {
+
+	check := assert.New(t)
+	alice := NewPrincipal(rand.Reader, map[string]string{"hometown": "wonderland"}, nil)
+
+	msg, err := alice.Assert()
+	check.NoError(err)
+	peer, err := ParseAssertion(msg)
+	check.NoError(err)
+	check.True(peer.Equal(alice.AsPeer()))
+	hometown, _ := peer.Properties.Get("hometown")
+	check.Equal("wonderland", hometown)
+	_, hasGrip := peer.Properties.Get("grip")
+	check.False(hasGrip)
+
+	//	props are signed
+	msg.PlainText = []byte(`{"msg":"i assert that I am me","props":{"hometown":"looking-glass"}}`)
+	_, err = ParseAssertion(msg)
+	check.ErrorIs(err, ErrBadSignature)
+
+	msg.Subject = "GREETING"
+	_, err = ParseAssertion(msg)
+	check.ErrorIs(err, ErrBadAssertion)
+
+	//	assertions heard long after they were made are refused
+	msg, err = alice.Assert()
+	check.NoError(err)
+	_, err = FreshAssertion(msg, time.Now())
+	check.NoError(err)
+	_, err = FreshAssertion(msg, time.Now().Add(time.Hour))
+	check.ErrorIs(err, ErrStaleAssertion)
+	_, err = FreshAssertion(msg, time.Now().Add(-time.Hour))
+	check.ErrorIs(err, ErrStaleAssertion)
+
+}
+
+func TestRoster(t *testing.T) {
+
+	check := assert.New(t)
+	near := FileRoster{Fs: afero.NewMemMapFs(), Name: "conf.json.nearby", MaxAge: time.Hour}
+
+	sight := func(p Principal, addr string, at time.Time) Sighting {
+		msg, err := p.Assert()
+		check.NoError(err)
+		s, err := NewSighting(msg, addr, at)
+		check.NoError(err)
+		check.NoError(near.Sight(s))
+		return s
+	}
+
+	alice := NewPrincipal(rand.Reader, nil, nil)
+	bob := NewPrincipal(rand.Reader, nil, nil)
+	carol := NewPrincipal(rand.Reader, nil, nil)
+	then := time.Now().Add(-10 * time.Minute)
+
+	sight(alice, "10.0.0.1:5656", then)
+	sight(bob, "10.0.0.2:5656", then)
+	sight(carol, "10.0.0.3:5656", time.Now().Add(-2*time.Hour))
+	sight(alice, "10.0.0.9:5656", time.Now())
+
+	sightings, err := near.Sightings()
+	check.NoError(err)
+	check.Len(sightings, 2, "carol has gone stale")
+	check.Equal(alice.PublicKey().ToHex(), sightings[0].Pub, "most recent first")
+	check.Equal(then.Unix(), sightings[0].FirstSeen.Unix())
+
+	peer, err := sightings[0].Peer()
+	check.NoError(err)
+	check.True(peer.Equal(alice.AsPeer()))
+	addr, _ := peer.Properties.Get("addr")
+	check.Equal("10.0.0.9:5656", addr)
+
+	//	a sighting can't be passed off as someone else's
+	forged := sightings[1]
+	forged.Pub = alice.PublicKey().ToHex()
+	_, err = forged.Peer()
+	check.ErrorIs(err, ErrBadAssertion)
+
+	check.NoError(near.Forget(alice.PublicKey().ToHex()))
+	sightings, _ = near.Sightings()
+	check.Len(sightings, 1)
+	check.Equal(bob.PublicKey().ToHex(), sightings[0].Pub)
+
+	//	a replayed assertion doesn't move the node
+	replayed := sightings[0]
+	replayed.Addr = "10.6.6.6:5656"
+	check.NoError(near.Sight(replayed))
+	sightings, _ = near.Sightings()
+	check.Equal("10.0.0.2:5656", sightings[0].Addr)
+
+	//	the roster is only so big
+	small := FileRoster{Fs: afero.NewMemMapFs(), Name: "conf.json.requests", MaxSize: 2}
+	for _, p := range []Principal{alice, bob, carol} {
+		msg, err := p.Assert()
+		check.NoError(err)
+		s, err := NewSighting(msg, "10.0.0.1:5656", time.Now())
+		check.NoError(err)
+		check.NoError(small.Sight(s))
+	}
+	sightings, _ = small.Sightings()
+	check.Len(sightings, 2)
+	check.Equal(carol.PublicKey().ToHex(), sightings[0].Pub)
+	check.Equal(bob.PublicKey().ToHex(), sightings[1].Pub)
+
+}
@@ -0,0 +1,47 @@
+package gork
+
+import (
+	"crypto/sha256"
+	"sync"
+
+	"github.com/sean9999/go-delphi"
+)
+
+// verifiedLimit is how many verified messages are remembered before they're all forgotten
+const verifiedLimit = 4096
+
+// verified remembers messages whose signatures have been checked, by a hash of what was signed and the signature,
+// so that a message is only verified once, however many times it's parsed
+var verified = struct {
+	sync.Mutex
+	seen map[[sha256.Size]byte]bool
+}{seen: map[[sha256.Size]byte]bool{}}
+
+// Verify checks that a message is well-formed, and signed by its sender.
+// A message that's been verified before isn't checked again. A changed one is, since what was signed has changed.
+func Verify(msg *delphi.Message) bool {
+	if msg == nil || !msg.Valid() {
+		return false
+	}
+	digest, err := msg.Digest()
+	if err != nil {
+		return false
+	}
+	key := sha256.Sum256(append(digest, msg.Signature()...))
+	verified.Lock()
+	seen := verified.seen[key]
+	verified.Unlock()
+	if seen {
+		return true
+	}
+	if !msg.Verify() {
+		return false
+	}
+	verified.Lock()
+	defer verified.Unlock()
+	if len(verified.seen) >= verifiedLimit {
+		clear(verified.seen)
+	}
+	verified.seen[key] = true
+	return true
+}
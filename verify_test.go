@@ -0,0 +1,25 @@
+package gork
+
+import (
+	"crypto/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerify(t *testing.T) {
+
+	check := assert.New(t)
+	alice := NewPrincipal(rand.Reader, nil, nil)
+	msg, err := alice.Assert()
+	check.NoError(err)
+
+	check.True(Verify(msg))
+	check.True(Verify(msg), "again, from memory")
+	check.False(Verify(nil))
+
+	//	what's remembered is what was signed, so a changed message is checked afresh
+	msg.PlainText = []byte(`{"msg":"i assert that I am mallory"}`)
+	check.False(Verify(msg))
+
+}